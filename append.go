@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Appends arrays to the array in a file, e.g. to collect records over
+// several runs. The file is created if it does not exist (or is empty).
+// NDJSON records are appended to the file as they are; other files are read
+// with Format and written again with the elements of both arrays to a
+// temporary file that then replaces the file. Writer writes the result
+// (Format if nil), e.g. a VerifyingFormat.
+//
+// Values that are not arrays (in the file or appended) are an error unless
+// Wrap is set, which treats them as arrays of one element. A lock file (the
+// name of the file with `.lock`) created exclusively for the duration of an
+// append makes concurrent appends fail rather than lose records.
+type FileAppender struct {
+	File   string
+	Format InputOutputFormat
+	Writer OutputFormat
+	Wrap   bool
+}
+
+func (a FileAppender) Append(data interface{}) error {
+	elements, err := a.elements(data, "the data to append")
+	if err != nil {
+		return err
+	}
+	lock := a.File + ".lock"
+	file, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if os.IsExist(err) {
+		return fmt.Errorf("another process appears to be appending to %s (remove %s if it is stale)", a.File, lock)
+	} else if err != nil {
+		return err
+	}
+	file.Close()
+	defer os.Remove(lock)
+
+	writer := a.Writer
+	if writer == nil {
+		writer = a.Format
+	}
+	if _, ok := a.Format.(NDJSONFormat); ok {
+		return a.appendRecords(elements, writer)
+	}
+	existing, mode, err := a.read()
+	if err != nil {
+		return err
+	}
+	buffer := &bytes.Buffer{}
+	if err = writer.Marshal(append(existing, elements...), buffer); err != nil {
+		return err
+	}
+	return replaceFile(a.File, buffer.Bytes(), mode)
+}
+
+// Returns the elements of the array in the file (none if there is no file)
+// and the permissions of the file.
+func (a FileAppender) read() ([]interface{}, os.FileMode, error) {
+	content, err := ioutil.ReadFile(a.File)
+	if os.IsNotExist(err) {
+		return nil, 0640, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	info, err := os.Stat(a.File)
+	if err != nil {
+		return nil, 0, err
+	} else if len(bytes.TrimSpace(content)) == 0 {
+		return nil, info.Mode().Perm(), nil
+	}
+	data, err := a.Format.Unmarshal(bytes.NewReader(content))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s cannot be read as %s: %s", a.File, a.Format.Name(), err)
+	}
+	elements, err := a.elements(data, a.File)
+	return elements, info.Mode().Perm(), err
+}
+
+func (a FileAppender) elements(data interface{}, what string) ([]interface{}, error) {
+	elements, err := topLevelArray(data)
+	if err != nil && a.Wrap {
+		return []interface{}{data}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%s is %s rather than an array", what, describeType(data))
+	}
+	return elements, nil
+}
+
+// Appends the records to the file, after a line break if its last line
+// does not have one.
+func (a FileAppender) appendRecords(elements []interface{}, writer OutputFormat) error {
+	buffer := &bytes.Buffer{}
+	if len(elements) > 0 {
+		if err := writer.Marshal(elements, buffer); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(a.File, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	last := make([]byte, 1)
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		if _, err = file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			buffer = bytes.NewBuffer(append([]byte("\n"), buffer.Bytes()...))
+		}
+	}
+	_, err = io.Copy(file, buffer)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Replaces the file by writing the content to a temporary file in the same
+// directory and renaming it, so that the file is never partially written.
+func replaceFile(name string, content []byte, mode os.FileMode) error {
+	file, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), name)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
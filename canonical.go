@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Encodes data as canonical JSON following the JSON Canonicalization Scheme
+// (RFC 8785): no insignificant whitespace, keys sorted by their UTF-16 code
+// units, ECMAScript number formatting and minimal string escaping.
+//
+// Types without a direct JSON representation (such as dates) are encoded via
+// encoding/json first. Non-finite numbers cannot be represented and fail.
+func CanonicalJSON(data interface{}) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	err := writeCanonicalJSON(buffer, data)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeCanonicalJSON(b *bytes.Buffer, data interface{}) error {
+	if isNil(data) {
+		b.WriteString("null")
+		return nil
+	}
+	switch d := data.(type) {
+	case bool:
+		b.WriteString(strconv.FormatBool(d))
+		return nil
+	case string:
+		writeCanonicalString(b, d)
+		return nil
+	case json.Number:
+		f, err := d.Float64()
+		if err != nil {
+			return err
+		}
+		return writeCanonicalNumber(b, f)
+	}
+
+	value := reflect.ValueOf(data)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return writeCanonicalNumber(b, float64(value.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return writeCanonicalNumber(b, float64(value.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return writeCanonicalNumber(b, value.Float())
+	case reflect.Slice, reflect.Array:
+		b.WriteByte('[')
+		for i := 0; i < value.Len(); i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			err := writeCanonicalJSON(b, value.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+		return nil
+	case reflect.Map:
+		keys := make([]string, 0, value.Len())
+		values := make(map[string]interface{}, value.Len())
+		for _, k := range value.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			values[key] = value.MapIndex(k).Interface()
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return lessUTF16(keys[i], keys[j])
+		})
+		b.WriteByte('{')
+		for n, key := range keys {
+			if n > 0 {
+				b.WriteByte(',')
+			}
+			writeCanonicalString(b, key)
+			b.WriteByte(':')
+			err := writeCanonicalJSON(b, values[key])
+			if err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+		return nil
+	}
+
+	// anything else: use its JSON representation
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var decoded interface{}
+	err = json.Unmarshal(encoded, &decoded)
+	if err != nil {
+		return err
+	}
+	return writeCanonicalJSON(b, decoded)
+}
+
+// Formats a number like ECMAScript's Number.prototype.toString.
+func writeCanonicalNumber(b *bytes.Buffer, f float64) error {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("non-finite number %v cannot be represented in JSON", f)
+	}
+	if f == 0 {
+		b.WriteByte('0') // includes -0
+		return nil
+	}
+	if f < 0 {
+		b.WriteByte('-')
+		f = -f
+	}
+	// shortest round-tripping digits and the decimal exponent
+	mantissa, exponent := splitExponent(strconv.FormatFloat(f, 'e', -1, 64))
+	digits := strings.Replace(mantissa, ".", "", 1)
+	k := len(digits)
+	n := exponent + 1
+	switch {
+	case k <= n && n <= 21:
+		b.WriteString(digits + strings.Repeat("0", n-k))
+	case 0 < n && n <= 21:
+		b.WriteString(digits[:n] + "." + digits[n:])
+	case -6 < n && n <= 0:
+		b.WriteString("0." + strings.Repeat("0", -n) + digits)
+	default:
+		b.WriteString(digits[:1])
+		if k > 1 {
+			b.WriteString("." + digits[1:])
+		}
+		b.WriteString("e")
+		if n-1 > 0 {
+			b.WriteString("+")
+		}
+		b.WriteString(strconv.Itoa(n - 1))
+	}
+	return nil
+}
+
+func splitExponent(formatted string) (string, int) {
+	parts := strings.SplitN(formatted, "e", 2)
+	exponent, _ := strconv.Atoi(parts[1])
+	return parts[0], exponent
+}
+
+func writeCanonicalString(b *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				b.WriteString(`\u00`)
+				b.WriteByte(hex[r>>4])
+				b.WriteByte(hex[r&0xf])
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+}
+
+// Compares strings by their UTF-16 code units as required for canonical JSON.
+func lessUTF16(a, b string) bool {
+	ua := utf16.Encode([]rune(a))
+	ub := utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// YAML documents read as nodes (see YAMLFormat.Nodes), which keep comments.
+type yamlDocuments []*yaml.Node
+
+// A transformer that can also work on YAML nodes, keeping their comments.
+type NodeTransformer interface {
+	Transformer
+	TransformNode(node *yaml.Node) error
+}
+
+// Returns the transformer as a NodeTransformer, if it (and, for pipelines,
+// each of its transformers) supports nodes.
+func asNodeTransformer(transformer Transformer) (NodeTransformer, bool) {
+	if pipeline, ok := transformer.(TransformerPipeline); ok {
+		for _, t := range pipeline.Transformers {
+			if _, ok := asNodeTransformer(t); !ok {
+				return nil, false
+			}
+		}
+		return nodePipeline{pipeline}, true
+	}
+	nodeTransformer, ok := transformer.(NodeTransformer)
+	return nodeTransformer, ok
+}
+
+type nodePipeline struct {
+	TransformerPipeline
+}
+
+func (p nodePipeline) TransformNode(node *yaml.Node) error {
+	for _, t := range p.Transformers {
+		nodeTransformer, _ := asNodeTransformer(t)
+		if err := nodeTransformer.TransformNode(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applies a NodeTransformer to the documents read by YAMLFormat with Nodes.
+type nodeTransformerAdapter struct {
+	NodeTransformer
+}
+
+func (a nodeTransformerAdapter) Transform(data interface{}) (interface{}, error) {
+	documents, ok := data.(yamlDocuments)
+	if !ok {
+		return a.NodeTransformer.Transform(data)
+	}
+	for _, document := range documents {
+		if err := a.TransformNode(document); err != nil {
+			return documents, err
+		}
+	}
+	return documents, nil
+}
+
+func (t NopTransformer) TransformNode(node *yaml.Node) error {
+	return nil
+}
+
+func (t NilRemovalTransformer) TransformNode(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, content := range node.Content {
+			t.TransformNode(content)
+		}
+	case yaml.MappingNode:
+		content := make([]*yaml.Node, 0, len(node.Content))
+		for n := 0; n+1 < len(node.Content); n += 2 {
+			key, value := node.Content[n], node.Content[n+1]
+			if (t.RemoveNilKeys && isNullNode(key)) || (t.RemoveNilValues && isNullNode(value)) {
+				continue
+			}
+			t.TransformNode(value)
+			content = append(content, key, value)
+		}
+		node.Content = content
+	case yaml.SequenceNode:
+		content := make([]*yaml.Node, 0, len(node.Content))
+		for _, element := range node.Content {
+			if t.RemoveNilElements && isNullNode(element) {
+				continue
+			}
+			t.TransformNode(element)
+			content = append(content, element)
+		}
+		node.Content = content
+	}
+	return nil
+}
+
+func (t SortKeysTransformer) TransformNode(node *yaml.Node) error {
+	less := t.Less
+	if less == nil {
+		less = collations[CollationBytes]
+	}
+	for _, content := range node.Content {
+		if err := t.TransformNode(content); err != nil {
+			return err
+		}
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	pairs := make([][2]*yaml.Node, len(node.Content)/2)
+	if len(pairs) == 0 {
+		return nil
+	}
+	for n := range pairs {
+		pairs[n] = [2]*yaml.Node{node.Content[2*n], node.Content[2*n+1]}
+	}
+	// a comment after the last key ends the mapping rather than the key
+	last := pairs[len(pairs)-1][0]
+	footComment := last.FootComment
+	last.FootComment = ""
+	sort.SliceStable(pairs, func(i, j int) bool { return less(pairs[i][0].Value, pairs[j][0].Value) })
+	for n, pair := range pairs {
+		node.Content[2*n], node.Content[2*n+1] = pair[0], pair[1]
+	}
+	pairs[len(pairs)-1][0].FootComment = footComment
+	return nil
+}
+
+func isNullNode(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null"
+}
+
+// Returns the documents as a single node: a sequence of their contents
+// unless there is exactly one.
+func combineDocuments(documents yamlDocuments) *yaml.Node {
+	if len(documents) == 1 {
+		return documents[0]
+	}
+	sequence := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, document := range documents {
+		sequence.Content = append(sequence.Content, document.Content...)
+	}
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{sequence}}
+}
+
+// Switches the conversion to YAML nodes or TOML text so that comments are
+// kept. YAML requires a transformer supporting nodes (comments are dropped
+// with a warning otherwise), for TOML see tomlLayoutTransformer.
+func preserveComments(inputFormat InputFormat, transformer Transformer, outputFormat OutputFormat) (InputFormat, Transformer) {
+	if tomlInput, ok := inputFormat.(TOMLFormat); ok {
+		if _, tomlOutput := outputFormat.(TOMLFormat); tomlOutput {
+			tomlInput.KeepLayout = true
+			return tomlInput, tomlLayoutTransformer{transformer}
+		}
+	}
+	yamlInput, ok := inputFormat.(YAMLFormat)
+	if _, yamlOutput := outputFormat.(YAMLFormat); !ok || !yamlOutput {
+		exit(exitConfigurationError, "comments can only be preserved from "+formatNameYAML+" to "+formatNameYAML+
+			" and from "+formatNameTOML+" to "+formatNameTOML)
+	}
+	nodeTransformer, ok := asNodeTransformer(transformer)
+	if !ok {
+		if !quiet {
+			fmt.Fprintln(os.Stderr, "warning: the transformation does not support comments, they are dropped")
+		}
+		return inputFormat, transformer
+	}
+	yamlInput.Nodes = true
+	return yamlInput, nodeTransformerAdapter{nodeTransformer}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// How the format of an input was detected.
+const (
+	DetectedByExtension = "extension"
+	DetectedByMagic     = "magic bytes"
+	DetectedByContent   = "content"
+)
+
+// The format of an input as detected by DetectInput. Count is the number of
+// documents (YAML) or records (NDJSON, CSF) for formats that have several,
+// see CountUnit, and 0 otherwise.
+type Detection struct {
+	Format string
+	Method string
+	Count  int
+}
+
+// Returns "documents" or "records" for formats counting them, or an empty
+// string.
+func (d Detection) CountUnit() string {
+	switch d.Format {
+	case formatNameYAML:
+		return "documents"
+	case formatNameNDJSON, formatNameCSF:
+		return "records"
+	}
+	return ""
+}
+
+func (d Detection) String() string {
+	if unit := d.CountUnit(); unit != "" {
+		return fmt.Sprintf("%s (%s, %d %s)", d.Format, d.Method, d.Count, unit)
+	}
+	return fmt.Sprintf("%s (%s)", d.Format, d.Method)
+}
+
+var errNotDetected = errors.New("the format could not be detected")
+
+// Detects the format of the input like --sniff does: from the content (see
+// DetectFormat) or, if that is ambiguous, from the extension of the file
+// name. The input must be valid in the format detected.
+func DetectInput(fileName string, input []byte) (Detection, error) {
+	detection := Detection{Method: DetectedByContent}
+	if name, ok := DetectFormat(input); ok {
+		detection.Format = name
+		if name == formatNameYAML && hasYAMLMarker(contentStart(input)) {
+			detection.Method = DetectedByMagic
+		}
+	} else if name, ok := FormatForExtension(fileName); ok {
+		detection.Format, detection.Method = name, DetectedByExtension
+	} else {
+		return detection, errNotDetected
+	}
+
+	if detection.Format == formatNameYAML {
+		decoder := yaml.NewDecoder(bytes.NewReader(input))
+		for {
+			var document yaml.Node
+			if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+				return detection, nil
+			} else if err != nil {
+				return detection, fmt.Errorf("the input is not valid %s: %s", detection.Format, err)
+			}
+			detection.Count++
+		}
+	}
+	format, err := NewInputFormat(fileName, detection.Format, ",", "NL")
+	if err != nil {
+		return detection, err
+	}
+	data, err := format.Unmarshal(bytes.NewReader(input))
+	if err != nil {
+		return detection, fmt.Errorf("the input is not valid %s: %s", detection.Format, err)
+	}
+	if detection.CountUnit() != "" {
+		records, _ := topLevelArray(data)
+		detection.Count = len(records)
+	}
+	return detection, nil
+}
+
+// The detection for an input, or the reason it failed.
+type detectionResult struct {
+	Input     string
+	Detection Detection
+	Err       error
+}
+
+func (r detectionResult) MarshalJSON() ([]byte, error) {
+	result := map[string]interface{}{"input": r.Input, "format": nil}
+	if r.Err != nil {
+		result["error"] = r.Err.Error()
+	} else {
+		result["format"], result["method"] = r.Detection.Format, r.Detection.Method
+		if unit := r.Detection.CountUnit(); unit != "" {
+			result[unit] = r.Detection.Count
+		}
+	}
+	return json.Marshal(result)
+}
+
+// Detects the format of each input file. Inputs that cannot be read or
+// identified are reported with the error and the others are still detected;
+// the exit code combines exitInputError and exitCheckError accordingly.
+func detectInputs(files []string) ([]detectionResult, int) {
+	results := make([]detectionResult, len(files))
+	code := exitNoError
+	for n, file := range files {
+		results[n].Input = file
+		content, err := readFileContent(file)
+		if err != nil {
+			results[n].Err = err
+			code |= exitInputError
+			continue
+		}
+		if results[n].Detection, err = DetectInput(file, content); err != nil {
+			results[n].Err = err
+			code |= exitCheckError
+		}
+	}
+	return results, code
+}
+
+// Writes the results as lines of text (`INPUT: FORMAT (METHOD)`) or as a JSON
+// array.
+func writeDetectionResults(w io.Writer, results []detectionResult, asJSON bool) error {
+	if asJSON {
+		encoded, err := json.Marshal(results)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(encoded, '\n'))
+		return err
+	}
+	var b strings.Builder
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(&b, "%s: unknown (%s)\n", result.Input, result.Err)
+		} else {
+			fmt.Fprintf(&b, "%s: %s\n", result.Input, result.Detection)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	mowcli "github.com/jawher/mow.cli"
 )
@@ -19,40 +27,210 @@ var (
 const (
 	prettyPrintOptName        = "pretty-print p"
 	inputTypeOptName          = "input-format i"
+	sniffOptName              = "sniff detect-from-content"
+	inputFormatHintOptName    = "input-format-hint"
 	outputTypeOptName         = "output-format o"
 	fieldDelimOptName         = "field-delimiter F"
 	recordDelimOptName        = "record-delimiter R"
+	defineDelimOptName        = "define-delimiter"
+	escapeDelimOptName        = "escape-delimiters"
+	skipRowsOptName           = "skip-rows"
+	headerOptName             = "header H"
+	headerRenameOptName       = "header-rename"
+	preserveOrderOptName      = "preserve-order"
 	verboseOptName            = "verbose v"
+	quietOptName              = "quiet q"
 	stringTo64bfNumberOptName = "parse-to-finite-64b-number"
+	coerceBoolsOptName        = "coerce-bools"
+	trueStringsOptName        = "true-strings"
+	falseStringsOptName       = "false-strings"
+	strictParseOptName        = "strict-parse"
+	typesOptName              = "types"
+	typesLenientOptName       = "types-lenient"
+	multiDocumentOptName      = "multidoc"
+	yamlDocStartOptName       = "yaml-doc-start"
+	yamlNullStyleOptName      = "yaml-null-style"
+	yamlQuoteStringsOptName   = "yaml-quote-strings"
+	tomlArraysOptName         = "toml-arrays"
+	defaultKeyOptName         = "default-key"
+	requireOptName            = "require"
+	resolveRefsOptName        = "resolve-refs"
+	externalRefsOptName       = "resolve-external-refs"
+	maxRefDepthOptName        = "max-ref-depth"
+	iniNestingOptName         = "ini-nesting"
+	columnsOptName            = "columns"
+	dottedKeysOptName         = "dotted-keys"
+	iniArraysOptName          = "ini-arrays"
+	envArraysOptName          = "env-arrays"
+	jsSafeNumbersOptName      = "js-safe-numbers"
+	nonFiniteOptName          = "nonfinite"
+	floatStyleOptName         = "float-style"
+	parallelOptName           = "parallel"
+	asciiOptName              = "ascii"
+	floatPrecisionOptName     = "float-precision"
+	preserveCommentsOptName   = "preserve-comments"
+	verifyOptName             = "verify"
+	appendOptName             = "append"
+	appendWrapOptName         = "append-wrap"
+	atOptName                 = "at"
+	limitKeysOptName          = "limit-keys"
+	limitMarkerOptName        = "limit-marker"
+	outputBOMOptName          = "output-bom"
+	excelOptName              = "excel"
+	alsoOutputOptName         = "also-output"
+	verifyEpsilonOptName      = "epsilon"
+	timingsOptName            = "timings"
+	debugDumpOptName          = "debug-dump"
+	debugOptName              = "debug"
+	formatOptionOptName       = "format-option"
+	perDocumentOptName        = "per-document"
+	inPlaceOptName            = "in-place"
+	requireMatchOptName       = "require-match"
+	outputBufferSizeOptName   = "output-buffer-size buffer-size"
+	inlineDataOptName         = "data"
+	duplicateKeysOptName      = "dup-keys"
+	envPrefixOptName          = "env-prefix"
+	envNestOptName            = "env-nest"
+	iniValueDelimOptName      = "ini-value-delimiter"
+	keepEmptyDefaultOptName   = "keep-empty-default"
+	keepGoingOptName          = "keep-going"
+	maxErrorsOptName          = "max-errors"
+	skipRecordsOptName        = "skip"
+	recordLimitOptName        = "limit"
+	widthsOptName             = "widths"
+	keepPaddingOptName        = "keep-padding"
+	strictWidthsOptName       = "strict-widths"
+	truncateOptName           = "truncate"
+	alignNumbersOptName       = "align-numbers"
+	outputDirOptName          = "output-dir"
+	nameTemplateOptName       = "name-template"
+	dryRunOptName             = "dry-run"
+	pathsOptName              = "paths P"
+	collationOptName          = "collation"
 	inputName                 = "INPUT"
 	outputName                = "OUTPUT"
 
-	inputTypeDesc  = "input format"
+	pathsDesc      = "only transform the values at these comma-separated paths (e.g. 'a.b[0],c.*')"
+	inputTypeDesc  = "input format (or a comma-separated list of formats to try in order)"
+	sniffDesc      = "detect the input format from the content rather than the file extension"
+	formatHintDesc = "the input format used if neither the extension nor the content identifies it, e.g. lines"
 	outputTypeDesc = "output format"
 	inputDesc      = "input file (or stdin if not provided)"
 	outputDesc     = "output file (or stdout if not provided)"
 	verboseDesc    = "produce slightly more verbose output"
+	quietDesc      = "do not print error messages, only exit with the error code"
 )
 
 var (
 	prettyPrintDesc = "[" +
 		formatNameJSON + "," + formatNameYAML + "," + formatNameTOML +
 		"] produce humand-friendly output"
-	fieldDelimDesc         = "[" + formatNameCSF + "] field delimiter"
-	recordDelimDesc        = "[" + formatNameCSF + "] record delimiter"
-	stringToJSONNumberDesc = "[" + formatNameCSF + "," + formatNameINI + "] " +
-		`attempts to convert strings to JSON
+	multiDocumentDesc = "[" + formatNameYAML + "] write the elements of a top-level array as separate documents"
+	yamlDocStartDesc  = "[" + formatNameYAML + "] start the output with '---'"
+	yamlNullStyleDesc = "[" + formatNameYAML + "] how to write nulls (" + YAMLNullsEmpty + ", " + YAMLNullsNull + ", " +
+		YAMLNullsTilde + "), by default null unless the input is kept with --preserve-comments"
+	yamlQuoteStringsDesc = "[" + formatNameYAML + "] double-quote all strings (including keys) so that no parser reads them as other types"
+	outputBufferSizeDesc = "the size of the output buffer in bytes (0 to write directly)"
+	tomlArraysDesc       = "[" + formatNameTOML + "] array style (" + strings.Join(tomlArrayStyles, ", ") + ")"
+	defaultKeyDesc       = "[" + formatNameTOML + "," + formatNameINI + "," + formatNameEnv + "] the key data that is not an object is written under (`_` if empty)"
+	iniNestingDesc       = "[" + formatNameINI + "] how to write objects nested in sections (" +
+		strings.Join(iniNestingPolicies, ", ") + ")"
+	iniArraysDesc = "[" + formatNameINI + "] array style (" + strings.Join(iniArrayStyles, ", ") + ")"
+	envArraysDesc = "[" + formatNameEnv + "," + formatNameShell + "] array style (" + strings.Join(envArrayStyles, ", ") + ")"
+	columnsDesc   = "[" + formatNameCSF + "] comma-separated columns of the output (and its header with --header), " +
+		"or NAME:WIDTH columns of " + formatNameFixed + " input"
+	dottedKeysDesc       = "[" + formatNameCSF + "] write nested objects of a top-level object as rows with keys joined by dots"
+	alsoOutputDesc       = "also write the result to this file (repeatable), in the format of its extension or FILE:FORMAT"
+	verifyDesc           = "read the output back and fail if it differs from the data written"
+	appendDesc           = "append the result (an array) to the array in the output file instead of replacing the file"
+	appendWrapDesc       = "with --append, treat values that are not arrays as arrays of one element"
+	atDesc               = "only write the value at this path, e.g. items (wildcards write an array of all matches)"
+	limitKeysDesc        = "only keep the first N keys of objects and elements of arrays, or the first N,M,... at each level (0 for all)"
+	limitMarkerDesc      = "with --" + limitKeysOptName + ", add this element to truncated arrays and key (with the number of keys left out) to truncated objects"
+	outputBOMDesc        = "start the output with a UTF-8 byte order mark"
+	excelDesc            = "[" + formatNameCSF + "] write for Excel (with a byte order mark, CRLF and, for decimal comma locales, semicolons)"
+	verifyEpsilonDesc    = "the difference allowed between numbers with --verify"
+	requireDesc          = "comma-separated paths that must be present in the input (the conversion fails otherwise)"
+	resolveRefsDesc      = "replace $ref objects pointing into the input (e.g. #/components/schemas/User) by a copy of the value"
+	externalRefsDesc     = "like --" + resolveRefsOptName + " but also resolve $ref to other files (relative to the input file)"
+	maxRefDepthDesc      = "fail if $ref are nested more than this many references deep (no limit if 0)"
+	debugDumpDesc        = "write the data after reading and before writing it to this file (as two indented JSON documents)"
+	debugDesc            = "log each step of the transformation (with the type and size of the data before and after it) to stderr"
+	formatOptionDesc     = "set an option of a format as FORMAT:OPTION=VALUE (repeatable), e.g. yaml:indentation=4"
+	perDocumentDesc      = "transform each document of " + formatNameYAML + " input on its own and write them as separate documents (or records)"
+	inPlaceDesc          = "replace the input file with the result"
+	requireMatchDesc     = "fail if no value matches the path"
+	timingsDesc          = "report the time and allocated memory of each stage of the conversion on stderr"
+	preserveCommentsDesc = "[" + formatNameYAML + "] keep the comments of " + formatNameYAML + " input (if the transformation supports it)"
+	nonFiniteDesc        = "[" + formatNameJSON + "," + formatNameNDJSON + "] what to do with infinities and NaN (" +
+		strings.Join(nonFinitePolicies, ", ") + ")"
+	floatStyleDesc = "[" + formatNameJSON + "," + formatNameNDJSON + "," + formatNameYAML + "," + formatNameTOML +
+		"] notation of floats (" + strings.Join(floatStyles, ", ") + ")"
+	floatPrecisionDesc = "[" + formatNameJSON + "," + formatNameNDJSON + "," + formatNameYAML + "," + formatNameTOML +
+		"] number of decimals of floats (negative for as many as necessary)"
+	parallelDesc      = "transform the elements of a top-level array with this many goroutines"
+	asciiDesc         = "[" + formatNameJSON + "," + formatNameNDJSON + "] escape all non-ASCII characters (\\uXXXX)"
+	jsSafeNumbersDesc = "[" + formatNameJSON + "," + formatNameNDJSON + "] write integers beyond ±2^53 as strings for JavaScript"
+	fieldDelimDesc    = "[" + formatNameCSF + "] field delimiter"
+	recordDelimDesc   = "[" + formatNameCSF + "] record delimiter"
+	defineDelimDesc   = "[" + formatNameCSF + "] define a named delimiter as NAME=DELIMITER (repeatable)"
+	escapeDelimDesc   = "[" + formatNameCSF + "] interpret escapes such as \\t or \\x1f in all delimiters " +
+		"(not only in those starting with a backslash)"
+	skipRowsDesc = "[" + formatNameStrings + "," + formatNameNTStr + "," + formatNameCSF + "," + formatNameFixed + "] " +
+		"number of records to discard before any other processing (including the header)"
+	headerDesc        = "[" + formatNameCSF + "," + formatNameFixed + "] use the first record as field names and produce objects"
+	preserveOrderDesc = "[" + formatNameCSF + "," + formatNameFixed + "] keep the column order for objects created from a header (" +
+		formatNameJSON + "," + formatNameYAML + " output)"
+	collationDesc    = "the order of strings (" + strings.Join(collationNames(), ", ") + ")"
+	headerRenameDesc = "[" + formatNameCSF + "] rename header fields (comma-separated OLD=NEW pairs), requires --header"
+	inlineDataDesc   = "use this value as the input (" + formatNameJSON + " unless the input format is " +
+		"given) or read the file for @FILE"
+	duplicateKeysDesc = "how to handle keys occurring more than once in an object (" +
+		strings.Join(duplicateKeyPolicies, ", ") + "), by default as the format's parser does"
+	envPrefixDesc = "[" + formatNameEnv + "] only read variables with this prefix (which is removed), " +
+		"or write variables with it"
+	iniValueDelimDesc    = "[" + formatNameINI + "] split values at this delimiter into arrays (with surrounding spaces removed)"
+	keepEmptyDefaultDesc = "[" + formatNameINI + "] keep an empty [default] section as an empty object (under the default key)"
+	envNestDesc          = "[" + formatNameEnv + "] create nested objects for variable names containing '__'"
+	keepGoingDesc        = "[" + formatNameNDJSON + "," + formatNameCSF + "," + formatNameFixed + "] skip records that cannot be read " +
+		"or transformed (with a warning, or without one and exiting with 0 for --" + keepGoingOptName + "=silent)"
+	skipRecordsDesc = "[" + formatNameNDJSON + "," + formatNameStrings + "," + formatNameNTStr + "," + formatNameCSF + "," + formatNameFixed + "] " +
+		"number of records to discard (after --" + skipRowsOptName + " and the header)"
+	recordLimitDesc = "[" + formatNameNDJSON + "," + formatNameStrings + "," + formatNameNTStr + "," + formatNameCSF + "," + formatNameFixed + "] " +
+		"read at most this many records (after those skipped, all if 0)"
+	widthsDesc = "[" + formatNameFixed + "] comma-separated widths of the fields in characters (or NAME:WIDTH pairs), " +
+		"by default those of the widest values for output"
+	keepPaddingDesc  = "[" + formatNameFixed + "] keep the spaces padding fields"
+	strictWidthsDesc = "[" + formatNameFixed + "] reject lines longer than the fields (instead of adding the rest to the last field)"
+	truncateDesc     = "[" + formatNameFixed + "] cut values longer than their column (instead of failing)"
+	alignNumbersDesc = "[" + formatNameFixed + "] right-align columns of numbers"
+	outputDirDesc    = "the directory to write the outputs to"
+	nameTemplateDesc = "the output file names as a Go template with the fields " +
+		".Key, .Index, .Input, .InputBase, .Ext and the function .Field \"path\""
+	dryRunDesc             = "only print the output file names"
+	maxErrorsDesc          = "[" + formatNameNDJSON + "," + formatNameCSF + "," + formatNameFixed + "] skip at most this many records that cannot be read"
+	stringToJSONNumberDesc = `attempts to convert strings to JSON
 Numbers (64-bit signed or finite double floats)`
+	coerceBoolsDesc  = "convert the strings of --" + trueStringsOptName + " and --" + falseStringsOptName + " to booleans"
+	trueStringsDesc  = "comma-separated strings read as true with --" + coerceBoolsOptName + " (in any case)"
+	falseStringsDesc = "comma-separated strings read as false with --" + coerceBoolsOptName + " (in any case)"
+	typesDesc        = "a file mapping paths (wildcards allowed) to the types their values are coerced to (" + strings.Join(coercibleTypes, ", ") + ")"
+	typesLenientDesc = "keep values that cannot be coerced to their type of --" + typesOptName + " (rather than failing)"
+	strictParseDesc  = "fail if fields of records stay strings in columns of mostly other types with --" +
+		strings.Split(stringTo64bfNumberOptName, " ")[0] + " or --" + coerceBoolsOptName
 )
 
 var (
 	inputFormats = []string{
 		formatNameJSON, formatNameYAML, formatNameTOML,
 		formatNameStrings, formatNameNTStr, formatNameCSF,
-		formatNameINI,
+		formatNameINI, formatNameNDJSON, formatNameEnv,
+		formatNameFixed,
 		autoFormat}
 	outputFormats = []string{
 		formatNameJSON, formatNameYAML, formatNameTOML,
+		formatNameNDJSON, formatNameINI, formatNameCSF,
+		formatNameEnv, formatNameShell, formatNameFixed,
 		autoFormat}
 	inputFormatsList  = strings.Join(inputFormats, ", ")
 	outputFormatsList = strings.Join(outputFormats, ", ")
@@ -63,9 +241,17 @@ Supported output format values:
     %s
 
 Strings are EOL-separated strings, %s are null-terminated strings. 
+%s (JSON Lines) files contain one JSON value per line and are read as
+an array.
+
+%s reads the process environment if the input is stdin (or a file of
+NAME=VALUE lines otherwise) as an object of strings, see --%s and --%s.
+It writes the leaves of the data as NAME="VALUE" lines, quoted for shells,
+with nested keys joined with '__'. %s output adds export and upper case.
 
-%s represents ".ini" files with case-insensitive keys. Settings outside 
-any section are added to a '_' section. This section is omitted if empty.
+%s represents ".ini" (as well as ".cfg" and ".conf") files with 
+case-insensitive keys. Settings outside any section, as is common for 
+".conf" files, are added to a '_' section. This section is omitted if empty.
 
 Character-separated fields (CSFs) can be imported by specifying the field
 and record separators. Unlike many CSV parsers, this tool applies no special 
@@ -73,15 +259,27 @@ escaping or treatment for variable field counts. Special character names:
 NL (new line), CR (carriage return), LF (line feed), NUL (\x00), or 
 TAB (tabulator).
 
+Presets read system files with fields in a fixed order as objects with
+their canonical field names: %s, e.g. -i passwd for /etc/passwd.
+
+Text inputs are processed in this order: records are split, --skip-rows
+records are discarded, then the header (if any) is consumed. With
+--header, the first remaining CSF record provides the field names and every
+following record becomes an object. Header fields can be renamed with
+--header-rename "First Name=first_name,Age=age".
+
 The behaviour of CSFs configured without a field delimiter and with NL or NUL
 is undefined. It may behave like lines or null-terminated strings but this
 may change at any time and may not be consistent across subcommands. 
 
+%s output of arrays can be written as one document per element with
+--multidoc, the inverse of reading multi-document files.
+
 %s output of anything but maps and objects is added to a global key '_' 
 as a key is required.
 
-For %s and %s, 64-bit signed integer and finite float conversions are 
-attempted if the '--%s' option is given, otherwise the 
+For any input format, 64-bit signed integer and finite float conversions
+of strings are attempted if the '--%s' option is given, otherwise the 
 string representation is kept (see README.md for details).
 This may result in larger numbers being rounded to a 64-bit float
 representation.
@@ -90,22 +288,101 @@ Arbitrarily large numbers are not currently supported. Suggestions
 and code contributions for dealing with them across formats are welcome .`,
 		inputFormatsList, outputFormatsList,
 		formatNameNTStr,
+		formatNameNDJSON,
+		formatNameEnv, envPrefixOptName, envNestOptName, formatNameShell,
 		formatNameINI,
+		strings.Join(formatPresetNames(), ", "),
+		formatNameYAML,
 		formatNameTOML,
-		formatNameINI, formatNameCSF, strings.Split(stringTo64bfNumberOptName, " ")[0])
+		strings.Split(stringTo64bfNumberOptName, " ")[0])
 )
 
 // CLI option and argument values
 var (
 	prettyPrint        bool   = false
 	inputType          string = autoFormat
+	sniff              bool   = false
+	inputFormatHint    string = ""
 	outputType         string = autoFormat
 	stringToJSONNumber bool   = false
+	coerceBools        bool   = false
+	trueStrings        string = strings.Join(DefaultTrueStrings, ",")
+	falseStrings       string = strings.Join(DefaultFalseStrings, ",")
+	strictParse        bool   = false
+	typesFile          string = ""
+	typesLenient       bool   = false
 	fieldDelim         string = ","
 	recordDelim        string = "NL"
+	multiDocument      bool   = false
+	yamlDocStart       bool   = false
+	yamlNullStyle      string = ""
+	yamlQuoteStrings   bool   = false
+	tomlArrays         string = TOMLArraysAuto
+	defaultKey         string = ""
+	iniNesting         string = ININestingError
+	iniArrays          string = INIArraysRepeat
+	envArrays          string = EnvArraysIndexed
+	columns            string = ""
+	dottedKeys         bool   = false
+	jsSafeNumbers      bool   = false
+	nonFinite          string = NonFiniteError
+	floatStyle         string = FloatStyleAuto
+	floatPrecision     int    = -1
+	asciiOnly          bool   = false
+	parallelism        int    = 1
+	elementWise        bool   = false
+	keepComments       bool   = false
+	verify             bool   = false
+	appendOutput       bool   = false
+	appendWrap         bool   = false
+	atPath             string = ""
+	limitKeys          string = ""
+	limitMarker        string = ""
+	outputBOM          bool   = false
+	excelOutput        bool   = false
+	verifyEpsilon      float64
+	alsoOutputs        []string
+	timings            bool = false
+	requiredPaths      string
+	resolveRefs        bool = false
+	externalRefs       bool = false
+	maxRefDepth        int
+	debugDumpFile      string
+	debugSteps         bool = false
+	formatOptions      []string
+	perDocument        bool = false
+	inPlace            bool = false
+	requireMatch       bool = false
+	edited             *editReport
+	recordedDump       *stageDump
+	recordedTimings           = &stageTimings{}
+	inlineData         string = ""
+	inlineDataSet      bool   = false
+	duplicateKeys      string = DuplicateKeysDefault
+	envPrefix          string = ""
+	envNest            bool   = false
+	iniValueDelim      string = ""
+	keepEmptyDefault   bool   = false
+	keepGoing          bool   = false
+	keepGoingSilent    bool   = false
+	maxErrors          int    = 0
+	skippedRecords     int    = 0
+	skippedRecordsLock sync.Mutex
+	skipRows           int    = 0
+	skipRecords        int    = 0
+	recordLimit        int    = 0
+	widths             string = ""
+	keepPadding        bool   = false
+	strictWidths       bool   = false
+	truncate           bool   = false
+	alignNumbers       bool   = false
+	header             bool   = false
+	headerRename       string = ""
+	preserveOrder      bool   = false
 	input              string = ""
 	output             string = ""
 	verbose            bool   = false
+	quiet              bool   = false
 )
 
 func main() {
@@ -118,26 +395,56 @@ func main() {
 func configureApp() *mowcli.Cli {
 	var app = mowcli.App(appName, "A data file multi-tool.")
 	app.LongDesc = toolLongDescription
+	app.BoolOptPtr(&quiet, quietOptName, false, quietDesc)
+	app.Before = func() { elementWise = false }
 
 	app.Command("convert",
 		"Converts data files.\n\n"+"Also see `"+appName+" --help` for details.",
 		func(cmd *mowcli.Cmd) {
-			cmd.BoolOptPtr(&prettyPrint, prettyPrintOptName, false, prettyPrintDesc)
-			cmd.StringOptPtr(&inputType, inputTypeOptName, autoFormat, inputTypeDesc)
-			cmd.StringOptPtr(&outputType, outputTypeOptName, autoFormat, outputTypeDesc)
-			cmd.StringOptPtr(&fieldDelim, fieldDelimOptName, ",", fieldDelimDesc)
-			cmd.StringOptPtr(&recordDelim, recordDelimOptName, "NL", recordDelimDesc)
-			cmd.BoolOptPtr(&stringToJSONNumber, stringTo64bfNumberOptName, false, stringToJSONNumberDesc)
-			cmd.StringArgPtr(&input, inputName, "", inputDesc)
-			cmd.StringArgPtr(&output, outputName, "", outputDesc)
+			cmd.BoolOptPtr(&verbose, verboseOptName, false, verboseDesc)
+			configureElementWiseOptions(cmd)
+			configureConversionOptions(cmd)
+			cmd.StringsOptPtr(&alsoOutputs, alsoOutputOptName, nil, alsoOutputDesc)
+			cmd.BoolOptPtr(&appendOutput, appendOptName, false, appendDesc)
+			cmd.BoolOptPtr(&appendWrap, appendWrapOptName, false, appendWrapDesc)
+			cmd.StringOptPtr(&atPath, atOptName, "", atDesc)
+			cmd.StringOptPtr(&limitKeys, limitKeysOptName, "", limitKeysDesc)
+			cmd.StringOptPtr(&limitMarker, limitMarkerOptName, "", limitMarkerDesc)
+			cmd.Spec = "[OPTIONS] [INPUT] [OUTPUT] [--also-output=<FILE>]..."
+
+			cmd.Action = func() {
+				var transformer Transformer
+				if atPath != "" {
+					parsed, err := ParsePath(atPath)
+					if err != nil {
+						exit(exitConfigurationError, err.Error())
+					}
+					transformer = ExtractTransformer{Path: parsed}
+				}
+				if appendOutput {
+					appendToOutput(transformer)
+				} else {
+					runConversion(transformer)
+				}
+			}
+		})
 
-			cmd.Spec = "[OPTIONS] [INPUT] [OUTPUT]"
+	app.Command("fmt",
+		"Reformats data files.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				write = cmd.BoolOpt("write w", false, "replace the input file with the result")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Like convert, but the output format defaults to the format of the input."
 
 			cmd.Action = func() {
-				inputFormat, transformer, outputFormat := configureFormats()
-				err := ConvertFile(input, inputFormat, transformer, output, outputFormat)
-				if err != nil {
-					exit(exitTransformError, err.Error())
+				c := commandConversion()
+				c.defaultToInputFormat()
+				if *write {
+					rewriteInput(c, nil)
+				} else {
+					convertInput(c, nil)
 				}
 			}
 		})
@@ -145,67 +452,2541 @@ func configureApp() *mowcli.Cli {
 	app.Command("remove-nulls",
 		"Converts data files and removes 'null' entries.",
 		func(cmd *mowcli.Cmd) {
-			cmd.BoolOptPtr(&prettyPrint, prettyPrintOptName, false, prettyPrintDesc)
-			cmd.StringOptPtr(&inputType, inputTypeOptName, autoFormat, inputTypeDesc)
-			cmd.StringOptPtr(&outputType, outputTypeOptName, autoFormat, outputTypeDesc)
-			cmd.StringOptPtr(&fieldDelim, fieldDelimOptName, ",", fieldDelimDesc)
-			cmd.StringOptPtr(&recordDelim, recordDelimOptName, "NL", recordDelimDesc)
-			cmd.BoolOptPtr(&stringToJSONNumber, stringTo64bfNumberOptName, false, stringToJSONNumberDesc)
 			var (
 				rmValues   = cmd.BoolOpt("values v", false, "remove key-value pairs whose value is null")
 				rmElements = cmd.BoolOpt("elements e", false, "remove array elements that are null")
+				rmZero     = cmd.BoolOpt("remove-zero", false, "remove key-value pairs whose value is a zero value")
+				zero       = cmd.StringOpt("zero-kinds", strings.Join(zeroKinds, ","),
+					"comma-separated kinds of zero values to remove ("+strings.Join(zeroKinds, ", ")+")")
 			)
-			cmd.StringArgPtr(&input, inputName, "", inputDesc)
-			cmd.StringArgPtr(&output, outputName, "", outputDesc)
-
-			cmd.Spec = "[OPTIONS] [INPUT] [OUTPUT]"
-			cmd.LongDesc = "If none of the removal options are provided, a simple format conversion is performed."
+			configureElementWiseOptions(cmd)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "If none of the removal options are provided, a simple format conversion is performed. " +
+				"Zero values (0, \"\", false, and empty objects and arrays) are removed after nulls, " +
+				"so objects that only contained nulls are removed as well."
 
 			cmd.Action = func() {
-				inputFormat, transformer, outputFormat := configureFormats()
-				transformer = NewMultiTransformer(transformer, NilRemovalTransformer{
+				var transformer Transformer = NilRemovalTransformer{
 					RemoveNilKeys:     false,
 					RemoveNilValues:   *rmValues,
 					RemoveNilElements: *rmElements,
-				})
-				err := ConvertFile(input, inputFormat, transformer, output, outputFormat)
+				}
+				if *rmZero {
+					kinds, err := ParseZeroKinds(*zero)
+					if err != nil {
+						exit(exitConfigurationError, err.Error())
+					}
+					transformer = NewMultiTransformer(transformer, RemoveZeroValuesTransformer{Kinds: kinds})
+				}
+				runConversion(transformer)
+			}
+		})
+
+	app.Command("apply-defaults",
+		"Converts data files and fills in missing keys from a defaults file.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				defaultsFile   = cmd.StringOpt("defaults d", "", "the defaults file (any supported input format)")
+				defaultsFormat = cmd.StringOpt("defaults-format", autoFormat, "the format of the defaults file")
+			)
+			configureConversionOptions(cmd)
+			cmd.Spec = "--defaults [OPTIONS] [INPUT] [OUTPUT]"
+			cmd.LongDesc = "Maps are merged recursively, values in the input take precedence over defaults."
+
+			cmd.Action = func() {
+				defaults, err := readAuxiliaryFile(*defaultsFile, *defaultsFormat)
+				if err != nil {
+					exit(exitInputError, err.Error())
+				}
+				runConversion(DefaultsTransformer{Defaults: defaults})
+			}
+		})
+
+	app.Command("merge",
+		"Merges several data files into one.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				onConflict = cmd.StringOpt("on-conflict", MergeLast, "what to do with different values at the same path ("+
+					strings.Join(mergeConflictPolicies, ", ")+")")
+				arrays = cmd.StringOpt("arrays", ArraysDefault, "how to combine arrays at the same path ("+
+					strings.Join(mergeArrayModes, ", ")+"), by default as implied by --on-conflict")
+				annotateSource = cmd.BoolOpt("annotate-source", false, "list the files contributing to each top-level key in '"+
+					mergeSourcesKey+"'")
+				files = cmd.StringsArg(inputName, nil, "input files (`-` for stdin)")
+			)
+			configureInputOptions(cmd)
+			configureOutputOptions(cmd)
+			cmd.Spec = "[OPTIONS] INPUT..."
+			cmd.LongDesc = "Objects are merged recursively, later files take precedence unless " +
+				"--on-conflict says otherwise: " + MergeFirst + " keeps the first value, " + MergeError +
+				" fails on different values, " + MergeConcatArrays + " and " + MergeDeep + " concatenate " +
+				"arrays or merge them element by element (and otherwise keep the last value). Each file " +
+				"can have its own format, the output is written to stdout in the format of the first file " +
+				"unless another output format is requested."
+
+			cmd.Action = func() {
+				sources := make([]MergeSource, len(*files))
+				for n, file := range *files {
+					data, err := readInputFile(file)
+					if err != nil {
+						exit(exitInputError, fmt.Sprintf("%s: %s", file, err))
+					}
+					sources[n] = MergeSource{Name: file, Data: data}
+				}
+				merged, err := Merger{OnConflict: *onConflict, Arrays: *arrays, AnnotateSource: *annotateSource}.Merge(sources)
 				if err != nil {
 					exit(exitTransformError, err.Error())
 				}
+				writer := newOutputBuffer(os.Stdout)
+				err = outputFormatFor((*files)[0]).Marshal(merged, writer)
+				if ferr := writer.Flush(); err == nil {
+					err = ferr
+				}
+				if err != nil {
+					exit(exitCodeFor(err, exitOutputError), err.Error())
+				}
+				reportSkippedRecords()
 			}
 		})
 
-	app.Command("version", "Prints the application version.", func(cmd *mowcli.Cmd) {
-		cmd.BoolOptPtr(&verbose, verboseOptName, false, verboseDesc)
-		cmd.Action = func() {
-			if verbose {
-				os.Stderr.WriteString(fmt.Sprintf("version %s (%s)\n", Version, tag))
+	app.Command("del",
+		"Deletes the values at a path.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				path = cmd.StringArg("PATH", "", "the path of the values (e.g. '**.metadata.managedFields' or 'users[].password')")
+			)
+			configureEditOptions(cmd)
+			cmd.Spec = "[OPTIONS] PATH [INPUT] [OUTPUT]"
+			cmd.LongDesc = editLongDesc
 
-			} else {
-				os.Stderr.WriteString(fmt.Sprintf("version %s\n", Version))
+			cmd.Action = func() {
+				edited = &editReport{Verb: "deleted", Path: editPath(*path)}
+				runEdit(DeletePathTransformer{Path: edited.Path, Matches: &edited.Matches})
+			}
+		})
+
+	app.Command("set",
+		"Sets the values at a path.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				path  = cmd.StringArg("PATH", "", "the path of the values (e.g. 'spec.template.**.image')")
+				value = cmd.StringArg("VALUE", "", "the value (as YAML, e.g. 1, true, 'a b' or '{a: 1}')")
+			)
+			configureEditOptions(cmd)
+			cmd.Spec = "[OPTIONS] PATH VALUE [INPUT] [OUTPUT]"
+			cmd.LongDesc = editLongDesc + " Without wildcards, a missing last key is added to its object."
+
+			cmd.Action = func() {
+				parsed, err := YAMLFormat{}.Unmarshal(strings.NewReader(*value))
+				if err != nil {
+					exit(exitConfigurationError, fmt.Sprintf("the value is not valid YAML: %s", err))
+				}
+				edited = &editReport{Verb: "set", Path: editPath(*path)}
+				runEdit(SetPathTransformer{Path: edited.Path, Value: parsed, Matches: &edited.Matches})
+			}
+		})
+
+	app.Command("redact",
+		"Replaces the values at a path by a placeholder.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				path = cmd.StringArg("PATH", "", "the path of the values (e.g. 'users[].password')")
+				with = cmd.StringOpt("with", "REDACTED", "the string replacing the values")
+			)
+			configureEditOptions(cmd)
+			cmd.Spec = "[OPTIONS] PATH [INPUT] [OUTPUT]"
+			cmd.LongDesc = editLongDesc
 
+			cmd.Action = func() {
+				edited = &editReport{Verb: "redacted", Path: editPath(*path)}
+				runEdit(SetPathTransformer{Path: edited.Path, Value: *with, Existing: true, Matches: &edited.Matches})
 			}
-		}
-	})
+		})
 
-	return app
-}
+	app.Command("get",
+		"Extracts the value at a path.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				path = cmd.StringArg("PATH", "", "the path of the value (wildcards extract an array of all matches)")
+			)
+			configureConversionOptions(cmd)
+			cmd.Spec = "[OPTIONS] PATH [INPUT] [OUTPUT]"
+			cmd.LongDesc = "For " + formatNameJSON + " input, the value is decoded from the token stream " +
+				"without decoding the rest of the document, unless the path has wildcards or " +
+				"--" + requireOptName + ", --" + typesOptName + ", --" + resolveRefsOptName + " or --" + perDocumentOptName + " need the whole document."
 
-// Create formats and the default (import) transformer based
-// on command line arguments.
-func configureFormats() (InputFormat, Transformer, OutputFormat) {
-	inputFormat, err := NewInputFormat(input, inputType, fieldDelim, recordDelim)
-	if err != nil {
-		exit(exitConfigurationError, err.Error())
-	}
-	outputFormat, err := NewOutputFormat(output, outputType, prettyPrint)
-	if err != nil {
-		exit(exitConfigurationError, err.Error())
+			cmd.Action = func() {
+				parsed, err := ParsePath(*path)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				runConversion(ExtractTransformer{Path: parsed})
+			}
+		})
+
+	app.Command("freq",
+		"Counts the occurrences of distinct values.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				path = cmd.StringOpt("path", "", "the path of the values to count, e.g. 'items[].status' "+
+					"(the elements of a top-level array if empty)")
+				top = cmd.IntOpt("top", 0, "only list this many of the most frequent values (0 for all)")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "The result maps each value (non-strings as compact JSON, e.g. null) " +
+				"to its count, the most frequent first (in " + formatNameJSON + " and " +
+				formatNameYAML + " output)."
+
+			cmd.Action = func() {
+				parsed, err := ParsePath(*path)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				runConversion(FrequencyTransformer{Path: parsed, Top: *top})
+			}
+		})
+
+	app.Command("group-by",
+		"Groups the elements of a top-level array by the value of a field.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				field      = cmd.StringArg("FIELD", "", "the (dotted) path of the field to group by")
+				missingKey = cmd.StringOpt("missing-key", "", "collect elements without the field under this key (dropped if empty), which must not be a value of the field")
+				count      = cmd.BoolOpt("count", false, "output the number of elements per group instead of the elements")
+			)
+			configureConversionOptions(cmd)
+			cmd.Spec = "[OPTIONS] FIELD [INPUT] [OUTPUT]"
+			cmd.LongDesc = "String values are used as group keys as they are, " +
+				"all other values are converted to compact JSON."
+
+			cmd.Action = func() {
+				path, err := ParsePath(*field)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				runConversion(GroupByTransformer{Path: path, MissingKey: *missingKey, Count: *count})
+			}
+		})
+
+	app.Command("unique-by",
+		"Removes elements of a top-level array with duplicate values of a field.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				field      = cmd.StringArg("FIELD", "", "the (dotted) path of the field identifying elements")
+				last       = cmd.BoolOpt("last", false, "keep the last instead of the first element for each value")
+				requireKey = cmd.BoolOpt("require-key", false, "drop elements without the field")
+			)
+			cmd.BoolOptPtr(&verbose, verboseOptName, false, verboseDesc)
+			configureConversionOptions(cmd)
+			cmd.Spec = "[OPTIONS] FIELD [INPUT] [OUTPUT]"
+			cmd.LongDesc = "The remaining elements keep the order of the first occurrence of their value."
+
+			cmd.Action = func() {
+				path, err := ParsePath(*field)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				removed := 0
+				runConversion(UniqueByTransformer{Path: path, KeepLast: *last, RequireKey: *requireKey, Removed: &removed})
+				if verbose {
+					os.Stderr.WriteString(fmt.Sprintf("removed %d element(s)\n", removed))
+				}
+			}
+		})
+
+	app.Command("enforce-types",
+		"Rejects data containing values of types that are not allowed.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				allow = cmd.StringOpt("allow", "", "comma-separated allowed types ("+strings.Join(valueTypeNames, ", ")+")")
+			)
+			configureElementWiseOptions(cmd)
+			configureConversionOptions(cmd)
+			cmd.Spec = "--allow [OPTIONS] [INPUT] [OUTPUT]"
+			cmd.LongDesc = "Maps and arrays are always allowed. Numbers without a fractional part " +
+				"are ints, even if the format does not distinguish them. The data is written " +
+				"unchanged if all values are allowed."
+
+			cmd.Action = func() {
+				allowed, err := ParseValueTypes(*allow)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				runConversion(TypeWhitelistTransformer{Allowed: allowed})
+			}
+		})
+
+	app.Command("join",
+		"Joins the objects of two top-level arrays on a key.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				on          = cmd.StringOpt("on", "", "the (dotted) path of the key in the left input")
+				rightOn     = cmd.StringOpt("right-on", "", "the path of the key in the right input (defaults to --on)")
+				kind        = cmd.StringOpt("kind", JoinInner, "the kind of join ("+JoinInner+", "+JoinLeft+", "+JoinOuter+")")
+				rightType   = cmd.StringOpt("right-type", autoFormat, "the format of the right input")
+				rightPrefix = cmd.StringOpt("right-prefix", "", "prefix for right field names colliding with left ones")
+				rightSuffix = cmd.StringOpt("right-suffix", "_right", "suffix for right field names colliding with left ones")
+			)
+			configureInputOptions(cmd)
+			configureOutputOptions(cmd)
+			cmd.StringArgPtr(&input, "LEFT", "", "the left input file (`-` for stdin)")
+			right := cmd.StringArg("RIGHT", "", "the right input file")
+			cmd.StringArgPtr(&output, outputName, "", outputDesc)
+			cmd.Spec = "--on [OPTIONS] LEFT RIGHT [OUTPUT]"
+			cmd.LongDesc = "Both inputs must be arrays of objects, keys are compared as strings " +
+				"so that values read from text formats match numbers. The input options apply " +
+				"to both files (e.g. --header for " + formatNameCSF + " files)."
+
+			cmd.Action = func() {
+				leftPath, err := ParsePath(*on)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				rightPath := leftPath
+				if *rightOn != "" {
+					rightPath, err = ParsePath(*rightOn)
+					if err != nil {
+						exit(exitConfigurationError, err.Error())
+					}
+				}
+				rightData, err := readAuxiliaryFile(*right, *rightType)
+				if err != nil {
+					exit(exitInputError, err.Error())
+				}
+				runConversion(JoinTransformer{
+					Right:       rightData,
+					LeftPath:    leftPath,
+					RightPath:   rightPath,
+					Kind:        strings.ToLower(*kind),
+					RightPrefix: *rightPrefix,
+					RightSuffix: *rightSuffix,
+				})
+			}
+		})
+
+	app.Command("sort-keys",
+		"Sorts the keys of all objects.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				collation = cmd.StringOpt(collationOptName, CollationBytes, collationDesc)
+			)
+			configureElementWiseOptions(cmd)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "The order is kept by " + formatNameJSON + " and " + formatNameYAML + " output, " +
+				"even for keys read in a different order (e.g. with --preserve-order)."
+
+			cmd.Action = func() {
+				less, err := NewCollation(*collation)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				runConversion(SortKeysTransformer{Less: less})
+			}
+		})
+
+	app.Command("sort-arrays",
+		"Sorts the elements of arrays.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				collation = cmd.StringOpt(collationOptName, CollationBytes, collationDesc)
+				paths     = cmd.StringOpt(pathsOptName, "", pathsDesc)
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Nulls come first, then booleans, numbers, strings and datetimes, " +
+				"objects and arrays last in their original order."
+
+			cmd.Action = func() {
+				less, err := NewCollation(*collation)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				runConversion(scopedTransformer(*paths, SortArraysTransformer{Less: less}))
+			}
+		})
+
+	app.Command("transpose",
+		"Swaps the rows and columns of an array of arrays.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				path   = cmd.StringOpt("path", "", "the path of the array to transpose (the top-level array if empty)")
+				strict = cmd.BoolOpt("strict", false, "fail for rows of different lengths instead of padding them with nulls")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "An array of objects (e.g. " + formatNameCSF + " read with --header) becomes " +
+				"an object mapping each key to the column of its values."
+
+			cmd.Action = func() {
+				parsed, err := ParsePath(*path)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				runConversion(TransposeTransformer{Path: parsed, Strict: *strict})
+			}
+		})
+
+	app.Command("annotate-index",
+		"Adds the position of each element of an array to the element.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				path     = cmd.StringOpt("path", "", "the path of the array (the top-level array if empty)")
+				key      = cmd.StringOpt("key", "_index", "the key of the index in each element")
+				valueKey = cmd.StringOpt("wrap", "", "wrap elements that are not objects, with the value under this key")
+				strip    = cmd.BoolOpt("strip", false, "remove the index (and unwrap wrapped elements) instead")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Elements that are not objects are left alone unless --wrap is given. " +
+				"Objects that already have the key are an error."
+
+			cmd.Action = func() {
+				parsed, err := ParsePath(*path)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				runConversion(IndexAnnotateTransformer{Path: parsed, Key: *key, ValueKey: *valueKey, Strip: *strip})
+			}
+		})
+
+	app.Command("zip",
+		"Combines an array of keys and an array of values by position.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				pairs        = cmd.BoolOpt("pairs", false, "produce an array of [key, value] pairs instead of an object")
+				pad          = cmd.BoolOpt("pad", false, "pad the shorter array with nulls instead of failing")
+				valuesFormat = cmd.StringOpt("values-format", autoFormat, "the format of the values file")
+			)
+			configureInputOptions(cmd)
+			configureOutputOptions(cmd)
+			cmd.StringArgPtr(&input, "KEYS", "", "the file with the array of keys (`-` for stdin)")
+			values := cmd.StringArg("VALUES", "", "the file with the array of values")
+			cmd.StringArgPtr(&output, outputName, "", outputDesc)
+			cmd.Spec = "[OPTIONS] KEYS VALUES [OUTPUT]"
+			cmd.LongDesc = "Keys that are not strings are converted to compact JSON for objects. " +
+				"Objects cannot have missing keys, so only values are padded for them."
+
+			cmd.Action = func() {
+				valuesData, err := readAuxiliaryFile(*values, *valuesFormat)
+				if err != nil {
+					exit(exitInputError, err.Error())
+				}
+				runConversion(ZipTransformer{Values: valuesData, Pairs: *pairs, Pad: *pad})
+			}
+		})
+
+	app.Command("ensure-array",
+		"Wraps single values at the given paths in arrays.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths = cmd.StringOpt(pathsOptName, "", "comma-separated paths of values that must be arrays (e.g. 'items[].tags')")
+			)
+			configureConversionOptions(cmd)
+			cmd.Spec = "--paths [OPTIONS] [INPUT] [OUTPUT]"
+			cmd.LongDesc = "Arrays and nulls are left alone, as are paths not present in the data."
+
+			cmd.Action = func() {
+				parsed, err := ParsePaths(*paths)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				runConversion(EnsureArrayTransformer{Paths: parsed})
+			}
+		})
+
+	app.Command("numeric-keys",
+		"Converts string keys that are integers into integer keys.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths = cmd.StringOpt(pathsOptName, "", "comma-separated paths of the objects to convert (all objects if empty)")
+			)
+			configureElementWiseOptions(cmd)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Restores integer keys lost in " + formatNameJSON + ", e.g. when converting " +
+				formatNameYAML + " to " + formatNameJSON + " and back. Keys such as \"01\" are kept as strings. " +
+				"Only " + formatNameYAML + " output can represent the result."
+
+			cmd.Action = func() {
+				parsed, err := ParsePaths(*paths)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				} else if *paths == "" {
+					parsed = nil
+				}
+				runConversion(NumericKeysTransformer{Paths: parsed})
+			}
+		})
+
+	app.Command("fold-keys",
+		"Combines keys that differ only in case.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				keep = cmd.StringOpt("keep", FoldKeysFirst, "the key kept ("+strings.Join(foldKeysChoices, ", ")+
+					"), the value is always that of the last key")
+				strict = cmd.BoolOpt("strict", false, "fail for keys with different values instead of keeping the last value")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Keys such as Host, host and HOST of any object become one key. " +
+				"Keys are in the order of the input for ordered objects and sorted otherwise."
+
+			cmd.Action = func() {
+				if !containsFold(*keep, foldKeysChoices) {
+					exit(exitConfigurationError, "unknown key choice '"+*keep+"'")
+				}
+				runConversion(FoldKeysTransformer{Keep: *keep, Strict: *strict})
+			}
+		})
+
+	app.Command("normalize-keys",
+		"Removes whitespace and control characters around and in keys.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				collapseSpaces = cmd.BoolOpt("collapse-spaces", false, "also replace runs of whitespace inside keys by a single space")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Keys of all objects are trimmed (including non-breaking and other Unicode spaces), " +
+				"control characters and byte order marks are removed. Keys that become equal are an error."
+
+			cmd.Action = func() {
+				runConversion(NormalizeKeysTransformer{CollapseSpaces: *collapseSpaces})
+			}
+		})
+
+	app.Command("entries",
+		"Converts objects into arrays of {key, value} entries.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths      = cmd.StringOpt(pathsOptName, "", "comma-separated paths of the objects to convert (the top level if empty)")
+				keyField   = cmd.StringOpt("key-field", "key", "the field name of the keys in the entries")
+				valueField = cmd.StringOpt("value-field", "value", "the field name of the values in the entries")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Entries are sorted by key, except for objects keeping the order of their input " +
+				"(such as " + formatNameCSF + " records read with --preserve-order). Nulls are left alone, other values fail."
+
+			cmd.Action = func() {
+				runConversion(scopedTransformer(*paths, EntriesTransformer{KeyField: *keyField, ValueField: *valueField}))
+			}
+		})
+
+	app.Command("from-entries",
+		"Converts arrays of {key, value} entries into objects.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths      = cmd.StringOpt(pathsOptName, "", "comma-separated paths of the arrays to convert (the top level if empty)")
+				keyField   = cmd.StringOpt("key-field", "key", "the field name of the keys in the entries")
+				valueField = cmd.StringOpt("value-field", "value", "the field name of the values in the entries")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Objects keep the order of the entries, later entries replace earlier ones with the same key. " +
+				"Keys that are not strings are converted, entries without a value have the value null."
+
+			cmd.Action = func() {
+				runConversion(scopedTransformer(*paths, FromEntriesTransformer{KeyField: *keyField, ValueField: *valueField}))
+			}
+		})
+
+	app.Command("merge-array",
+		"Merges arrays of objects into one object.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths    = cmd.StringOpt(pathsOptName, "", "comma-separated paths of the arrays to merge (the top level if empty)")
+				lastWins = cmd.BoolOpt("last-wins", false, "keep the value of the last element with a key rather than failing")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Turns lists of single-entry maps such as [{\"a\": 1}, {\"b\": 2}] into {\"a\": 1, \"b\": 2}, " +
+				"with the keys in the order of the elements. Elements that are not objects fail, nulls are left alone."
+
+			cmd.Action = func() {
+				runConversion(scopedTransformer(*paths, MergeArrayOfObjectsTransformer{LastWins: *lastWins}))
+			}
+		})
+
+	app.Command("parse-durations",
+		"Parses durations such as 30s or 1h30m at the given paths into nanoseconds.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths     = cmd.StringOpt(pathsOptName, "", "comma-separated paths of the durations (e.g. 'servers[].timeout')")
+				canonical = cmd.BoolOpt("canonical", false, "write durations in their canonical form (e.g. 1h30m0s) rather than as nanoseconds")
+			)
+			configureConversionOptions(cmd)
+			cmd.Spec = "--paths [OPTIONS] [INPUT] [OUTPUT]"
+			cmd.LongDesc = "Durations are read as by Go's time.ParseDuration, with the units ns, us, ms, s, m and h. " +
+				"Strings that are not durations stay strings, other values are left alone."
+
+			cmd.Action = func() {
+				runConversion(scopedTransformer(*paths, ParseUnitsTransformer{Unit: UnitDuration, Canonical: *canonical}))
+			}
+		})
+
+	app.Command("parse-sizes",
+		"Parses byte sizes such as 10MB or 1.5GiB at the given paths into bytes.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths  = cmd.StringOpt(pathsOptName, "", "comma-separated paths of the sizes (e.g. 'cache.limit')")
+				binary = cmd.BoolOpt("binary", false, "read K, M, G etc. (with or without B) as powers of 1024 rather than 1000")
+			)
+			configureConversionOptions(cmd)
+			cmd.Spec = "--paths [OPTIONS] [INPUT] [OUTPUT]"
+			cmd.LongDesc = "Sizes are numbers with an optional suffix (B, K, M, G, T, P, E with or without B, or KiB, MiB etc. " +
+				"for powers of 1024) in any case. Strings that are not sizes stay strings, other values are left alone."
+
+			cmd.Action = func() {
+				runConversion(scopedTransformer(*paths, ParseUnitsTransformer{Unit: UnitSize, Binary: *binary}))
+			}
+		})
+
+	app.Command("mask-numbers",
+		"Converts data files and masks numbers, e.g. for anonymization.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths       = cmd.StringOpt(pathsOptName, "", "comma-separated paths of the values to mask (all if empty)")
+				mode        = cmd.StringOpt("mode", MaskBucket, "how to mask numbers ("+strings.Join(maskModes, ", ")+")")
+				granularity = cmd.Float64Opt("granularity", 1, "the size of buckets and ranges (e.g. 1000)")
+				noise       = cmd.Float64Opt("noise", 1, "the maximum noise added in the noise mode")
+				seed        = cmd.IntOpt("seed", 0, "the seed of the noise (the same seed gives the same result)")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "bucket rounds numbers to the nearest multiple of the granularity, zero replaces them by 0, " +
+				"redact by a label of their range (such as \"1000..2000\"), and noise adds random noise. " +
+				"Integers stay integers, strings are left alone."
+
+			cmd.Action = func() {
+				runConversion(scopedTransformer(*paths, MaskNumbersTransformer{
+					Mode: *mode, Granularity: *granularity, Noise: *noise, Seed: int64(*seed),
+				}))
+			}
+		})
+
+	app.Command("format-bools",
+		"Converts data files and replaces booleans by other values.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths       = cmd.StringOpt(pathsOptName, "", pathsDesc)
+				trueValue   = cmd.StringOpt("true", "1", "the value for true")
+				falseValue  = cmd.StringOpt("false", "0", "the value for false")
+				keepStrings = cmd.BoolOpt("strings", false, "write integer values as strings")
+			)
+			configureElementWiseOptions(cmd)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Values that are integers, such as the default 1 and 0, are written as numbers " +
+				"unless --strings is given."
+
+			cmd.Action = func() {
+				runConversion(scopedTransformer(*paths, BoolFormatTransformer{
+					True:  boolRendering(*trueValue, *keepStrings),
+					False: boolRendering(*falseValue, *keepStrings),
+				}))
+			}
+		})
+
+	app.Command("keys",
+		"Lists the key paths in the union or intersection of several data files.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				union     = cmd.BoolOpt("union", false, "list the paths present in any file (the default)")
+				intersect = cmd.BoolOpt("intersect", false, "only list the paths present in all files")
+				files     = cmd.StringsArg(inputName, nil, "input files (`-` for stdin)")
+			)
+			configureInputOptions(cmd)
+			configureOutputOptions(cmd)
+			cmd.Spec = "[OPTIONS] INPUT..."
+			cmd.LongDesc = "The paths of all values other than objects and arrays (e.g. 'db.hosts[0]') are " +
+				"written as a sorted array to stdout in the format of the first file unless another " +
+				"output format is requested. Each file can have its own format."
+
+			cmd.Action = func() {
+				if *union && *intersect {
+					exit(exitConfigurationError, "only one of --union and --intersect can be given")
+				}
+				documents := make([]interface{}, len(*files))
+				for n, file := range *files {
+					data, err := readInputFile(file)
+					if err != nil {
+						exit(exitInputError, fmt.Sprintf("%s: %s", file, err))
+					}
+					documents[n] = data
+				}
+				paths := make([]interface{}, 0)
+				for _, path := range combineLeafPaths(documents, *intersect) {
+					paths = append(paths, path)
+				}
+				writer := newOutputBuffer(os.Stdout)
+				err := outputFormatFor((*files)[0]).Marshal(paths, writer)
+				if ferr := writer.Flush(); err == nil {
+					err = ferr
+				}
+				if err != nil {
+					exit(exitCodeFor(err, exitOutputError), err.Error())
+				}
+			}
+		})
+
+	app.Command("url-encode",
+		"Converts data files and percent-encodes strings.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths        = cmd.StringOpt(pathsOptName, "", pathsDesc)
+				pathEscaping = cmd.BoolOpt("path-escaping", false, "escape as path segments instead of query components")
+			)
+			configureElementWiseOptions(cmd)
+			configureConversionOptions(cmd)
+
+			cmd.Action = func() {
+				runConversion(scopedTransformer(*paths, URLEncodeTransformer{PathEscaping: *pathEscaping}))
+			}
+		})
+
+	app.Command("normalize-numbers",
+		"Rewrites strings that are numbers in plain decimal notation.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths = cmd.StringOpt(pathsOptName, "", pathsDesc)
+			)
+			configureElementWiseOptions(cmd)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "Strings such as 1E-05 or +1.50 become 0.00001 and 1.5 but stay strings, " +
+				"unlike with --" + stringTo64bfNumberOptName + " no precision is lost."
+
+			cmd.Action = func() {
+				runConversion(scopedTransformer(*paths, NormalizeNumericStringsTransformer{}))
+			}
+		})
+
+	app.Command("url-decode",
+		"Converts data files and decodes percent-encoded strings.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				paths        = cmd.StringOpt(pathsOptName, "", pathsDesc)
+				pathEscaping = cmd.BoolOpt("path-escaping", false, "unescape path segments instead of query components")
+				lenient      = cmd.BoolOpt("lenient", false, "keep malformed encodings instead of failing")
+			)
+			configureElementWiseOptions(cmd)
+			configureConversionOptions(cmd)
+
+			cmd.Action = func() {
+				runConversion(scopedTransformer(*paths, URLDecodeTransformer{PathEscaping: *pathEscaping, Lenient: *lenient}))
+			}
+		})
+
+	app.Command("infer-schema",
+		"Infers a JSON Schema from sample data.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				enumThreshold = cmd.IntOpt("enum-threshold", 0,
+					"treat string values as enums if there are at most this many distinct values (0 to disable)")
+			)
+			configureConversionOptions(cmd)
+			cmd.LongDesc = "The schema (draft 2020-12) validates the input it was inferred from. " +
+				"It is written as JSON unless another output format is requested."
+
+			cmd.Action = func() {
+				c := commandConversion()
+				if c.OutputType == autoFormat && (output == "" || output == "-") {
+					c.OutputType = formatNameJSON
+				}
+				convertInput(c, SchemaInferenceTransformer{EnumThreshold: *enumThreshold})
+			}
+		})
+
+	app.Command("tree",
+		"Prints an outline of the structure of a document.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				depth = cmd.IntOpt("depth d", 0, "the maximum depth to show (0 for all levels)")
+			)
+			configureInputOptions(cmd)
+			cmd.StringArgPtr(&input, inputName, "", inputDesc)
+			cmd.Spec = "[OPTIONS] [INPUT]"
+			cmd.LongDesc = "Each line shows a key or array index with the type of its value " +
+				"(not the value itself), objects and arrays with the number of children. " +
+				"Keys are sorted unless --preserve-order is given and supported by the format."
+
+			cmd.Action = func() {
+				inputFormat, err := NewInputFormat(input, inputFormatName(), fieldDelim, recordDelim)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				inputFormat = configureInputFormat(inputFormat, input)
+				err = ConvertFile(input, inputFormat, importTransformer(), "", TreeFormat{MaxDepth: *depth})
+				if err != nil {
+					exit(exitInputError, err.Error())
+				}
+				reportSkippedRecords()
+			}
+		})
+
+	app.Command("detect",
+		"Reports the format of data files.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				files  = cmd.StringsArg(inputName, nil, "input files (`-` for stdin)")
+				asJSON = cmd.BoolOpt("json", false, "write the results as a JSON array")
+			)
+			cmd.Spec = "[OPTIONS] INPUT..."
+			cmd.LongDesc = "The format is detected like with --sniff: from the content, or from the extension " +
+				"if the content is ambiguous. Each input is reported with the format, the method of detection " +
+				"(" + DetectedByExtension + ", " + DetectedByMagic + ", " + DetectedByContent + "), and the number " +
+				"of documents or records where applicable. The exit code is " + strconv.Itoa(exitCheckError) +
+				" if any input could not be identified and " + strconv.Itoa(exitInputError) + " if any could not be read; " +
+				"the other inputs are still reported."
+
+			cmd.Action = func() {
+				results, code := detectInputs(*files)
+				if err := writeDetectionResults(os.Stdout, results, *asJSON); err != nil {
+					exit(exitOutputError, err.Error())
+				}
+				if code != exitNoError {
+					failed := 0
+					for _, result := range results {
+						if result.Err != nil {
+							failed++
+						}
+					}
+					exit(code, fmt.Sprintf("%d input(s) could not be read or identified", failed))
+				}
+			}
+		})
+
+	app.Command("lint",
+		"Reports likely data-quality issues in a document.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				enable   = cmd.StringOpt("enable", "", "comma-separated rules to run (all if empty)")
+				disable  = cmd.StringOpt("disable", "", "comma-separated rules not to run")
+				maxDepth = cmd.IntOpt("max-depth", 32, "the nesting depth reported as too deep (0 for any depth)")
+				asJSON   = cmd.BoolOpt("json", false, "write the findings as a JSON array")
+			)
+			configureInputOptions(cmd)
+			cmd.StringArgPtr(&input, inputName, "", inputDesc)
+			cmd.Spec = "[OPTIONS] [INPUT]"
+			cmd.LongDesc = "Each finding is reported with the path, severity, and rule (" +
+				strings.Join(lintRuleIDs(), ", ") + "). The exit code is " + strconv.Itoa(exitCheckError) +
+				" if any finding is an error (key-whitespace and deep-nesting)."
+
+			cmd.Action = func() {
+				enabled, err := ParseLintRules(*enable, *disable)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				inputFormat, err := NewInputFormat(input, inputFormatName(), fieldDelim, recordDelim)
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+				inputFormat = configureInputFormat(inputFormat, input)
+				data, err := ReadFile(input, inputFormat)
+				if err == nil {
+					data, err = importTransformer().Transform(data)
+				}
+				if err != nil {
+					exit(exitInputError, err.Error())
+				}
+				findings := Linter{Enabled: enabled, MaxDepth: *maxDepth}.Lint(data)
+				if err = writeLintFindings(os.Stdout, findings, *asJSON); err != nil {
+					exit(exitOutputError, err.Error())
+				}
+				reportSkippedRecords()
+				if hasLintErrors(findings) {
+					exit(exitCheckError, fmt.Sprintf("%d finding(s), including errors", len(findings)))
+				}
+			}
+		})
+
+	app.Command("repl",
+		"Explores a document interactively.",
+		func(cmd *mowcli.Cmd) {
+			noColor := cmd.BoolOpt("no-color", false, "do not colorize the values printed")
+			configureInputOptions(cmd)
+			cmd.StringArgPtr(&input, inputName, "", "input file")
+			cmd.Spec = "[OPTIONS] INPUT"
+			cmd.LongDesc = "Reads the document once and then prompts for commands: a path (such as " +
+				"spec.containers[0]) prints the value at the path, keys, length, and type describe it, " +
+				"set PATH VALUE changes it, and write FILE[:FORMAT] writes the document. Enter help " +
+				"for all commands. Values are printed as JSON, colorized if stdout is a terminal (and NO_COLOR is not set)."
+
+			cmd.Action = func() {
+				if input == "-" {
+					exit(exitConfigurationError, "the input must be a file since commands are read from stdin")
+				}
+				data, err := readInputFile(input)
+				if err != nil {
+					exit(exitInputError, err.Error())
+				}
+				reportSkippedRecords()
+				repl := &REPL{Data: data, Color: !*noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout), Write: func(target string, data interface{}) error {
+					file, format, err := parseOutputTarget(target, perDocument)
+					if err != nil {
+						return err
+					}
+					return WriteFile(file, data, format)
+				}}
+				if isTerminal(os.Stdin) {
+					repl.Prompt = "> "
+				}
+				if err = repl.Run(stdin, os.Stdout); err != nil {
+					exit(exitInputError, err.Error())
+				}
+			}
+		})
+
+	app.Command("generate",
+		"Generates type declarations from sample data.",
+		func(cmd *mowcli.Cmd) {
+			cmd.Command("ts",
+				"Generates TypeScript declarations.",
+				func(cmd *mowcli.Cmd) {
+					var (
+						rootName = cmd.StringOpt("root", "Root", "the name of the top-level type")
+						inline   = cmd.BoolOpt("inline", false, "use inline object types instead of nested interfaces")
+					)
+					configureConversionOptions(cmd)
+					cmd.LongDesc = "Keys missing in some samples become optional properties, " +
+						"differing types become unions, and unknown is used where nothing can be inferred."
+
+					cmd.Action = func() {
+						inputFormat, err := NewInputFormat(input, inputFormatName(), fieldDelim, recordDelim)
+						if err != nil {
+							exit(exitConfigurationError, err.Error())
+						}
+						inputFormat = configureInputFormat(inputFormat, input)
+						outputFormat := TypeScriptFormat{RootName: *rootName, InlineObjects: *inline}
+						err = ConvertFile(input, inputFormat, importTransformer(), output, outputFormat)
+						if err != nil {
+							exit(exitTransformError, err.Error())
+						}
+					}
+				})
+		})
+
+	app.Command("cat",
+		"Concatenates line-based input files into one stream and converts it.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				files = cmd.StringsArg(inputName, nil, "input files (`-` for stdin)")
+			)
+			configureInputOptions(cmd)
+			configureOutputOptions(cmd)
+			cmd.Spec = "[OPTIONS] INPUT..."
+			cmd.LongDesc = "Unlike merging, the files are read as if they were a single file, " +
+				"e.g. the lines of several " + formatNameNDJSON + " files become one array. " +
+				"Only line-based formats (" + strings.Join([]string{formatNameNDJSON, formatNameStrings,
+				formatNameNTStr, formatNameCSF}, ", ") + ") are supported. Options such as " +
+				"--skip-rows and --header apply to the combined stream. The output is written " +
+				"to stdout as " + formatNameJSON + " unless another output format is requested."
+
+			cmd.Action = func() {
+				outputFormatName := outputType
+				if outputFormatName == autoFormat {
+					outputFormatName = formatNameJSON
+				}
+				concatFiles(*files, outputFormatName)
+			}
+		})
+
+	app.Command("split",
+		"Writes each element of a top-level array (or value of an object) to its own file.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				outputDir    = cmd.StringOpt(outputDirOptName, ".", outputDirDesc)
+				nameTemplate = cmd.StringOpt(nameTemplateOptName, defaultSplitNameTemplate, nameTemplateDesc)
+				dryRun       = cmd.BoolOpt(dryRunOptName, false, dryRunDesc)
+			)
+			configureInputOptions(cmd)
+			configureOutputOptions(cmd)
+			cmd.StringArgPtr(&input, inputName, "", inputDesc)
+			cmd.Spec = "[OPTIONS] [INPUT]"
+			cmd.LongDesc = "Elements of arrays have an .Index, values of objects also have a .Key. " +
+				"Names are made safe for the file system and must be unique, e.g. " +
+				"--name-template '{{.Field \"id\"}}{{.Ext}}' or '{{printf \"%03d\" .Index}}.json'. " +
+				"The output format is the input format if possible or " + formatNameJSON + " unless requested."
+
+			cmd.Action = func() {
+				data, err := readInputFile(input)
+				if err != nil {
+					exit(exitInputError, err.Error())
+				}
+				splitData(data, *outputDir, *nameTemplate, *dryRun, false)
+				reportSkippedRecords()
+			}
+		})
+
+	app.Command("split-by-key",
+		"Writes the value of each key of a top-level object to a file named by the key.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				outputDir    = cmd.StringOpt(outputDirOptName, ".", outputDirDesc)
+				nameTemplate = cmd.StringOpt(nameTemplateOptName, defaultSplitByKeyNameTemplate, nameTemplateDesc)
+				dryRun       = cmd.BoolOpt(dryRunOptName, false, dryRunDesc)
+			)
+			configureInputOptions(cmd)
+			configureOutputOptions(cmd)
+			cmd.StringArgPtr(&input, inputName, "", inputDesc)
+			cmd.Spec = "[OPTIONS] [INPUT]"
+			cmd.LongDesc = "Like split, but only for objects and with files named like the keys " +
+				"(with the extension of the output format) by default, e.g. svc1.json. " +
+				"Keys are made safe for the file system and the names of the files written are printed."
+
+			cmd.Action = func() {
+				data, err := readInputFile(input)
+				if err != nil {
+					exit(exitInputError, err.Error())
+				}
+				if !isObject(data) {
+					exit(exitTransformError, "cannot split "+describeType(data)+" by key")
+				}
+				splitData(data, *outputDir, *nameTemplate, *dryRun, true)
+				reportSkippedRecords()
+			}
+		})
+
+	app.Command("batch",
+		"Converts several files into a directory.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				outputDir    = cmd.StringOpt(outputDirOptName, "", outputDirDesc)
+				nameTemplate = cmd.StringOpt(nameTemplateOptName, defaultBatchNameTemplate, nameTemplateDesc)
+				dryRun       = cmd.BoolOpt(dryRunOptName, false, dryRunDesc)
+				jobs         = cmd.IntOpt("jobs j", runtime.NumCPU(), "the number of files to convert at the same time")
+				files        = cmd.StringsArg(inputName, nil, "input files")
+			)
+			configureInputOptions(cmd)
+			configureOutputOptions(cmd)
+			cmd.Spec = "--output-dir [OPTIONS] INPUT..."
+			cmd.LongDesc = "Each input is read in its own (detected) format. The names are rendered " +
+				"like for split with the index of the input and .Field over its data. " +
+				"Errors do not stop the other files, they are reported in the order of the inputs " +
+				"at the end (with the exit codes combined)."
+
+			cmd.Action = func() {
+				batchConvert(*files, *outputDir, *nameTemplate, *dryRun, *jobs)
+				reportSkippedRecords()
+			}
+		})
+
+	app.Command("hash",
+		"Prints digests of the canonical form of data files.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				algorithm = cmd.StringOpt("algorithm a", "sha256",
+					"the hash algorithm ("+strings.Join(hashAlgorithmNames(), ", ")+")")
+				check = cmd.BoolOpt("check c", false, "read checksum lists and verify the files listed")
+				files = cmd.StringsArg(inputName, nil, "input files (or stdin if not provided)")
+			)
+			configureInputOptions(cmd)
+			cmd.Spec = "[OPTIONS] [INPUT...]"
+			cmd.LongDesc = "The digest is computed over the canonical JSON encoding (sorted keys, " +
+				"no whitespace) of the parsed data, so files differing only in format, whitespace, " +
+				"or key order have the same digest. Each input results in a line `HASH  NAME`."
+
+			cmd.Action = func() {
+				if len(*files) == 0 {
+					*files = []string{"-"}
+				}
+				if *check {
+					checkHashes(*files, *algorithm)
+				} else {
+					printHashes(*files, *algorithm)
+				}
+			}
+		})
+
+	app.Command("serve",
+		"Runs an HTTP server converting request bodies.",
+		func(cmd *mowcli.Cmd) {
+			var (
+				listen        = cmd.StringOpt("listen l", ":8080", "the address to listen on")
+				maxBodySize   = cmd.IntOpt("max-body-size", 10*1024*1024, "the maximum size of request bodies in bytes")
+				maxConcurrent = cmd.IntOpt("max-concurrent", 16, "the maximum number of requests converted concurrently")
+			)
+			cmd.LongDesc = "POST /convert?from=yaml&to=json&pretty=1 converts the request body. " +
+				"The formats default to the Content-Type and Accept headers. Other query parameters " +
+				"mirror the command line options (e.g. header, skip-rows, dup-keys, multidoc), " +
+				"transform=NAME,... applies " + strings.Join(serverTransformerNames(), ", ") + " with " +
+				"their options as parameters (e.g. transform=remove-nulls&values=1)."
+
+			cmd.Action = func() {
+				server := &http.Server{
+					Addr:              *listen,
+					Handler:           NewConversionServer(int64(*maxBodySize), *maxConcurrent),
+					ReadHeaderTimeout: 10 * time.Second,
+					ReadTimeout:       time.Minute,
+					WriteTimeout:      time.Minute,
+				}
+				err := server.ListenAndServe()
+				if err != nil {
+					exit(exitConfigurationError, err.Error())
+				}
+			}
+		})
+
+	app.Command("version", "Prints the application version.", func(cmd *mowcli.Cmd) {
+		cmd.BoolOptPtr(&verbose, verboseOptName, false, verboseDesc)
+		cmd.Action = func() {
+			if verbose {
+				os.Stderr.WriteString(fmt.Sprintf("version %s (%s)\n", Version, tag))
+
+			} else {
+				os.Stderr.WriteString(fmt.Sprintf("version %s\n", Version))
+
+			}
+		}
+	})
+
+	return app
+}
+
+// Registers --parallel for the commands transforming each element of a
+// top-level array on its own, which can also skip the records they fail on
+// with --keep-going.
+func configureElementWiseOptions(cmd *mowcli.Cmd) {
+	cmd.IntOptPtr(&parallelism, parallelOptName, 1, parallelDesc)
+	cmd.Before = func() { elementWise = true }
+}
+
+// Returns the transformer applied after the import transformer, logging
+// the steps to stderr with --debug and skipping the records it fails on with
+// --keep-going or --max-errors. Only commands transforming the elements on
+// their own support this, see configureElementWiseOptions.
+func conversionPipeline(importTransformer Transformer, transformer Transformer) Transformer {
+	pipeline := NewMultiTransformer(importTransformer, transformer)
+	if debugSteps {
+		pipeline = WithStepLog(pipeline, os.Stderr)
+	}
+	if onRecordError := recordErrorHandler(); onRecordError != nil {
+		if err := keepGoingConflict(); err != nil {
+			exit(exitConfigurationError, err.Error())
+		}
+		return RecordSkippingTransformer{Transformer: pipeline, OnRecordError: onRecordError}
+	}
+	return pipeline
+}
+
+// Returns an error if records are skipped with --keep-going or --max-errors
+// but the command (or an option) does not transform them on their own.
+func keepGoingConflict() error {
+	if !elementWise {
+		return fmt.Errorf("--%s and --%s are not supported by commands transforming the whole input",
+			keepGoingOptName, maxErrorsOptName)
+	} else if option := wholeInputOption(""); option != "" {
+		return fmt.Errorf("--%s cannot be combined with --%s, which needs the whole input", keepGoingOptName, option)
+	}
+	return nil
+}
+
+// The settings of a conversion that commands derive from the options (and
+// the input), passed on explicitly rather than written back to the options.
+type conversion struct {
+	// The input format configured for the input, see commandInputFormat.
+	InputFormat InputFormat
+	// The name of the output format, see NewOutputFormat.
+	OutputType string
+	// Whether each document of the input is transformed on its own.
+	PerDocument bool
+}
+
+// Returns the conversion of the input as given by the options.
+func commandConversion() conversion {
+	return conversion{InputFormat: commandInputFormat(), OutputType: outputType, PerDocument: perDocument}
+}
+
+// Selects the input format of a list (see selectInputFormat) and uses it for
+// the output unless the output format is given.
+func (c *conversion) defaultToInputFormat() {
+	c.InputFormat = selectInputFormat(c.InputFormat, input)
+	if c.OutputType == autoFormat && containsFold(c.InputFormat.Name(), outputFormats) {
+		c.OutputType = c.InputFormat.Name()
+	}
+}
+
+// Converts the input to the output applying the given transformer after the import.
+func runConversion(transformer Transformer) {
+	convertInput(commandConversion(), transformer)
+}
+
+// Like runConversion, for a conversion derived from the options.
+func convertInput(c conversion, transformer Transformer) {
+	inputFormat, importTransformer, outputFormat := configureFormats(c)
+	if extract, ok := transformer.(ExtractTransformer); ok {
+		inputFormat, transformer = streamedExtraction(inputFormat, extract, c.PerDocument)
+	}
+	transformer = NewMultiTransformer(conversionPipeline(importTransformer, transformer), truncateTransformer())
+	inputFormat, transformer, outputFormat = documentsFormats(c.PerDocument, inputFormat, transformer, outputFormat)
+	transformer = NewMultiTransformer(transformer, editReportTransformer())
+	if keepComments {
+		inputFormat, transformer = preserveComments(inputFormat, transformer, outputFormat)
+	}
+	if parallelism > 1 {
+		if err := parallelismConflict(""); err != nil {
+			exit(exitConfigurationError, err.Error())
+		}
+		transformer = ParallelTransformer{Transformer: transformer, Workers: parallelism}
+	}
+	if len(alsoOutputs) > 0 {
+		convertToOutputs(inputFormat, transformer, outputFormat, c.PerDocument)
+		return
+	}
+	err := ConvertFile(input, inputFormat, transformer, output, finalOutputFormat(outputFormat), stageHooks()...)
+	reportStages()
+	if err != nil {
+		exit(exitCodeFor(err, exitTransformError), err.Error())
+	}
+	reportSkippedRecords()
+}
+
+// Returns a JSONSubtreeFormat (and no transformer) to extract the path while
+// reading JSON input if nothing else needs the whole document (or each
+// document is transformed on its own).
+func streamedExtraction(inputFormat InputFormat, extract ExtractTransformer, perDocument bool) (InputFormat, Transformer) {
+	jsonFormat, ok := inputFormat.(JSONFormat)
+	if !ok || extract.Path.hasWildcards() || jsonFormat.DuplicateKeys == DuplicateKeysError ||
+		requiredPaths != "" || typesFile != "" || resolveRefs || externalRefs || perDocument || keepComments {
+		return inputFormat, extract
+	}
+	if verbose {
+		os.Stderr.WriteString(fmt.Sprintf("extracting %s from the token stream\n", describePath(extract.Path)))
+	}
+	return JSONSubtreeFormat{JSONFormat: jsonFormat, Path: extract.Path}, nil
+}
+
+// Reads and transforms the input once and writes the result to the output
+// and each of the --also-output files (each with a copy of the data). All
+// files are written even if some fail, the errors are reported at the end
+// with the exit codes combined.
+func convertToOutputs(inputFormat InputFormat, transformer Transformer, outputFormat OutputFormat, perDocument bool) {
+	if keepComments {
+		exit(exitConfigurationError, "comments cannot be preserved with several outputs")
+	}
+	files, formats := []string{output}, []OutputFormat{finalOutputFormat(outputFormat)}
+	for _, target := range alsoOutputs {
+		file, format, err := parseOutputTarget(target, perDocument)
+		if err != nil {
+			exit(exitConfigurationError, err.Error())
+		}
+		files, formats = append(files, file), append(formats, format)
+	}
+	hooks := stageHooks()
+	var data interface{}
+	err := measureStage("read", hooks, func() (_ interface{}, err error) {
+		data, err = ReadFile(input, inputFormat)
+		return data, err
+	})
+	if err != nil {
+		reportStages()
+		exit(exitInputError, err.Error())
+	}
+	if data, err = transformStages(data, transformer, hooks); err != nil {
+		reportStages()
+		exit(exitTransformError, err.Error())
+	}
+
+	code, messages := 0, make([]string, 0)
+	for n, file := range files {
+		err = measureStage("write "+outputFileName(file), hooks, func() (interface{}, error) {
+			return nil, writeOutputFile(file, copyData(data), formats[n])
+		})
+		if err != nil {
+			code |= exitCodeFor(err, exitOutputError)
+			messages = append(messages, fmt.Sprintf("%s: %s", outputFileName(file), err))
+		}
+	}
+	reportStages()
+	if code != 0 {
+		if skippedRecords > 0 && !keepGoingSilent {
+			code |= exitSkippedRecords
+		}
+		exit(code, strings.Join(messages, "\n"))
+	}
+	reportSkippedRecords()
+}
+
+// Returns the file and output format of FILE or FILE:FORMAT (if FORMAT is an
+// output format), writing a document for each element with perDocument.
+func parseOutputTarget(target string, perDocument bool) (string, OutputFormat, error) {
+	file, formatName := target, autoFormat
+	if i := strings.LastIndex(target, ":"); i > 0 && containsFold(target[i+1:], outputFormats) {
+		file, formatName = target[:i], target[i+1:]
+	}
+	if file == "" || file == "-" {
+		return file, nil, fmt.Errorf("%s is not a file", outputFileName(file))
+	}
+	format, err := NewOutputFormat(file, formatName, prettyPrint)
+	if err != nil {
+		return file, nil, err
+	}
+	format = configureOutputFormat(format)
+	if perDocument {
+		format = documentsOutputFormat(format)
+	}
+	return file, finalOutputFormat(format), nil
+}
+
+// Writes the data to the file, or stdout for empty file names and `-`.
+func writeOutputFile(file string, data interface{}, outputFormat OutputFormat) error {
+	if file != "" && file != "-" {
+		return WriteFile(file, data, outputFormat)
+	}
+	writer := newOutputBuffer(os.Stdout)
+	err := outputFormat.Marshal(data, writer)
+	if ferr := writer.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+func outputFileName(file string) string {
+	if file == "" || file == "-" {
+		return "stdout"
+	}
+	return file
+}
+
+// Like convertInput, but the result replaces the input file (once the
+// conversion succeeded, see replaceFile), which must keep its format.
+func rewriteInput(c conversion, transformer Transformer) {
+	if input == "" || input == "-" || output != "" || inlineDataSet {
+		exit(exitConfigurationError, "only an input file (and no output file) can be rewritten")
+	}
+	info, err := os.Stat(input)
+	if err != nil {
+		exit(exitInputError, err.Error())
+	}
+	inputFormat, importTransformer, outputFormat := configureFormats(c)
+	if err = rewriteConflict(inputFormat, outputFormat); err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	inputFormat, transformer, outputFormat = documentsFormats(c.PerDocument, inputFormat, conversionPipeline(importTransformer, transformer), outputFormat)
+	transformer = NewMultiTransformer(transformer, editReportTransformer())
+	if keepComments {
+		inputFormat, transformer = preserveComments(inputFormat, transformer, outputFormat)
+	}
+	reader, err := openInputFile(input)
+	if err != nil {
+		exit(exitInputError, err.Error())
+	}
+	result := &bytes.Buffer{}
+	err = ConvertStream(reader, inputFormat, transformer, result, finalOutputFormat(outputFormat), stageHooks()...)
+	reader.Close()
+	reportStages()
+	if err != nil {
+		exit(exitCodeFor(err, exitTransformError), err.Error())
+	}
+	if err = replaceFile(input, result.Bytes(), info.Mode().Perm()); err != nil {
+		exit(exitOutputError, err.Error())
+	}
+	reportSkippedRecords()
+}
+
+// Returns an error if the input would be rewritten in another format.
+func rewriteConflict(inputFormat InputFormat, outputFormat OutputFormat) error {
+	if !strings.EqualFold(inputFormat.Name(), outputFormat.Name()) {
+		return fmt.Errorf("the input can only be rewritten as %s, not as %s", inputFormat.Name(), outputFormat.Name())
+	}
+	return nil
+}
+
+// Describes del, set and redact.
+var editLongDesc = "Wildcards (*, [] and **) edit all matches, whose number is written to stderr. " +
+	"The output format defaults to the input format, and each document of " + formatNameYAML + " input is edited on its own."
+
+// Registers the options of the commands editing the values at a path.
+func configureEditOptions(cmd *mowcli.Cmd) {
+	cmd.BoolOptPtr(&inPlace, inPlaceOptName, false, inPlaceDesc)
+	cmd.BoolOptPtr(&requireMatch, requireMatchOptName, false, requireMatchDesc)
+	configureConversionOptions(cmd)
+}
+
+func editPath(path string) Path {
+	parsed, err := ParsePath(path)
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	return parsed
+}
+
+// Runs a transformer of del, set or redact, in place with --in-place.
+func runEdit(transformer Transformer) {
+	c := commandConversion()
+	c.defaultToInputFormat()
+	if c.InputFormat.Name() == formatNameYAML && !keepComments {
+		c.PerDocument = true
+	}
+	edited.Require = requireMatch
+	if inPlace {
+		rewriteInput(c, transformer)
+	} else {
+		convertInput(c, transformer)
+	}
+}
+
+// A transformer reporting the number of values edited by del, set or
+// redact on stderr once all documents are transformed, which fails if there
+// are none and Require is set.
+type editReport struct {
+	Verb    string
+	Path    Path
+	Matches int
+	Require bool
+}
+
+func (r *editReport) Transform(data interface{}) (interface{}, error) {
+	if r.Matches == 0 && r.Require {
+		return data, fmt.Errorf("no value matches %s", describePath(r.Path))
+	} else if !quiet {
+		noun := "values"
+		if r.Matches == 1 {
+			noun = "value"
+		}
+		os.Stderr.WriteString(fmt.Sprintf("%s %d %s\n", r.Verb, r.Matches, noun))
+	}
+	return data, nil
+}
+
+// Returns the transformer reporting the values edited, if any.
+func editReportTransformer() Transformer {
+	if edited == nil {
+		return nil
+	}
+	return edited
+}
+
+// Converts the input and appends the result to the output file for
+// --append, see FileAppender.
+func appendToOutput(transformer Transformer) {
+	if output == "" || output == "-" {
+		exit(exitConfigurationError, "--"+appendOptName+" requires an output file")
+	} else if len(alsoOutputs) > 0 || keepComments || outputBOM || excelOutput {
+		exit(exitConfigurationError, "--"+appendOptName+" cannot be combined with --"+alsoOutputOptName+
+			", --"+preserveCommentsOptName+", --"+outputBOMOptName+" or --"+excelOptName)
+	}
+	c := commandConversion()
+	inputFormat, importTransformer, outputFormat := configureFormats(c)
+	transformer = NewMultiTransformer(conversionPipeline(importTransformer, transformer), truncateTransformer())
+	inputFormat, transformer, outputFormat = documentsFormats(c.PerDocument, inputFormat, transformer, outputFormat)
+	format, ok := outputFormat.(InputOutputFormat)
+	if !ok {
+		exit(exitConfigurationError, fmt.Sprintf("%s output cannot be read to append to it", outputFormat.Name()))
+	}
+	if parallelism > 1 {
+		if err := parallelismConflict(""); err != nil {
+			exit(exitConfigurationError, err.Error())
+		}
+		transformer = ParallelTransformer{Transformer: transformer, Workers: parallelism}
+	}
+	hooks := stageHooks()
+	var data interface{}
+	err := measureStage("read", hooks, func() (_ interface{}, err error) {
+		data, err = ReadFile(input, inputFormat)
+		return data, err
+	})
+	if err != nil {
+		reportStages()
+		exit(exitInputError, err.Error())
+	}
+	if data, err = transformStages(data, transformer, hooks); err != nil {
+		reportStages()
+		exit(exitCodeFor(err, exitTransformError), err.Error())
+	}
+	appender := FileAppender{File: output, Format: format, Writer: finalOutputFormat(outputFormat), Wrap: appendWrap}
+	err = measureStage("write", hooks, func() (interface{}, error) {
+		return nil, appender.Append(data)
+	})
+	reportStages()
+	if err != nil {
+		exit(exitCodeFor(err, exitOutputError), err.Error())
+	}
+	reportSkippedRecords()
+}
+
+// Wraps the output format to read the output back with --verify and to
+// start it with a byte order mark with --output-bom (or --excel).
+func finalOutputFormat(outputFormat OutputFormat) OutputFormat {
+	if verify && keepComments {
+		exit(exitConfigurationError, "the output cannot be verified when comments are preserved")
+	} else if verify {
+		format, ok := outputFormat.(InputOutputFormat)
+		if !ok {
+			exit(exitConfigurationError, fmt.Sprintf("%s output cannot be read back to verify it", outputFormat.Name()))
+		}
+		outputFormat = VerifyingFormat{InputOutputFormat: format, Epsilon: verifyEpsilon}
+	}
+	if outputBOM || excelOutput {
+		outputFormat = BOMFormat{OutputFormat: outputFormat}
+	}
+	return outputFormat
+}
+
+// Returns the exit code for an error of writing the output, which is the
+// given one unless verifying the output failed.
+func exitCodeFor(err error, code int) int {
+	var verifyError *VerifyError
+	if errors.As(err, &verifyError) {
+		return exitVerifyError
+	}
+	return code
+}
+
+// Restricts the transformer to the comma-separated paths (if any).
+func scopedTransformer(paths string, transformer Transformer) Transformer {
+	if paths == "" {
+		return transformer
+	} else if err := parallelismConflict(paths); err != nil {
+		exit(exitConfigurationError, err.Error())
+	} else if recordErrorHandler() != nil {
+		exit(exitConfigurationError, fmt.Sprintf("--%s cannot be combined with --%s, which needs the whole input",
+			keepGoingOptName, strings.Split(pathsOptName, " ")[0]))
+	}
+	parsed, err := ParsePaths(paths)
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	return PathScopedTransformer{Paths: parsed, Transformer: transformer}
+}
+
+// Returns an error if --parallel is combined with options addressing values
+// by their path from the top level (such as the paths of a command) or
+// comparing records, as the transformation only sees chunks of the array.
+func parallelismConflict(paths string) error {
+	if parallelism <= 1 {
+		return nil
+	}
+	option := wholeInputOption(paths)
+	if option == "" && recordErrorHandler() != nil {
+		option = keepGoingOptName
+	}
+	if option != "" {
+		return fmt.Errorf("--%s cannot be combined with --%s, which needs the whole input", parallelOptName, option)
+	}
+	return nil
+}
+
+// Returns the name of an option given that addresses values by their path
+// from the top level or compares records, or an empty string.
+func wholeInputOption(paths string) string {
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{strings.Split(pathsOptName, " ")[0], paths != ""},
+		{atOptName, atPath != ""},
+		{requireOptName, requiredPaths != ""},
+		{typesOptName, typesFile != ""},
+		{strictParseOptName, strictParse},
+		{resolveRefsOptName, resolveRefs || externalRefs},
+		{limitKeysOptName, limitKeys != ""},
+	} {
+		if option.set {
+			return option.name
+		}
+	}
+	return ""
+}
+
+// Returns the value given for a boolean, as an integer if it is one (unless
+// strings are requested).
+func boolRendering(value string, keepStrings bool) interface{} {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && !keepStrings {
+		return n
+	}
+	return value
+}
+
+// Registers the options and arguments shared by all converting subcommands.
+func configureConversionOptions(cmd *mowcli.Cmd) {
+	configureInputOptions(cmd)
+	configureOutputOptions(cmd)
+	cmd.StringPtr(&inlineData, mowcli.StringOpt{Name: inlineDataOptName, Desc: inlineDataDesc, SetByUser: &inlineDataSet})
+	cmd.StringArgPtr(&input, inputName, "", inputDesc)
+	cmd.StringOptPtr(&requiredPaths, requireOptName, "", requireDesc)
+	cmd.BoolOptPtr(&resolveRefs, resolveRefsOptName, false, resolveRefsDesc)
+	cmd.BoolOptPtr(&externalRefs, externalRefsOptName, false, externalRefsDesc)
+	cmd.IntOptPtr(&maxRefDepth, maxRefDepthOptName, 0, maxRefDepthDesc)
+	cmd.BoolOptPtr(&timings, timingsOptName, false, timingsDesc)
+	cmd.StringOptPtr(&debugDumpFile, debugDumpOptName, "", debugDumpDesc)
+	cmd.BoolOptPtr(&debugSteps, debugOptName, false, debugDesc)
+	cmd.StringsOptPtr(&formatOptions, formatOptionOptName, nil, formatOptionDesc)
+	cmd.BoolOptPtr(&perDocument, perDocumentOptName, false, perDocumentDesc)
+	cmd.StringArgPtr(&output, outputName, "", outputDesc)
+
+	cmd.Spec = "[OPTIONS] [INPUT] [OUTPUT]"
+}
+
+// Registers the options configuring the input format and import.
+func configureInputOptions(cmd *mowcli.Cmd) {
+	cmd.StringOptPtr(&inputType, inputTypeOptName, autoFormat, inputTypeDesc)
+	cmd.BoolOptPtr(&sniff, sniffOptName, false, sniffDesc)
+	cmd.StringOptPtr(&inputFormatHint, inputFormatHintOptName, "", formatHintDesc)
+	cmd.StringOptPtr(&fieldDelim, fieldDelimOptName, ",", fieldDelimDesc)
+	cmd.StringOptPtr(&recordDelim, recordDelimOptName, "NL", recordDelimDesc)
+	cmd.VarOpt(defineDelimOptName, delimiterDefinitions{}, defineDelimDesc)
+	cmd.BoolOptPtr(&escapeDelimiters, escapeDelimOptName, false, escapeDelimDesc)
+	cmd.IntOptPtr(&skipRows, skipRowsOptName, 0, skipRowsDesc)
+	cmd.BoolOptPtr(&header, headerOptName, false, headerDesc)
+	cmd.StringOptPtr(&headerRename, headerRenameOptName, "", headerRenameDesc)
+	cmd.BoolOptPtr(&preserveOrder, preserveOrderOptName, false, preserveOrderDesc)
+	cmd.BoolOptPtr(&stringToJSONNumber, stringTo64bfNumberOptName, false, stringToJSONNumberDesc)
+	cmd.BoolOptPtr(&coerceBools, coerceBoolsOptName, false, coerceBoolsDesc)
+	cmd.StringOptPtr(&trueStrings, trueStringsOptName, trueStrings, trueStringsDesc)
+	cmd.StringOptPtr(&falseStrings, falseStringsOptName, falseStrings, falseStringsDesc)
+	cmd.BoolOptPtr(&strictParse, strictParseOptName, false, strictParseDesc)
+	cmd.StringOptPtr(&typesFile, typesOptName, "", typesDesc)
+	cmd.BoolOptPtr(&typesLenient, typesLenientOptName, false, typesLenientDesc)
+	cmd.StringOptPtr(&duplicateKeys, duplicateKeysOptName, DuplicateKeysDefault, duplicateKeysDesc)
+	cmd.StringOptPtr(&envPrefix, envPrefixOptName, "", envPrefixDesc)
+	cmd.BoolOptPtr(&envNest, envNestOptName, false, envNestDesc)
+	cmd.StringOptPtr(&iniValueDelim, iniValueDelimOptName, "", iniValueDelimDesc)
+	cmd.BoolOptPtr(&keepEmptyDefault, keepEmptyDefaultOptName, false, keepEmptyDefaultDesc)
+	keepGoing, keepGoingSilent = false, false
+	cmd.VarOpt(keepGoingOptName, keepGoingValue{}, keepGoingDesc)
+	cmd.IntOptPtr(&maxErrors, maxErrorsOptName, 0, maxErrorsDesc)
+	cmd.IntOptPtr(&skipRecords, skipRecordsOptName, 0, skipRecordsDesc)
+	cmd.IntOptPtr(&recordLimit, recordLimitOptName, 0, recordLimitDesc)
+	cmd.StringOptPtr(&widths, widthsOptName, "", widthsDesc)
+	cmd.BoolOptPtr(&keepPadding, keepPaddingOptName, false, keepPaddingDesc)
+	cmd.BoolOptPtr(&strictWidths, strictWidthsOptName, false, strictWidthsDesc)
+}
+
+// Registers the options configuring the output format.
+func configureOutputOptions(cmd *mowcli.Cmd) {
+	cmd.BoolOptPtr(&prettyPrint, prettyPrintOptName, false, prettyPrintDesc)
+	cmd.StringOptPtr(&outputType, outputTypeOptName, autoFormat, outputTypeDesc)
+	cmd.BoolOptPtr(&multiDocument, multiDocumentOptName, false, multiDocumentDesc)
+	cmd.BoolOptPtr(&yamlDocStart, yamlDocStartOptName, false, yamlDocStartDesc)
+	cmd.StringOptPtr(&yamlNullStyle, yamlNullStyleOptName, "", yamlNullStyleDesc)
+	cmd.BoolOptPtr(&yamlQuoteStrings, yamlQuoteStringsOptName, false, yamlQuoteStringsDesc)
+	cmd.StringOptPtr(&tomlArrays, tomlArraysOptName, TOMLArraysAuto, tomlArraysDesc)
+	cmd.StringOptPtr(&defaultKey, defaultKeyOptName, "", defaultKeyDesc)
+	cmd.StringOptPtr(&iniNesting, iniNestingOptName, ININestingError, iniNestingDesc)
+	cmd.StringOptPtr(&iniArrays, iniArraysOptName, INIArraysRepeat, iniArraysDesc)
+	cmd.StringOptPtr(&envArrays, envArraysOptName, EnvArraysIndexed, envArraysDesc)
+	cmd.StringOptPtr(&columns, columnsOptName, "", columnsDesc)
+	cmd.BoolOptPtr(&dottedKeys, dottedKeysOptName, false, dottedKeysDesc)
+	cmd.BoolOptPtr(&truncate, truncateOptName, false, truncateDesc)
+	cmd.BoolOptPtr(&alignNumbers, alignNumbersOptName, false, alignNumbersDesc)
+	cmd.BoolOptPtr(&jsSafeNumbers, jsSafeNumbersOptName, false, jsSafeNumbersDesc)
+	cmd.StringOptPtr(&nonFinite, nonFiniteOptName, NonFiniteError, nonFiniteDesc)
+	cmd.StringOptPtr(&floatStyle, floatStyleOptName, FloatStyleAuto, floatStyleDesc)
+	cmd.IntOptPtr(&floatPrecision, floatPrecisionOptName, -1, floatPrecisionDesc)
+	cmd.BoolOptPtr(&asciiOnly, asciiOptName, false, asciiDesc)
+	cmd.BoolOptPtr(&keepComments, preserveCommentsOptName, false, preserveCommentsDesc)
+	cmd.BoolOptPtr(&verify, verifyOptName, false, verifyDesc)
+	cmd.BoolOptPtr(&outputBOM, outputBOMOptName, false, outputBOMDesc)
+	cmd.BoolOptPtr(&excelOutput, excelOptName, false, excelDesc)
+	cmd.Float64OptPtr(&verifyEpsilon, verifyEpsilonOptName, 0, verifyEpsilonDesc)
+	cmd.IntOptPtr(&outputBufferSize, outputBufferSizeOptName, outputBufferSize, outputBufferSizeDesc)
+}
+
+// Reads a data file with the input options from the command line, so that
+// each file can have its own format if auto-detected.
+func readInputFile(fileName string) (interface{}, error) {
+	inputFormat, err := NewInputFormat(fileName, inputFormatName(), fieldDelim, recordDelim)
+	if err != nil {
+		return nil, err
+	}
+	inputFormat = configureInputFormat(inputFormat, fileName)
+	data, err := ReadFile(fileName, inputFormat)
+	if err != nil {
+		return nil, err
+	}
+	return importTransformer().Transform(data)
+}
+
+// Reads the files as one stream (in the format of the first file) and
+// writes the result to stdout in the output format given.
+func concatFiles(files []string, outputFormatName string) {
+	inputFormat, err := NewInputFormat(files[0], inputFormatName(), fieldDelim, recordDelim)
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	inputFormat = configureInputFormat(inputFormat, files[0])
+	outputFormat, err := NewOutputFormat("", outputFormatName, prettyPrint)
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	data, err := ConcatFiles(files, inputFormat)
+	if err != nil {
+		exit(exitInputError, err.Error())
+	}
+	data, err = importTransformer().Transform(data)
+	if err != nil {
+		exit(exitTransformError, err.Error())
+	}
+	err = finalOutputFormat(configureOutputFormat(outputFormat)).Marshal(data, os.Stdout)
+	if err != nil {
+		exit(exitCodeFor(err, exitOutputError), err.Error())
+	}
+	reportSkippedRecords()
+}
+
+// Returns the output format for data read from the input: the requested
+// format or, if automatic, the input format if possible or JSON otherwise.
+func outputFormatFor(input string) OutputFormat {
+	outputFormat, err := NewOutputFormat(input, outputType, prettyPrint)
+	if err != nil && outputType == autoFormat {
+		outputFormat, err = NewOutputFormat("", formatNameJSON, prettyPrint)
+	}
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	return finalOutputFormat(configureOutputFormat(outputFormat))
+}
+
+// Writes the data to the file or only prints its name for dry runs.
+func writeOutput(fileName string, data interface{}, outputFormat OutputFormat, dryRun bool) {
+	if dryRun {
+		fmt.Println(fileName)
+		return
+	}
+	err := WriteFile(fileName, data, outputFormat)
+	if err != nil {
+		exit(exitCodeFor(err, exitOutputError), err.Error())
+	}
+}
+
+// Writes each element of an array or value of an object to a file of its
+// own, printing the names of the files written if report is set.
+func splitData(data interface{}, outputDir string, nameTemplate string, dryRun bool, report bool) {
+	outputFormat := outputFormatFor(input)
+	names, err := NewNameTemplate(nameTemplate)
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	var parts []OutputName
+	if keys := mapKeys(data); keys != nil {
+		for n, key := range keys {
+			value, _ := mapValue(data, key)
+			parts = append(parts, OutputName{Key: key, Index: n, data: value})
+		}
+	} else if elements, err := topLevelArray(data); err == nil {
+		for n, element := range elements {
+			parts = append(parts, OutputName{Index: n, data: element})
+		}
+	} else {
+		exit(exitTransformError, "cannot split "+describeType(data))
+	}
+
+	// all names are rendered first so that nothing is written if they collide
+	fileNames := make([]string, len(parts))
+	for n := range parts {
+		parts[n].Input, parts[n].InputBase = input, inputBaseName(input)
+		parts[n].Ext = formatExtension(outputFormat)
+		description := fmt.Sprintf("part %d", n)
+		if mapKeys(data) != nil {
+			description = fmt.Sprintf("key '%s'", parts[n].Key)
+		}
+		fileNames[n], err = names.Render(parts[n], description)
+		if err != nil {
+			exit(exitConfigurationError, err.Error())
+		}
+	}
+	for n, part := range parts {
+		writeOutput(filepath.Join(outputDir, fileNames[n]), part.data, outputFormat, dryRun)
+		if report && !dryRun {
+			fmt.Println(filepath.Join(outputDir, fileNames[n]))
+		}
+	}
+}
+
+// Converts the files into the directory with up to jobs files at a time.
+// Names are rendered in the order of the files, so that collisions are
+// reported the same way each time, and all errors are reported in that order
+// once every file was processed, with the exit codes combined.
+func batchConvert(files []string, outputDir string, nameTemplate string, dryRun bool, jobs int) {
+	names, err := NewNameTemplate(nameTemplate)
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	if jobs < 1 {
+		exit(exitConfigurationError, "the number of jobs must be positive")
+	}
+	code, messages := 0, make([]string, 0)
+	for _, result := range runBatch(newBatchTasks(files, outputDir, dryRun), names, jobs) {
+		if result.err != nil {
+			code |= result.code
+			messages = append(messages, result.err.Error())
+		}
+	}
+	if code != 0 {
+		if skippedRecords > 0 && !keepGoingSilent {
+			code |= exitSkippedRecords
+		}
+		exit(code, strings.Join(messages, "\n"))
+	}
+}
+
+// Creates the tasks of a batch with the formats and transformer configured
+// from the options up front, so that the workers do not use the option
+// globals. A file whose input format cannot be determined gets a task that
+// only reports the error.
+func newBatchTasks(files []string, outputDir string, dryRun bool) []batchTask {
+	transformer := importTransformer()
+	tasks := make([]batchTask, len(files))
+	for n, file := range files {
+		tasks[n] = batchTask{Index: n, Input: file, OutputDir: outputDir, DryRun: dryRun, Transformer: transformer}
+		inputFormat, err := NewInputFormat(file, inputFormatName(), fieldDelim, recordDelim)
+		if err != nil {
+			tasks[n].err = err
+			continue
+		}
+		tasks[n].InputFormat = configureInputFormat(inputFormat, file)
+		tasks[n].OutputFormat = outputFormatFor(file)
+	}
+	return tasks
+}
+
+// Runs the tasks with up to jobs tasks at a time and returns their results
+// in the order of the tasks.
+func runBatch(tasks []batchTask, names *NameTemplate, jobs int) []batchResult {
+	turns := make([]chan struct{}, len(tasks)+1)
+	for n := range turns {
+		turns[n] = make(chan struct{})
+	}
+	close(turns[0])
+
+	queue := make(chan batchTask)
+	results := make([]batchResult, len(tasks))
+	var workers sync.WaitGroup
+	for w := 0; w < jobs && w < len(tasks); w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for task := range queue {
+				results[task.Index] = task.run(names, turns[task.Index], turns[task.Index+1])
+			}
+		}()
+	}
+	for _, task := range tasks {
+		queue <- task
+	}
+	close(queue)
+	workers.Wait()
+	return results
+}
+
+// The conversion of a single file of a batch.
+type batchTask struct {
+	Index        int
+	Input        string
+	OutputDir    string
+	DryRun       bool
+	InputFormat  InputFormat
+	Transformer  Transformer
+	OutputFormat OutputFormat
+	err          error
+}
+
+type batchResult struct {
+	code int
+	err  error
+}
+
+// Converts the file. The name is rendered once turn is closed, next is
+// closed afterwards to let the following task render its name.
+func (t batchTask) run(names *NameTemplate, turn <-chan struct{}, next chan<- struct{}) batchResult {
+	data, code, err := t.read()
+	<-turn
+	if err != nil {
+		close(next)
+		return batchResult{code, fmt.Errorf("%s: %s", t.Input, err)}
+	}
+	fileName, err := names.Render(OutputName{
+		Index:     t.Index,
+		Input:     t.Input,
+		InputBase: inputBaseName(t.Input),
+		Ext:       formatExtension(t.OutputFormat),
+		data:      data,
+	}, "'"+t.Input+"'")
+	if err == nil && t.DryRun {
+		fmt.Println(filepath.Join(t.OutputDir, fileName))
+	}
+	close(next)
+	if err != nil {
+		return batchResult{exitConfigurationError, err}
+	} else if t.DryRun {
+		return batchResult{}
+	}
+	err = WriteFile(filepath.Join(t.OutputDir, fileName), data, t.OutputFormat)
+	if err != nil {
+		return batchResult{exitCodeFor(err, exitOutputError), fmt.Errorf("%s: %s", t.Input, err)}
+	}
+	return batchResult{}
+}
+
+// Reads and transforms the file, returning the exit code for the error.
+func (t batchTask) read() (interface{}, int, error) {
+	if t.err != nil {
+		return nil, exitInputError, t.err
+	}
+	data, err := ReadFile(t.Input, t.InputFormat)
+	if err != nil {
+		return nil, exitInputError, err
+	}
+	data, err = t.Transformer.Transform(data)
+	if err != nil {
+		return nil, exitCodeFor(err, exitTransformError), err
+	}
+	return data, 0, nil
+}
+
+func printHashes(files []string, algorithm string) {
+	for _, file := range files {
+		data, err := readInputFile(file)
+		if err != nil {
+			exit(exitInputError, fmt.Sprintf("%s: %s", file, err))
+		}
+		hash, err := HashData(data, algorithm)
+		if err != nil {
+			exit(exitTransformError, fmt.Sprintf("%s: %s", file, err))
+		}
+		fmt.Printf("%s  %s\n", hash, file)
+	}
+}
+
+func checkHashes(lists []string, algorithm string) {
+	failures := 0
+	for _, list := range lists {
+		reader, err := openInputFile(list)
+		if err != nil {
+			exit(exitInputError, err.Error())
+		}
+		entries, err := readChecksumList(reader)
+		reader.Close()
+		if err != nil {
+			exit(exitInputError, fmt.Sprintf("%s: %s", list, err))
+		}
+		for _, entry := range entries {
+			data, err := readInputFile(entry.Name)
+			var hash string
+			if err == nil {
+				hash, err = HashData(data, algorithm)
+			}
+			if err != nil {
+				fmt.Printf("%s: FAILED (%s)\n", entry.Name, err)
+				failures++
+			} else if hash != entry.Hash {
+				fmt.Printf("%s: FAILED\n", entry.Name)
+				failures++
+			} else {
+				fmt.Printf("%s: OK\n", entry.Name)
+			}
+		}
+	}
+	if failures > 0 {
+		exit(exitCheckError, fmt.Sprintf("%d computed checksum(s) did NOT match", failures))
+	}
+}
+
+// Reads an additional input file (such as defaults) with the delimiter and
+// parsing options of the main input.
+func readAuxiliaryFile(fileName string, formatName string) (interface{}, error) {
+	format, err := NewInputFormat(fileName, formatName, fieldDelim, recordDelim)
+	if err != nil {
+		return nil, err
+	}
+	format = configureInputFormat(format, fileName)
+	data, err := ReadFile(fileName, format)
+	if err != nil {
+		return nil, err
+	}
+	return importTransformer().Transform(data)
+}
+
+// Returns the input format from the command line configured for the input
+// (including the data of --data).
+func commandInputFormat() InputFormat {
+	configureInlineData()
+	inputFormat, err := NewInputFormat(input, inputFormatName(), fieldDelim, recordDelim)
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	return configureInputFormat(inputFormat, input)
+}
+
+// Create the output format and the default (import) transformer based on
+// command line arguments for the conversion, and returns its input format.
+func configureFormats(c conversion) (InputFormat, Transformer, OutputFormat) {
+	inputFormat := c.InputFormat
+	if keepComments {
+		inputFormat = selectInputFormat(inputFormat, input)
+	}
+	outputFormat, err := NewOutputFormat(output, c.OutputType, prettyPrint)
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	return inputFormat, NewMultiTransformer(importTransformer(), refsTransformer(), requireTransformer()), configureOutputFormat(outputFormat)
+}
+
+// Reads and writes lists of documents with --per-document (or perDocument
+// otherwise set), the transformer is applied to each document.
+func documentsFormats(perDocument bool, inputFormat InputFormat, transformer Transformer, outputFormat OutputFormat) (InputFormat, Transformer, OutputFormat) {
+	if !perDocument {
+		return inputFormat, transformer, outputFormat
+	} else if keepComments {
+		exit(exitConfigurationError, "comments cannot be preserved when processing each document on its own")
+	}
+	return DocumentsFormat{inputFormat}, PerDocumentTransformer{transformer}, documentsOutputFormat(outputFormat)
+}
+
+// Creates the transformer truncating the data with --limit-keys (after any
+// extraction with --at), if any.
+func truncateTransformer() Transformer {
+	if limitKeys == "" {
+		return nil
+	}
+	var limits []int
+	for _, limit := range strings.Split(limitKeys, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || n < 0 {
+			exit(exitConfigurationError, fmt.Sprintf("invalid limit '%s' of --%s (expected a number of keys, or 0 for all)", limit, limitKeysOptName))
+		}
+		limits = append(limits, n)
+	}
+	return TruncateTransformer{Limits: limits, Marker: limitMarker}
+}
+
+// Creates the transformer checking the paths of the require option.
+func requireTransformer() Transformer {
+	if requiredPaths == "" {
+		return nil
+	}
+	paths, err := ParsePaths(requiredPaths)
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	return RequireKeysTransformer{Paths: paths}
+}
+
+// Creates the transformer resolving $ref with --resolve-refs (or
+// --resolve-external-refs).
+func refsTransformer() Transformer {
+	if !resolveRefs && !externalRefs {
+		return nil
+	}
+	dir := ""
+	if input != "" && input != "-" {
+		dir = filepath.Dir(input)
+	}
+	return RefResolvingTransformer{External: externalRefs, Dir: dir, MaxDepth: maxRefDepth}
+}
+
+// Replaces the input by the value of the data option, if given.
+func configureInlineData() {
+	if !inlineDataSet {
+		return
+	}
+	if input != "" && input != "-" {
+		exit(exitConfigurationError, "the data option cannot be used with an input file")
+	}
+	if strings.HasPrefix(inlineData, "@") {
+		input = strings.TrimPrefix(inlineData, "@")
+		return
+	}
+	stdin = strings.NewReader(inlineData)
+	if inputType == autoFormat && !sniff {
+		inputType = formatNameJSON
+	}
+}
+
+// Returns the input format from the command line, where automatic detection
+// uses the content rather than the file name with --sniff.
+func inputFormatName() string {
+	if sniff && inputType == autoFormat {
+		return sniffFormat
+	}
+	return inputType
+}
+
+// Applies format-specific output options from the command line.
+func configureOutputFormat(outputFormat OutputFormat) OutputFormat {
+	if format, ok := outputFormat.(TextFormat); excelOutput && (!ok || format.FieldDelimiter == "") {
+		exit(exitConfigurationError, "--"+excelOptName+" requires "+formatNameCSF+" output")
+	}
+	if !containsFold(tomlArrays, tomlArrayStyles) {
+		exit(exitConfigurationError, "unknown TOML array style '"+tomlArrays+"'")
+	} else if !containsFold(nonFinite, nonFinitePolicies) {
+		exit(exitConfigurationError, "unknown policy for non-finite numbers '"+nonFinite+"'")
+	} else if !containsFold(iniNesting, iniNestingPolicies) {
+		exit(exitConfigurationError, "unknown INI nesting policy '"+iniNesting+"'")
+	} else if !containsFold(iniArrays, iniArrayStyles) {
+		exit(exitConfigurationError, "unknown INI array style '"+iniArrays+"'")
+	} else if !containsFold(envArrays, envArrayStyles) {
+		exit(exitConfigurationError, "unknown ENV array style '"+envArrays+"'")
+	}
+	if _, ok := yamlNullStyles[strings.ToLower(yamlNullStyle)]; !ok && yamlNullStyle != "" {
+		exit(exitConfigurationError, "unknown YAML null style '"+yamlNullStyle+"'")
+	}
+	floats := FloatFormat{Style: strings.ToLower(floatStyle), Precision: floatPrecision}
+	if err := floats.validate(); err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	switch format := outputFormat.(type) {
+	case YAMLFormat:
+		format.MultiDocument, format.Floats = multiDocument, floats
+		format.ExplicitStart, format.NullStyle = yamlDocStart, strings.ToLower(yamlNullStyle)
+		format.QuoteStrings = yamlQuoteStrings
+		outputFormat = format
+	case TOMLFormat:
+		format.ArrayStyle, format.Floats = strings.ToLower(tomlArrays), floats
+		format.DefaultKey = defaultKey
+		outputFormat = format
+	case JSONFormat:
+		format.JSSafeNumbers, format.NonFinite, format.Floats = jsSafeNumbers, strings.ToLower(nonFinite), floats
+		format.ASCIIOnly = asciiOnly
+		outputFormat = format
+	case NDJSONFormat:
+		format.JSSafeNumbers, format.NonFinite, format.Floats = jsSafeNumbers, strings.ToLower(nonFinite), floats
+		format.ASCIIOnly = asciiOnly
+		outputFormat = format
+	case INIFormat:
+		format.NestingPolicy, format.ArrayStyle = strings.ToLower(iniNesting), strings.ToLower(iniArrays)
+		format.DefaultKey = defaultKey
+		outputFormat = format
+	case EnvFormat:
+		format.Prefix, format.ArrayStyle = envPrefix, strings.ToLower(envArrays)
+		format.DefaultKey = defaultKey
+		outputFormat = format
+	case TextFormat:
+		if format.FieldDelimiter != "" {
+			configured, err := NewTextFormat(recordDelim, fieldDelim)
+			if err != nil {
+				exit(exitConfigurationError, err.Error())
+			}
+			format.FieldDelimiter, format.RecordDelimiter = configured.FieldDelimiter, configured.RecordDelimiter
+		}
+		format.Header, format.DottedKeys = header, dottedKeys
+		if columns != "" {
+			format.Columns = columnNames(columns)
+		}
+		if excelOutput && format.FieldDelimiter != "" {
+			format.RecordDelimiter = "\r\n"
+			if fieldDelim == "," && localeUsesDecimalComma() {
+				format.FieldDelimiter = ";"
+			}
+		}
+		outputFormat = format
+	case FixedWidthFormat:
+		var err error
+		if widths != "" {
+			format.Widths, format.Names, err = ParseFieldWidths(widths)
+		} else if strings.Contains(columns, ":") {
+			format.Widths, format.Names, err = ParseFieldWidths(columns)
+		}
+		if err != nil {
+			exit(exitConfigurationError, err.Error())
+		}
+		if columns != "" {
+			format.Names = columnNames(columns)
+		}
+		format.Header, format.Truncate, format.AlignNumbers = header, truncate, alignNumbers
+		outputFormat = format
+	}
+	return applyFormatOptions(outputFormat).(OutputFormat)
+}
+
+// Checks if floats are formatted, so that JSON input must keep integers apart.
+func formatsFloats() bool {
+	return !strings.EqualFold(floatStyle, FloatStyleAuto) || floatPrecision >= 0
+}
+
+// Applies format-specific input options from the command line for reading
+// the file (where empty names and `-` indicate stdin).
+func configureInputFormat(inputFormat InputFormat, fileName string) InputFormat {
+	if fallback, ok := inputFormat.(FallbackFormat); ok {
+		return configureFallbackFormat(fallback, fileName)
+	}
+	if sniffing, ok := inputFormat.(SniffingFormat); ok {
+		if keepGoing || keepGoingSilent || maxErrors > 0 {
+			exit(exitConfigurationError, "skipping records is not supported with several input formats")
+		}
+		if skipRecords != 0 || recordLimit != 0 {
+			exit(exitConfigurationError, "--"+skipRecordsOptName+" and --"+recordLimitOptName+
+				" are not supported with several input formats")
+		}
+		formats := make([]InputFormat, len(sniffing.Formats))
+		for i, format := range sniffing.Formats {
+			formats[i] = configureInputFormat(format, fileName)
+		}
+		sniffing.Formats = formats
+		if sniffing.Default == nil && inputFormatHint != "" {
+			hint, err := NewInputFormat(fileName, inputFormatHint, fieldDelim, recordDelim)
+			if err != nil {
+				exit(exitConfigurationError, err.Error())
+			} else if _, ok := hint.(SniffingFormat); ok || strings.Contains(inputFormatHint, ",") {
+				exit(exitConfigurationError, "the input format hint must be a single format")
+			}
+			sniffing.Default = hint
+		}
+		if sniffing.Default != nil {
+			sniffing.Default = configureInputFormat(sniffing.Default, fileName)
+		}
+		if verbose {
+			sniffing.OnSelect = func(format InputFormat) {
+				os.Stderr.WriteString(fmt.Sprintf("read the input as %s\n", format.Name()))
+			}
+		}
+		return sniffing
+	}
+	if skipRows < 0 {
+		exit(exitConfigurationError, "the number of rows to skip must not be negative")
+	}
+	if maxErrors < 0 {
+		exit(exitConfigurationError, "the maximum number of errors must not be negative")
+	}
+	if skipRecords < 0 || recordLimit < 0 {
+		exit(exitConfigurationError, "the numbers of records to skip and to read must not be negative")
+	}
+	onRecordError := recordErrorHandler()
+	inputFormat = configureDuplicateKeys(inputFormat)
+	switch format := inputFormat.(type) {
+	case JSONFormat:
+		format.UseNumber = jsSafeNumbers || formatsFloats()
+		inputFormat = format
+	case NDJSONFormat:
+		format.UseNumber = jsSafeNumbers || formatsFloats()
+		inputFormat = format
+	case INIFormat:
+		delim, err := NormalizeDelim(iniValueDelim)
+		if err != nil {
+			exit(exitConfigurationError, err.Error())
+		}
+		format.ValueDelimiter, format.KeepEmptyDefault = delim, keepEmptyDefault
+		inputFormat = format
+	}
+	if envFormat, ok := inputFormat.(EnvFormat); ok {
+		envFormat.Prefix = envPrefix
+		envFormat.Nest = envNest
+		if fileName == "" || fileName == "-" {
+			envFormat.Environment = os.Environ()
+		}
+		inputFormat = envFormat
+	}
+	if err := recordOptionsConflict(inputFormat); err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	if ndjsonFormat, ok := inputFormat.(NDJSONFormat); ok {
+		ndjsonFormat.OnRecordError = onRecordError
+		ndjsonFormat.SkipRecords = skipRecords
+		ndjsonFormat.RecordLimit = recordLimit
+		inputFormat = ndjsonFormat
+	}
+	if textFormat, ok := inputFormat.(TextFormat); ok {
+		textFormat.OnRecordError = onRecordError
+		textFormat.SkipRows = skipRows
+		textFormat.SkipRecords = skipRecords
+		textFormat.RecordLimit = recordLimit
+		textFormat.Header = header
+		textFormat.PreserveOrder = preserveOrder
+		if headerRename != "" {
+			if !header {
+				exit(exitConfigurationError, "header renaming requires the header option")
+			}
+			var err error
+			textFormat.HeaderRename, err = parseKeyValueList(headerRename)
+			if err != nil {
+				exit(exitConfigurationError, err.Error())
+			}
+		}
+		inputFormat = textFormat
+	}
+	if presetFormat, ok := inputFormat.(PresetFormat); ok {
+		presetFormat.OnRecordError = onRecordError
+		presetFormat.SkipRecords = skipRecords
+		presetFormat.RecordLimit = recordLimit
+		presetFormat.PreserveOrder = preserveOrder
+		inputFormat = presetFormat
+	}
+	if fixedFormat, ok := inputFormat.(FixedWidthFormat); ok {
+		fields := widths
+		if fields == "" && strings.Contains(columns, ":") {
+			fields = columns
+		}
+		if fields == "" {
+			exit(exitConfigurationError, formatNameFixed+" input requires --"+widthsOptName)
+		}
+		var err error
+		fixedFormat.Widths, fixedFormat.Names, err = ParseFieldWidths(fields)
+		if err != nil {
+			exit(exitConfigurationError, err.Error())
+		}
+		fixedFormat.KeepPadding, fixedFormat.Strict = keepPadding, strictWidths
+		fixedFormat.Header, fixedFormat.PreserveOrder = header, preserveOrder
+		fixedFormat.SkipRows, fixedFormat.SkipRecords, fixedFormat.RecordLimit = skipRows, skipRecords, recordLimit
+		fixedFormat.OnRecordError = onRecordError
+		inputFormat = fixedFormat
+	}
+	return applyFormatOptions(inputFormat).(InputFormat)
+}
+
+// Configures the formats of a list (see FallbackFormat) which support the
+// record options given, the first of which that can read the input is used
+// when it is read.
+func configureFallbackFormat(fallback FallbackFormat, fileName string) InputFormat {
+	formats := make([]InputFormat, 0, len(fallback.Formats))
+	var conflict error
+	for _, format := range fallback.Formats {
+		if err := recordOptionsConflict(format); err != nil {
+			if conflict == nil {
+				conflict = err
+			}
+			continue
+		}
+		formats = append(formats, configureInputFormat(format, fileName))
+	}
+	if len(formats) == 0 {
+		exit(exitConfigurationError, conflict.Error())
+	}
+	fallback.Formats = formats
+	if verbose {
+		fallback.OnSelect = func(format InputFormat) {
+			os.Stderr.WriteString(fmt.Sprintf("read the input as %s\n", format.Name()))
+		}
+	}
+	return fallback
+}
+
+// Returns the names of comma-separated columns, which may be given with
+// their widths (as for fixed-width input) as NAME:WIDTH.
+func columnNames(columns string) []string {
+	names := strings.Split(columns, ",")
+	if _, fieldNames, err := ParseFieldWidths(columns); err == nil && len(fieldNames) > 0 {
+		names = fieldNames
+	}
+	return names
+}
+
+// Applies the format options from the command line to the format.
+func applyFormatOptions(format FileFormat) FileFormat {
+	options, err := ParseFormatOptions(formatOptions)
+	if err == nil {
+		format, err = options.Apply(format)
+	}
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	return format
+}
+
+// Applies the policy for duplicate keys. YAML and TOML parsers always
+// reject duplicate keys, so other policies cannot be supported for them.
+func configureDuplicateKeys(inputFormat InputFormat) InputFormat {
+	policy := strings.ToLower(duplicateKeys)
+	if policy != DuplicateKeysDefault && !containsFold(policy, duplicateKeyPolicies) {
+		exit(exitConfigurationError, "unknown duplicate key policy '"+duplicateKeys+"'")
+	}
+	switch format := inputFormat.(type) {
+	case JSONFormat:
+		format.DuplicateKeys = policy
+		return format
+	case NDJSONFormat:
+		format.DuplicateKeys = policy
+		return format
+	case INIFormat:
+		format.DuplicateKeys = policy
+		return format
+	case EnvFormat:
+		format.DuplicateKeys = policy
+		return format
+	case TextFormat:
+		format.DuplicateKeys = policy
+		return format
+	}
+	if policy == DuplicateKeysFirst || policy == DuplicateKeysLast {
+		exit(exitConfigurationError, "duplicate keys are always an error for "+inputFormat.Name()+" input")
+	}
+	return inputFormat
+}
+
+// Returns the format of a list of input formats (see FallbackFormat) that can
+// read the input, for commands that need to know it before reading the input
+// (which is read an additional time for this, stdin is buffered). Other
+// formats, and lists none of whose formats can read the input (which fails
+// when it is read), are returned as they are.
+func selectInputFormat(inputFormat InputFormat, fileName string) InputFormat {
+	fallback, ok := inputFormat.(FallbackFormat)
+	if !ok {
+		return inputFormat
+	}
+	content, err := readFileContent(fileName)
+	if fileName == "" || fileName == "-" {
+		stdin = bytes.NewReader(content)
+	}
+	if err != nil {
+		return inputFormat
+	}
+	selected, _, err := fallback.selectFormat(content)
+	if err != nil {
+		return inputFormat
+	}
+	if fallback.OnSelect != nil {
+		fallback.OnSelect(selected)
+	}
+	return selected
+}
+
+// Returns an error if records are to be skipped or limited, but the format
+// has none.
+func recordOptionsConflict(format InputFormat) error {
+	if _, ok := format.(NDJSONFormat); ok || isTextFormat(format) {
+		return nil
+	} else if recordErrorHandler() != nil {
+		return errors.New("skipping records is not supported for " + format.Name() + " input")
+	} else if skipRecords != 0 || recordLimit != 0 {
+		return errors.New("--" + skipRecordsOptName + " and --" + recordLimitOptName +
+			" are not supported for " + format.Name() + " input (which has no records)")
+	}
+	return nil
+}
+
+// Checks if the format reads records from text (strings, CSF or fixed-width
+// fields).
+func isTextFormat(format InputFormat) bool {
+	switch format.(type) {
+	case TextFormat, FixedWidthFormat, PresetFormat:
+		return true
+	}
+	return false
+}
+
+// Counts and reports a record that could not be read or transformed, as long as the
+// maximum number of errors is not exceeded.
+func skipRecord(record int, err error) bool {
+	skippedRecordsLock.Lock()
+	defer skippedRecordsLock.Unlock()
+	skippedRecords++
+	if maxErrors > 0 && skippedRecords > maxErrors {
+		return false
+	}
+	if !keepGoingSilent && !quiet {
+		os.Stderr.WriteString(fmt.Sprintf("warning: skipping record %d: %s\n", record, err))
+	}
+	return true
+}
+
+// Returns the handler skipping records with --keep-going or --max-errors (or nil).
+func recordErrorHandler() RecordErrorHandler {
+	if keepGoing || keepGoingSilent || maxErrors > 0 {
+		return skipRecord
+	}
+	return nil
+}
+
+// The value of --keep-going, a boolean option that can also be set to
+// `silent` (--keep-going=silent) to skip records without warnings.
+type keepGoingValue struct{}
+
+func (keepGoingValue) Set(value string) error {
+	if strings.EqualFold(value, "silent") {
+		keepGoing, keepGoingSilent = false, true
+		return nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid value '%s' (expected a boolean or silent)", value)
+	}
+	keepGoing, keepGoingSilent = enabled, false
+	return nil
+}
+
+func (keepGoingValue) String() string {
+	if keepGoingSilent {
+		return "silent"
+	}
+	return strconv.FormatBool(keepGoing)
+}
+
+func (keepGoingValue) IsBoolFlag() bool {
+	return true
+}
+
+func (keepGoingValue) IsDefault() bool {
+	return !keepGoing && !keepGoingSilent
+}
+
+// Exits with an error if records were skipped (unless silenced).
+func reportSkippedRecords() {
+	if skippedRecords > 0 && !keepGoingSilent {
+		exit(exitSkippedRecords, fmt.Sprintf("%d record(s) could not be read or transformed and were skipped", skippedRecords))
+	}
+}
+
+// Returns the hooks recording the stages of the conversion with --timings
+// and --debug-dump.
+func stageHooks() []StageHook {
+	var hooks []StageHook
+	if timings {
+		hooks = append(hooks, recordedTimings.Record)
+	}
+	if debugDumpFile != "" {
+		dump, err := newStageDump(debugDumpFile)
+		if err != nil {
+			exit(exitOutputError, err.Error())
+		}
+		recordedDump = dump
+		hooks = append(hooks, dump.Record)
+	}
+	return hooks
+}
+
+// Writes the stages recorded with --timings to stderr and reports errors
+// writing --debug-dump (which do not fail the conversion).
+func reportStages() {
+	if timings && len(recordedTimings.Stages) > 0 {
+		_ = recordedTimings.Write(os.Stderr)
+	}
+	if recordedDump != nil && recordedDump.Err != nil && !quiet {
+		fmt.Fprintf(os.Stderr, "the data could not be dumped to %s: %s\n", recordedDump.File, recordedDump.Err)
+	}
+}
+
+// Creates the transformer applied to data directly after reading it
+// based on command line arguments, which coerces strings of any input
+// format to booleans and numbers or the types of --types (and checks the
+// result with --strict-parse).
+func importTransformer() Transformer {
+	var converters []StringConverter
+	if coerceBools {
+		parser, err := StringToBoolParser(strings.Split(trueStrings, ","), strings.Split(falseStrings, ","))
+		if err != nil {
+			exit(exitConfigurationError, err.Error())
+		}
+		converters = append(converters, parser)
+	}
+	if stringToJSONNumber {
+		converters = append(converters, StringToFiniteNumberParser)
+	}
+	if len(converters) == 0 && strictParse && typesFile == "" {
+		exit(exitConfigurationError, "--"+strictParseOptName+" requires --"+
+			strings.Split(stringTo64bfNumberOptName, " ")[0]+", --"+coerceBoolsOptName+" or --"+typesOptName)
+	} else if len(converters) == 0 && typesFile == "" {
+		return NopTransformer{}
+	}
+	var transformer Transformer
+	if typesFile != "" {
+		transformer = typeCoercion(converters)
+	} else {
+		transformer = NewConfigurableTransformer(ChainStringConverters(converters...), nil, nil, nil, nil)
+	}
+	if strictParse {
+		return NewMultiTransformer(transformer, StrictParseTransformer{})
+	}
+	return transformer
+}
+
+// Creates the transformer coercing values to the types declared in the
+// --types file, which passes all other strings to the converters.
+func typeCoercion(converters []StringConverter) Transformer {
+	format, err := NewInputFormat(typesFile, autoFormat, fieldDelim, recordDelim)
+	if err != nil {
+		exit(exitConfigurationError, err.Error())
+	}
+	data, err := ReadFile(typesFile, format)
+	if err != nil {
+		exit(exitInputError, err.Error())
+	}
+	types, err := ParseTypeDeclarations(data)
+	if err != nil {
+		exit(exitConfigurationError, fmt.Sprintf("invalid types in %s: %s", typesFile, err))
 	}
-	var transformer Transformer = NopTransformer{}
-	if stringToJSONNumber &&
-		(inputFormat.Name() == formatNameINI || inputFormat.Name() == formatNameCSF) {
-		transformer = NewConfigurableTransformer(StringToFiniteNumberParser, nil, nil, nil, nil)
+	coercion := TypeCoercionTransformer{Types: types, Lenient: typesLenient,
+		TrueStrings: strings.Split(trueStrings, ","), FalseStrings: strings.Split(falseStrings, ",")}
+	if len(converters) > 0 {
+		coercion.Fallback = ChainStringConverters(converters...)
 	}
-	return inputFormat, transformer, outputFormat
+	return coercion
 }
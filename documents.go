@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// A transformer applying another transformer to each document of a list of
+// documents (see DocumentsFormat) on its own.
+type PerDocumentTransformer struct {
+	Transformer Transformer
+}
+
+func (t PerDocumentTransformer) Transform(data interface{}) (interface{}, error) {
+	documents, ok := data.([]interface{})
+	if !ok {
+		return data, fmt.Errorf("expected a list of documents but found %s", describeType(data))
+	}
+	if t.Transformer == nil {
+		return documents, nil
+	}
+	for n, document := range documents {
+		transformed, err := t.Transformer.Transform(document)
+		if err != nil {
+			return data, fmt.Errorf("document %d: %s", n+1, err)
+		}
+		documents[n] = transformed
+	}
+	return documents, nil
+}
+
+// An input format reading a list of documents: the documents of YAML input
+// (even if there is only one) or the data as a single document.
+type DocumentsFormat struct {
+	InputFormat
+}
+
+func (f DocumentsFormat) Unmarshal(reader io.Reader) (interface{}, error) {
+	if yamlFormat, ok := f.InputFormat.(YAMLFormat); ok {
+		yamlFormat.Documents = true
+		return yamlFormat.Unmarshal(reader)
+	}
+	data, err := f.InputFormat.Unmarshal(reader)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{data}, nil
+}
+
+// Returns an output format writing a list of documents: YAML as separate
+// documents, JSON and NDJSON as one record per line, and other formats only
+// if there is a single document.
+func documentsOutputFormat(format OutputFormat) OutputFormat {
+	switch f := format.(type) {
+	case YAMLFormat:
+		f.MultiDocument = true
+		return f
+	case JSONFormat:
+		return NDJSONFormat{JSSafeNumbers: f.JSSafeNumbers, NonFinite: f.NonFinite, Floats: f.Floats, ASCIIOnly: f.ASCIIOnly}
+	case NDJSONFormat:
+		return f
+	}
+	return singleDocumentFormat{format}
+}
+
+// An output format writing the only document of a list of documents.
+type singleDocumentFormat struct {
+	OutputFormat
+}
+
+func (f singleDocumentFormat) Marshal(data interface{}, w io.Writer) error {
+	documents, ok := data.([]interface{})
+	if !ok || len(documents) != 1 {
+		return fmt.Errorf("%s output can only hold a single document", f.Name())
+	}
+	return f.OutputFormat.Marshal(documents[0], w)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Policies for keys occurring more than once in the same object (or section).
+// The default keeps the behaviour of the format's parser.
+const (
+	DuplicateKeysDefault = ""
+	DuplicateKeysFirst   = "first"
+	DuplicateKeysLast    = "last"
+	DuplicateKeysError   = "error"
+)
+
+var duplicateKeyPolicies = []string{DuplicateKeysFirst, DuplicateKeysLast, DuplicateKeysError}
+
+// Selects the value of a duplicate key according to the policy from the
+// values in the order they occur.
+func selectDuplicate(policy string, key string, at Path, values []interface{}) (interface{}, error) {
+	switch {
+	case len(values) == 1:
+		return values[0], nil
+	case policy == DuplicateKeysError:
+		return nil, fmt.Errorf("duplicate key '%s' in %s", key, describePath(at))
+	case policy == DuplicateKeysFirst:
+		return values[0], nil
+	default:
+		return values[len(values)-1], nil
+	}
+}
+
+// Decodes a single JSON value (like json.Unmarshal into an interface{})
+// applying the policy to duplicate keys.
+func decodeJSONValue(decoder *json.Decoder, policy string) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSONToken(decoder, token, policy, Path{})
+}
+
+func decodeJSONToken(decoder *json.Decoder, token json.Token, policy string, at Path) (interface{}, error) {
+	switch token {
+	case json.Delim('{'):
+		object := make(map[string]interface{})
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyToken.(string)
+			valueToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSONToken(decoder, valueToken, policy, at.append(PathSegment{Key: key}))
+			if err != nil {
+				return nil, err
+			}
+			if previous, ok := object[key]; ok {
+				value, err = selectDuplicate(policy, key, at, []interface{}{previous, value})
+				if err != nil {
+					return nil, err
+				}
+			}
+			object[key] = value
+		}
+		_, err := decoder.Token() // '}'
+		return object, err
+	case json.Delim('['):
+		array := make([]interface{}, 0)
+		for decoder.More() {
+			elementToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			element, err := decodeJSONToken(decoder, elementToken, policy, at.append(PathSegment{IsIndex: true, Index: len(array)}))
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, element)
+		}
+		_, err := decoder.Token() // ']'
+		return array, err
+	default:
+		return token, nil
+	}
+}
+
+// Decodes a complete JSON document applying the policy to duplicate keys.
+func unmarshalJSONWithPolicy(reader io.Reader, policy string) (interface{}, error) {
+	return decodeJSONDocument(json.NewDecoder(reader), policy)
+}
+
+// Decodes the only value of a JSON document (with the decoder's settings)
+// applying the policy to duplicate keys.
+func decodeJSONDocument(decoder *json.Decoder, policy string) (interface{}, error) {
+	value, err := decodeJSONValue(decoder, policy)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid data after the top-level value")
+	}
+	return value, nil
+}
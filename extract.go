@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// An input format reading only the value at a path (without wildcards) of
+// JSON input, like JSONFormat followed by ExtractTransformer. The rest of the
+// document is only read as tokens and never decoded, so that memory is
+// bounded by the size of the value rather than that of the document. As
+// with JSONFormat, the last of duplicate keys is used unless the policy
+// selects the first one (the error policy is not supported).
+type JSONSubtreeFormat struct {
+	JSONFormat
+	Path Path
+}
+
+func (f JSONSubtreeFormat) Unmarshal(reader io.Reader) (interface{}, error) {
+	if f.Path.hasWildcards() {
+		return nil, fmt.Errorf("cannot extract a path with wildcards from the token stream")
+	} else if f.DuplicateKeys == DuplicateKeysError {
+		return nil, fmt.Errorf("duplicate keys cannot be detected when extracting a path from the token stream")
+	}
+	decoder := json.NewDecoder(reader)
+	if f.UseNumber {
+		decoder.UseNumber()
+	}
+	value, found, err := f.extract(decoder, f.Path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid data after the top-level value")
+	}
+	if !found {
+		return nil, fmt.Errorf("there is no value at %s", describePath(f.Path))
+	}
+	return value, nil
+}
+
+// Reads the next value, decoding only the value at the path within it.
+func (f JSONSubtreeFormat) extract(decoder *json.Decoder, path Path) (interface{}, bool, error) {
+	if len(path) == 0 {
+		if f.DuplicateKeys != DuplicateKeysDefault {
+			value, err := decodeJSONValue(decoder, f.DuplicateKeys)
+			return value, err == nil, err
+		}
+		var value interface{}
+		err := decoder.Decode(&value)
+		return value, err == nil, err
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, false, err
+	}
+	segment := path[0]
+	var (
+		value interface{}
+		found bool
+	)
+	switch {
+	case token == json.Delim('{') && !segment.IsIndex:
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, false, err
+			}
+			if key != segment.Key || (found && f.DuplicateKeys == DuplicateKeysFirst) {
+				err = skipJSONValue(decoder)
+			} else {
+				// like the value of the key, a later one replaces the value
+				value, found, err = f.extract(decoder, path[1:])
+			}
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	case token == json.Delim('[') && segment.IsIndex:
+		for n := 0; decoder.More(); n++ {
+			if n != segment.Index {
+				err = skipJSONValue(decoder)
+			} else {
+				value, found, err = f.extract(decoder, path[1:])
+			}
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	case token == json.Delim('{') || token == json.Delim('['):
+		for decoder.More() {
+			if err = skipJSONValue(decoder); err != nil {
+				return nil, false, err
+			}
+		}
+	default:
+		return nil, false, nil
+	}
+	// the closing delimiter
+	if _, err = decoder.Token(); err != nil {
+		return nil, false, err
+	}
+	return value, found, nil
+}
+
+// Reads the next value (or key and value) as tokens without decoding it.
+func skipJSONValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
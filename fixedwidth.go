@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Lines with fields of fixed widths (in runes) rather than delimiters, read
+// as arrays of strings or, with Names or a Header, as objects. Padding spaces
+// at the end of fields are removed unless KeepPadding is set. Fields missing
+// at the end of short lines are empty, the rest of long lines is added to the
+// last field or, with Strict, is an error.
+//
+// With Header, the first record (after SkipRows) holds the names of the
+// fields, unless Names are given which are used instead. SkipRecords and
+// RecordLimit select records after the header as for TextFormat.
+//
+// As output, records are written like CSF (with objects as the values of
+// the Names or of all keys) with the fields padded with spaces to the Widths
+// or, without them, to the widest value of each column. Values longer than
+// their column are an error unless Truncate is set.
+type FixedWidthFormat struct {
+	Widths        []int
+	Names         []string
+	KeepPadding   bool
+	Strict        bool
+	Header        bool
+	PreserveOrder bool
+	SkipRows      int
+	SkipRecords   int
+	RecordLimit   int
+	OnRecordError RecordErrorHandler
+	Truncate      bool
+	// Right-align the columns whose values are all numbers (or null).
+	AlignNumbers bool
+}
+
+func (f FixedWidthFormat) Name() string {
+	return formatNameFixed
+}
+
+func (f FixedWidthFormat) SupportedExtensions() []string {
+	return []string{}
+}
+
+func (f FixedWidthFormat) Unmarshal(reader io.Reader) (interface{}, error) {
+	if len(f.Widths) == 0 {
+		return nil, fmt.Errorf("%s input requires the widths of the fields", formatNameFixed)
+	} else if len(f.Names) > 0 && len(f.Names) != len(f.Widths) {
+		return nil, fmt.Errorf("there are %d field names but %d widths", len(f.Names), len(f.Widths))
+	}
+	for _, width := range f.Widths {
+		if width < 1 {
+			return nil, fmt.Errorf("the widths of fields must be positive")
+		}
+	}
+	lines, err := readRecords(reader, "", recordsToRead(f.SkipRows, f.Header, f.SkipRecords, f.RecordLimit))
+	if err != nil {
+		return nil, err
+	}
+	if f.SkipRows >= len(lines) {
+		lines = lines[:0]
+	} else if f.SkipRows > 0 {
+		lines = lines[f.SkipRows:]
+	}
+
+	names, first := f.Names, 1
+	if f.Header && len(lines) > 0 {
+		if len(names) == 0 {
+			header, _ := f.fields(lines[0])
+			names = make([]string, len(header))
+			for n, name := range header {
+				names[n] = strings.TrimSpace(name)
+			}
+		}
+		lines, first = lines[1:], 2
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate field name '%s'", name)
+		}
+		seen[name] = true
+	}
+
+	data := make([]interface{}, 0, len(lines))
+	for n, line := range recordWindow(lines, f.SkipRecords, f.RecordLimit) {
+		if line == "" {
+			continue
+		}
+		fields, err := f.fields(line)
+		if err != nil {
+			record := n + first + f.SkipRecords
+			err = fmt.Errorf("record %d %s", record, err)
+			if f.OnRecordError != nil && f.OnRecordError(record, err) {
+				continue
+			}
+			return nil, err
+		}
+		data = append(data, f.record(names, fields))
+	}
+	return data, nil
+}
+
+// Slices the line into fields.
+func (f FixedWidthFormat) fields(line string) ([]string, error) {
+	runes := []rune(line)
+	fields := make([]string, len(f.Widths))
+	start := 0
+	for n, width := range f.Widths {
+		end := start + width
+		if end > len(runes) {
+			end = len(runes)
+		}
+		if n == len(f.Widths)-1 && end < len(runes) {
+			if f.Strict {
+				return nil, fmt.Errorf("has %d characters but the fields only %d", len(runes), end)
+			}
+			end = len(runes)
+		}
+		if start < end {
+			fields[n] = string(runes[start:end])
+		}
+		if !f.KeepPadding {
+			fields[n] = strings.TrimRight(fields[n], " ")
+		}
+		start = end
+	}
+	return fields, nil
+}
+
+func (f FixedWidthFormat) record(names []string, fields []string) interface{} {
+	if len(names) == 0 {
+		record := make([]interface{}, len(fields))
+		for n, field := range fields {
+			record[n] = field
+		}
+		return record
+	}
+	if f.PreserveOrder {
+		object := NewOrderedMap()
+		for n, name := range names {
+			object.Set(name, fields[n])
+		}
+		return object
+	}
+	object := make(map[string]interface{}, len(names))
+	for n, name := range names {
+		object[name] = fields[n]
+	}
+	return object
+}
+
+func (f FixedWidthFormat) Marshal(data interface{}, w io.Writer) error {
+	csf := TextFormat{FieldDelimiter: ",", Columns: f.Names, Header: f.Header}
+	records, columns, err := csf.records(data)
+	if err != nil {
+		return err
+	}
+	// records start with the header (if written), which is not aligned
+	header := f.Header && len(columns) > 0
+	count := len(f.Widths)
+	numeric := make([]bool, 0)
+	cells := make([][]string, len(records))
+	for r, record := range records {
+		cells[r] = make([]string, len(record.fields))
+		for n, value := range record.fields {
+			field, err := csfField(value)
+			if err != nil {
+				return err
+			} else if strings.ContainsAny(field, "\r\n") {
+				return &MarshalError{Path: record.paths[n], Reason: "fields cannot contain line breaks"}
+			}
+			cells[r][n] = field
+			if n >= len(numeric) {
+				numeric = append(numeric, true)
+			}
+			switch scalarType(value) {
+			case valueTypeInt, valueTypeFloat, valueTypeNull:
+			default:
+				numeric[n] = numeric[n] && r == 0 && header
+			}
+		}
+		if len(f.Widths) > 0 && len(record.fields) > len(f.Widths) {
+			return &MarshalError{Path: record.paths[len(f.Widths)],
+				Reason: fmt.Sprintf("the record has %d fields but there are only %d widths", len(record.fields), len(f.Widths))}
+		} else if len(record.fields) > count {
+			count = len(record.fields)
+		}
+	}
+	widths := f.Widths
+	if len(widths) == 0 {
+		widths = make([]int, count)
+		for _, record := range cells {
+			for n, field := range record {
+				if length := utf8.RuneCountInString(field); length > widths[n] {
+					widths[n] = length
+				}
+			}
+		}
+	}
+
+	var output strings.Builder
+	for r, record := range cells {
+		for n, width := range widths {
+			field := ""
+			if n < len(record) {
+				field = record[n]
+			}
+			runes := []rune(field)
+			if len(runes) > width && !f.Truncate {
+				return &MarshalError{Path: records[r].paths[n],
+					Reason: fmt.Sprintf("the value has %d characters but the column only %d", len(runes), width)}
+			} else if len(runes) > width {
+				field = string(runes[:width])
+			}
+			padding := strings.Repeat(" ", width-utf8.RuneCountInString(field))
+			if f.AlignNumbers && n < len(numeric) && numeric[n] && !(r == 0 && header) {
+				output.WriteString(padding + field)
+			} else {
+				output.WriteString(field + padding)
+			}
+		}
+		output.WriteString("\n")
+	}
+	_, err = io.WriteString(w, output.String())
+	return err
+}
+
+// Parses the widths of fields given as a comma-separated list of widths or
+// NAME:WIDTH pairs, returning the names if given (for all fields).
+func ParseFieldWidths(list string) ([]int, []string, error) {
+	var widths []int
+	var names []string
+	for n, field := range strings.Split(list, ",") {
+		name, width := "", strings.TrimSpace(field)
+		if i := strings.LastIndex(field, ":"); i >= 0 {
+			name, width = strings.TrimSpace(field[:i]), strings.TrimSpace(field[i+1:])
+			if name == "" {
+				return nil, nil, fmt.Errorf("invalid field '%s' (expected NAME:WIDTH)", field)
+			}
+		}
+		parsed, err := strconv.Atoi(width)
+		if err != nil || parsed < 1 {
+			return nil, nil, fmt.Errorf("invalid width '%s' of field %d", width, n+1)
+		}
+		if (name == "") != (len(names) == 0) && n > 0 {
+			return nil, nil, fmt.Errorf("either all or no fields must have names")
+		}
+		widths = append(widths, parsed)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return widths, names, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Notations for floats in the output.
+const (
+	FloatStyleAuto        = "auto"
+	FloatStyleFixed       = "fixed"
+	FloatStyleExponential = "exponential"
+)
+
+var floatStyles = []string{FloatStyleAuto, FloatStyleFixed, FloatStyleExponential}
+
+// How floats (but not integers) are written by JSON, NDJSON, YAML and TOML
+// output.
+//
+// With FloatStyleAuto, each format uses its own notation and a non-negative
+// precision only rounds to that many decimals. FloatStyleFixed and
+// FloatStyleExponential use `ddd.ddd` and `d.ddde±dd` with exactly that
+// many decimals, or as many as necessary for a negative precision. Non-finite
+// numbers are left alone. The zero value (without a style) changes nothing.
+//
+// Floats without a fractional part cannot be told apart from integers in
+// JSON input unless it is read with UseNumber.
+type FloatFormat struct {
+	Style     string
+	Precision int
+}
+
+// A float with its text in the output.
+type formattedFloat struct {
+	value float64
+	text  string
+}
+
+func (f formattedFloat) String() string {
+	return f.text
+}
+
+func (f formattedFloat) MarshalJSON() ([]byte, error) {
+	return []byte(f.text), nil
+}
+
+func (f formattedFloat) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: f.text}, nil
+}
+
+// The TOML encoder only supports text (which it quotes), so the quotes are
+// removed again by unquoteTOMLFloats.
+func (f formattedFloat) MarshalText() ([]byte, error) {
+	return []byte(tomlFloatMarker + f.text), nil
+}
+
+const tomlFloatMarker = "\ue000"
+
+var tomlFloatPattern = regexp.MustCompile(`"` + tomlFloatMarker + `([^"]*)"`)
+
+func unquoteTOMLFloats(output []byte) []byte {
+	if !bytes.Contains(output, []byte(tomlFloatMarker)) {
+		return output
+	}
+	return tomlFloatPattern.ReplaceAll(output, []byte("$1"))
+}
+
+func (f FloatFormat) validate() error {
+	if f.Style != "" && !containsFold(f.Style, floatStyles) {
+		return fmt.Errorf("unknown float style '%s'", f.Style)
+	}
+	return nil
+}
+
+// Replaces the floats in the data according to the format.
+func (f FloatFormat) apply(data interface{}) (interface{}, error) {
+	style := strings.ToLower(f.Style)
+	if err := f.validate(); err != nil {
+		return data, err
+	} else if style == "" || (style == FloatStyleAuto && f.Precision < 0) {
+		return data, nil
+	}
+	return transformLeavesCopy(data, func(value interface{}, at Path) (interface{}, error) {
+		var x float64
+		switch v := value.(type) {
+		case float64:
+			x = v
+		case float32:
+			x = float64(v)
+		case json.Number:
+			// exact JSON input distinguishes integers from floats
+			if !strings.ContainsAny(string(v), ".eE") {
+				if i, err := v.Int64(); err == nil {
+					return i, nil
+				}
+				return value, nil
+			}
+			var err error
+			if x, err = v.Float64(); err != nil {
+				return value, nil
+			}
+		default:
+			return value, nil
+		}
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return value, nil
+		}
+		switch style {
+		case FloatStyleFixed:
+			text := strconv.FormatFloat(x, 'f', f.Precision, 64)
+			if f.Precision < 0 && !strings.Contains(text, ".") {
+				text += ".0"
+			}
+			return formattedFloat{x, text}, nil
+		case FloatStyleExponential:
+			return formattedFloat{x, strconv.FormatFloat(x, 'e', f.Precision, 64)}, nil
+		}
+		rounded, err := strconv.ParseFloat(strconv.FormatFloat(x, 'f', f.Precision, 64), 64)
+		if err != nil {
+			return value, err
+		}
+		return rounded, nil
+	})
+}
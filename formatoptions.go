@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Options of formats by format name (in lower case) and option name, which
+// set the fields of the format with the same name in kebab case, e.g.
+// `yaml:explicit-start=true` sets YAMLFormat.ExplicitStart. Booleans,
+// integers, strings and comma-separated lists of strings can be set, except
+// for the fields in internalFormatFields. Fields named *Delimiter take
+// delimiters such as `TAB`, see NormalizeDelim.
+type FormatOptions map[string]map[string]string
+
+// Fields set by dfmt itself rather than by options.
+var internalFormatFields = map[string]bool{"Nodes": true, "KeepLayout": true, "Documents": true, "Environment": true, "Preset": true}
+
+// Shorter names accepted for options, e.g. `yaml:indent=4`.
+var formatOptionAliases = map[string]string{"indent": "indentation"}
+
+// Parses options given as FORMAT:OPTION=VALUE and checks that the format
+// has the option.
+func ParseFormatOptions(options []string) (FormatOptions, error) {
+	parsed := make(FormatOptions)
+	for _, option := range options {
+		kv := strings.SplitN(option, "=", 2)
+		name := strings.SplitN(kv[0], ":", 2)
+		if len(kv) != 2 || len(name) != 2 || name[1] == "" {
+			return nil, fmt.Errorf("invalid format option '%s' (expected FORMAT:OPTION=VALUE)", option)
+		}
+		format, err := NewFormat("", strings.TrimSpace(name[0]), ",", "NL", false)
+		if err != nil {
+			return nil, fmt.Errorf("unknown format '%s' in format option '%s'", name[0], option)
+		}
+		formatName := strings.ToLower(format.Name())
+		if parsed[formatName] == nil {
+			parsed[formatName] = make(map[string]string)
+		}
+		optionName := strings.ToLower(strings.TrimSpace(name[1]))
+		if alias, ok := formatOptionAliases[optionName]; ok {
+			optionName = alias
+		}
+		parsed[formatName][optionName] = kv[1]
+		if _, err = (FormatOptions{formatName: parsed[formatName]}).Apply(format); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// Returns the format with the options for its name applied.
+func (o FormatOptions) Apply(format FileFormat) (FileFormat, error) {
+	options := o[strings.ToLower(format.Name())]
+	if len(options) == 0 || reflect.TypeOf(format).Kind() != reflect.Struct {
+		return format, nil
+	}
+	fields := formatOptionFields(format)
+	configured := reflect.New(reflect.TypeOf(format)).Elem()
+	configured.Set(reflect.ValueOf(format))
+	for option, value := range options {
+		fieldName, ok := fields[option]
+		if !ok {
+			names := make([]string, 0, len(fields))
+			for name := range fields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return format, fmt.Errorf("unknown %s format option '%s' (expected one of %s)",
+				format.Name(), option, strings.Join(names, ", "))
+		}
+		if err := setFormatField(configured.FieldByName(fieldName), fieldName, value); err != nil {
+			return format, fmt.Errorf("invalid value '%s' of %s format option '%s': %s", value, format.Name(), option, err)
+		}
+	}
+	return configured.Interface().(FileFormat), nil
+}
+
+// Returns the names of the fields of the format by option name.
+func formatOptionFields(format FileFormat) map[string]string {
+	fields := make(map[string]string)
+	t := reflect.TypeOf(format)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || internalFormatFields[field.Name] {
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Bool, reflect.Int, reflect.String:
+		case reflect.Slice:
+			if field.Type.Elem().Kind() != reflect.String {
+				continue
+			}
+		default:
+			continue
+		}
+		fields[kebabCase(field.Name)] = field.Name
+	}
+	return fields
+}
+
+func setFormatField(field reflect.Value, name string, value string) error {
+	switch field.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("not a boolean")
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("not an integer")
+		}
+		field.SetInt(int64(n))
+	case reflect.String:
+		if strings.HasSuffix(name, "Delimiter") {
+			delim, err := NormalizeDelim(value)
+			if err != nil {
+				return err
+			}
+			value = delim
+		}
+		field.SetString(value)
+	case reflect.Slice:
+		var values []string
+		if value != "" {
+			values = strings.Split(value, ",")
+		}
+		field.Set(reflect.ValueOf(values))
+	}
+	return nil
+}
+
+// Converts a Go name to kebab case, e.g. `JSSafeNumbers` to
+// `js-safe-numbers`.
+func kebabCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for n, r := range runes {
+		if n > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[n-1]) || (n+1 < len(runes) && unicode.IsLower(runes[n+1]))) {
+			b.WriteByte('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
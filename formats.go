@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
@@ -9,7 +10,13 @@ import (
 	"io/ioutil"
 	"path"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	toml "github.com/BurntSushi/toml"
 	ini "github.com/go-ini/ini"
@@ -17,7 +24,8 @@ import (
 )
 
 const (
-	autoFormat = "auto"
+	autoFormat  = "auto"
+	sniffFormat = "sniff"
 )
 
 var (
@@ -30,6 +38,12 @@ var (
 	formatNamesNTStr   []string = []string{"NTStr", "NTStrings", "NTString", "NTS"}
 	formatNameNTStr    string   = formatNamesNTStr[0]
 	formatNameCSF      string   = "CSF"
+	formatNamesNDJSON  []string = []string{"NDJSON", "JSONL", "JSONLines"}
+	formatNameNDJSON   string   = formatNamesNDJSON[0]
+	formatNameEnv      string   = "ENV"
+	formatNameShell    string   = "Shell"
+	formatNamesFixed   []string = []string{"FixedWidth", "Fixed", "FWF"}
+	formatNameFixed    string   = formatNamesFixed[0]
 
 	fidJSON     string   = strings.ToLower(formatNameJSON)
 	fidYAML     string   = strings.ToLower(formatNameYAML)
@@ -38,6 +52,10 @@ var (
 	fidsStrings []string = sliceToLower(formatNamesStrings)
 	fidsNTStr   []string = sliceToLower(formatNamesNTStr)
 	fidCSF      string   = strings.ToLower(formatNameCSF)
+	fidsNDJSON  []string = sliceToLower(formatNamesNDJSON)
+	fidEnv      string   = strings.ToLower(formatNameEnv)
+	fidShell    string   = strings.ToLower(formatNameShell)
+	fidsFixed   []string = sliceToLower(formatNamesFixed)
 )
 
 type Unmarshaler interface {
@@ -70,8 +88,19 @@ type InputOutputFormat interface {
 }
 
 type JSONFormat struct {
-	PrettyPrint bool
-	Indentation int
+	PrettyPrint   bool
+	Indentation   int
+	DuplicateKeys string
+	// Decode numbers as json.Number, keeping their exact value.
+	UseNumber bool
+	// Write integers JavaScript cannot represent exactly as strings.
+	JSSafeNumbers bool
+	// The policy for non-finite numbers JSON cannot represent (see
+	// NonFiniteTransformer).
+	NonFinite string
+	Floats    FloatFormat
+	// Escape all non-ASCII characters as \uXXXX.
+	ASCIIOnly bool
 }
 
 func (f JSONFormat) Name() string {
@@ -83,6 +112,15 @@ func (f JSONFormat) SupportedExtensions() []string {
 }
 
 func (f JSONFormat) Unmarshal(reader io.Reader) (interface{}, error) {
+	if f.UseNumber {
+		decoder := json.NewDecoder(reader)
+		decoder.UseNumber()
+		return decodeJSONDocument(decoder, f.DuplicateKeys)
+	} else if f.DuplicateKeys != DuplicateKeysDefault {
+		return unmarshalJSONWithPolicy(reader, f.DuplicateKeys)
+	}
+	// json.Decoder would buffer the complete value as well (and accept
+	// trailing data), so reading it first does not cost more memory
 	bytes, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return nil, err
@@ -96,29 +134,299 @@ func (f JSONFormat) Unmarshal(reader io.Reader) (interface{}, error) {
 }
 
 func (f JSONFormat) Marshal(data interface{}, w io.Writer) error {
-	indent := createIndentString(f.PrettyPrint, f.Indentation)
-	var (
-		bytes []byte
-		err   error
-	)
+	data, err := applyNonFinitePolicy(data, f.NonFinite)
+	if err != nil {
+		return err
+	}
+	if f.JSSafeNumbers {
+		data, err = JSSafeNumberTransformer{}.Transform(data)
+		if err != nil {
+			return err
+		}
+	}
+	data, err = f.Floats.apply(data)
+	if err != nil {
+		return err
+	}
+	if f.ASCIIOnly {
+		w = asciiWriter{w}
+	}
+	// unlike json.MarshalIndent, the encoder indents into a pooled buffer
+	encoder := json.NewEncoder(valueWriter{w})
 	if f.PrettyPrint {
-		bytes, err = json.MarshalIndent(data, "", indent)
-	} else {
-		bytes, err = json.Marshal(data)
+		encoder.SetIndent("", createIndentString(f.PrettyPrint, f.Indentation))
+	}
+	return describeNonFiniteError(data, encoder.Encode(data))
+}
+
+// Applies the policy for non-finite numbers (unless they are errors anyway).
+func applyNonFinitePolicy(data interface{}, policy string) (interface{}, error) {
+	if policy == "" || strings.EqualFold(policy, NonFiniteError) {
+		return data, nil
+	}
+	return NonFiniteTransformer{Policy: policy}.Transform(data)
+}
+
+// Replaces the JSON encoder's error for non-finite numbers by one with their path.
+func describeNonFiniteError(data interface{}, err error) error {
+	var unsupported *json.UnsupportedValueError
+	if errors.As(err, &unsupported) {
+		if _, found := (NonFiniteTransformer{}).Transform(data); found != nil {
+			return found
+		}
+	}
+	return err
+}
+
+// Writes the output of a json.Encoder without the newline it adds after
+// each value (it writes each value with a single call).
+type valueWriter struct {
+	io.Writer
+}
+
+func (w valueWriter) Write(p []byte) (int, error) {
+	_, err := w.Writer.Write(bytes.TrimSuffix(p, []byte("\n")))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// A writer escaping the non-ASCII characters of encoded JSON, which only
+// occur in strings. It relies on being passed complete values.
+type asciiWriter struct {
+	io.Writer
+}
+
+func (w asciiWriter) Write(p []byte) (int, error) {
+	_, err := w.Writer.Write(escapeNonASCII(p))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Replaces non-ASCII characters by \uXXXX escapes (surrogate pairs beyond
+// the Basic Multilingual Plane).
+func escapeNonASCII(encoded []byte) []byte {
+	start := bytes.IndexFunc(encoded, func(r rune) bool { return r >= utf8.RuneSelf })
+	if start < 0 {
+		return encoded
+	}
+	escaped := bytes.NewBuffer(make([]byte, 0, len(encoded)+len(encoded)/2))
+	escaped.Write(encoded[:start])
+	for rest := encoded[start:]; len(rest) > 0; {
+		r, size := utf8.DecodeRune(rest)
+		rest = rest[size:]
+		if r < utf8.RuneSelf {
+			escaped.WriteByte(byte(r))
+		} else if r1, r2 := utf16.EncodeRune(r); r1 != unicode.ReplacementChar {
+			fmt.Fprintf(escaped, "\\u%04x\\u%04x", r1, r2)
+		} else {
+			fmt.Fprintf(escaped, "\\u%04x", r)
+		}
+	}
+	return escaped.Bytes()
+}
+
+// Handles an error in a single (1-based) record of a record-oriented
+// format. The record is skipped if it returns true, otherwise the whole
+// input fails.
+type RecordErrorHandler func(record int, err error) bool
+
+// Newline-delimited JSON (JSON Lines): one JSON value per line, read as and
+// written from a top-level array.
+//
+// Without an error handler, values may span lines like in a JSON stream.
+// With one, each non-empty line must be a complete value.
+type NDJSONFormat struct {
+	OnRecordError RecordErrorHandler
+	DuplicateKeys string
+	UseNumber     bool
+	JSSafeNumbers bool
+	NonFinite     string
+	Floats        FloatFormat
+	ASCIIOnly     bool
+	// Discard this many records (without decoding them) and read at most
+	// RecordLimit records after them (all if not positive).
+	SkipRecords int
+	RecordLimit int
+}
+
+func (f NDJSONFormat) Name() string {
+	return formatNameNDJSON
+}
+
+func (f NDJSONFormat) SupportedExtensions() []string {
+	return []string{".ndjson", ".jsonl"}
+}
+
+func (f NDJSONFormat) Unmarshal(reader io.Reader) (interface{}, error) {
+	if f.OnRecordError != nil {
+		return f.unmarshalLines(reader)
+	}
+	data := make([]interface{}, 0)
+	decoder := json.NewDecoder(reader)
+	if f.UseNumber {
+		decoder.UseNumber()
+	}
+	for skipped := 0; skipped < f.SkipRecords; skipped++ {
+		var record json.RawMessage
+		if err := decoder.Decode(&record); err == io.EOF {
+			return data, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	for !recordLimitReached(len(data), f.RecordLimit) {
+		value, err := f.decode(decoder)
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, value)
+	}
+	return data, nil
+}
+
+// Checks if the number of records read reached the limit (if positive).
+func recordLimitReached(count int, limit int) bool {
+	return limit > 0 && count >= limit
+}
+
+// Returns the number of records to read for recordWindow after skipRows
+// records and a header (if any), or 0 for all of them if there is no limit.
+func recordsToRead(skipRows int, header bool, skip int, limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	count := limit
+	for _, n := range []int{skipRows, skip} {
+		if n > 0 {
+			count += n
+		}
+	}
+	if header {
+		count++
+	}
+	return count
+}
+
+// Returns the records after the first skip ones, at most limit of them (all
+// if limit is not positive).
+func recordWindow(records []string, skip int, limit int) []string {
+	if skip >= len(records) {
+		return records[:0]
+	} else if skip > 0 {
+		records = records[skip:]
+	}
+	if recordLimitReached(len(records), limit) {
+		records = records[:limit]
+	}
+	return records
+}
+
+func (f NDJSONFormat) unmarshalLines(reader io.Reader) (interface{}, error) {
+	data := make([]interface{}, 0)
+	lines := bufio.NewReader(reader)
+	skipped := 0
+	for n := 1; !recordLimitReached(len(data), f.RecordLimit); n++ {
+		line, err := lines.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if strings.TrimSpace(line) != "" && skipped < f.SkipRecords {
+			skipped++
+		} else if strings.TrimSpace(line) != "" {
+			if value, perr := f.decodeLine(line); perr == nil {
+				data = append(data, value)
+			} else if !f.OnRecordError(n, perr) {
+				return nil, fmt.Errorf("line %d: %s", n, perr)
+			}
+		}
+		if err == io.EOF {
+			return data, nil
+		}
+	}
+	return data, nil
+}
+
+func (f NDJSONFormat) decode(decoder *json.Decoder) (interface{}, error) {
+	if f.DuplicateKeys != DuplicateKeysDefault {
+		return decodeJSONValue(decoder, f.DuplicateKeys)
+	}
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+func (f NDJSONFormat) decodeLine(line string) (interface{}, error) {
+	if f.UseNumber {
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		return decodeJSONDocument(decoder, f.DuplicateKeys)
+	} else if f.DuplicateKeys != DuplicateKeysDefault {
+		return unmarshalJSONWithPolicy(strings.NewReader(line), f.DuplicateKeys)
 	}
+	var value interface{}
+	err := json.Unmarshal([]byte(line), &value)
+	return value, err
+}
+
+// Writes each element of a top-level array as a line, anything else as a single line.
+func (f NDJSONFormat) Marshal(data interface{}, w io.Writer) error {
+	data, err := applyNonFinitePolicy(data, f.NonFinite)
 	if err != nil {
 		return err
 	}
-	_, err = w.Write(bytes)
+	if f.JSSafeNumbers {
+		data, err = JSSafeNumberTransformer{}.Transform(data)
+		if err != nil {
+			return err
+		}
+	}
+	data, err = f.Floats.apply(data)
 	if err != nil {
 		return err
 	}
+	elements, ok := data.([]interface{})
+	if !ok {
+		elements = []interface{}{data}
+	}
+	for _, element := range elements {
+		bytes, err := json.Marshal(element)
+		if err != nil {
+			return describeNonFiniteError(data, err)
+		}
+		if f.ASCIIOnly {
+			bytes = escapeNonASCII(bytes)
+		}
+		_, err = w.Write(append(bytes, '\n'))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 type YAMLFormat struct {
-	PrettyPrint bool
-	Indentation int
+	PrettyPrint   bool
+	Indentation   int
+	MultiDocument bool
+	// Read the documents as nodes (keeping comments) for transformers
+	// supporting them, see preserveComments.
+	Nodes  bool
+	Floats FloatFormat
+	// Start the (first) document with `---`.
+	ExplicitStart bool
+	// How nulls are written (see yamlNullStyles), as the encoder or input does by default.
+	NullStyle string
+	// Read the documents as an array even if there is a single one (or none).
+	Documents bool
+	// Write all strings (and keys) double-quoted.
+	QuoteStrings bool
 }
 
 func (f YAMLFormat) Name() string {
@@ -131,6 +439,19 @@ func (f YAMLFormat) SupportedExtensions() []string {
 
 func (f YAMLFormat) Unmarshal(reader io.Reader) (interface{}, error) {
 	decoder := yaml.NewDecoder(reader)
+	if f.Nodes {
+		nodes := make(yamlDocuments, 0)
+		for {
+			node := &yaml.Node{}
+			err := decoder.Decode(node)
+			if errors.Is(err, io.EOF) {
+				return nodes, nil
+			} else if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		}
+	}
 	var documents []interface{} = make([]interface{}, 0)
 	for {
 		var document interface{}
@@ -144,7 +465,7 @@ func (f YAMLFormat) Unmarshal(reader io.Reader) (interface{}, error) {
 		}
 		documents = append(documents, document)
 	}
-	if len(documents) == 1 {
+	if len(documents) == 1 && !f.Documents {
 		return documents[0], nil
 	} else {
 		return documents, nil
@@ -160,7 +481,50 @@ func (f YAMLFormat) Marshal(data interface{}, w io.Writer) error {
 	}
 	encoder.SetIndent(spaces)
 
-	err := encoder.Encode(data)
+	if _, ok := data.(yamlDocuments); !ok {
+		var err error
+		data, err = f.Floats.apply(data)
+		if err != nil {
+			return err
+		}
+	}
+	documents := []interface{}{data}
+	if nodes, ok := data.(yamlDocuments); ok && f.MultiDocument {
+		documents = make([]interface{}, len(nodes))
+		for n, node := range nodes {
+			documents[n] = node
+		}
+	} else if ok {
+		documents[0] = combineDocuments(nodes)
+	} else if f.MultiDocument {
+		if elements, ok := data.([]interface{}); ok {
+			documents = elements
+		}
+	}
+	if f.ExplicitStart {
+		buffer.WriteString("---\n")
+	}
+	for _, document := range documents {
+		if f.NullStyle != "" {
+			node, err := styleYAMLNulls(document, f.NullStyle)
+			if err != nil {
+				return err
+			}
+			document = node
+		}
+		if f.QuoteStrings {
+			node, err := quoteYAMLStrings(document)
+			if err != nil {
+				return err
+			}
+			document = node
+		}
+		err := encoder.Encode(document)
+		if err != nil {
+			return err
+		}
+	}
+	err := encoder.Close()
 	if err != nil {
 		return err
 	}
@@ -171,10 +535,92 @@ func (f YAMLFormat) Marshal(data interface{}, w io.Writer) error {
 	return nil
 }
 
+const (
+	YAMLNullsEmpty = "empty"
+	YAMLNullsNull  = "null"
+	YAMLNullsTilde = "tilde"
+)
+
+// The spellings of nulls in YAML output: nothing at all (`key:`), `null` or `~`.
+var yamlNullStyles = map[string]string{
+	YAMLNullsEmpty: "",
+	YAMLNullsNull:  "null",
+	YAMLNullsTilde: "~",
+}
+
+// Converts the document into nodes and spells their nulls in the style.
+func styleYAMLNulls(document interface{}, style string) (*yaml.Node, error) {
+	spelling, ok := yamlNullStyles[strings.ToLower(style)]
+	if !ok {
+		return nil, fmt.Errorf("unknown YAML null style '%s'", style)
+	}
+	node, ok := document.(*yaml.Node)
+	if !ok {
+		node = &yaml.Node{}
+		if err := node.Encode(document); err != nil {
+			return nil, err
+		}
+	}
+	var restyle func(node *yaml.Node)
+	restyle = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" {
+			node.Value, node.Style = spelling, 0
+		}
+		for _, child := range node.Content {
+			restyle(child)
+		}
+	}
+	restyle(node)
+	return node, nil
+}
+
+// Converts the document to a node (unless it is one) with all strings in
+// double quotes, so that no parser reads them as other types (e.g. `yes` as
+// a boolean).
+func quoteYAMLStrings(document interface{}) (*yaml.Node, error) {
+	node, ok := document.(*yaml.Node)
+	if !ok {
+		node = &yaml.Node{}
+		if err := node.Encode(document); err != nil {
+			return nil, err
+		}
+	}
+	var quote func(node *yaml.Node)
+	quote = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str" {
+			node.Style = yaml.DoubleQuotedStyle
+		}
+		for _, child := range node.Content {
+			quote(child)
+		}
+	}
+	quote(node)
+	return node, nil
+}
+
+const (
+	TOMLArraysAuto      = "auto"
+	TOMLArraysInline    = "inline"
+	TOMLArraysMultiline = "multiline"
+	TOMLArraysJoined    = "joined"
+)
+
+// The styles for TOML arrays: the encoder's choice (inline arrays except for
+// arrays of tables), always inline (`[1, 2]`, with inline tables for
+// objects), one element per line (nested arrays stay inline, arrays of
+// tables stay arrays of tables), or arrays of scalars joined into
+// comma-separated strings.
+var tomlArrayStyles = []string{TOMLArraysAuto, TOMLArraysInline, TOMLArraysMultiline, TOMLArraysJoined}
+
 type TOMLFormat struct {
 	PrettyPrint bool
 	Indentation int
 	DefaultKey  string
+	ArrayStyle  string
+	// Read the text along with the data so that changes can be applied to
+	// it, keeping comments and layout (see tomlLayoutTransformer).
+	KeepLayout bool
+	Floats     FloatFormat
 }
 
 func (f TOMLFormat) Name() string {
@@ -195,35 +641,322 @@ func (f TOMLFormat) Unmarshal(reader io.Reader) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	if f.KeepLayout {
+		return tomlDocument{text: string(bytes), data: value}, nil
+	}
 	return value, nil
 }
 
 func (f TOMLFormat) Marshal(data interface{}, w io.Writer) error {
+	if document, ok := data.(tomlDocument); ok {
+		_, err := io.WriteString(w, document.text)
+		return err
+	}
 	buffer := &bytes.Buffer{}
 	encoder := toml.NewEncoder(buffer)
 	encoder.Indent = createIndentString(f.PrettyPrint, f.Indentation)
 
+	data, err := f.Floats.apply(plainMaps(data))
+	if err != nil {
+		return err
+	}
+	if f.ArrayStyle == TOMLArraysJoined {
+		data, _ = joinScalarArrays(data)
+	}
 	var ndata interface{}
 	switch reflect.ValueOf(data).Kind() {
 	case reflect.Map, reflect.Struct:
 		ndata = data
+		err = checkTOMLArrays(data, Path{})
 	default:
 		ndata = map[string]interface{}{NonemptyDefaultKey(f.DefaultKey): data}
+		err = checkTOMLArrays(data, Path{PathSegment{Key: NonemptyDefaultKey(f.DefaultKey)}})
 	}
-	err := encoder.Encode(ndata)
 	if err != nil {
 		return err
 	}
-	_, err = w.Write(buffer.Bytes())
+	arrays := &tomlArrayWriter{Style: f.ArrayStyle, KeyIndent: encoder.Indent,
+		ElementIndent: createIndentString(true, f.Indentation)}
+	if f.ArrayStyle == TOMLArraysInline || f.ArrayStyle == TOMLArraysMultiline {
+		if ndata, err = arrays.replace(ndata, 0); err != nil {
+			return err
+		}
+	}
+	err = encodeTOML(encoder, ndata)
+	if err != nil {
+		return err
+	}
+	output := unquoteTOMLFloats(arrays.write(buffer.Bytes()))
+	_, err = w.Write(output)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// An error for a value that the output format cannot represent.
+type MarshalError struct {
+	Path   Path
+	Reason string
+}
+
+func (e *MarshalError) Error() string {
+	return fmt.Sprintf("cannot marshal the value at %s: %s", describePath(e.Path), e.Reason)
+}
+
+// Checks that the arrays in the data can be written as TOML: arrays of objects
+// become arrays of tables, arrays of other values inline arrays, but objects
+// cannot be mixed with other values and nulls are not allowed in arrays
+// (unlike in objects where they are left out).
+func checkTOMLArrays(data interface{}, at Path) error {
+	if isObject(data) {
+		for _, key := range mapKeys(data) {
+			value, _ := mapValue(data, key)
+			if err := checkTOMLArrays(value, at.append(PathSegment{Key: key})); err != nil {
+				return err
+			}
+		}
+		return nil
+	} else if _, ok := data.(string); ok {
+		return nil
+	}
+	elements, err := topLevelArray(data)
+	if err != nil {
+		return nil
+	}
+	tables := 0
+	for n, element := range elements {
+		elementPath := at.append(PathSegment{Index: n, IsIndex: true})
+		if isNil(element) {
+			return &MarshalError{Path: elementPath, Reason: "TOML arrays cannot contain nulls"}
+		} else if isObject(element) {
+			tables++
+		}
+		if err := checkTOMLArrays(element, elementPath); err != nil {
+			return err
+		}
+	}
+	if tables > 0 && tables < len(elements) {
+		return &MarshalError{Path: at, Reason: "TOML arrays cannot mix tables with other values"}
+	}
+	return nil
+}
+
+// Encodes the data and turns any panics of the encoder into errors.
+func encodeTOML(encoder *toml.Encoder, data interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot marshal the data as TOML: %v", r)
+		}
+	}()
+	return encoder.Encode(data)
+}
+
+// Replaces arrays consisting only of scalars (and not nulls) by their
+// comma-separated string representations, recursively. The data is left
+// alone: the objects and arrays containing joined arrays are copied, and the
+// result reports whether anything was joined.
+func joinScalarArrays(data interface{}) (interface{}, bool) {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		result, copied := data, false
+		for k, v := range d {
+			joined, changed := joinScalarArrays(v)
+			if !changed {
+				continue
+			} else if !copied {
+				result, copied = shallowCopy(data), true
+			}
+			result.(map[string]interface{})[k] = joined
+		}
+		return result, copied
+	case []interface{}:
+		if joined, ok := joinScalars(d); ok {
+			return joined, true
+		}
+		result, copied := data, false
+		for n, v := range d {
+			joined, changed := joinScalarArrays(v)
+			if !changed {
+				continue
+			} else if !copied {
+				result, copied = shallowCopy(data), true
+			}
+			result.([]interface{})[n] = joined
+		}
+		return result, copied
+	}
+	return data, false
+}
+
+func joinScalars(elements []interface{}) (string, bool) {
+	strs := make([]string, len(elements))
+	for n, element := range elements {
+		switch describeType(element) {
+		case "null", "an object", "an array":
+			return "", false
+		}
+		strs[n] = fmt.Sprint(element)
+	}
+	return strings.Join(strs, ","), len(elements) > 0
+}
+
+// Writes the arrays that are values of keys in the inline or multiline
+// style. The encoder decides on the layout of arrays itself, so replace
+// substitutes placeholders for them, which the encoder writes as quoted
+// strings, and write puts the arrays rendered from the data in their place.
+// Multiline arrays of tables stay arrays of tables.
+type tomlArrayWriter struct {
+	Style string
+	// The indentation of nested keys (as used by the encoder) and of the
+	// elements of multiline arrays.
+	KeyIndent, ElementIndent string
+	arrays                   []string
+}
+
+type tomlArrayPlaceholder int
+
+func (p tomlArrayPlaceholder) MarshalText() ([]byte, error) {
+	return []byte(tomlArrayMarker + strconv.Itoa(int(p))), nil
+}
+
+const tomlArrayMarker = "\ue001"
+
+var (
+	tomlArrayPattern = regexp.MustCompile(`"` + tomlArrayMarker + `([0-9]+)"`)
+	tomlBareKey      = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// Returns a copy of the data with placeholders for the arrays, with depth
+// the number of keys of the tables containing it.
+func (w *tomlArrayWriter) replace(data interface{}, depth int) (interface{}, error) {
+	if !isObject(data) {
+		return data, nil
+	}
+	replaced := make(map[string]interface{}, len(mapKeys(data)))
+	for _, key := range mapKeys(data) {
+		value, _ := mapValue(data, key)
+		elements, err := topLevelArray(value)
+		if _, isString := value.(string); isString || err != nil {
+			if replaced[key], err = w.replace(value, depth+1); err != nil {
+				return data, err
+			}
+			continue
+		}
+		if w.Style == TOMLArraysMultiline && len(elements) > 0 && isObject(elements[0]) {
+			tables := make([]interface{}, len(elements))
+			for n, element := range elements {
+				if tables[n], err = w.replace(element, depth+1); err != nil {
+					return data, err
+				}
+			}
+			replaced[key] = tables
+			continue
+		}
+		rendered, err := w.render(elements, depth)
+		if err != nil {
+			return data, err
+		}
+		replaced[key] = tomlArrayPlaceholder(len(w.arrays))
+		w.arrays = append(w.arrays, rendered)
+	}
+	return replaced, nil
+}
+
+// Renders the array of a key in the tables at the depth.
+func (w *tomlArrayWriter) render(elements []interface{}, depth int) (string, error) {
+	if w.Style != TOMLArraysMultiline || len(elements) == 0 {
+		return tomlInlineValue(elements)
+	}
+	indent := strings.Repeat(w.KeyIndent, depth)
+	var b strings.Builder
+	b.WriteString("[\n")
+	for _, element := range elements {
+		rendered, err := tomlInlineValue(element)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(indent + w.ElementIndent + rendered + ",\n")
+	}
+	b.WriteString(indent + "]")
+	return b.String(), nil
+}
+
+// Replaces the placeholders in the encoded data by the rendered arrays.
+func (w *tomlArrayWriter) write(encoded []byte) []byte {
+	if len(w.arrays) == 0 {
+		return encoded
+	}
+	return tomlArrayPattern.ReplaceAllFunc(encoded, func(placeholder []byte) []byte {
+		n, _ := strconv.Atoi(string(tomlArrayPattern.FindSubmatch(placeholder)[1]))
+		return []byte(w.arrays[n])
+	})
+}
+
+// Renders a value as an inline TOML value, with objects as inline tables
+// (leaving out nulls like the encoder does for tables). Scalars are written
+// by the encoder, so that they look the same as elsewhere.
+func tomlInlineValue(value interface{}) (string, error) {
+	if isObject(value) {
+		var pairs []string
+		for _, key := range mapKeys(value) {
+			element, _ := mapValue(value, key)
+			if isNil(element) {
+				continue
+			}
+			rendered, err := tomlInlineValue(element)
+			if err != nil {
+				return "", err
+			}
+			if !tomlBareKey.MatchString(key) {
+				if key, err = tomlInlineValue(key); err != nil {
+					return "", err
+				}
+			}
+			pairs = append(pairs, key+" = "+rendered)
+		}
+		if len(pairs) == 0 {
+			return "{}", nil
+		}
+		return "{" + strings.Join(pairs, ", ") + "}", nil
+	}
+	if _, isString := value.(string); !isString {
+		if elements, err := topLevelArray(value); err == nil {
+			rendered := make([]string, len(elements))
+			for n, element := range elements {
+				if rendered[n], err = tomlInlineValue(element); err != nil {
+					return "", err
+				}
+			}
+			return "[" + strings.Join(rendered, ", ") + "]", nil
+		}
+	}
+	buffer := &bytes.Buffer{}
+	if err := encodeTOML(toml.NewEncoder(buffer), map[string]interface{}{"v": value}); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(buffer.String(), "v = "), "\n"), nil
+}
+
 type TextFormat struct {
 	RecordDelimiter string
 	FieldDelimiter  string
+	SkipRows        int
+	Header          bool
+	HeaderRename    map[string]string
+	PreserveOrder   bool
+	OnRecordError   RecordErrorHandler
+	DuplicateKeys   string
+	// The header of the output (if Header is set), by default the keys of the
+	// objects, or "key" and "value" for a top-level object.
+	Columns []string
+	// Write the values of objects nested in a top-level object as rows of
+	// their own, with the keys joined with dots.
+	DottedKeys bool
+	// Discard this many records after SkipRows (and the header) and read at
+	// most RecordLimit records after them (all if not positive).
+	SkipRecords int
+	RecordLimit int
 }
 
 func (f TextFormat) Name() string {
@@ -246,31 +979,32 @@ func (f TextFormat) SupportedExtensions() []string {
 }
 
 func (f TextFormat) Unmarshal(reader io.Reader) (interface{}, error) {
-	bytes, err := ioutil.ReadAll(reader)
+	records, err := readRecords(reader, f.RecordDelimiter,
+		recordsToRead(f.SkipRows, f.Header && f.FieldDelimiter != "", f.SkipRecords, f.RecordLimit))
 	if err != nil {
 		return nil, err
 	}
 
-	var records []string
-	if f.RecordDelimiter == "" {
-		records, err = readLines(bytes)
-	} else {
-		records = readSeparatedStrings(bytes, f.RecordDelimiter)
-		if len(records) > 0 {
-			if len(records[len(records)-1]) == 0 {
-				records = records[0 : len(records)-1]
-			}
-		}
-		err = nil
+	// records are skipped before any further processing, including the header
+	if f.SkipRows >= len(records) {
+		records = records[:0]
+	} else if f.SkipRows > 0 {
+		records = records[f.SkipRows:]
 	}
-	if err != nil {
-		return nil, err
+	if f.Header && f.FieldDelimiter != "" && len(records) > 0 {
+		records = append(records[:1:1], recordWindow(records[1:], f.SkipRecords, f.RecordLimit)...)
+	} else {
+		records = recordWindow(records, f.SkipRecords, f.RecordLimit)
 	}
 
 	if f.FieldDelimiter == "" {
 		return records, nil
 	}
 
+	if f.Header {
+		return f.unmarshalWithHeader(records)
+	}
+
 	var data []interface{} = make([]interface{}, 0, len(records))
 	for _, record := range records {
 		fields := readSeparatedStrings([]byte(record), f.FieldDelimiter)
@@ -283,58 +1017,693 @@ func (f TextFormat) Unmarshal(reader io.Reader) (interface{}, error) {
 	return data, nil
 }
 
-type INIFormat struct {
-	CaseSensitive bool
-	DefaultKey    string
-}
+// Writes records of delimited fields, or one value per record without a field
+// delimiter. The elements of a top-level array are the records: arrays with
+// their elements as fields, objects with the values of the columns as fields
+// (preceded by a header if Header is set), and anything else as a single
+// field. A top-level object is written as two columns, key and value (see
+// DottedKeys for nested objects).
+//
+// Strings are written as they are, other values as compact JSON. Fields
+// cannot contain delimiters since there is no quoting.
+func (f TextFormat) Marshal(data interface{}, w io.Writer) error {
+	records, _, err := f.records(data)
+	if err != nil {
+		return err
+	}
 
-func (f INIFormat) Name() string {
-	return "INI"
+	terminator := f.RecordDelimiter
+	if terminator == "" {
+		terminator = "\n"
+	}
+	var output strings.Builder
+	for _, record := range records {
+		for i, value := range record.fields {
+			field, err := csfField(value)
+			if err != nil {
+				return err
+			}
+			if strings.Contains(field, terminator) || (f.FieldDelimiter != "" && strings.Contains(field, f.FieldDelimiter)) {
+				return &MarshalError{Path: record.paths[i], Reason: "fields cannot contain delimiters"}
+			}
+			if i > 0 {
+				output.WriteString(f.FieldDelimiter)
+			}
+			output.WriteString(field)
+		}
+		output.WriteString(terminator)
+	}
+	_, err = io.WriteString(w, output.String())
+	return err
+}
+
+// Returns the records to write for the data (starting with the header if
+// any) and the columns of objects.
+func (f TextFormat) records(data interface{}) ([]csfRecord, []string, error) {
+	var (
+		records []csfRecord
+		columns = f.Columns
+	)
+	if isObject(data) {
+		if f.FieldDelimiter == "" {
+			return nil, nil, fmt.Errorf("objects can only be written with a field delimiter")
+		} else if len(columns) == 0 {
+			columns = []string{"key", "value"}
+		} else if len(columns) != 2 {
+			return nil, nil, fmt.Errorf("objects are written as 2 columns but %d are given", len(columns))
+		}
+		for _, key := range mapKeys(data) {
+			value, _ := mapValue(data, key)
+			at := Path{{Key: key}}
+			if !f.DottedKeys || !isObject(value) {
+				records = append(records, csfRecord{[]interface{}{key, value}, []Path{at, at}})
+				continue
+			}
+			for _, nestedKey := range mapKeys(value) {
+				nested, _ := mapValue(value, nestedKey)
+				nestedAt := at.append(PathSegment{Key: nestedKey})
+				records = append(records, csfRecord{[]interface{}{key + "." + nestedKey, nested}, []Path{nestedAt, nestedAt}})
+			}
+		}
+	} else {
+		elements, err := topLevelArray(data)
+		if err != nil {
+			elements = []interface{}{data}
+		}
+		if len(columns) == 0 && f.FieldDelimiter != "" {
+			columns = objectColumns(elements)
+		}
+		for n, element := range elements {
+			at := Path{{Index: n, IsIndex: true}}
+			var record csfRecord
+			switch {
+			case isObject(element) && f.FieldDelimiter != "":
+				for _, column := range columns {
+					value, _ := mapValue(element, column)
+					record.fields = append(record.fields, value)
+					record.paths = append(record.paths, at.append(PathSegment{Key: column}))
+				}
+			case describeType(element) == "an array" && f.FieldDelimiter != "":
+				record.fields, _ = topLevelArray(element)
+				for i := range record.fields {
+					record.paths = append(record.paths, at.append(PathSegment{Index: i, IsIndex: true}))
+				}
+			default:
+				record = csfRecord{[]interface{}{element}, []Path{at}}
+			}
+			records = append(records, record)
+		}
+	}
+	if f.Header && f.FieldDelimiter != "" && len(columns) > 0 {
+		header := csfRecord{make([]interface{}, len(columns)), make([]Path, len(columns))}
+		for n, column := range columns {
+			header.fields[n] = column
+		}
+		records = append([]csfRecord{header}, records...)
+	}
+	return records, columns, nil
+}
+
+// The fields of a record and the paths of their values.
+type csfRecord struct {
+	fields []interface{}
+	paths  []Path
+}
+
+// Returns the keys of the objects in order of appearance.
+func objectColumns(elements []interface{}) []string {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, element := range elements {
+		for _, key := range mapKeys(element) {
+			if !seen[key] && isObject(element) {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+	return columns
+}
+
+func csfField(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	}
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(value)
+	return strings.TrimSuffix(encoded.String(), "\n"), err
+}
+
+// Converts records to objects, using the (renamed) fields of the first record as keys.
+// Records with fewer fields than the header omit the missing keys. If the order
+// is preserved, objects are ordered maps with the keys in column order.
+func (f TextFormat) unmarshalWithHeader(records []string) (interface{}, error) {
+	var data []interface{} = make([]interface{}, 0, len(records))
+	if len(records) == 0 {
+		return data, nil
+	}
+
+	keys := readSeparatedStrings([]byte(records[0]), f.FieldDelimiter)
+	seen := make(map[string]bool, len(keys))
+	for n, key := range keys {
+		if renamed, ok := f.HeaderRename[key]; ok {
+			key = renamed
+			keys[n] = key
+		}
+		if seen[key] && (f.DuplicateKeys == DuplicateKeysDefault || f.DuplicateKeys == DuplicateKeysError) {
+			return nil, fmt.Errorf("duplicate header field '%s'", key)
+		}
+		seen[key] = true
+	}
+
+	for n, record := range records[1:] {
+		fields := readSeparatedStrings([]byte(record), f.FieldDelimiter)
+		if len(fields) > len(keys) {
+			err := fmt.Errorf("record %d has %d fields but the header only %d", n+2, len(fields), len(keys))
+			if f.OnRecordError != nil && f.OnRecordError(n+2, err) {
+				continue
+			}
+			return nil, err
+		}
+		if f.PreserveOrder {
+			object := NewOrderedMap()
+			for i, s := range fields {
+				if _, ok := object.Get(keys[i]); !ok || f.DuplicateKeys != DuplicateKeysFirst {
+					object.Set(keys[i], s)
+				}
+			}
+			data = append(data, object)
+		} else {
+			object := make(map[string]interface{}, len(fields))
+			for i, s := range fields {
+				if _, ok := object[keys[i]]; !ok || f.DuplicateKeys != DuplicateKeysFirst {
+					object[keys[i]] = s
+				}
+			}
+			data = append(data, object)
+		}
+	}
+	return data, nil
+}
+
+// Environment variables as an object of strings, either from the given
+// environment (`NAME=VALUE` entries such as os.Environ()) or, if it is nil,
+// from `NAME=VALUE` lines in the input (.env files, with comments, `export`
+// and quoted values).
+//
+// Only variables with the prefix are kept (without it). With Nest, names are
+// split at `__` into nested objects, e.g. DB__HOST becomes {"DB":{"HOST":...}}.
+//
+// As output, the leaves of the data are written as `NAME="VALUE"` lines with
+// the values quoted for POSIX shells, and the names are the prefix and the
+// keys of the path joined with KeySeparator (`__` if empty, as read with
+// Nest). Characters other than letters, digits and `_` in names are replaced
+// by `_`. The Shell format is the same with Export and Uppercase set, for
+// sourcing the output in a shell.
+type EnvFormat struct {
+	Environment   []string
+	Prefix        string
+	Nest          bool
+	DuplicateKeys string
+	DefaultKey    string
+	KeySeparator  string
+	// Prefix each line with `export`.
+	Export bool
+	// Write the names (without the prefix) in upper case.
+	Uppercase bool
+	// How arrays are written (see envArrayStyles): as a variable per element
+	// with the index as the last key (EnvArraysIndexed, the default), or as a
+	// single variable with the (scalar) elements separated by spaces.
+	ArrayStyle string
+}
+
+// How arrays are written as ENV.
+const (
+	EnvArraysIndexed = "indexed"
+	EnvArraysJoined  = "joined"
+)
+
+var envArrayStyles = []string{EnvArraysIndexed, EnvArraysJoined}
+
+func (f EnvFormat) Name() string {
+	if f.Export {
+		return formatNameShell
+	}
+	return formatNameEnv
+}
+
+func (f EnvFormat) SupportedExtensions() []string {
+	return []string{".env"}
+}
+
+func (f EnvFormat) Unmarshal(reader io.Reader) (interface{}, error) {
+	entries := f.Environment
+	if entries == nil {
+		var err error
+		entries, err = readEnvFile(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := make(map[string]interface{})
+	seen := make(map[string]string)
+	for _, entry := range entries {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], f.Prefix) || kv[0] == f.Prefix {
+			continue
+		}
+		name := strings.TrimPrefix(kv[0], f.Prefix)
+		if previous, ok := seen[kv[0]]; ok {
+			value, err := selectDuplicate(f.DuplicateKeys, kv[0], Path{}, []interface{}{previous, kv[1]})
+			if err != nil {
+				return nil, err
+			}
+			kv[1] = value.(string)
+		}
+		seen[kv[0]] = kv[1]
+		if !f.Nest {
+			data[name] = kv[1]
+			continue
+		}
+		err := setNestedEnv(data, strings.Split(name, "__"), kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("variable '%s': %s", kv[0], err)
+		}
+	}
+	return data, nil
+}
+
+func setNestedEnv(data map[string]interface{}, names []string, value string) error {
+	for _, name := range names[:len(names)-1] {
+		switch existing := data[name].(type) {
+		case nil:
+			nested := make(map[string]interface{})
+			data[name] = nested
+			data = nested
+		case map[string]interface{}:
+			data = existing
+		default:
+			return fmt.Errorf("'%s' is also a variable itself", name)
+		}
+	}
+	name := names[len(names)-1]
+	if _, ok := data[name].(map[string]interface{}); ok {
+		return fmt.Errorf("'%s' also has nested variables", name)
+	}
+	data[name] = value
+	return nil
+}
+
+// Reads `NAME=VALUE` lines, skipping empty lines and comments, removing
+// `export` and the quotes around values.
+func readEnvFile(reader io.Reader) ([]string, error) {
+	bytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := readLines(bytes)
+	if err != nil {
+		return nil, err
+	}
+	var entries []string
+	for n, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("line %d is not of the form NAME=VALUE", n+1)
+		}
+		value := kv[1]
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		entries = append(entries, strings.TrimSpace(kv[0])+"="+value)
+	}
+	return entries, nil
+}
+
+func (f EnvFormat) Marshal(data interface{}, w io.Writer) error {
+	if f.ArrayStyle != "" && !containsFold(f.ArrayStyle, envArrayStyles) {
+		return fmt.Errorf("unknown ENV array style '%s'", f.ArrayStyle)
+	}
+	if !isObject(data) {
+		data = map[string]interface{}{NonemptyDefaultKey(f.DefaultKey): data}
+	}
+	var b strings.Builder
+	names := make(map[string]Path)
+	err := f.writeVariables(&b, names, "", data, Path{})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the leaves of the value with the names of their paths, where names
+// records the path of each name written.
+func (f EnvFormat) writeVariables(b *strings.Builder, names map[string]Path, name string, value interface{}, at Path) error {
+	separator := f.KeySeparator
+	if separator == "" {
+		separator = "__"
+	}
+	if name != "" {
+		name += separator
+	}
+	if isObject(value) {
+		for _, key := range mapKeys(value) {
+			element, _ := mapValue(value, key)
+			if err := f.writeVariables(b, names, name+key, element, at.append(PathSegment{Key: key})); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	name = strings.TrimSuffix(name, separator)
+	if describeType(value) == "an array" {
+		elements, _ := topLevelArray(value)
+		if !strings.EqualFold(f.ArrayStyle, EnvArraysJoined) {
+			for n, element := range elements {
+				if err := f.writeVariables(b, names, fmt.Sprintf("%s%s%d", name, separator, n), element,
+					at.append(PathSegment{Index: n, IsIndex: true})); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		values := make([]string, len(elements))
+		for n, element := range elements {
+			if isObject(element) || describeType(element) == "an array" {
+				return &MarshalError{Path: at.append(PathSegment{Index: n, IsIndex: true}),
+					Reason: "joined ENV arrays cannot contain objects or arrays"}
+			}
+			values[n] = iniValue(element)
+		}
+		value = strings.Join(values, " ")
+	}
+
+	variable := f.variableName(name)
+	if previous, ok := names[variable]; ok {
+		return &MarshalError{Path: at, Reason: fmt.Sprintf("the variable name %s is also that of %s", variable, describePath(previous))}
+	}
+	names[variable] = at
+	if f.Export {
+		b.WriteString("export ")
+	}
+	b.WriteString(variable)
+	b.WriteString("=")
+	b.WriteString(shellQuote(iniValue(value)))
+	b.WriteString("\n")
+	return nil
+}
+
+// Returns the prefixed name with characters that cannot occur in variable
+// names replaced by `_` (and a leading `_` if it would start with a digit).
+func (f EnvFormat) variableName(name string) string {
+	if f.Uppercase {
+		name = strings.ToUpper(name)
+	}
+	name = f.Prefix + name
+	variable := []rune(name)
+	for n, r := range variable {
+		if r > unicode.MaxASCII || !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			variable[n] = '_'
+		}
+	}
+	if len(variable) == 0 || unicode.IsDigit(variable[0]) {
+		return "_" + string(variable)
+	}
+	return string(variable)
+}
+
+// Quotes the string with double quotes for POSIX shells, escaping the
+// characters that are special in them (`"`, `\`, `$` and backticks).
+func shellQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\', '$', '`':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// How objects nested in sections are written as INI.
+const (
+	ININestingError           = "error"
+	ININestingFlattenDots     = "flatten-dots"
+	ININestingFlattenSections = "flatten-sections"
+)
+
+// How arrays are written as INI.
+const (
+	INIArraysRepeat = "repeat"
+	INIArraysJoined = "joined"
+)
+
+var (
+	iniNestingPolicies = []string{ININestingError, ININestingFlattenDots, ININestingFlattenSections}
+	iniArrayStyles     = []string{INIArraysRepeat, INIArraysJoined}
+)
+
+type INIFormat struct {
+	CaseSensitive bool
+	DefaultKey    string
+	DuplicateKeys string
+	// How objects deeper than sections are written (see iniNestingPolicies):
+	// not at all (ININestingError, the default), as keys joined with dots, or
+	// as sections joined with dots.
+	NestingPolicy string
+	// How arrays of scalars are written: as repeated keys (INIArraysRepeat,
+	// the default) or as comma-separated values.
+	ArrayStyle string
+	// Split values containing this delimiter (if not empty) into arrays of
+	// trimmed strings.
+	ValueDelimiter string
+	// Read an empty default section as an empty object (under the default
+	// key) if the input has a `[default]` header, rather than omitting it.
+	KeepEmptyDefault bool
+}
+
+var iniDefaultSectionHeader = regexp.MustCompile(`(?mi)^[ \t]*\[[ \t]*default[ \t]*\][ \t]*\r?$`)
+
+func (f INIFormat) Name() string {
+	return "INI"
 }
 
 func (f INIFormat) SupportedExtensions() []string {
-	return []string{".ini"}
+	return []string{".ini", ".cfg", ".conf"}
 }
 
 func (f INIFormat) Unmarshal(reader io.Reader) (interface{}, error) {
-	var (
-		file *ini.File
-		err  error
-	)
-	if f.CaseSensitive {
-		file, err = ini.Load(reader)
-	} else {
-		file, err = ini.InsensitiveLoad(reader)
+	input, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
 	}
+	file, err := ini.LoadSources(ini.LoadOptions{
+		Insensitive:  !f.CaseSensitive,
+		AllowShadows: f.DuplicateKeys != DuplicateKeysDefault,
+	}, input)
 	if err != nil {
 		return nil, err
 	}
-	var data map[string]map[string]interface{} = make(map[string]map[string]interface{})
+	// the parser always has a default section, so only a header tells if it is
+	// in the input
+	keepDefault := f.KeepEmptyDefault && iniDefaultSectionHeader.Match(input)
+	var data map[string]interface{} = make(map[string]interface{})
 	for _, section := range file.Sections() {
 		name := section.Name()
 		if name == "default" {
 			name = NonemptyDefaultKey(f.DefaultKey)
-			if len(section.KeysHash()) == 0 {
+			if len(section.Keys()) == 0 && !keepDefault {
 				continue
 			}
 		}
-		data[name] = make(map[string]interface{})
-		for k, v := range section.KeysHash() {
-			data[name][k] = v
+		values := make(map[string]interface{})
+		for _, key := range section.Keys() {
+			shadows := key.ValueWithShadows()
+			candidates := make([]interface{}, len(shadows))
+			for n, v := range shadows {
+				candidates[n] = f.splitValue(v)
+			}
+			value, err := selectDuplicate(f.DuplicateKeys, key.Name(), Path{{Key: name}}, candidates)
+			if err != nil {
+				return nil, err
+			}
+			values[key.Name()] = value
 		}
+		data[name] = values
 	}
-	if err != nil {
-		return nil, err
+	return data, nil
+}
+
+func (f INIFormat) splitValue(value string) interface{} {
+	if f.ValueDelimiter == "" || !strings.Contains(value, f.ValueDelimiter) {
+		return value
 	}
+	parts := strings.Split(value, f.ValueDelimiter)
+	elements := make([]interface{}, len(parts))
+	for n, part := range parts {
+		elements[n] = strings.TrimSpace(part)
+	}
+	return elements
+}
 
-	return data, nil
+// Writes the top-level objects as sections and all other top-level values
+// as keys before the first section. The section of the default key is
+// written as keys before the first section as well, and data that is not an
+// object is written as the default key.
+func (f INIFormat) Marshal(data interface{}, w io.Writer) error {
+	if f.NestingPolicy != "" && !containsFold(f.NestingPolicy, iniNestingPolicies) {
+		return fmt.Errorf("unknown INI nesting policy '%s'", f.NestingPolicy)
+	} else if f.ArrayStyle != "" && !containsFold(f.ArrayStyle, iniArrayStyles) {
+		return fmt.Errorf("unknown INI array style '%s'", f.ArrayStyle)
+	}
+	defaultKey := NonemptyDefaultKey(f.DefaultKey)
+	if !isObject(data) {
+		data = map[string]interface{}{defaultKey: data}
+	}
+	file := ini.Empty(ini.LoadOptions{AllowShadows: true})
+	for _, key := range mapKeys(data) {
+		if value, _ := mapValue(data, key); !isObject(value) {
+			if err := f.writeKey(file.Section(""), key, value, Path{{Key: key}}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range mapKeys(data) {
+		value, _ := mapValue(data, key)
+		if !isObject(value) {
+			continue
+		}
+		section := file.Section("")
+		if key != defaultKey {
+			var err error
+			if section, err = file.NewSection(key); err != nil {
+				return err
+			}
+		}
+		if err := f.writeSection(file, section, key, "", value, Path{{Key: key}}); err != nil {
+			return err
+		}
+	}
+	buffer := &bytes.Buffer{}
+	if _, err := file.WriteTo(buffer); err != nil {
+		return err
+	}
+	if output := bytes.TrimRight(buffer.Bytes(), "\n"); len(output) > 0 {
+		_, err := w.Write(append(output, '\n'))
+		return err
+	}
+	return nil
 }
 
-func NewTextFormat(rdelim string, fdelim string) TextFormat {
-	return TextFormat{
-		RecordDelimiter: normalizeDelim(rdelim),
-		FieldDelimiter:  normalizeDelim(fdelim),
+// Writes the values of an object into the section named name, with the keys
+// prefixed for ININestingFlattenDots.
+func (f INIFormat) writeSection(file *ini.File, section *ini.Section, name string, prefix string, data interface{}, at Path) error {
+	var nested []string
+	for _, key := range mapKeys(data) {
+		value, _ := mapValue(data, key)
+		keyPath := at.append(PathSegment{Key: key})
+		if !isObject(value) {
+			if err := f.writeKey(section, prefix+key, value, keyPath); err != nil {
+				return err
+			}
+			continue
+		}
+		switch strings.ToLower(f.NestingPolicy) {
+		case ININestingFlattenDots:
+			if err := f.writeSection(file, section, name, prefix+key+".", value, keyPath); err != nil {
+				return err
+			}
+		case ININestingFlattenSections:
+			nested = append(nested, key)
+		default:
+			return &MarshalError{Path: keyPath, Reason: "INI cannot represent objects nested in sections"}
+		}
+	}
+	for _, key := range nested {
+		value, _ := mapValue(data, key)
+		child, err := file.NewSection(name + "." + key)
+		if err != nil {
+			return err
+		}
+		if err = f.writeSection(file, child, name+"."+key, "", value, at.append(PathSegment{Key: key})); err != nil {
+			return err
+		}
+	}
+	// sections only containing other sections are left out
+	if len(nested) > 0 && len(section.Keys()) == 0 && section.Name() != ini.DefaultSection {
+		file.DeleteSection(section.Name())
 	}
+	return nil
+}
+
+func (f INIFormat) writeKey(section *ini.Section, key string, value interface{}, at Path) error {
+	if describeType(value) != "an array" {
+		_, err := section.NewKey(key, iniValue(value))
+		return err
+	}
+	elements, _ := topLevelArray(value)
+	values := make([]string, len(elements))
+	for n, element := range elements {
+		if isObject(element) || describeType(element) == "an array" {
+			return &MarshalError{Path: at.append(PathSegment{Index: n, IsIndex: true}),
+				Reason: "INI cannot represent objects or arrays in arrays"}
+		}
+		values[n] = iniValue(element)
+	}
+	if len(values) == 0 || strings.EqualFold(f.ArrayStyle, INIArraysJoined) {
+		_, err := section.NewKey(key, strings.Join(values, ","))
+		return err
+	}
+	for _, v := range values {
+		if _, err := section.NewKey(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func iniValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+func NewTextFormat(rdelim string, fdelim string) (TextFormat, error) {
+	recordDelimiter, err := NormalizeDelim(rdelim)
+	if err != nil {
+		return TextFormat{}, err
+	}
+	fieldDelimiter, err := NormalizeDelim(fdelim)
+	if err != nil {
+		return TextFormat{}, err
+	}
+	return TextFormat{
+		RecordDelimiter: recordDelimiter,
+		FieldDelimiter:  fieldDelimiter,
+	}, nil
 }
 
 func NewFormat(fileName string, formatName string, fieldDelim string, recordDelim string, prettyPrint bool) (FileFormat, error) {
@@ -353,14 +1722,24 @@ func NewFormat(fileName string, formatName string, fieldDelim string, recordDeli
 	case fidTOML:
 		return tomlFormatConfig, nil
 	case fidCSF:
-		return NewTextFormat(recordDelim, fieldDelim), nil
+		return NewTextFormat(recordDelim, fieldDelim)
 	case fidINI:
 		return iniFormatConfig, nil
+	case fidEnv:
+		return EnvFormat{}, nil
+	case fidShell:
+		return EnvFormat{Export: true, Uppercase: true}, nil
 	default:
-		if containsFold(fid, fidsStrings) {
-			return NewTextFormat("NL", ""), nil
+		if containsFold(fid, fidsNDJSON) {
+			return NDJSONFormat{}, nil
+		} else if containsFold(fid, fidsStrings) {
+			return NewTextFormat("NL", "")
 		} else if containsFold(fid, fidsNTStr) {
-			return NewTextFormat("NUL", ""), nil
+			return NewTextFormat("NUL", "")
+		} else if containsFold(fid, fidsFixed) {
+			return FixedWidthFormat{}, nil
+		} else if preset, ok := formatPresets[fid]; ok {
+			return preset, nil
 		}
 	}
 
@@ -368,21 +1747,230 @@ func NewFormat(fileName string, formatName string, fieldDelim string, recordDeli
 		return nil, fmt.Errorf("unknown/unexpected format name '%s'", formatName)
 	}
 
+	if name, ok := FormatForExtension(fileName); ok {
+		return NewFormat(fileName, name, fieldDelim, recordDelim, prettyPrint)
+	}
+	return nil, fmt.Errorf("cannot determine format of file '%s'", fileName)
+}
+
+// Returns the name of the format for the extension of the file name.
+func FormatForExtension(fileName string) (string, bool) {
 	ext := path.Ext(fileName)
 	if containsFold(ext, JSONFormat{}.SupportedExtensions()) {
-		return jsonFormatConfig, nil
+		return formatNameJSON, true
+	} else if containsFold(ext, NDJSONFormat{}.SupportedExtensions()) {
+		return formatNameNDJSON, true
 	} else if containsFold(ext, YAMLFormat{}.SupportedExtensions()) {
-		return yamlFormatConfig, nil
+		return formatNameYAML, true
 	} else if containsFold(ext, TOMLFormat{}.SupportedExtensions()) {
-		return tomlFormatConfig, nil
+		return formatNameTOML, true
 	} else if containsFold(ext, INIFormat{}.SupportedExtensions()) {
-		return iniFormatConfig, nil
+		return formatNameINI, true
+	} else if containsFold(ext, EnvFormat{}.SupportedExtensions()) {
+		return formatNameEnv, true
+	} else if strings.EqualFold(ext, ".csv") {
+		return formatNameCSF, true
 	}
+	return "", false
+}
 
-	return nil, fmt.Errorf("cannot determine format of file '%s'", fileName)
+// An input format trying several formats in order, using the first one
+// that can unmarshal the (buffered) input. OnSelect, if set, is called with
+// the format that succeeded.
+type FallbackFormat struct {
+	Formats  []InputFormat
+	OnSelect func(format InputFormat)
 }
 
+func (f FallbackFormat) Name() string {
+	names := make([]string, len(f.Formats))
+	for i, format := range f.Formats {
+		names[i] = format.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (f FallbackFormat) SupportedExtensions() []string {
+	return []string{}
+}
+
+func (f FallbackFormat) Unmarshal(reader io.Reader) (interface{}, error) {
+	input, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	format, data, err := f.selectFormat(input)
+	if err == nil && f.OnSelect != nil {
+		f.OnSelect(format)
+	}
+	return data, err
+}
+
+// Returns the first of the formats that can unmarshal the input and the data,
+// or an error listing why each of them failed.
+func (f FallbackFormat) selectFormat(input []byte) (InputFormat, interface{}, error) {
+	failures := make([]string, 0, len(f.Formats))
+	for _, format := range f.Formats {
+		data, err := format.Unmarshal(bytes.NewReader(input))
+		if err == nil {
+			return format, data, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", format.Name(), err))
+	}
+	return nil, nil, fmt.Errorf("the input could not be read as any of the formats:\n%s", strings.Join(failures, "\n"))
+}
+
+// An input format detecting the format of the (buffered) input from its
+// content, see DetectFormat, rather than from the file name. Formats are the
+// candidates, Default (if not nil) is used if the content is ambiguous.
+// OnSelect, if set, is called with the format used.
+type SniffingFormat struct {
+	Formats  []InputFormat
+	Default  InputFormat
+	OnSelect func(format InputFormat)
+}
+
+// The formats that can be detected from the content.
+var sniffedFormats = []string{formatNameJSON, formatNameNDJSON, formatNameYAML, formatNameTOML, formatNameINI}
+
+func (f SniffingFormat) Name() string {
+	return sniffFormat
+}
+
+func (f SniffingFormat) SupportedExtensions() []string {
+	return []string{}
+}
+
+func (f SniffingFormat) Unmarshal(reader io.Reader) (interface{}, error) {
+	input, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	format := f.Default
+	if name, ok := DetectFormat(input); ok {
+		for _, candidate := range f.Formats {
+			if candidate.Name() == name {
+				format = candidate
+			}
+		}
+	}
+	if format == nil {
+		return nil, fmt.Errorf("the format of the input could not be detected from its content")
+	}
+	if f.OnSelect != nil {
+		f.OnSelect(format)
+	}
+	return format.Unmarshal(bytes.NewReader(input))
+}
+
+var (
+	iniSectionPattern  = regexp.MustCompile(`^\[[^\]]+\]$`)
+	iniPropertyPattern = regexp.MustCompile(`^[^:=\s][^:=]*=`)
+)
+
+// Detects the format of the input (one of sniffedFormats) from its content.
+// The result is false if the content is empty or does not clearly belong to
+// one of the formats. Documents valid in several formats are assigned to the
+// first of JSON, NDJSON, YAML (with a document marker), TOML, INI, and YAML.
+func DetectFormat(input []byte) (string, bool) {
+	input = contentStart(input)
+	if len(input) == 0 {
+		return "", false
+	}
+	if input[0] == '{' || input[0] == '[' {
+		if json.Valid(input) {
+			return formatNameJSON, true
+		} else if lines := bytes.Split(input, []byte("\n")); len(lines) > 1 && validJSONLines(lines) {
+			return formatNameNDJSON, true
+		}
+	}
+	if hasYAMLMarker(input) {
+		return formatNameYAML, true
+	}
+	var table map[string]interface{}
+	if err := toml.Unmarshal(input, &table); err == nil && len(table) > 0 {
+		return formatNameTOML, true
+	}
+	if looksLikeINI(input) {
+		return formatNameINI, true
+	}
+	var document interface{}
+	if err := yaml.Unmarshal(input, &document); err == nil && (isObject(document) || describeType(document) == "an array") {
+		return formatNameYAML, true
+	}
+	return "", false
+}
+
+// Returns the input without a byte order mark and surrounding whitespace.
+func contentStart(input []byte) []byte {
+	return bytes.TrimSpace(bytes.TrimPrefix(input, []byte("\ufeff")))
+}
+
+// Checks if the (trimmed) input starts with a YAML document marker or directive.
+func hasYAMLMarker(input []byte) bool {
+	return bytes.HasPrefix(input, []byte("---")) || bytes.HasPrefix(input, []byte("%YAML"))
+}
+
+// Checks that all non-empty lines are JSON values.
+func validJSONLines(lines [][]byte) bool {
+	for _, line := range lines {
+		if line = bytes.TrimSpace(line); len(line) > 0 && !json.Valid(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks that all lines are sections, properties or comments (and that there
+// is at least one property).
+func looksLikeINI(input []byte) bool {
+	properties := 0
+	for _, line := range bytes.Split(input, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		switch {
+		case len(line) == 0 || line[0] == ';' || line[0] == '#' || iniSectionPattern.Match(line):
+		case iniPropertyPattern.Match(line):
+			properties++
+		default:
+			return false
+		}
+	}
+	return properties > 0
+}
+
+// Creates an input format. A comma-separated list of format names results
+// in a FallbackFormat trying them in order, sniffFormat in a SniffingFormat
+// (falling back to the format for the file name). The automatic format is a
+// SniffingFormat as well for files with unknown extensions (and stdin).
 func NewInputFormat(fileName string, formatName string, fieldDelim string, recordDelim string) (InputFormat, error) {
+	if strings.EqualFold(formatName, sniffFormat) {
+		sniffing := SniffingFormat{}
+		for _, name := range sniffedFormats {
+			format, err := NewInputFormat(fileName, name, fieldDelim, recordDelim)
+			if err != nil {
+				return nil, err
+			}
+			sniffing.Formats = append(sniffing.Formats, format)
+		}
+		if format, err := NewFormat(fileName, autoFormat, fieldDelim, recordDelim, false); err == nil {
+			sniffing.Default, _ = format.(InputFormat)
+		}
+		return sniffing, nil
+	}
+	if _, ok := FormatForExtension(fileName); !ok && strings.EqualFold(formatName, autoFormat) {
+		return NewInputFormat(fileName, sniffFormat, fieldDelim, recordDelim)
+	}
+	if strings.Contains(formatName, ",") {
+		fallback := FallbackFormat{}
+		for _, name := range strings.Split(formatName, ",") {
+			format, err := NewInputFormat(fileName, strings.TrimSpace(name), fieldDelim, recordDelim)
+			if err != nil {
+				return nil, err
+			}
+			fallback.Formats = append(fallback.Formats, format)
+		}
+		return fallback, nil
+	}
 	format, err := NewFormat(fileName, formatName, fieldDelim, recordDelim, false)
 	if err != nil {
 		return nil, err
@@ -395,8 +1983,10 @@ func NewInputFormat(fileName string, formatName string, fieldDelim string, recor
 	}
 }
 
+// Creates an output format. CSF output uses commas and newlines unless
+// configured otherwise.
 func NewOutputFormat(fileName string, formatName string, prettyPrint bool) (OutputFormat, error) {
-	format, err := NewFormat(fileName, formatName, "", "", prettyPrint)
+	format, err := NewFormat(fileName, formatName, ",", "NL", prettyPrint)
 	if err != nil {
 		return nil, err
 	}
@@ -408,6 +1998,20 @@ func NewOutputFormat(fileName string, formatName string, prettyPrint bool) (Outp
 	}
 }
 
+// An output format writing a UTF-8 byte order mark before the output of
+// the format (once, even for several documents), e.g. for Excel to detect
+// UTF-8 in CSF files.
+type BOMFormat struct {
+	OutputFormat
+}
+
+func (f BOMFormat) Marshal(data interface{}, w io.Writer) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	return f.OutputFormat.Marshal(data, w)
+}
+
 func NonemptyDefaultKey(key string) string {
 	key = strings.TrimSpace(key)
 	if key == "" {
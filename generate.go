@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	tsIdentifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+)
+
+// An output "format" writing TypeScript declarations describing the data.
+//
+// Objects become interfaces (or inline object types if InlineObjects is set),
+// keys missing in some samples become optional properties, and differing
+// types become unions. Values without any samples are typed as unknown.
+type TypeScriptFormat struct {
+	RootName      string
+	InlineObjects bool
+}
+
+func (f TypeScriptFormat) Name() string {
+	return "TypeScript"
+}
+
+func (f TypeScriptFormat) SupportedExtensions() []string {
+	return []string{".ts"}
+}
+
+func (f TypeScriptFormat) Marshal(data interface{}, w io.Writer) error {
+	root := newSchemaNode()
+	root.observe(data, 0)
+
+	g := &tsGenerator{
+		inline: f.InlineObjects,
+		names:  make(map[string]bool),
+	}
+	rootName := tsTypeName(f.RootName)
+	if rootName == "" {
+		rootName = "Root"
+	}
+	if !f.InlineObjects && len(root.types) == 1 && root.types[schemaTypeObject] {
+		g.names[rootName] = true
+		g.declareInterface(rootName, root)
+	} else {
+		g.declarations = append(g.declarations, "")
+		g.declarations[0] = fmt.Sprintf("export type %s = %s;\n", rootName, g.typeOf(root, rootName, ""))
+	}
+	_, err := io.WriteString(w, strings.Join(g.declarations, "\n"))
+	return err
+}
+
+type tsGenerator struct {
+	inline       bool
+	names        map[string]bool
+	declarations []string
+}
+
+// Returns the TypeScript type for the node, declaring interfaces as needed.
+// The name hint is used for (nested) interfaces.
+func (g *tsGenerator) typeOf(n *schemaNode, nameHint string, indent string) string {
+	types := g.unionOf(n, nameHint, indent)
+	if len(types) == 0 {
+		return "unknown"
+	}
+	return strings.Join(types, " | ")
+}
+
+func (g *tsGenerator) unionOf(n *schemaNode, nameHint string, indent string) []string {
+	var types []string
+	for _, t := range []string{schemaTypeObject, schemaTypeArray, schemaTypeString, schemaTypeNumber, schemaTypeBoolean, schemaTypeNull} {
+		switch {
+		case t == schemaTypeNumber && (n.types[schemaTypeNumber] || n.types[schemaTypeInteger]):
+			types = append(types, "number")
+		case !n.types[t]:
+			continue
+		case t == schemaTypeObject && g.inline:
+			types = append(types, g.properties(n, "", indent))
+		case t == schemaTypeObject:
+			types = append(types, g.declareInterface(g.uniqueName(nameHint), n))
+		case t == schemaTypeArray:
+			element := "unknown"
+			if n.items != nil {
+				if union := g.unionOf(n.items, nameHint+"Item", indent); len(union) > 1 {
+					element = "(" + strings.Join(union, " | ") + ")"
+				} else if len(union) == 1 {
+					element = union[0]
+				}
+			}
+			types = append(types, element+"[]")
+		default:
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+func (g *tsGenerator) declareInterface(name string, n *schemaNode) string {
+	// reserve the slot so that the root/parent interface is written first
+	slot := len(g.declarations)
+	g.declarations = append(g.declarations, "")
+	g.declarations[slot] = fmt.Sprintf("export interface %s %s\n", name, g.properties(n, name, ""))
+	return name
+}
+
+func (g *tsGenerator) properties(n *schemaNode, name string, indent string) string {
+	keys := make([]string, 0, len(n.properties))
+	for k := range n.properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, k := range keys {
+		property := n.properties[k]
+		optional := ""
+		if property.count < n.objects {
+			optional = "?"
+		}
+		b.WriteString(fmt.Sprintf("%s  %s%s: %s;\n", indent, tsPropertyName(k), optional,
+			g.typeOf(property, name+tsTypeName(k), indent+"  ")))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+// Returns a name not used by any other interface so far.
+func (g *tsGenerator) uniqueName(name string) string {
+	if name == "" {
+		name = "Anonymous"
+	}
+	unique := name
+	for n := 2; g.names[unique]; n++ {
+		unique = fmt.Sprintf("%s%d", name, n)
+	}
+	g.names[unique] = true
+	return unique
+}
+
+// Converts a key into a PascalCase type name, dropping all other characters.
+func tsTypeName(key string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range key {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			upper = true
+			continue
+		}
+		if b.Len() == 0 && unicode.IsDigit(r) {
+			b.WriteRune('_')
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Quotes property names that are not valid identifiers.
+func tsPropertyName(key string) string {
+	if tsIdentifierPattern.MatchString(key) {
+		return key
+	}
+	quoted, _ := json.Marshal(key)
+	return string(quoted)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// Digest functions by (lower case) algorithm name.
+var hashAlgorithms = map[string]func([]byte) []byte{
+	"sha256": func(data []byte) []byte {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	},
+	"sha512": func(data []byte) []byte {
+		sum := sha512.Sum512(data)
+		return sum[:]
+	},
+	"blake2b": func(data []byte) []byte {
+		sum := blake2b512(data)
+		return sum[:]
+	},
+}
+
+// Returns the names of all supported hash algorithms.
+func hashAlgorithmNames() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Hashes the canonical JSON form of the data, so that documents only
+// differing in format, whitespace, or key order have the same digest.
+func HashData(data interface{}, algorithm string) (string, error) {
+	digest, ok := hashAlgorithms[strings.ToLower(algorithm)]
+	if !ok {
+		return "", fmt.Errorf("unsupported hash algorithm '%s'", algorithm)
+	}
+	canonical, err := CanonicalJSON(data)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest(canonical)), nil
+}
+
+// An entry of a checksum list as written by the hash command (and sha256sum).
+type checksumEntry struct {
+	Hash string
+	Name string
+}
+
+// Reads a checksum list with lines of the form `HASH  NAME`.
+// Empty lines are ignored.
+func readChecksumList(reader io.Reader) ([]checksumEntry, error) {
+	var entries []checksumEntry
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		fields := strings.SplitN(text, " ", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid checksum line %d", line)
+		}
+		// sha256sum uses a second character to indicate binary/text mode
+		name := strings.TrimPrefix(strings.TrimPrefix(fields[1], " "), "*")
+		entries = append(entries, checksumEntry{Hash: strings.ToLower(fields[0]), Name: name})
+	}
+	return entries, scanner.Err()
+}
+
+var (
+	blake2bIV = [8]uint64{
+		0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+		0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+	}
+	blake2bSigma = [10][16]byte{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+		{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+		{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+		{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+		{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+		{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+		{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+		{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+		{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+	}
+)
+
+// Computes an unkeyed BLAKE2b-512 digest (RFC 7693).
+// The standard library does not provide BLAKE2, only the digest of
+// in-memory data is needed here.
+func blake2b512(data []byte) [64]byte {
+	const blockSize = 128
+	h := blake2bIV
+	h[0] ^= 0x01010000 ^ 64 // no key, 64 byte digest
+
+	var block [blockSize]byte
+	var counter uint64
+	for len(data) > blockSize {
+		counter += blockSize
+		copy(block[:], data[:blockSize])
+		blake2bCompress(&h, &block, counter, false)
+		data = data[blockSize:]
+	}
+	block = [blockSize]byte{}
+	copy(block[:], data)
+	counter += uint64(len(data))
+	blake2bCompress(&h, &block, counter, true)
+
+	var sum [64]byte
+	for i, v := range h {
+		binary.LittleEndian.PutUint64(sum[8*i:], v)
+	}
+	return sum
+}
+
+func blake2bCompress(h *[8]uint64, block *[128]byte, counter uint64, last bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(block[8*i:])
+	}
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= counter // the high word of the 128 bit counter stays zero
+	if last {
+		v[14] = ^v[14]
+	}
+
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] = v[a] + v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] = v[c] + v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] = v[a] + v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] = v[c] + v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	for round := 0; round < 12; round++ {
+		s := &blake2bSigma[round%10]
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severities of lint findings.
+const (
+	LintInfo    = "info"
+	LintWarning = "warning"
+	LintError   = "error"
+)
+
+// A heuristic check of the data.
+type lintRule struct {
+	ID          string
+	Severity    string
+	Description string
+}
+
+var lintRules = []lintRule{
+	{"mixed-array", LintWarning, "arrays with elements of different types (nulls aside)"},
+	{"key-case", LintWarning, "sibling keys differing only by case"},
+	{"key-whitespace", LintError, "sibling keys differing only by leading or trailing whitespace"},
+	{"duplicate-value", LintInfo, "sibling keys with the same (non-trivial) value"},
+	{"numeric-string", LintInfo, "strings that look like numbers"},
+	{"date-string", LintInfo, "strings that look like dates or timestamps"},
+	{"deep-nesting", LintError, "objects and arrays nested deeper than the maximum depth"},
+}
+
+// The layouts of strings that look like dates.
+var lintDateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// A finding of a lint rule at a path.
+type LintFinding struct {
+	Path     Path
+	Rule     string
+	Severity string
+	Message  string
+}
+
+func (f LintFinding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"path": f.Path.String(), "rule": f.Rule, "severity": f.Severity, "message": f.Message,
+	})
+}
+
+// Checks data for likely quality issues (see lintRules), independent of the
+// format it was read from. Only the rules in Enabled run (all if it is nil).
+// Nesting deeper than MaxDepth levels (if positive) is an error.
+type Linter struct {
+	Enabled  map[string]bool
+	MaxDepth int
+}
+
+// Parses comma-separated rule ids into the enabled rules: all rules but the
+// disabled ones, or only the enabled ones if any are given.
+func ParseLintRules(enable string, disable string) (map[string]bool, error) {
+	enabled := make(map[string]bool, len(lintRules))
+	for _, rule := range lintRules {
+		enabled[rule.ID] = enable == ""
+	}
+	if err := setLintRules(enabled, enable, true); err != nil {
+		return nil, err
+	}
+	return enabled, setLintRules(enabled, disable, false)
+}
+
+func setLintRules(enabled map[string]bool, list string, value bool) error {
+	if list == "" {
+		return nil
+	}
+	for _, id := range strings.Split(list, ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if _, ok := enabled[id]; !ok {
+			return fmt.Errorf("unknown lint rule '%s' (expected one of %s)", id, strings.Join(lintRuleIDs(), ", "))
+		}
+		enabled[id] = value
+	}
+	return nil
+}
+
+func lintRuleIDs() []string {
+	ids := make([]string, len(lintRules))
+	for n, rule := range lintRules {
+		ids[n] = rule.ID
+	}
+	return ids
+}
+
+// Returns the findings in document order.
+func (l Linter) Lint(data interface{}) []LintFinding {
+	var findings []LintFinding
+	l.lintValue(data, Path{}, 0, &findings)
+	return findings
+}
+
+func (l Linter) report(findings *[]LintFinding, id string, at Path, format string, args ...interface{}) {
+	if l.Enabled != nil && !l.Enabled[id] {
+		return
+	}
+	for _, rule := range lintRules {
+		if rule.ID == id {
+			*findings = append(*findings, LintFinding{Path: at, Rule: id, Severity: rule.Severity, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+}
+
+func (l Linter) lintValue(data interface{}, at Path, depth int, findings *[]LintFinding) {
+	container := isObject(data) || describeType(data) == "an array"
+	if container && l.MaxDepth > 0 && depth >= l.MaxDepth {
+		l.report(findings, "deep-nesting", at, "nested deeper than %d levels", l.MaxDepth)
+		return
+	}
+	if isObject(data) {
+		l.lintKeys(data, at, findings)
+		for _, key := range mapKeys(data) {
+			value, _ := mapValue(data, key)
+			l.lintValue(value, at.append(PathSegment{Key: key}), depth+1, findings)
+		}
+	} else if describeType(data) == "an array" {
+		elements, _ := topLevelArray(data)
+		types := make(map[string]bool)
+		for _, element := range elements {
+			if !isNil(element) {
+				types[describeType(element)] = true
+			}
+		}
+		if len(types) > 1 {
+			l.report(findings, "mixed-array", at, "the elements are of %d different types", len(types))
+		}
+		for n, element := range elements {
+			l.lintValue(element, at.append(PathSegment{Index: n, IsIndex: true}), depth+1, findings)
+		}
+	} else if s, ok := data.(string); ok && s != "" {
+		if _, err := strconv.ParseFloat(s, 64); err == nil && strings.ContainsAny(s, "0123456789") {
+			l.report(findings, "numeric-string", at, "the string '%s' looks like a number", s)
+		}
+		for _, layout := range lintDateLayouts {
+			if _, err := time.Parse(layout, s); err == nil {
+				l.report(findings, "date-string", at, "the string '%s' looks like a date", s)
+				break
+			}
+		}
+	}
+}
+
+// Compares the keys of an object with those of their siblings.
+func (l Linter) lintKeys(data interface{}, at Path, findings *[]LintFinding) {
+	keys := mapKeys(data)
+	for n, key := range keys {
+		value, _ := mapValue(data, key)
+		for _, sibling := range keys[:n] {
+			switch {
+			case strings.TrimSpace(key) == strings.TrimSpace(sibling):
+				l.report(findings, "key-whitespace", at.append(PathSegment{Key: key}),
+					"the key differs from '%s' only by whitespace", sibling)
+			case strings.EqualFold(key, sibling):
+				l.report(findings, "key-case", at.append(PathSegment{Key: key}),
+					"the key differs from '%s' only by case", sibling)
+			}
+			if siblingValue, _ := mapValue(data, sibling); nonTrivial(value) && reflect.DeepEqual(value, siblingValue) {
+				l.report(findings, "duplicate-value", at.append(PathSegment{Key: key}),
+					"the value is the same as that of '%s'", sibling)
+			}
+		}
+	}
+}
+
+// Checks if a value is worth reporting as a duplicate (not null, a boolean,
+// an empty string or an empty object or array).
+func nonTrivial(value interface{}) bool {
+	switch v := value.(type) {
+	case nil, bool:
+		return false
+	case string:
+		return v != ""
+	}
+	if isObject(value) {
+		return len(mapKeys(value)) > 0
+	} else if describeType(value) == "an array" {
+		return reflect.ValueOf(value).Len() > 0
+	}
+	return true
+}
+
+// Checks if any of the findings is an error.
+func hasLintErrors(findings []LintFinding) bool {
+	for _, finding := range findings {
+		if finding.Severity == LintError {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes the findings as lines of text (`PATH: SEVERITY: MESSAGE [RULE]`) or
+// as a JSON array.
+func writeLintFindings(w io.Writer, findings []LintFinding, asJSON bool) error {
+	if asJSON {
+		if findings == nil {
+			findings = []LintFinding{}
+		}
+		encoded, err := json.Marshal(findings)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(encoded, '\n'))
+		return err
+	}
+	var b strings.Builder
+	for _, finding := range findings {
+		fmt.Fprintf(&b, "%s: %s: %s [%s]\n", lintPathName(finding.Path), finding.Severity, finding.Message, finding.Rule)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func lintPathName(path Path) string {
+	if len(path) == 0 {
+		return "(top level)"
+	}
+	return path.String()
+}
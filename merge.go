@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Policies for values at the same path in several inputs that cannot be merged.
+const (
+	MergeLast         = "last"
+	MergeFirst        = "first"
+	MergeError        = "error"
+	MergeConcatArrays = "concat-arrays"
+	MergeDeep         = "deep"
+)
+
+// How arrays at the same path are combined.
+const (
+	ArraysDefault = ""
+	ArraysReplace = "replace"
+	ArraysConcat  = "concat"
+	ArraysMerge   = "merge"
+)
+
+// The key of the map listing the sources of the top-level keys.
+const mergeSourcesKey = "__sources"
+
+var (
+	mergeConflictPolicies = []string{MergeLast, MergeFirst, MergeError, MergeConcatArrays, MergeDeep}
+	mergeArrayModes       = []string{ArraysReplace, ArraysConcat, ArraysMerge}
+)
+
+// A named input of a merge.
+type MergeSource struct {
+	Name string
+	Data interface{}
+}
+
+// Merges several documents into one.
+//
+// Objects are always merged recursively. Other values at the same path
+// conflict unless they are equal: the last (or first) one wins, or the merge
+// fails for MergeError. MergeConcatArrays and MergeDeep are like MergeLast
+// but concatenate arrays or merge them element by element, respectively.
+// Arrays replaces this choice for arrays (ArraysReplace treats them like
+// other values).
+//
+// With AnnotateSource, the merged object gets a `__sources` map listing the
+// names of the inputs that contributed to each top-level key.
+type Merger struct {
+	OnConflict     string
+	Arrays         string
+	AnnotateSource bool
+}
+
+// A value together with the index of the source it was read from.
+type sourcedValue struct {
+	source int
+	value  interface{}
+}
+
+func (m Merger) Merge(sources []MergeSource) (interface{}, error) {
+	m.OnConflict, m.Arrays = strings.ToLower(m.OnConflict), strings.ToLower(m.Arrays)
+	if !containsFold(m.OnConflict, mergeConflictPolicies) {
+		return nil, fmt.Errorf("unknown conflict policy '%s'", m.OnConflict)
+	} else if len(sources) == 0 {
+		return nil, fmt.Errorf("nothing to merge")
+	} else if m.Arrays != ArraysDefault && !containsFold(m.Arrays, mergeArrayModes) {
+		return nil, fmt.Errorf("unknown array mode '%s'", m.Arrays)
+	}
+	values := make([]sourcedValue, len(sources))
+	for n, source := range sources {
+		values[n] = sourcedValue{n, source.Data}
+	}
+	if !m.AnnotateSource {
+		merged, _, err := m.mergeValues(sources, values, Path{})
+		return merged, err
+	}
+	for _, source := range sources {
+		if !isObject(source.Data) {
+			return nil, fmt.Errorf("sources can only be annotated for objects but '%s' contains %s",
+				source.Name, describeType(source.Data))
+		}
+	}
+	merged, contributors, err := m.mergeObjects(sources, values, Path{})
+	if err != nil {
+		return nil, err
+	}
+	annotations, set := newObjectLike(merged)
+	for _, key := range mapKeys(merged) {
+		var names []interface{}
+		for _, n := range contributors[key] {
+			names = append(names, sources[n].Name)
+		}
+		set(key, names)
+	}
+	return merged, setMapValue(merged, mergeSourcesKey, annotations)
+}
+
+// Returns the array mode in effect for the conflict policy.
+func (m Merger) arrayMode() string {
+	switch {
+	case m.Arrays != ArraysDefault:
+		return m.Arrays
+	case m.OnConflict == MergeConcatArrays:
+		return ArraysConcat
+	case m.OnConflict == MergeDeep:
+		return ArraysMerge
+	}
+	return ArraysReplace
+}
+
+// Merges the values at a path and returns the indexes of the contributing sources.
+func (m Merger) mergeValues(sources []MergeSource, values []sourcedValue, at Path) (interface{}, []int, error) {
+	if len(values) == 1 {
+		return values[0].value, []int{values[0].source}, nil
+	}
+	// only the values of the same kind as the winning one are combined
+	var mergeable []sourcedValue
+	if m.OnConflict == MergeFirst {
+		mergeable = m.mergeableRun(values, 0, 1)
+	} else {
+		mergeable = m.mergeableRun(values, len(values)-1, -1)
+	}
+	if m.OnConflict == MergeError && len(mergeable) < len(values) {
+		if conflict, ok := firstConflict(values); ok {
+			return nil, nil, fmt.Errorf("conflicting values at %s in '%s' and '%s'",
+				describePath(at), sources[conflict[0]].Name, sources[conflict[1]].Name)
+		}
+	}
+	switch winner := mergeable[0].value; {
+	case len(mergeable) == 1:
+		return winner, []int{mergeable[0].source}, nil
+	case isObject(winner):
+		merged, contributors, err := m.mergeObjects(sources, mergeable, at)
+		return merged, allSources(contributors), err
+	case m.arrayMode() == ArraysConcat:
+		var concatenated []interface{}
+		for _, v := range mergeable {
+			elements, _ := topLevelArray(v.value)
+			concatenated = append(concatenated, elements...)
+		}
+		return concatenated, sourceIndexes(mergeable), nil
+	default:
+		return m.mergeArrays(sources, mergeable, at)
+	}
+}
+
+// Returns the values (in order) that can be combined with the one at the
+// start index (going in the given direction).
+func (m Merger) mergeableRun(values []sourcedValue, start int, step int) []sourcedValue {
+	kind := m.mergeKind(values[start].value)
+	end := start
+	for end+step >= 0 && end+step < len(values) && kind != "" && m.mergeKind(values[end+step].value) == kind {
+		end += step
+	}
+	if end < start {
+		start, end = end, start
+	}
+	if kind == "" && m.OnConflict == MergeError {
+		// equal values do not conflict
+		for _, v := range values {
+			if !reflect.DeepEqual(v.value, values[start].value) {
+				return values[start : start+1]
+			}
+		}
+		return values[len(values)-1:]
+	}
+	return values[start : end+1]
+}
+
+// Returns "object" or "array" for values that can be merged, "" otherwise.
+func (m Merger) mergeKind(value interface{}) string {
+	if isObject(value) {
+		return "object"
+	} else if _, err := topLevelArray(value); err == nil && m.arrayMode() != ArraysReplace {
+		return "array"
+	}
+	return ""
+}
+
+// Merges objects and returns the indexes of the contributing sources by key.
+func (m Merger) mergeObjects(sources []MergeSource, values []sourcedValue, at Path) (interface{}, map[string][]int, error) {
+	var keys []string
+	byKey := make(map[string][]sourcedValue)
+	for _, v := range values {
+		for _, key := range mapKeys(v.value) {
+			if _, ok := byKey[key]; !ok {
+				keys = append(keys, key)
+			}
+			value, _ := mapValue(v.value, key)
+			byKey[key] = append(byKey[key], sourcedValue{v.source, value})
+		}
+	}
+	merged, set := newObjectLike(values[0].value)
+	contributors := make(map[string][]int, len(keys))
+	for _, key := range keys {
+		value, nested, err := m.mergeValues(sources, byKey[key], at.append(PathSegment{Key: key}))
+		if err != nil {
+			return nil, nil, err
+		}
+		set(key, value)
+		contributors[key] = nested
+	}
+	return merged, contributors, nil
+}
+
+func (m Merger) mergeArrays(sources []MergeSource, values []sourcedValue, at Path) (interface{}, []int, error) {
+	var byIndex [][]sourcedValue
+	for _, v := range values {
+		elements, _ := topLevelArray(v.value)
+		for n, element := range elements {
+			if n == len(byIndex) {
+				byIndex = append(byIndex, nil)
+			}
+			byIndex[n] = append(byIndex[n], sourcedValue{v.source, element})
+		}
+	}
+	merged := make([]interface{}, len(byIndex))
+	var contributors []int
+	for n := range byIndex {
+		value, nested, err := m.mergeValues(sources, byIndex[n], at.append(PathSegment{Index: n, IsIndex: true}))
+		if err != nil {
+			return nil, nil, err
+		}
+		merged[n] = value
+		contributors = append(contributors, nested...)
+	}
+	return merged, uniqueSources(contributors), nil
+}
+
+// Returns the sources of the first two values that differ.
+func firstConflict(values []sourcedValue) ([2]int, bool) {
+	for _, v := range values[1:] {
+		if !reflect.DeepEqual(v.value, values[0].value) {
+			return [2]int{values[0].source, v.source}, true
+		}
+	}
+	return [2]int{}, false
+}
+
+func sourceIndexes(values []sourcedValue) []int {
+	indexes := make([]int, len(values))
+	for n, v := range values {
+		indexes[n] = v.source
+	}
+	return indexes
+}
+
+func allSources(contributors map[string][]int) []int {
+	var all []int
+	for _, indexes := range contributors {
+		all = append(all, indexes...)
+	}
+	return uniqueSources(all)
+}
+
+// Returns the source indexes in ascending order without duplicates.
+func uniqueSources(indexes []int) []int {
+	seen := make(map[int]bool, len(indexes))
+	max := -1
+	for _, n := range indexes {
+		seen[n] = true
+		if n > max {
+			max = n
+		}
+	}
+	unique := make([]int, 0, len(seen))
+	for n := 0; n <= max; n++ {
+		if seen[n] {
+			unique = append(unique, n)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	defaultSplitNameTemplate      = "{{.InputBase}}-{{.Index}}{{.Ext}}"
+	defaultBatchNameTemplate      = "{{.InputBase}}{{.Ext}}"
+	defaultSplitByKeyNameTemplate = "{{.Key}}{{.Ext}}"
+)
+
+var fileNameReplacer = strings.NewReplacer(
+	"/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+
+// The fields available to output name templates.
+type OutputName struct {
+	// The key of the part (for objects split by key) or empty.
+	Key string
+	// The (0-based) position of the part or input file.
+	Index int
+	// The input path and its base name without the extension.
+	Input     string
+	InputBase string
+	// The extension of the output format including the dot.
+	Ext string
+
+	data interface{}
+}
+
+// Returns the value at the path (see ParsePath) of the part or input, or
+// an empty string if there is none. Non-strings are encoded as compact JSON.
+func (n OutputName) Field(path string) (string, error) {
+	parsed, err := ParsePath(path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := lookupPath(n.data, parsed)
+	if !ok {
+		return "", nil
+	}
+	return groupKey(value)
+}
+
+// A template for output file names (using Go template syntax), which keeps
+// track of the names rendered so far so that no two outputs are written to
+// the same file.
+type NameTemplate struct {
+	template *template.Template
+	rendered map[string]string
+}
+
+func NewNameTemplate(text string) (*NameTemplate, error) {
+	t, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template: %s", err)
+	}
+	return &NameTemplate{template: t, rendered: make(map[string]string)}, nil
+}
+
+// Renders the (sanitized) file name for an output. The description of the
+// output is used in the error if the name was already rendered before.
+func (t *NameTemplate) Render(name OutputName, description string) (string, error) {
+	var b strings.Builder
+	err := t.template.Execute(&b, name)
+	if err != nil {
+		return "", err
+	}
+	fileName := sanitizeFileName(b.String())
+	if previous, ok := t.rendered[fileName]; ok {
+		return "", fmt.Errorf("%s and %s would both be written to '%s'", previous, description, fileName)
+	}
+	t.rendered[fileName] = description
+	return fileName, nil
+}
+
+// Makes a string safe to use as a file name (in a single directory) by
+// replacing separators, reserved and control characters.
+func sanitizeFileName(name string) string {
+	name = fileNameReplacer.Replace(name)
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " ")
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// Returns the base name of a file without its extension (or "stdin").
+func inputBaseName(input string) string {
+	if input == "" || input == "-" {
+		return "stdin"
+	}
+	base := filepath.Base(input)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// Returns the (first) extension of a format including the dot or an empty string.
+func formatExtension(format FileFormat) string {
+	if extensions := format.SupportedExtensions(); len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ""
+}
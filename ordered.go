@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// A map with string keys that keeps the order in which keys were added,
+// e.g. the column order of a CSF header.
+//
+// JSON and YAML output preserve the key order, other formats treat it
+// like any other map.
+type OrderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func NewOrderedMap() *OrderedMap {
+	return &OrderedMap{
+		keys:   make([]string, 0),
+		values: make(map[string]interface{}),
+	}
+}
+
+// Sets the value of a key, new keys are appended.
+func (m *OrderedMap) Set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *OrderedMap) Get(key string) (interface{}, bool) {
+	value, ok := m.values[key]
+	return value, ok
+}
+
+func (m *OrderedMap) Delete(key string) {
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+	delete(m.values, key)
+	for n, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:n], m.keys[n+1:]...)
+			break
+		}
+	}
+}
+
+// Returns the keys in order. The slice must not be modified.
+func (m *OrderedMap) Keys() []string {
+	return m.keys
+}
+
+func (m *OrderedMap) Len() int {
+	return len(m.keys)
+}
+
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	buffer.WriteByte('{')
+	for n, key := range m.keys {
+		if n > 0 {
+			buffer.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(k)
+		buffer.WriteByte(':')
+		buffer.Write(v)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func (m *OrderedMap) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, key := range m.keys {
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+		valueNode := &yaml.Node{}
+		err := valueNode.Encode(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, keyNode, valueNode)
+	}
+	return node, nil
+}
+
+// Converts all ordered maps in the data into plain maps, e.g. for encoders
+// that only support the built-in types. Other values are kept as they are,
+// and the data is left alone: the maps and arrays containing ordered maps
+// are copied.
+func plainMaps(data interface{}) interface{} {
+	plain, _ := plainMapsCopy(data)
+	return plain
+}
+
+// Like plainMaps, but also reports if anything was converted.
+func plainMapsCopy(data interface{}) (interface{}, bool) {
+	switch d := data.(type) {
+	case *OrderedMap:
+		if d == nil {
+			return nil, true
+		}
+		plain := make(map[string]interface{}, len(d.keys))
+		for _, key := range d.keys {
+			plain[key], _ = plainMapsCopy(d.values[key])
+		}
+		return plain, true
+	case map[string]interface{}:
+		result, copied := data, false
+		for k, v := range d {
+			if plain, changed := plainMapsCopy(v); changed {
+				if !copied {
+					result, copied = shallowCopy(data), true
+				}
+				result.(map[string]interface{})[k] = plain
+			}
+		}
+		return result, copied
+	case map[interface{}]interface{}:
+		result, copied := data, false
+		for k, v := range d {
+			if plain, changed := plainMapsCopy(v); changed {
+				if !copied {
+					result, copied = shallowCopy(data), true
+				}
+				result.(map[interface{}]interface{})[k] = plain
+			}
+		}
+		return result, copied
+	case []interface{}:
+		result, copied := data, false
+		for n, v := range d {
+			if plain, changed := plainMapsCopy(v); changed {
+				if !copied {
+					result, copied = shallowCopy(data), true
+				}
+				result.([]interface{})[n] = plain
+			}
+		}
+		return result, copied
+	}
+	return data, false
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var pathKeyEscaper = strings.NewReplacer("\\", "\\\\", ".", "\\.", "[", "\\[")
+
+// A path into the data such as `spec.containers[0].image`.
+//
+// Keys are separated by dots (`\.` for literal dots), array elements are
+// selected with `[n]`. `*` matches any key, `[]` or `[*]` any element, and
+// `**` any number of keys and elements (including none), e.g.
+// `**.image` matches `image` at any depth. The empty path refers to the data
+// itself.
+type Path []PathSegment
+
+type PathSegment struct {
+	Key      string
+	Index    int
+	IsIndex  bool
+	Wildcard bool
+	// Set for `**` (a Wildcard), which matches any number of segments.
+	Recursive bool
+}
+
+func ParsePath(path string) (Path, error) {
+	var (
+		parsed  Path
+		key     strings.Builder
+		inKey   bool
+		escaped bool
+	)
+	endKey := func() {
+		if !inKey {
+			return
+		}
+		k := key.String()
+		recursive := k == "**" && !escaped
+		parsed = append(parsed, PathSegment{Key: k, Wildcard: (k == "*" || recursive) && !escaped, Recursive: recursive})
+		key.Reset()
+		inKey = false
+		escaped = false
+	}
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\' && i+1 < len(path):
+			i++
+			key.WriteByte(path[i])
+			inKey = true
+			escaped = true
+		case c == '.':
+			if !inKey && (i == 0 || path[i-1] != ']') {
+				return nil, fmt.Errorf("empty key in path '%s'", path)
+			}
+			endKey()
+		case c == '[':
+			endKey()
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in path '%s'", path)
+			}
+			index := path[i+1 : i+end]
+			if index == "" || index == "*" {
+				parsed = append(parsed, PathSegment{IsIndex: true, Wildcard: true})
+			} else {
+				n, err := strconv.Atoi(index)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid index '%s' in path '%s'", index, path)
+				}
+				parsed = append(parsed, PathSegment{IsIndex: true, Index: n})
+			}
+			i += end
+			if i+1 < len(path) && path[i+1] != '.' && path[i+1] != '[' {
+				return nil, fmt.Errorf("unexpected character after index in path '%s'", path)
+			}
+		default:
+			key.WriteByte(c)
+			inKey = true
+		}
+	}
+	if strings.HasSuffix(path, ".") && !strings.HasSuffix(path, "\\.") {
+		return nil, fmt.Errorf("empty key in path '%s'", path)
+	}
+	endKey()
+	return parsed, nil
+}
+
+// Parses a comma-separated list of paths.
+func ParsePaths(paths string) ([]Path, error) {
+	var parsed []Path
+	for _, p := range strings.Split(paths, ",") {
+		path, err := ParsePath(strings.TrimSpace(p))
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, path)
+	}
+	return parsed, nil
+}
+
+func (p Path) String() string {
+	var b strings.Builder
+	for n, segment := range p {
+		switch {
+		case segment.IsIndex && segment.Wildcard:
+			b.WriteString("[]")
+		case segment.IsIndex:
+			b.WriteString("[" + strconv.Itoa(segment.Index) + "]")
+		default:
+			if n > 0 {
+				b.WriteByte('.')
+			}
+			if segment.Recursive {
+				b.WriteString("**")
+			} else if segment.Wildcard {
+				b.WriteByte('*')
+			} else if segment.Key == "*" || segment.Key == "**" {
+				b.WriteString("\\" + segment.Key)
+			} else {
+				b.WriteString(pathKeyEscaper.Replace(segment.Key))
+			}
+		}
+	}
+	return b.String()
+}
+
+// Returns a new path with the segment appended.
+func (p Path) append(segment PathSegment) Path {
+	appended := make(Path, len(p), len(p)+1)
+	copy(appended, p)
+	return append(appended, segment)
+}
+
+// Checks if the path has a `**` segment.
+func (p Path) isRecursive() bool {
+	for _, segment := range p {
+		if segment.Recursive {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Path) hasWildcards() bool {
+	for _, segment := range p {
+		if segment.Wildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if a path without wildcards is matched by the path.
+func (p Path) matches(concrete Path) bool {
+	if len(p) == 0 {
+		return len(concrete) == 0
+	}
+	segment := p[0]
+	if segment.Recursive {
+		for n := 0; n <= len(concrete); n++ {
+			if p[1:].matches(concrete[n:]) {
+				return true
+			}
+		}
+		return false
+	} else if len(concrete) == 0 || segment.IsIndex != concrete[0].IsIndex {
+		return false
+	} else if !segment.Wildcard && (segment.Key != concrete[0].Key || segment.Index != concrete[0].Index) {
+		return false
+	}
+	return p[1:].matches(concrete[1:])
+}
+
+// Returns the value at the given path (which must not contain wildcards).
+func lookupPath(data interface{}, path Path) (interface{}, bool) {
+	for _, segment := range path {
+		var ok bool
+		if segment.IsIndex {
+			data, ok = sliceElement(data, segment.Index)
+		} else {
+			data, ok = mapValue(data, segment.Key)
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// Calls the function for each value matching the path with the concrete path of the value.
+func matchPath(data interface{}, path Path, fn func(value interface{}, at Path)) {
+	matchPathFrom(data, path, Path{}, fn)
+}
+
+func matchPathFrom(data interface{}, path Path, at Path, fn func(value interface{}, at Path)) {
+	if len(path) == 0 {
+		fn(data, at)
+		return
+	}
+	segment := path[0]
+	switch {
+	case segment.Recursive:
+		matchPathFrom(data, path[1:], at, fn)
+		for _, child := range pathChildren(data) {
+			matchPathFrom(child.value, path, at.append(child.segment), fn)
+		}
+	case segment.IsIndex && segment.Wildcard:
+		value := reflect.ValueOf(data)
+		if isNil(data) || (value.Kind() != reflect.Slice && value.Kind() != reflect.Array) {
+			return
+		}
+		for i := 0; i < value.Len(); i++ {
+			matchPathFrom(value.Index(i).Interface(), path[1:], at.append(PathSegment{IsIndex: true, Index: i}), fn)
+		}
+	case segment.Wildcard:
+		for _, key := range mapKeys(data) {
+			value, _ := mapValue(data, key)
+			matchPathFrom(value, path[1:], at.append(PathSegment{Key: key}), fn)
+		}
+	case segment.IsIndex:
+		if value, ok := sliceElement(data, segment.Index); ok {
+			matchPathFrom(value, path[1:], at.append(segment), fn)
+		}
+	default:
+		if value, ok := mapValue(data, segment.Key); ok {
+			matchPathFrom(value, path[1:], at.append(segment), fn)
+		}
+	}
+}
+
+// Replaces each value matching the path by the result of the function and
+// returns the data (which is only replaced itself for the empty path). As
+// `**` can match values within other matches, only the outermost ones are
+// replaced then, so that the function is applied to each value once.
+func updatePath(data interface{}, path Path, fn func(value interface{}, at Path) (interface{}, error)) (interface{}, error) {
+	if !path.isRecursive() {
+		return updatePathFrom(data, path, Path{}, fn)
+	}
+	var err error
+	for _, match := range outermostMatches(data, path) {
+		if data, err = updatePathFrom(data, match, Path{}, fn); err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// Returns the paths of the values matching the path that are not within
+// other matches.
+func outermostMatches(data interface{}, path Path) []Path {
+	var matches []Path
+	matchPath(data, path, func(value interface{}, at Path) {
+		matches = append(matches, at)
+	})
+	var outermost []Path
+	for _, match := range matches {
+		within := false
+		for _, other := range matches {
+			if len(other) < len(match) && reflect.DeepEqual(other, match[:len(other)]) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			outermost = append(outermost, match)
+		}
+	}
+	return outermost
+}
+
+func updatePathFrom(data interface{}, path Path, at Path, fn func(value interface{}, at Path) (interface{}, error)) (interface{}, error) {
+	if len(path) == 0 {
+		return fn(data, at)
+	}
+	segment := path[0]
+	update := func(value interface{}, segment PathSegment) (interface{}, error) {
+		return updatePathFrom(value, path[1:], at.append(segment), fn)
+	}
+	switch {
+	case segment.IsIndex && segment.Wildcard:
+		value := reflect.ValueOf(data)
+		if isNil(data) || value.Kind() != reflect.Slice {
+			return data, nil
+		}
+		for i := 0; i < value.Len(); i++ {
+			updated, err := update(value.Index(i).Interface(), PathSegment{IsIndex: true, Index: i})
+			if err != nil {
+				return data, err
+			}
+			if err = setSliceElement(data, i, updated); err != nil {
+				return data, err
+			}
+		}
+	case segment.Wildcard:
+		for _, key := range mapKeys(data) {
+			value, _ := mapValue(data, key)
+			updated, err := update(value, PathSegment{Key: key})
+			if err != nil {
+				return data, err
+			}
+			if err = setMapValue(data, key, updated); err != nil {
+				return data, err
+			}
+		}
+	case segment.IsIndex:
+		if value, ok := sliceElement(data, segment.Index); ok {
+			updated, err := update(value, segment)
+			if err != nil {
+				return data, err
+			}
+			return data, setSliceElement(data, segment.Index, updated)
+		}
+	default:
+		if value, ok := mapValue(data, segment.Key); ok {
+			updated, err := update(value, segment)
+			if err != nil {
+				return data, err
+			}
+			return data, setMapValue(data, segment.Key, updated)
+		}
+	}
+	return data, nil
+}
+
+type pathChild struct {
+	segment PathSegment
+	value   interface{}
+}
+
+// Returns the values of an object or array with their segments (none for
+// other values).
+func pathChildren(data interface{}) []pathChild {
+	var children []pathChild
+	if keys := mapKeys(data); keys != nil {
+		for _, key := range keys {
+			value, _ := mapValue(data, key)
+			children = append(children, pathChild{PathSegment{Key: key}, value})
+		}
+		return children
+	}
+	value := reflect.ValueOf(data)
+	if isNil(data) || (value.Kind() != reflect.Slice && value.Kind() != reflect.Array) {
+		return nil
+	}
+	for i := 0; i < value.Len(); i++ {
+		children = append(children, pathChild{PathSegment{IsIndex: true, Index: i}, value.Index(i).Interface()})
+	}
+	return children
+}
+
+// Sets the value at the path (which must not contain wildcards) and returns
+// the data. The last key is added to its object if it is missing, all other
+// keys and elements must exist.
+func setPath(data interface{}, path Path, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	last := path[len(path)-1]
+	parent, ok := lookupPath(data, path[:len(path)-1])
+	if !ok {
+		return data, fmt.Errorf("there is no value at %s", describePath(path[:len(path)-1]))
+	} else if last.IsIndex {
+		return data, setSliceElement(parent, last.Index, value)
+	}
+	return data, setMapValue(parent, last.Key, value)
+}
+
+// Returns the paths of all scalars (including nulls) in the data as strings,
+// e.g. `a.b` and `a.c[0]`.
+func leafPaths(data interface{}) []string {
+	var paths []string
+	transformLeaves(data, func(value interface{}, at Path) (interface{}, error) {
+		if len(at) > 0 {
+			paths = append(paths, at.String())
+		}
+		return value, nil
+	})
+	return paths
+}
+
+// Returns the sorted intersection (or union) of the leaf paths of the documents.
+func combineLeafPaths(documents []interface{}, intersect bool) []string {
+	counts := make(map[string]int)
+	for _, data := range documents {
+		seen := make(map[string]bool)
+		for _, path := range leafPaths(data) {
+			if !seen[path] {
+				seen[path] = true
+				counts[path]++
+			}
+		}
+	}
+	combined := make([]string, 0, len(counts))
+	for path, count := range counts {
+		if !intersect || count == len(documents) {
+			combined = append(combined, path)
+		}
+	}
+	sort.Strings(combined)
+	return combined
+}
+
+// Describes a path for messages.
+func describePath(path Path) string {
+	if len(path) == 0 {
+		return "the top level"
+	}
+	return "'" + path.String() + "'"
+}
+
+// Returns the value of a key in any kind of map, non-string keys are
+// compared by their string representation.
+func mapValue(data interface{}, key string) (interface{}, bool) {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		value, ok := d[key]
+		return value, ok
+	case *OrderedMap:
+		if d == nil {
+			return nil, false
+		}
+		return d.Get(key)
+	}
+	value := reflect.ValueOf(data)
+	if isNil(data) || value.Kind() != reflect.Map {
+		return nil, false
+	}
+	for _, k := range value.MapKeys() {
+		if fmt.Sprint(k.Interface()) == key {
+			return value.MapIndex(k).Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// Returns the (string representations of the) keys of any kind of map,
+// or nil for anything else. Keys of ordered maps are kept in order, all
+// others are sorted.
+func mapKeys(data interface{}) []string {
+	if m, ok := data.(*OrderedMap); ok {
+		if m == nil {
+			return nil
+		}
+		return append([]string{}, m.Keys()...)
+	}
+	value := reflect.ValueOf(data)
+	if isNil(data) || value.Kind() != reflect.Map {
+		return nil
+	}
+	keys := make([]string, 0, value.Len())
+	for _, k := range value.MapKeys() {
+		keys = append(keys, fmt.Sprint(k.Interface()))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Sets the value of a key in any kind of map. Existing non-string keys are
+// matched by their string representation, new keys must be assignable.
+func setMapValue(data interface{}, key string, value interface{}) error {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		d[key] = value
+		return nil
+	case *OrderedMap:
+		d.Set(key, value)
+		return nil
+	}
+	m := reflect.ValueOf(data)
+	if isNil(data) || m.Kind() != reflect.Map {
+		return fmt.Errorf("cannot set key '%s' of %s", key, describeType(data))
+	}
+	v, ok := assignableValue(reflect.ValueOf(&value).Elem(), m.Type().Elem())
+	if !ok {
+		return fmt.Errorf("cannot set key '%s' to %s", key, describeType(value))
+	}
+	for _, k := range m.MapKeys() {
+		if fmt.Sprint(k.Interface()) == key {
+			m.SetMapIndex(k, v)
+			return nil
+		}
+	}
+	k, ok := assignableValue(reflect.ValueOf(key), m.Type().Key())
+	if !ok {
+		return fmt.Errorf("cannot add key '%s' to a map with %s keys", key, m.Type().Key())
+	}
+	m.SetMapIndex(k, v)
+	return nil
+}
+
+// Removes a key from any kind of map, non-string keys are matched by their
+// string representation.
+func deleteMapKey(data interface{}, key string) {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		delete(d, key)
+		return
+	case *OrderedMap:
+		d.Delete(key)
+		return
+	}
+	m := reflect.ValueOf(data)
+	if isNil(data) || m.Kind() != reflect.Map {
+		return
+	}
+	for _, k := range m.MapKeys() {
+		if fmt.Sprint(k.Interface()) == key {
+			m.SetMapIndex(k, reflect.Value{})
+		}
+	}
+}
+
+func setSliceElement(data interface{}, index int, value interface{}) error {
+	if elements, ok := data.([]interface{}); ok && index >= 0 && index < len(elements) {
+		elements[index] = value
+		return nil
+	}
+	s := reflect.ValueOf(data)
+	if isNil(data) || s.Kind() != reflect.Slice || index < 0 || index >= s.Len() {
+		return fmt.Errorf("cannot set element %d of %s", index, describeType(data))
+	}
+	v, ok := assignableValue(reflect.ValueOf(&value).Elem(), s.Type().Elem())
+	if !ok {
+		return fmt.Errorf("cannot set element %d to %s", index, describeType(value))
+	}
+	s.Index(index).Set(v)
+	return nil
+}
+
+func sliceElement(data interface{}, index int) (interface{}, bool) {
+	value := reflect.ValueOf(data)
+	if isNil(data) || (value.Kind() != reflect.Slice && value.Kind() != reflect.Array) {
+		return nil, false
+	}
+	if index < 0 || index >= value.Len() {
+		return nil, false
+	}
+	return value.Index(index).Interface(), true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Input formats for system files with records of fields in a fixed order,
+// by name, e.g. `-i passwd`. Further presets only need an entry here.
+var formatPresets = map[string]PresetFormat{
+	"passwd": {Preset: "passwd", FieldDelimiter: ":", CommentPrefix: "#",
+		Names: []string{"name", "passwd", "uid", "gid", "gecos", "home", "shell"}},
+	"group": {Preset: "group", FieldDelimiter: ":", CommentPrefix: "#",
+		Names: []string{"name", "passwd", "gid", "members"}, ListFields: []string{"members"}, ListDelimiter: ","},
+	"shadow": {Preset: "shadow", FieldDelimiter: ":", CommentPrefix: "#",
+		Names: []string{"name", "passwd", "lastchg", "min", "max", "warn", "inactive", "expire", "reserved"}},
+}
+
+// Returns the names of the presets in alphabetical order.
+func formatPresetNames() []string {
+	names := make([]string, 0, len(formatPresets))
+	for name := range formatPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lines of fields separated by FieldDelimiter (without quoting), read as
+// objects with the Names as keys, as in /etc/passwd. Lines that are empty or
+// start with CommentPrefix are skipped. Fields missing at the end of a line
+// are empty, more fields than Names are an error. The fields in ListFields
+// are split at ListDelimiter into arrays of strings (empty if the field is).
+// SkipRecords and RecordLimit select records as for TextFormat.
+type PresetFormat struct {
+	Preset         string
+	FieldDelimiter string
+	Names          []string
+	ListFields     []string
+	ListDelimiter  string
+	CommentPrefix  string
+	PreserveOrder  bool
+	SkipRecords    int
+	RecordLimit    int
+	OnRecordError  RecordErrorHandler
+}
+
+func (f PresetFormat) Name() string {
+	return f.Preset
+}
+
+func (f PresetFormat) SupportedExtensions() []string {
+	return []string{}
+}
+
+func (f PresetFormat) Unmarshal(reader io.Reader) (interface{}, error) {
+	if f.FieldDelimiter == "" || len(f.Names) == 0 {
+		return nil, fmt.Errorf("%s input requires a field delimiter and field names", f.Name())
+	}
+	lines, err := TextFormat{}.Unmarshal(reader)
+	if err != nil {
+		return nil, err
+	}
+	var records []string
+	var numbers []int
+	for n, line := range lines.([]string) {
+		if strings.TrimSpace(line) == "" || (f.CommentPrefix != "" && strings.HasPrefix(line, f.CommentPrefix)) {
+			continue
+		}
+		records, numbers = append(records, line), append(numbers, n+1)
+	}
+	skipped := 0
+	if f.SkipRecords > 0 {
+		skipped = f.SkipRecords
+	}
+
+	data := make([]interface{}, 0, len(records))
+	for n, record := range recordWindow(records, f.SkipRecords, f.RecordLimit) {
+		fields := readSeparatedStrings([]byte(record), f.FieldDelimiter)
+		if len(fields) > len(f.Names) {
+			line := numbers[skipped+n]
+			err = fmt.Errorf("line %d has %d fields but %s only %d", line, len(fields), f.Name(), len(f.Names))
+			if f.OnRecordError != nil && f.OnRecordError(line, err) {
+				continue
+			}
+			return nil, err
+		}
+		data = append(data, f.record(fields))
+	}
+	return data, nil
+}
+
+func (f PresetFormat) record(fields []string) interface{} {
+	var template interface{} = map[string]interface{}{}
+	if f.PreserveOrder {
+		template = NewOrderedMap()
+	}
+	object, set := newObjectLike(template)
+	for n, name := range f.Names {
+		field := ""
+		if n < len(fields) {
+			field = fields[n]
+		}
+		if !containsFold(name, f.ListFields) {
+			set(name, field)
+			continue
+		}
+		elements := []interface{}{}
+		if field != "" {
+			for _, element := range strings.Split(field, f.ListDelimiter) {
+				elements = append(elements, element)
+			}
+		}
+		set(name, elements)
+	}
+	return object
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A transformer grouping the elements of a top-level array by the value at a path.
+//
+// The result maps each distinct value (see groupKey) to the elements having
+// it, in their original order, or to the number of such elements if Count is
+// set. Elements without the path are collected under MissingKey or dropped if
+// it is empty. It is an error if MissingKey is also a value at the path.
+type GroupByTransformer struct {
+	Path       Path
+	MissingKey string
+	Count      bool
+}
+
+func (t GroupByTransformer) Transform(data interface{}) (interface{}, error) {
+	elements, err := topLevelArray(data)
+	if err != nil {
+		return data, err
+	}
+	groups := make(map[string][]interface{})
+	var missing []interface{}
+	for _, element := range elements {
+		value, ok := lookupPath(element, t.Path)
+		if !ok {
+			if t.MissingKey != "" {
+				missing = append(missing, element)
+			}
+			continue
+		}
+		key, err := groupKey(value)
+		if err != nil {
+			return data, err
+		}
+		groups[key] = append(groups[key], element)
+	}
+	if len(missing) > 0 {
+		if _, found := groups[t.MissingKey]; found {
+			return data, fmt.Errorf("the missing key '%s' is also a value at %s", t.MissingKey, describePath(t.Path))
+		}
+		groups[t.MissingKey] = missing
+	}
+
+	result := make(map[string]interface{}, len(groups))
+	for key, group := range groups {
+		if t.Count {
+			result[key] = len(group)
+		} else {
+			result[key] = group
+		}
+	}
+	return result, nil
+}
+
+// A transformer removing elements of a top-level array with the same value at a path.
+//
+// The first element for each value is kept at its position, or with KeepLast,
+// the last element replaces it. Elements without the path are kept unless
+// RequireKey is set. If Removed is set, it receives the number of removed elements.
+type UniqueByTransformer struct {
+	Path       Path
+	KeepLast   bool
+	RequireKey bool
+	Removed    *int
+}
+
+func (t UniqueByTransformer) Transform(data interface{}) (interface{}, error) {
+	elements, err := topLevelArray(data)
+	if err != nil {
+		return data, err
+	}
+	unique := make([]interface{}, 0, len(elements))
+	positions := make(map[string]int)
+	for _, element := range elements {
+		value, ok := lookupPath(element, t.Path)
+		if !ok {
+			if !t.RequireKey {
+				unique = append(unique, element)
+			}
+			continue
+		}
+		key, err := CanonicalJSON(value)
+		if err != nil {
+			return data, err
+		}
+		if position, found := positions[string(key)]; !found {
+			positions[string(key)] = len(unique)
+			unique = append(unique, element)
+		} else if t.KeepLast {
+			unique[position] = element
+		}
+	}
+	if t.Removed != nil {
+		*t.Removed = len(elements) - len(unique)
+	}
+	return unique, nil
+}
+
+// A transformer counting the occurrences of each distinct value (see groupKey)
+// at a path, which may contain wildcards. Without a path, the elements of a
+// top-level array are counted.
+//
+// The result is an ordered object mapping values to counts, the most frequent
+// first (ties in key order), limited to the Top values if it is positive.
+type FrequencyTransformer struct {
+	Path Path
+	Top  int
+}
+
+func (t FrequencyTransformer) Transform(data interface{}) (interface{}, error) {
+	var values []interface{}
+	if len(t.Path) == 0 {
+		elements, err := topLevelArray(data)
+		if err != nil {
+			return data, err
+		}
+		values = elements
+	} else {
+		matchPath(data, t.Path, func(value interface{}, at Path) {
+			values = append(values, value)
+		})
+	}
+
+	counts := make(map[string]int)
+	for _, value := range values {
+		key, err := groupKey(value)
+		if err != nil {
+			return data, err
+		}
+		counts[key]++
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if t.Top > 0 && len(keys) > t.Top {
+		keys = keys[:t.Top]
+	}
+
+	result := NewOrderedMap()
+	for _, key := range keys {
+		result.Set(key, counts[key])
+	}
+	return result, nil
+}
+
+// A transformer swapping the rows and columns of an array of arrays (at the
+// path, if any), padding short rows with nulls. An array of objects becomes an
+// object mapping each key to the column of its values (in the order the keys
+// first appear), as if the keys were a header. With Strict, rows of different
+// lengths or with different keys are an error instead.
+type TransposeTransformer struct {
+	Path   Path
+	Strict bool
+}
+
+func (t TransposeTransformer) Transform(data interface{}) (interface{}, error) {
+	return updatePath(data, t.Path, func(value interface{}, at Path) (interface{}, error) {
+		rows, err := topLevelArray(value)
+		if err != nil {
+			return value, fmt.Errorf("cannot transpose %s: %s", describePath(at), err)
+		}
+		if len(rows) > 0 && isObject(rows[0]) {
+			return t.transposeObjects(rows, at)
+		}
+		return t.transposeArrays(rows, at)
+	})
+}
+
+func (t TransposeTransformer) transposeArrays(rows []interface{}, at Path) (interface{}, error) {
+	columns := 0
+	cells := make([][]interface{}, len(rows))
+	for n, row := range rows {
+		var err error
+		if cells[n], err = topLevelArray(row); err != nil {
+			return rows, fmt.Errorf("cannot transpose %s: row %d is %s", describePath(at), n, describeType(row))
+		}
+		if t.Strict && n > 0 && len(cells[n]) != columns {
+			return rows, fmt.Errorf("cannot transpose %s: row %d has %d instead of %d elements", describePath(at), n, len(cells[n]), columns)
+		}
+		if len(cells[n]) > columns {
+			columns = len(cells[n])
+		}
+	}
+	transposed := make([]interface{}, columns)
+	for c := range transposed {
+		column := make([]interface{}, len(rows))
+		for r := range rows {
+			if c < len(cells[r]) {
+				column[r] = cells[r][c]
+			}
+		}
+		transposed[c] = column
+	}
+	return transposed, nil
+}
+
+func (t TransposeTransformer) transposeObjects(rows []interface{}, at Path) (interface{}, error) {
+	transposed := NewOrderedMap()
+	for n, row := range rows {
+		if !isObject(row) {
+			return rows, fmt.Errorf("cannot transpose %s: row %d is %s", describePath(at), n, describeType(row))
+		}
+		keys := mapKeys(row)
+		if t.Strict && n > 0 && len(keys) != transposed.Len() {
+			return rows, fmt.Errorf("cannot transpose %s: row %d has %d instead of %d keys", describePath(at), n, len(keys), transposed.Len())
+		}
+		for _, key := range keys {
+			column, ok := transposed.Get(key)
+			if !ok {
+				if t.Strict && n > 0 {
+					return rows, fmt.Errorf("cannot transpose %s: row %d has the additional key '%s'", describePath(at), n, key)
+				}
+				column = make([]interface{}, len(rows))
+				transposed.Set(key, column)
+			}
+			column.([]interface{})[n], _ = mapValue(row, key)
+		}
+	}
+	return transposed, nil
+}
+
+// A transformer combining the elements of a top-level array (the keys) with
+// those of another array (the values) by position, into an object (with keys
+// converted as by groupKey) or, if Pairs is set, an array of [key, value]
+// arrays. Arrays of different lengths are an error unless Pad is set, in which
+// case missing values (and, for pairs, keys) are null.
+type ZipTransformer struct {
+	Values interface{}
+	Pairs  bool
+	Pad    bool
+}
+
+func (t ZipTransformer) Transform(data interface{}) (interface{}, error) {
+	keys, err := topLevelArray(data)
+	if err != nil {
+		return data, fmt.Errorf("keys: %s", err)
+	}
+	values, err := topLevelArray(t.Values)
+	if err != nil {
+		return data, fmt.Errorf("values: %s", err)
+	}
+	if len(keys) != len(values) && !t.Pad {
+		return data, fmt.Errorf("cannot zip %d keys with %d values", len(keys), len(values))
+	}
+	if len(keys) < len(values) && !t.Pairs {
+		return data, fmt.Errorf("cannot zip %d keys with %d values into an object", len(keys), len(values))
+	}
+
+	length := len(keys)
+	if len(values) > length {
+		length = len(values)
+	}
+	element := func(elements []interface{}, n int) interface{} {
+		if n < len(elements) {
+			return elements[n]
+		}
+		return nil
+	}
+	if t.Pairs {
+		pairs := make([]interface{}, length)
+		for n := range pairs {
+			pairs[n] = []interface{}{element(keys, n), element(values, n)}
+		}
+		return pairs, nil
+	}
+	zipped := NewOrderedMap()
+	for n, key := range keys {
+		k, err := groupKey(key)
+		if err != nil {
+			return data, err
+		}
+		if _, exists := zipped.Get(k); exists {
+			return data, fmt.Errorf("duplicate key '%s' (element %d)", k, n)
+		}
+		zipped.Set(k, element(values, n))
+	}
+	return zipped, nil
+}
+
+const (
+	JoinInner = "inner"
+	JoinLeft  = "left"
+	JoinOuter = "outer"
+)
+
+// A transformer joining the elements of a top-level array (the left side) with
+// those of another array (the right side) whose values at the join paths are
+// equal (see groupKey, so that e.g. the CSF string "1" matches the number 1).
+//
+// Each pair of matching elements results in one object with the fields of
+// both; right fields that collide with left fields are renamed with the prefix
+// and suffix, except for the right join key if it has the same name. Inner
+// joins drop unmatched elements, left joins keep unmatched left elements and
+// outer joins additionally append the unmatched right elements.
+type JoinTransformer struct {
+	Right       interface{}
+	LeftPath    Path
+	RightPath   Path
+	Kind        string
+	RightPrefix string
+	RightSuffix string
+}
+
+func (t JoinTransformer) Transform(data interface{}) (interface{}, error) {
+	if t.Kind != JoinInner && t.Kind != JoinLeft && t.Kind != JoinOuter {
+		return data, fmt.Errorf("unknown join kind '%s'", t.Kind)
+	}
+	left, err := topLevelArray(data)
+	if err != nil {
+		return data, fmt.Errorf("left side: %s", err)
+	}
+	right, err := topLevelArray(t.Right)
+	if err != nil {
+		return data, fmt.Errorf("right side: %s", err)
+	}
+	index := make(map[string][]int)
+	for n, element := range right {
+		if !isObject(element) {
+			return data, fmt.Errorf("right side: element %d is %s, not an object", n, describeType(element))
+		}
+		if value, ok := lookupPath(element, t.RightPath); ok {
+			key, err := groupKey(value)
+			if err != nil {
+				return data, err
+			}
+			index[key] = append(index[key], n)
+		}
+	}
+
+	joined := make([]interface{}, 0, len(left))
+	matched := make([]bool, len(right))
+	for n, element := range left {
+		if !isObject(element) {
+			return data, fmt.Errorf("left side: element %d is %s, not an object", n, describeType(element))
+		}
+		var matches []int
+		if value, ok := lookupPath(element, t.LeftPath); ok {
+			key, err := groupKey(value)
+			if err != nil {
+				return data, err
+			}
+			matches = index[key]
+		}
+		if len(matches) == 0 && t.Kind != JoinInner {
+			joined = append(joined, element)
+		}
+		for _, m := range matches {
+			matched[m] = true
+			joined = append(joined, t.joinElements(element, right[m]))
+		}
+	}
+	if t.Kind == JoinOuter {
+		for n, element := range right {
+			if !matched[n] {
+				joined = append(joined, element)
+			}
+		}
+	}
+	return joined, nil
+}
+
+// Combines the fields of two objects, ordered objects stay ordered.
+func (t JoinTransformer) joinElements(left interface{}, right interface{}) interface{} {
+	joined, set := newObjectLike(left)
+	for _, key := range mapKeys(left) {
+		value, _ := mapValue(left, key)
+		set(key, value)
+	}
+	for _, key := range mapKeys(right) {
+		value, _ := mapValue(right, key)
+		if _, collides := mapValue(left, key); collides {
+			if len(t.RightPath) == 1 && t.RightPath.String() == key && t.LeftPath.String() == key {
+				continue
+			}
+			key = t.RightPrefix + key + t.RightSuffix
+		}
+		set(key, value)
+	}
+	return joined
+}
+
+// A transformer adding the position of each element of an array (the top-level
+// one or at Path) to the element under Key. Elements that are not objects are
+// left alone unless ValueKey is set, in which case they are wrapped in objects
+// with the value under ValueKey.
+//
+// With Strip, the transformation is reversed: Key is removed from the objects
+// and wrapped values (objects with no other key than ValueKey) are unwrapped.
+type IndexAnnotateTransformer struct {
+	Path     Path
+	Key      string
+	ValueKey string
+	Strip    bool
+}
+
+func (t IndexAnnotateTransformer) Transform(data interface{}) (interface{}, error) {
+	return updatePath(data, t.Path, func(value interface{}, at Path) (interface{}, error) {
+		elements, err := topLevelArray(value)
+		if err != nil {
+			return value, fmt.Errorf("cannot annotate %s: %s", describePath(at), err)
+		}
+		annotated := make([]interface{}, len(elements))
+		for n, element := range elements {
+			if t.Strip {
+				annotated[n] = t.stripElement(element)
+			} else if annotated[n], err = t.annotateElement(n, element); err != nil {
+				return value, fmt.Errorf("cannot annotate %s: %s", describePath(at.append(PathSegment{IsIndex: true, Index: n})), err)
+			}
+		}
+		return annotated, nil
+	})
+}
+
+// Returns a copy of the element with the index (first for ordered objects).
+func (t IndexAnnotateTransformer) annotateElement(index int, element interface{}) (interface{}, error) {
+	if !isObject(element) {
+		if t.ValueKey == "" {
+			return element, nil
+		}
+		element = map[string]interface{}{t.ValueKey: element}
+	} else if _, exists := mapValue(element, t.Key); exists {
+		return element, fmt.Errorf("the key '%s' already exists", t.Key)
+	}
+	annotated, set := newObjectLike(element)
+	set(t.Key, index)
+	for _, key := range mapKeys(element) {
+		value, _ := mapValue(element, key)
+		set(key, value)
+	}
+	return annotated, nil
+}
+
+func (t IndexAnnotateTransformer) stripElement(element interface{}) interface{} {
+	keys := mapKeys(element)
+	if _, exists := mapValue(element, t.Key); !exists {
+		return element
+	}
+	if value, wrapped := mapValue(element, t.ValueKey); wrapped && t.ValueKey != "" && len(keys) == 2 {
+		return value
+	}
+	stripped, set := newObjectLike(element)
+	for _, key := range keys {
+		if key != t.Key {
+			value, _ := mapValue(element, key)
+			set(key, value)
+		}
+	}
+	return stripped
+}
+
+// A transformer checking that the fields of records (the elements of a
+// top-level array) were parsed to the type of their column, e.g. after
+// parsing numbers in CSF input, to find dirty cells such as `n/a` in numeric
+// columns. The type of a column is the type of most of its values, ignoring
+// nulls and empty strings; it fails with all fields that are still strings
+// in columns of other types. Columns are the keys of objects or the
+// positions in arrays. Other data is left alone.
+type StrictParseTransformer struct{}
+
+func (t StrictParseTransformer) Transform(data interface{}) (interface{}, error) {
+	records, err := topLevelArray(data)
+	if err != nil {
+		return data, nil
+	}
+	var columns []string
+	types := make(map[string]map[string]int)
+	for _, record := range records {
+		t.fields(record, func(column string, value interface{}) {
+			if types[column] == nil {
+				columns = append(columns, column)
+				types[column] = make(map[string]int)
+			}
+			types[column][describeType(value)]++
+		})
+	}
+	expected := make(map[string]string, len(columns))
+	for _, column := range columns {
+		best, tie := 0, false
+		for kind, count := range types[column] {
+			if count > best {
+				expected[column], best, tie = kind, count, false
+			} else if count == best {
+				tie = true
+			}
+		}
+		if tie {
+			delete(expected, column)
+		}
+	}
+	var failures []string
+	for n, record := range records {
+		t.fields(record, func(column string, value interface{}) {
+			if kind := describeType(value); kind == "a string" && expected[column] != "" && kind != expected[column] {
+				failures = append(failures, fmt.Sprintf("record %d, column %s: %s is %s rather than %s",
+					n+1, column, describeValue(value), kind, expected[column]))
+			}
+		})
+	}
+	if len(failures) > 0 {
+		return data, fmt.Errorf("%d fields could not be parsed to the type of their column:\n%s",
+			len(failures), strings.Join(failures, "\n"))
+	}
+	return data, nil
+}
+
+// Calls the function with the scalar fields of a record other than nulls and
+// empty strings, with the column as 'key' or position (from 1).
+func (t StrictParseTransformer) fields(record interface{}, fn func(column string, value interface{})) {
+	field := func(column string, value interface{}) {
+		if s, ok := value.(string); isNil(value) || (ok && s == "") || isObject(value) || describeType(value) == "an array" {
+			return
+		}
+		fn(column, value)
+	}
+	if isObject(record) {
+		for _, key := range mapKeys(record) {
+			value, _ := mapValue(record, key)
+			field("'"+key+"'", value)
+		}
+	} else if elements, err := topLevelArray(record); err == nil {
+		for n, value := range elements {
+			field(strconv.Itoa(n+1), value)
+		}
+	}
+}
+
+// Creates an empty object (ordered if the template is) and a function setting
+// its keys.
+func newObjectLike(template interface{}) (interface{}, func(key string, value interface{})) {
+	if _, ok := template.(*OrderedMap); ok {
+		m := NewOrderedMap()
+		return m, m.Set
+	}
+	m := make(map[string]interface{})
+	return m, func(key string, value interface{}) { m[key] = value }
+}
+
+// Checks if the value is any kind of (non-nil) map.
+func isObject(value interface{}) bool {
+	return describeType(value) == "an object"
+}
+
+// Returns the elements of a top-level array as a slice.
+func topLevelArray(data interface{}) ([]interface{}, error) {
+	if elements, ok := data.([]interface{}); ok {
+		return elements, nil
+	}
+	value := reflect.ValueOf(data)
+	if isNil(data) || (value.Kind() != reflect.Slice && value.Kind() != reflect.Array) {
+		return nil, fmt.Errorf("expected a top-level array but found %s", describeType(data))
+	}
+	elements := make([]interface{}, value.Len())
+	for i := range elements {
+		elements[i] = value.Index(i).Interface()
+	}
+	return elements, nil
+}
+
+// Converts a value into a string key: strings are used as they are,
+// everything else is encoded as compact JSON.
+func groupKey(value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// Describes the (JSON) type of a value for messages.
+func describeType(value interface{}) string {
+	if isNil(value) {
+		return "null"
+	}
+	if _, ok := value.(*OrderedMap); ok {
+		return "an object"
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Map:
+		return "an object"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	default:
+		return fmt.Sprintf("a %T", value)
+	}
+}
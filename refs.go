@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A transformer replacing JSON References (objects with a `$ref` string, as
+// in OpenAPI and JSON Schema documents) by a copy of the value they point
+// to, resolved in turn. Fragments (`#/components/schemas/User`) are JSON
+// Pointers into the document. References to other files (`common.yaml` or
+// `common.yaml#/User`) are resolved relative to the directory of the file
+// containing them (Dir for the data) only if External is set, and left alone
+// otherwise, as are those with a URL scheme. Other keys of an object with a
+// `$ref` are dropped, as JSON References require.
+//
+// Circular references fail the transformation with the chain of references,
+// as do references nested more than MaxDepth levels deep if it is positive.
+// Load reads referenced files (by extension) if nil.
+type RefResolvingTransformer struct {
+	External bool
+	Dir      string
+	MaxDepth int
+	Load     func(file string) (interface{}, error)
+}
+
+// A document references are resolved in, the file is empty for the data.
+type refDocument struct {
+	root interface{}
+	file string
+	dir  string
+}
+
+type refResolver struct {
+	RefResolvingTransformer
+	files map[string]refDocument
+	// the references being resolved, to detect cycles
+	chain []string
+}
+
+func (t RefResolvingTransformer) Transform(data interface{}) (interface{}, error) {
+	resolver := &refResolver{RefResolvingTransformer: t, files: map[string]refDocument{}}
+	return resolver.resolve(data, refDocument{root: data, dir: t.Dir}, Path{})
+}
+
+func (r *refResolver) resolve(data interface{}, document refDocument, at Path) (interface{}, error) {
+	if keys := mapKeys(data); keys != nil {
+		if value, ok := mapValue(data, "$ref"); ok {
+			if ref, ok := value.(string); ok {
+				return r.resolveRef(data, ref, document, at)
+			}
+		}
+		object, set := newObjectLike(data)
+		for _, key := range keys {
+			value, _ := mapValue(data, key)
+			resolved, err := r.resolve(value, document, at.append(PathSegment{Key: key}))
+			if err != nil {
+				return data, err
+			}
+			set(key, resolved)
+		}
+		return object, nil
+	}
+	value := reflect.ValueOf(data)
+	if !isNil(data) && value.Kind() == reflect.Slice {
+		elements := make([]interface{}, value.Len())
+		for i := range elements {
+			resolved, err := r.resolve(value.Index(i).Interface(), document, at.append(PathSegment{IsIndex: true, Index: i}))
+			if err != nil {
+				return data, err
+			}
+			elements[i] = resolved
+		}
+		return elements, nil
+	}
+	return data, nil
+}
+
+func (r *refResolver) resolveRef(data interface{}, ref string, document refDocument, at Path) (interface{}, error) {
+	file, fragment := ref, ""
+	if n := strings.Index(ref, "#"); n >= 0 {
+		file, fragment = ref[:n], ref[n+1:]
+	}
+	if file != "" {
+		if !r.External || strings.Contains(file, "://") {
+			return data, nil
+		}
+		var err error
+		if document, err = r.file(file, document); err != nil {
+			return data, fmt.Errorf("the $ref '%s' at %s cannot be resolved: %s", ref, describePath(at), err)
+		}
+	}
+
+	id := document.file + "#" + fragment
+	for n, resolving := range r.chain {
+		if resolving == id {
+			return data, fmt.Errorf("circular $ref at %s: %s", describePath(at), strings.Join(append(r.chain[n:], id), " -> "))
+		}
+	}
+	if r.MaxDepth > 0 && len(r.chain) >= r.MaxDepth {
+		return data, fmt.Errorf("the $ref '%s' at %s is nested more than %d references deep", ref, describePath(at), r.MaxDepth)
+	}
+	target, err := resolvePointer(document.root, fragment)
+	if err != nil {
+		return data, fmt.Errorf("the $ref '%s' at %s cannot be resolved: %s", ref, describePath(at), err)
+	}
+	r.chain = append(r.chain, id)
+	resolved, err := r.resolve(target, document, at)
+	r.chain = r.chain[:len(r.chain)-1]
+	return resolved, err
+}
+
+// Returns the referenced file, relative to the directory of the document.
+func (r *refResolver) file(name string, document refDocument) (refDocument, error) {
+	name, err := url.PathUnescape(name)
+	if err != nil {
+		return refDocument{}, err
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(document.dir, name)
+	}
+	if name, err = filepath.Abs(name); err != nil {
+		return refDocument{}, err
+	}
+	if loaded, ok := r.files[name]; ok {
+		return loaded, nil
+	}
+	load := r.Load
+	if load == nil {
+		load = readReferencedFile
+	}
+	root, err := load(name)
+	if err != nil {
+		return refDocument{}, err
+	}
+	r.files[name] = refDocument{root: root, file: name, dir: filepath.Dir(name)}
+	return r.files[name], nil
+}
+
+func readReferencedFile(name string) (interface{}, error) {
+	format, err := NewInputFormat(name, autoFormat, ",", "NL")
+	if err != nil {
+		return nil, err
+	}
+	return ReadFile(name, format)
+}
+
+// Returns the value a JSON Pointer (RFC 6901) in a URI fragment points to,
+// e.g. `/components/schemas/User` (the data itself if empty).
+func resolvePointer(data interface{}, fragment string) (interface{}, error) {
+	pointer, err := url.PathUnescape(fragment)
+	if err != nil {
+		return nil, err
+	} else if pointer == "" {
+		return data, nil
+	} else if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("'%s' is not a JSON pointer", pointer)
+	}
+	value := data
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		if mapKeys(value) != nil {
+			var ok bool
+			if value, ok = mapValue(value, token); !ok {
+				return nil, fmt.Errorf("there is no key '%s'", token)
+			}
+			continue
+		}
+		elements := reflect.ValueOf(value)
+		if isNil(value) || elements.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("'%s' is the key of %s", token, describeType(value))
+		}
+		index, err := strconv.Atoi(token)
+		if err != nil || index < 0 || index >= elements.Len() || (token != "0" && strings.HasPrefix(token, "0")) {
+			return nil, fmt.Errorf("'%s' is not an index of the array of %d elements", token, elements.Len())
+		}
+		value = elements.Index(index).Interface()
+	}
+	return value, nil
+}
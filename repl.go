@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ANSI colors of JSON tokens in the REPL.
+const (
+	replColorKey     = "\x1b[34m"
+	replColorString  = "\x1b[32m"
+	replColorNumber  = "\x1b[36m"
+	replColorLiteral = "\x1b[33m"
+	replColorReset   = "\x1b[0m"
+)
+
+const replHelp = `PATH              print the value at the path (wildcards print all matches)
+keys [PATH]       print the keys of an object
+length [PATH]     print the number of keys, elements or characters
+type [PATH]       print the type of the value
+set PATH VALUE    set the value (read as YAML, e.g. 1, "a b" or {a: 1}), or all matches
+write FILE[:FMT]  write the document to a file
+history           print the previous commands (!N repeats command N)
+help              print this help
+quit              leave (as does the end of the input)
+`
+
+// An interactive session on a document, which is read once and then
+// navigated with the path syntax (see Path) and modified in place. Values
+// are printed as indented JSON, colorized if Color is set. Write writes the
+// document to the target of a write command.
+type REPL struct {
+	Data    interface{}
+	Color   bool
+	Prompt  string
+	Write   func(target string, data interface{}) error
+	History []string
+}
+
+// Runs commands read from the input line by line until the input ends or a
+// quit command. Errors of commands are printed and do not end the session.
+func (r *REPL) Run(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for {
+		if _, err := io.WriteString(out, r.Prompt); err != nil {
+			return err
+		}
+		if !scanner.Scan() {
+			if r.Prompt != "" {
+				_, _ = io.WriteString(out, "\n")
+			}
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "!") {
+			n, err := strconv.Atoi(line[1:])
+			if err != nil || n < 1 || n > len(r.History) {
+				fmt.Fprintf(out, "error: there is no command %s in the history\n", line)
+				continue
+			}
+			line = r.History[n-1]
+			fmt.Fprintln(out, line)
+		}
+		if line == "" {
+			continue
+		}
+		r.History = append(r.History, line)
+		if line == "quit" || line == "exit" {
+			return nil
+		}
+		if err := r.Execute(line, out); err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+		}
+	}
+}
+
+// Executes a single command.
+func (r *REPL) Execute(line string, out io.Writer) error {
+	command, argument := line, ""
+	if i := strings.IndexAny(line, " \t"); i > 0 {
+		command, argument = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch command {
+	case "help":
+		_, err := io.WriteString(out, replHelp)
+		return err
+	case "history":
+		for n, previous := range r.History {
+			fmt.Fprintf(out, "%4d  %s\n", n+1, previous)
+		}
+		return nil
+	case "keys", "length", "type":
+		value, err := r.lookup(argument)
+		if err != nil {
+			return err
+		}
+		return r.describe(command, value, out)
+	case "get":
+		return r.print(argument, out)
+	case "set":
+		return r.set(argument, out)
+	case "write":
+		if argument == "" {
+			return fmt.Errorf("write requires a file")
+		} else if r.Write == nil {
+			return fmt.Errorf("the document cannot be written")
+		}
+		if err := r.Write(argument, r.Data); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "wrote %s\n", argument)
+		return nil
+	}
+	return r.print(line, out)
+}
+
+// Parses a path, where `.` (like the empty path) is the document itself.
+func replPath(path string) (Path, error) {
+	if path == "" || path == "." {
+		return Path{}, nil
+	}
+	return ParsePath(strings.TrimPrefix(path, "."))
+}
+
+func (r *REPL) lookup(argument string) (interface{}, error) {
+	path, err := replPath(argument)
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range path {
+		if segment.Wildcard {
+			return nil, fmt.Errorf("the path must not contain wildcards")
+		}
+	}
+	value, ok := lookupPath(r.Data, path)
+	if !ok {
+		return nil, fmt.Errorf("there is no value at %s", describePath(path))
+	}
+	return value, nil
+}
+
+// Prints the value at the path, or an array of the values matching a path
+// with wildcards.
+func (r *REPL) print(argument string, out io.Writer) error {
+	path, err := replPath(argument)
+	if err != nil {
+		return err
+	}
+	wildcards := false
+	for _, segment := range path {
+		wildcards = wildcards || segment.Wildcard
+	}
+	if !wildcards {
+		value, ok := lookupPath(r.Data, path)
+		if !ok {
+			return fmt.Errorf("there is no value at %s", describePath(path))
+		}
+		return r.printValue(value, out)
+	}
+	matches := []interface{}{}
+	matchPath(r.Data, path, func(value interface{}, at Path) {
+		matches = append(matches, value)
+	})
+	return r.printValue(matches, out)
+}
+
+func (r *REPL) describe(command string, value interface{}, out io.Writer) error {
+	var result interface{}
+	switch {
+	case command == "type" && isObject(value):
+		result = "object"
+	case command == "type" && describeType(value) == "an array":
+		result = "array"
+	case command == "type":
+		result = scalarType(value)
+	case command == "keys" && isObject(value):
+		result = mapKeys(value)
+	case command == "keys":
+		return fmt.Errorf("%s has no keys", describeType(value))
+	case isObject(value):
+		result = len(mapKeys(value))
+	case describeType(value) == "an array":
+		elements, _ := topLevelArray(value)
+		result = len(elements)
+	default:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s has no length", describeType(value))
+		}
+		result = utf8.RuneCountInString(s)
+	}
+	return r.printValue(result, out)
+}
+
+// Sets the value at the path or, for paths with wildcards, replaces all
+// values matching it (which must be at least one) and prints their number.
+func (r *REPL) set(argument string, out io.Writer) error {
+	i := strings.IndexAny(argument, " \t")
+	if i < 0 {
+		return fmt.Errorf("set requires a path and a value")
+	}
+	path, err := replPath(argument[:i])
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if err = yaml.Unmarshal([]byte(strings.TrimSpace(argument[i+1:])), &value); err != nil {
+		return fmt.Errorf("the value is not valid YAML: %s", err)
+	}
+	if !path.hasWildcards() {
+		r.Data, err = setPath(r.Data, path, value)
+		return err
+	}
+	matches := 0
+	r.Data, err = updatePath(r.Data, path, func(interface{}, Path) (interface{}, error) {
+		matches++
+		return copyData(value), nil
+	})
+	if err != nil {
+		return err
+	} else if matches == 0 {
+		return fmt.Errorf("no value matches %s", describePath(path))
+	}
+	fmt.Fprintf(out, "set %d values\n", matches)
+	return nil
+}
+
+func (r *REPL) printValue(value interface{}, out io.Writer) error {
+	buffer := &bytes.Buffer{}
+	if err := (JSONFormat{PrettyPrint: true, NonFinite: NonFiniteString}).Marshal(value, buffer); err != nil {
+		return err
+	}
+	encoded := bytes.TrimRight(buffer.Bytes(), "\n")
+	if r.Color {
+		encoded = colorizeJSON(encoded)
+	}
+	_, err := out.Write(append(encoded, '\n'))
+	return err
+}
+
+// Highlights the keys, strings, numbers and literals of JSON text with ANSI
+// colors.
+func colorizeJSON(encoded []byte) []byte {
+	var b bytes.Buffer
+	for i := 0; i < len(encoded); {
+		c := encoded[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(encoded) && encoded[end] != '"' {
+				if encoded[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end++
+			if end > len(encoded) {
+				end = len(encoded)
+			}
+			color := replColorString
+			if next := bytes.TrimLeft(encoded[end:], " \t\r\n"); len(next) > 0 && next[0] == ':' {
+				color = replColorKey
+			}
+			b.WriteString(color)
+			b.Write(encoded[i:end])
+			b.WriteString(replColorReset)
+			i = end
+		case c == '-' || (c >= '0' && c <= '9') || c == 't' || c == 'f' || c == 'n':
+			end := i
+			for end < len(encoded) && !strings.ContainsRune(",]} \t\r\n", rune(encoded[end])) {
+				end++
+			}
+			color := replColorNumber
+			if c == 't' || c == 'f' || c == 'n' {
+				color = replColorLiteral
+			}
+			b.WriteString(color)
+			b.Write(encoded[i:end])
+			b.WriteString(replColorReset)
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+const (
+	schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+	schemaTypeNull    = "null"
+	schemaTypeBoolean = "boolean"
+	schemaTypeInteger = "integer"
+	schemaTypeNumber  = "number"
+	schemaTypeString  = "string"
+	schemaTypeArray   = "array"
+	schemaTypeObject  = "object"
+)
+
+// A transformer replacing the data with a (draft 2020-12) JSON Schema inferred from it.
+//
+// Keys are required if present in all objects observed at the same position,
+// differing types are combined into a type union. String values are turned
+// into an enum if there are at most EnumThreshold distinct values (0 disables
+// enum detection).
+type SchemaInferenceTransformer struct {
+	EnumThreshold int
+}
+
+func (t SchemaInferenceTransformer) Transform(data interface{}) (interface{}, error) {
+	root := newSchemaNode()
+	root.observe(data, t.EnumThreshold)
+	schema := root.schema(t.EnumThreshold)
+	schema["$schema"] = schemaDialect
+	return schema, nil
+}
+
+// The accumulated type information for all values observed at one position.
+type schemaNode struct {
+	count      int
+	objects    int
+	types      map[string]bool
+	properties map[string]*schemaNode
+	items      *schemaNode
+	strings    map[string]bool // nil once the enum threshold is exceeded
+}
+
+func newSchemaNode() *schemaNode {
+	return &schemaNode{
+		types:      make(map[string]bool),
+		properties: make(map[string]*schemaNode),
+		strings:    make(map[string]bool),
+	}
+}
+
+func (n *schemaNode) observe(value interface{}, enumThreshold int) {
+	n.count++
+	if isNil(value) {
+		n.types[schemaTypeNull] = true
+		return
+	}
+	if m, ok := value.(*OrderedMap); ok {
+		n.types[schemaTypeObject] = true
+		n.objects++
+		for _, key := range m.Keys() {
+			v, _ := m.Get(key)
+			n.property(key).observe(v, enumThreshold)
+		}
+		return
+	}
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Bool:
+		n.types[schemaTypeBoolean] = true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n.types[schemaTypeInteger] = true
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f == math.Trunc(f) && !math.IsInf(f, 0) {
+			n.types[schemaTypeInteger] = true
+		} else {
+			n.types[schemaTypeNumber] = true
+		}
+	case reflect.String:
+		n.types[schemaTypeString] = true
+		if n.strings != nil {
+			n.strings[v.String()] = true
+			if len(n.strings) > enumThreshold {
+				n.strings = nil
+			}
+		}
+	case reflect.Map:
+		n.types[schemaTypeObject] = true
+		n.objects++
+		for _, k := range v.MapKeys() {
+			n.property(fmt.Sprint(k.Interface())).observe(v.MapIndex(k).Interface(), enumThreshold)
+		}
+	case reflect.Slice, reflect.Array:
+		n.types[schemaTypeArray] = true
+		if n.items == nil {
+			n.items = newSchemaNode()
+		}
+		for i := 0; i < v.Len(); i++ {
+			n.items.observe(v.Index(i).Interface(), enumThreshold)
+		}
+	default:
+		// dates and other scalars end up as strings in JSON-like outputs
+		n.types[schemaTypeString] = true
+		n.strings = nil
+	}
+}
+
+// Returns the node of an object property, creating it if necessary.
+func (n *schemaNode) property(key string) *schemaNode {
+	property, ok := n.properties[key]
+	if !ok {
+		property = newSchemaNode()
+		n.properties[key] = property
+	}
+	return property
+}
+
+// Creates the schema for the node. Nodes that never observed a value accept anything.
+func (n *schemaNode) schema(enumThreshold int) map[string]interface{} {
+	schema := make(map[string]interface{})
+	if n.types[schemaTypeNumber] {
+		delete(n.types, schemaTypeInteger) // integers are numbers
+	}
+	types := make([]string, 0, len(n.types))
+	for t := range n.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	switch len(types) {
+	case 0:
+		return schema
+	case 1:
+		schema["type"] = types[0]
+	default:
+		schema["type"] = types
+	}
+
+	if n.types[schemaTypeObject] {
+		properties := make(map[string]interface{}, len(n.properties))
+		required := make([]string, 0, len(n.properties))
+		for key, property := range n.properties {
+			properties[key] = property.schema(enumThreshold)
+			if property.count == n.objects {
+				required = append(required, key)
+			}
+		}
+		sort.Strings(required)
+		if len(properties) > 0 {
+			schema["properties"] = properties
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+
+	if n.items != nil && n.items.count > 0 {
+		schema["items"] = n.items.schema(enumThreshold)
+	}
+
+	if enumThreshold > 0 && len(types) == 1 && types[0] == schemaTypeString && n.strings != nil {
+		enum := make([]string, 0, len(n.strings))
+		for s := range n.strings {
+			enum = append(enum, s)
+		}
+		sort.Strings(enum)
+		schema["enum"] = enum
+	}
+	return schema
+}
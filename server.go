@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Media types of formats for requests and responses, the first one is used
+// for responses.
+var formatMediaTypes = map[string][]string{
+	formatNameJSON:    {"application/json"},
+	formatNameYAML:    {"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"},
+	formatNameTOML:    {"application/toml"},
+	formatNameNDJSON:  {"application/x-ndjson", "application/jsonl", "application/json-seq"},
+	formatNameCSF:     {"text/csv"},
+	formatNameStrings: {"text/plain"},
+	formatNameINI:     {"text/x-ini"},
+	formatNameEnv:     {"text/x-env"},
+	formatNameShell:   {"text/x-shellscript", "application/x-sh"},
+	formatNameFixed:   {"text/x-fixed-width"},
+}
+
+// Returns the media type of responses in the format, text/plain for
+// formats without one.
+func mediaTypeFor(formatName string) string {
+	if mediaTypes := formatMediaTypes[formatName]; len(mediaTypes) > 0 {
+		return mediaTypes[0]
+	}
+	return "text/plain"
+}
+
+// Transformers available to the server by name, configured from the query
+// parameters that mirror the options of the corresponding subcommands.
+var serverTransformers = map[string]func(query url.Values) (Transformer, error){
+	"remove-nulls": func(query url.Values) (Transformer, error) {
+		return NilRemovalTransformer{
+			RemoveNilValues:   queryFlag(query, "values"),
+			RemoveNilElements: queryFlag(query, "elements"),
+		}, nil
+	},
+	"remove-zero": func(query url.Values) (Transformer, error) {
+		kinds, err := ParseZeroKinds(queryString(query, "zero-kinds", strings.Join(zeroKinds, ",")))
+		return RemoveZeroValuesTransformer{Kinds: kinds}, err
+	},
+	"url-encode": func(query url.Values) (Transformer, error) {
+		return queryScopedTransformer(query, URLEncodeTransformer{PathEscaping: queryFlag(query, "path-escaping")})
+	},
+	"url-decode": func(query url.Values) (Transformer, error) {
+		return queryScopedTransformer(query, URLDecodeTransformer{
+			PathEscaping: queryFlag(query, "path-escaping"),
+			Lenient:      queryFlag(query, "lenient"),
+		})
+	},
+	"ensure-array": func(query url.Values) (Transformer, error) {
+		paths, err := ParsePaths(query.Get("paths"))
+		return EnsureArrayTransformer{Paths: paths}, err
+	},
+	"entries": func(query url.Values) (Transformer, error) {
+		return queryScopedTransformer(query, EntriesTransformer{
+			KeyField:   query.Get("key-field"),
+			ValueField: query.Get("value-field"),
+		})
+	},
+	"from-entries": func(query url.Values) (Transformer, error) {
+		return queryScopedTransformer(query, FromEntriesTransformer{
+			KeyField:   query.Get("key-field"),
+			ValueField: query.Get("value-field"),
+		})
+	},
+	"mask-numbers": func(query url.Values) (Transformer, error) {
+		options := []float64{1, 1, 0}
+		for n, name := range []string{"granularity", "noise", "seed"} {
+			var err error
+			if query.Get(name) == "" {
+				continue
+			} else if options[n], err = strconv.ParseFloat(query.Get(name), 64); err != nil {
+				return nil, fmt.Errorf("invalid %s '%s'", name, query.Get(name))
+			}
+		}
+		return queryScopedTransformer(query, MaskNumbersTransformer{
+			Mode:        query.Get("mode"),
+			Granularity: options[0],
+			Noise:       options[1],
+			Seed:        int64(options[2]),
+		})
+	},
+	"enforce-types": func(query url.Values) (Transformer, error) {
+		allowed, err := ParseValueTypes(query.Get("allow"))
+		return TypeWhitelistTransformer{Allowed: allowed}, err
+	},
+}
+
+// An HTTP handler converting request bodies, e.g.
+// `POST /convert?from=yaml&to=json&pretty=1`.
+//
+// Formats default to the request's Content-Type and Accept headers.
+// Transformers are selected with `transform` (comma-separated names,
+// applied in order) and configured like on the command line. Errors result
+// in a JSON object with an `error` message and the status 400 (request or
+// input), 413 (body too large), 422 (transformation), 503 (too many
+// concurrent requests) or 500 (output).
+type ConversionServer struct {
+	MaxBodySize int64
+	slots       chan struct{}
+}
+
+func NewConversionServer(maxBodySize int64, maxConcurrent int) *ConversionServer {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &ConversionServer{
+		MaxBodySize: maxBodySize,
+		slots:       make(chan struct{}, maxConcurrent),
+	}
+}
+
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e httpError) Error() string {
+	return e.message
+}
+
+func newHTTPError(status int, err error) error {
+	return httpError{status: status, message: err.Error()}
+}
+
+// Errors of a specific phase of the conversion mapped to a status code.
+type statusUnmarshaler struct {
+	Unmarshaler
+	status int
+}
+
+func (u statusUnmarshaler) Unmarshal(reader io.Reader) (interface{}, error) {
+	data, err := u.Unmarshaler.Unmarshal(reader)
+	if err != nil {
+		return nil, newHTTPError(u.status, err)
+	}
+	return data, nil
+}
+
+type statusTransformer struct {
+	Transformer
+	status int
+}
+
+func (t statusTransformer) Transform(data interface{}) (interface{}, error) {
+	transformed, err := t.Transformer.Transform(data)
+	if err != nil {
+		return nil, newHTTPError(t.status, err)
+	}
+	return transformed, nil
+}
+
+func (s *ConversionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/convert" {
+		writeHTTPError(w, httpError{http.StatusNotFound, "not found"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, httpError{http.StatusMethodNotAllowed, "only POST is supported"})
+		return
+	}
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		writeHTTPError(w, httpError{http.StatusServiceUnavailable, "too many concurrent requests"})
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, s.MaxBodySize+1))
+	if err != nil {
+		writeHTTPError(w, newHTTPError(http.StatusBadRequest, err))
+		return
+	} else if int64(len(body)) > s.MaxBodySize {
+		writeHTTPError(w, httpError{http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("the request body exceeds %d bytes", s.MaxBodySize)})
+		return
+	}
+
+	inputFormat, transformer, outputFormat, err := configureServerConversion(r)
+	if err != nil {
+		writeHTTPError(w, newHTTPError(http.StatusBadRequest, err))
+		return
+	}
+	output := &bytes.Buffer{}
+	err = ConvertStream(bytes.NewReader(body),
+		statusUnmarshaler{inputFormat, http.StatusBadRequest},
+		statusTransformer{transformer, http.StatusUnprocessableEntity},
+		output, outputFormat)
+	if err != nil {
+		if _, ok := err.(httpError); !ok {
+			err = newHTTPError(http.StatusInternalServerError, err)
+		}
+		writeHTTPError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", mediaTypeFor(outputFormat.Name()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(output.Bytes())
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(httpError); ok {
+		status = e.status
+	}
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Creates the formats and transformers for a request.
+func configureServerConversion(r *http.Request) (InputFormat, Transformer, OutputFormat, error) {
+	query := r.URL.Query()
+
+	from := query.Get("from")
+	if from == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		from = formatForMediaType(mediaType)
+	}
+	if from == "" {
+		return nil, nil, nil, fmt.Errorf("the input format is required (from or Content-Type)")
+	}
+	fieldDelim := queryString(query, "field-delimiter", ",")
+	recordDelim := queryString(query, "record-delimiter", "NL")
+	inputFormat, err := NewInputFormat("", from, fieldDelim, recordDelim)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	skipRows, err := strconv.Atoi(queryString(query, "skip-rows", "0"))
+	if err != nil || skipRows < 0 {
+		return nil, nil, nil, fmt.Errorf("invalid number of rows to skip")
+	}
+	policy := strings.ToLower(query.Get("dup-keys"))
+	if policy != DuplicateKeysDefault && !containsFold(policy, duplicateKeyPolicies) {
+		return nil, nil, nil, fmt.Errorf("unknown duplicate key policy '%s'", policy)
+	}
+	switch format := inputFormat.(type) {
+	case TextFormat:
+		format.SkipRows, format.Header, format.DuplicateKeys = skipRows, queryFlag(query, "header"), policy
+		inputFormat = format
+	case JSONFormat:
+		format.DuplicateKeys = policy
+		inputFormat = format
+	case NDJSONFormat:
+		format.DuplicateKeys = policy
+		inputFormat = format
+	case INIFormat:
+		valueDelim, err := NormalizeDelim(query.Get("ini-value-delimiter"))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		format.DuplicateKeys, format.ValueDelimiter = policy, valueDelim
+		format.KeepEmptyDefault = queryFlag(query, "keep-empty-default")
+		inputFormat = format
+	case EnvFormat:
+		return nil, nil, nil, fmt.Errorf("%s input is not supported by the server", formatNameEnv)
+	}
+
+	to := query.Get("to")
+	if to == "" {
+		to = acceptedFormat(r.Header.Get("Accept"))
+	}
+	outputFormat, err := NewOutputFormat("", to, queryFlag(query, "pretty"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	arrayStyle := strings.ToLower(queryString(query, "toml-arrays", TOMLArraysAuto))
+	if !containsFold(arrayStyle, tomlArrayStyles) {
+		return nil, nil, nil, fmt.Errorf("unknown TOML array style '%s'", arrayStyle)
+	}
+	nonFinite := strings.ToLower(queryString(query, "nonfinite", NonFiniteError))
+	if !containsFold(nonFinite, nonFinitePolicies) {
+		return nil, nil, nil, fmt.Errorf("unknown policy for non-finite numbers '%s'", nonFinite)
+	}
+	switch format := outputFormat.(type) {
+	case YAMLFormat:
+		format.MultiDocument = queryFlag(query, "multidoc")
+		format.ExplicitStart, format.NullStyle = queryFlag(query, "yaml-doc-start"), query.Get("yaml-null-style")
+		format.QuoteStrings = queryFlag(query, "yaml-quote-strings")
+		outputFormat = format
+	case TOMLFormat:
+		format.ArrayStyle, format.DefaultKey = arrayStyle, query.Get("default-key")
+		outputFormat = format
+	case JSONFormat:
+		format.NonFinite, format.ASCIIOnly = nonFinite, queryFlag(query, "ascii")
+		outputFormat = format
+	case NDJSONFormat:
+		format.NonFinite, format.ASCIIOnly = nonFinite, queryFlag(query, "ascii")
+		outputFormat = format
+	case INIFormat:
+		format.NestingPolicy, format.ArrayStyle = query.Get("ini-nesting"), query.Get("ini-arrays")
+		format.DefaultKey = query.Get("default-key")
+		outputFormat = format
+	}
+
+	transformers := []Transformer{NopTransformer{}}
+	if queryFlag(query, "coerce-bools") {
+		trueStrings, falseStrings := DefaultTrueStrings, DefaultFalseStrings
+		if list := query.Get("true-strings"); list != "" {
+			trueStrings = strings.Split(list, ",")
+		}
+		if list := query.Get("false-strings"); list != "" {
+			falseStrings = strings.Split(list, ",")
+		}
+		parser, err := StringToBoolParser(trueStrings, falseStrings)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		transformers = append(transformers, NewConfigurableTransformer(parser, nil, nil, nil, nil))
+	}
+	if queryFlag(query, "parse-to-finite-64b-number") {
+		transformers = append(transformers, NewConfigurableTransformer(StringToFiniteNumberParser, nil, nil, nil, nil))
+	}
+	if names := query.Get("transform"); names != "" {
+		for _, name := range strings.Split(names, ",") {
+			create, ok := serverTransformers[strings.TrimSpace(name)]
+			if !ok {
+				return nil, nil, nil, fmt.Errorf("unknown transformer '%s'", name)
+			}
+			transformer, err := create(query)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			transformers = append(transformers, transformer)
+		}
+	}
+	return inputFormat, NewMultiTransformer(transformers...), outputFormat, nil
+}
+
+func serverTransformerNames() []string {
+	names := make([]string, 0, len(serverTransformers))
+	for name := range serverTransformers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func formatForMediaType(mediaType string) string {
+	for name, mediaTypes := range formatMediaTypes {
+		if containsFold(mediaType, mediaTypes) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Returns the first output format in an Accept header, JSON if there is none.
+func acceptedFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(part))
+		if name := formatForMediaType(mediaType); name != "" && containsFold(name, outputFormats) {
+			return name
+		}
+	}
+	return formatNameJSON
+}
+
+func queryString(query url.Values, name string, defaultValue string) string {
+	if value := query.Get(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// Checks a boolean parameter, which is set if present without a value.
+func queryFlag(query url.Values, name string) bool {
+	values, ok := query[name]
+	if !ok {
+		return false
+	}
+	value, err := strconv.ParseBool(values[0])
+	return values[0] == "" || (err == nil && value)
+}
+
+func queryScopedTransformer(query url.Values, transformer Transformer) (Transformer, error) {
+	if query.Get("paths") == "" {
+		return transformer, nil
+	}
+	paths, err := ParsePaths(query.Get("paths"))
+	return PathScopedTransformer{Paths: paths, Transformer: transformer}, err
+}
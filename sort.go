@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Collations deciding the order of strings for the sort transformers. Byte
+// order is the default since it does not depend on the environment.
+const (
+	CollationBytes   = "bytes"
+	CollationFold    = "fold"
+	CollationNatural = "natural"
+)
+
+// The less functions of the collations by name.
+var collations = map[string]func(a, b string) bool{
+	CollationBytes: func(a, b string) bool {
+		return a < b
+	},
+	// case-insensitive (by Unicode case folding), ties in byte order
+	CollationFold: func(a, b string) bool {
+		if fa, fb := foldCase(a), foldCase(b); fa != fb {
+			return fa < fb
+		}
+		return a < b
+	},
+	// runs of digits by their numeric value, otherwise like fold
+	CollationNatural: func(a, b string) bool {
+		if c := compareNatural(foldCase(a), foldCase(b)); c != 0 {
+			return c < 0
+		}
+		return a < b
+	},
+}
+
+// Matches language tags like `de`, `de_DE` or `zh-Hant-TW` given as a
+// collation.
+var localeTag = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+
+func collationNames() []string {
+	names := make([]string, 0, len(collations))
+	for name := range collations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the less function of the named collation (byte order if empty).
+func NewCollation(name string) (func(a, b string) bool, error) {
+	if name == "" {
+		name = CollationBytes
+	}
+	less, ok := collations[strings.ToLower(name)]
+	if !ok && localeTag.MatchString(name) {
+		return nil, fmt.Errorf("locale-aware collations such as '%s' are not supported (expected one of %s)",
+			name, strings.Join(collationNames(), ", "))
+	} else if !ok {
+		return nil, fmt.Errorf("unknown collation '%s' (expected one of %s)", name, strings.Join(collationNames(), ", "))
+	}
+	return less, nil
+}
+
+// Compares strings with runs of ASCII digits compared by their numeric value,
+// e.g. `item2` before `item10`. Leading zeros only decide between otherwise
+// equal strings.
+func compareNatural(a, b string) int {
+	zeros := 0
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da == "" || db == "" {
+			ra, sa := utf8.DecodeRuneInString(a)
+			rb, sb := utf8.DecodeRuneInString(b)
+			if ra != rb {
+				if ra < rb {
+					return -1
+				}
+				return 1
+			}
+			a, b = a[sa:], b[sb:]
+			continue
+		}
+		na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+		switch {
+		case len(na) != len(nb):
+			return compareInts(len(na), len(nb))
+		case na != nb:
+			return strings.Compare(na, nb)
+		case zeros == 0:
+			zeros = compareInts(len(da), len(db))
+		}
+		a, b = a[len(da):], b[len(db):]
+	}
+	if c := compareInts(len(a), len(b)); c != 0 {
+		return c
+	}
+	return zeros
+}
+
+func digitPrefix(s string) string {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	return s[:end]
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Maps each rune to the smallest rune of its case folding orbit, so that
+// all case variants of a string are equal.
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		smallest := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < smallest {
+				smallest = f
+			}
+		}
+		return smallest
+	}, s)
+}
+
+// A transformer replacing all objects by ordered objects with their keys
+// sorted by Less (byte order if nil), recursively.
+type SortKeysTransformer struct {
+	Less func(a, b string) bool
+}
+
+func (t SortKeysTransformer) Transform(data interface{}) (interface{}, error) {
+	less := t.Less
+	if less == nil {
+		less = collations[CollationBytes]
+	}
+	return sortKeys(data, less), nil
+}
+
+func sortKeys(data interface{}, less func(a, b string) bool) interface{} {
+	if keys := mapKeys(data); keys != nil {
+		sort.SliceStable(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+		sorted := NewOrderedMap()
+		for _, key := range keys {
+			value, _ := mapValue(data, key)
+			sorted.Set(key, sortKeys(value, less))
+		}
+		return sorted
+	}
+	if elements, err := topLevelArray(data); err == nil {
+		for n, element := range elements {
+			elements[n] = sortKeys(element, less)
+		}
+		return elements
+	}
+	return data
+}
+
+// A transformer sorting the elements of all arrays, recursively: nulls
+// first, then booleans, numbers, strings (ordered by Less, byte order if nil),
+// datetimes and, in their original order, objects and arrays.
+type SortArraysTransformer struct {
+	Less func(a, b string) bool
+}
+
+func (t SortArraysTransformer) Transform(data interface{}) (interface{}, error) {
+	less := t.Less
+	if less == nil {
+		less = collations[CollationBytes]
+	}
+	return sortArrays(data, less)
+}
+
+func sortArrays(data interface{}, less func(a, b string) bool) (interface{}, error) {
+	if keys := mapKeys(data); keys != nil {
+		for _, key := range keys {
+			value, _ := mapValue(data, key)
+			sorted, err := sortArrays(value, less)
+			if err != nil {
+				return data, err
+			}
+			if err = setMapValue(data, key, sorted); err != nil {
+				return data, err
+			}
+		}
+		return data, nil
+	}
+	elements, err := topLevelArray(data)
+	if err != nil {
+		return data, nil
+	}
+	for n, element := range elements {
+		if elements[n], err = sortArrays(element, less); err != nil {
+			return data, err
+		}
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		return lessValue(elements[i], elements[j], less)
+	})
+	return elements, nil
+}
+
+// Ranks of values of different types in sorted arrays.
+func valueRank(value interface{}) int {
+	switch scalarType(value) {
+	case valueTypeNull:
+		return 0
+	case valueTypeBool:
+		return 1
+	case valueTypeInt, valueTypeFloat:
+		return 2
+	case valueTypeString:
+		return 3
+	case valueTypeDateTime:
+		return 4
+	}
+	return 5
+}
+
+func lessValue(a interface{}, b interface{}, less func(a, b string) bool) bool {
+	ra, rb := valueRank(a), valueRank(b)
+	if ra != rb {
+		return ra < rb
+	}
+	switch ra {
+	case 1:
+		return !reflect.ValueOf(a).Bool() && reflect.ValueOf(b).Bool()
+	case 2:
+		return numberValue(a) < numberValue(b)
+	case 3:
+		return less(reflect.ValueOf(a).String(), reflect.ValueOf(b).String())
+	case 4:
+		return a.(time.Time).Before(b.(time.Time))
+	}
+	return false
+}
+
+// Returns the value of any kind of number as a float.
+func numberValue(value interface{}) float64 {
+	if number, ok := value.(json.Number); ok {
+		f, _ := number.Float64()
+		return f
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A stage of a conversion as reported to a StageHook: reading (including
+// unmarshalling) the input, each transformer of the pipeline, and writing
+// (marshalling) the output. AllocatedBytes counts the bytes allocated by
+// the whole process during the stage, so it is an estimate only. Data is
+// the data read or transformed by the stage (and nil for writing, since
+// formats may change the data while marshalling it).
+type ConversionStage struct {
+	Name           string
+	Duration       time.Duration
+	AllocatedBytes uint64
+	Data           interface{}
+}
+
+// A function called after each stage of a conversion (even if it failed).
+type StageHook func(stage ConversionStage)
+
+// Runs the function as a stage reported to the hooks (if any), it returns
+// the data of the stage (see ConversionStage).
+func measureStage(name string, hooks []StageHook, fn func() (interface{}, error)) error {
+	if len(hooks) == 0 {
+		_, err := fn()
+		return err
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	data, err := fn()
+	duration := time.Since(start)
+	runtime.ReadMemStats(&after)
+	stage := ConversionStage{Name: name, Duration: duration, AllocatedBytes: after.TotalAlloc - before.TotalAlloc, Data: data}
+	for _, hook := range hooks {
+		hook(stage)
+	}
+	return err
+}
+
+// Applies the transformer, with each transformer of a pipeline (including
+// nested ones) as a stage of its own.
+func transformStages(data interface{}, transformer Transformer, hooks []StageHook) (interface{}, error) {
+	if transformer == nil {
+		return data, nil
+	} else if len(hooks) == 0 {
+		return transformer.Transform(data)
+	}
+	for n, t := range pipelineTransformers(transformer) {
+		err := measureStage(fmt.Sprintf("transform %d: %s", n+1, transformerName(t)), hooks, func() (_ interface{}, err error) {
+			data, err = t.Transform(data)
+			return data, err
+		})
+		if err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// Returns the transformers applied by the transformer in order, without
+// pipelines and transformers that do nothing.
+func pipelineTransformers(transformer Transformer) []Transformer {
+	switch t := transformer.(type) {
+	case nil, NopTransformer:
+		return nil
+	case TransformerPipeline:
+		var transformers []Transformer
+		for _, element := range t.Transformers {
+			transformers = append(transformers, pipelineTransformers(element)...)
+		}
+		return transformers
+	}
+	return []Transformer{transformer}
+}
+
+// Returns the type name of the transformer (with that of the transformer
+// it scopes to paths).
+func transformerName(transformer Transformer) string {
+	name := reflect.TypeOf(transformer).String()
+	name = name[strings.LastIndex(name, ".")+1:]
+	if scoped, ok := transformer.(PathScopedTransformer); ok && scoped.Transformer != nil {
+		name += "(" + transformerName(scoped.Transformer) + ")"
+	}
+	return name
+}
+
+// Collects the stages of conversions (see StageHook) for --timings.
+type stageTimings struct {
+	Stages []ConversionStage
+}
+
+func (t *stageTimings) Record(stage ConversionStage) {
+	t.Stages = append(t.Stages, stage)
+}
+
+// Writes the stages as a table with their share of the total time.
+func (t *stageTimings) Write(w io.Writer) error {
+	var total ConversionStage
+	for _, stage := range t.Stages {
+		total.Duration += stage.Duration
+		total.AllocatedBytes += stage.AllocatedBytes
+	}
+	total.Name = "total"
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "stage\ttime\tshare\tallocated")
+	for _, stage := range append(t.Stages, total) {
+		share := 100.0
+		if total.Duration > 0 {
+			share = 100 * float64(stage.Duration) / float64(total.Duration)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%.1f%%\t%s\n",
+			stage.Name, stage.Duration.Round(time.Microsecond), share, formatBytes(stage.AllocatedBytes))
+	}
+	return table.Flush()
+}
+
+// Formats a number of bytes with a binary unit, e.g. `1.5 MiB`.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, prefix := float64(n)/unit, 0
+	for value >= unit && prefix < 4 {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[prefix])
+}
+
+// Writes the data after reading it and before writing it (after the last
+// transformation) as indented JSON documents to a file for --debug-dump.
+// The first error is kept in Err.
+type stageDump struct {
+	File    string
+	Err     error
+	last    []byte
+	written bool
+}
+
+func newStageDump(file string) (*stageDump, error) {
+	return &stageDump{File: file}, ioutil.WriteFile(file, nil, 0640)
+}
+
+func (d *stageDump) Record(stage ConversionStage) {
+	switch {
+	case stage.Name == "flush" || (strings.HasPrefix(stage.Name, "write") && d.written):
+		return
+	case strings.HasPrefix(stage.Name, "write"):
+		d.append(d.last)
+		d.written = true
+		return
+	}
+	buffer := &bytes.Buffer{}
+	if err := (JSONFormat{PrettyPrint: true, NonFinite: NonFiniteString}).Marshal(stage.Data, buffer); err != nil {
+		d.fail(err)
+		return
+	}
+	d.last = append(bytes.TrimRight(buffer.Bytes(), "\n"), '\n')
+	if stage.Name == "read" {
+		d.append(d.last)
+	}
+}
+
+func (d *stageDump) append(encoded []byte) {
+	file, err := os.OpenFile(d.File, os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		d.fail(err)
+		return
+	}
+	_, err = file.Write(encoded)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	d.fail(err)
+}
+
+func (d *stageDump) fail(err error) {
+	if d.Err == nil {
+		d.Err = err
+	}
+}
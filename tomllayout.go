@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	toml "github.com/BurntSushi/toml"
+)
+
+// A TOML document read with its text (see TOMLFormat.KeepLayout), so that
+// changes to values can be applied to the text, keeping comments and layout.
+type tomlDocument struct {
+	text string
+	data interface{}
+}
+
+// The position of a key-value pair in TOML text: the start of its line, the
+// value, and the start of the line following the value.
+type tomlSpan struct {
+	lineStart, start, end, next int
+}
+
+// A transformer applying another transformer to the data of a tomlDocument
+// and the changes to its text. If they cannot be applied, e.g. because a
+// value spanning several lines changed or keys were added, the transformed
+// data is returned (with a warning) and the comments are lost.
+type tomlLayoutTransformer struct {
+	Transformer
+}
+
+func (t tomlLayoutTransformer) Transform(data interface{}) (interface{}, error) {
+	document, ok := data.(tomlDocument)
+	if !ok {
+		return t.Transformer.Transform(data)
+	}
+	transformed, err := WithCopy(t.Transformer).Transform(document.data)
+	if err != nil {
+		return transformed, err
+	}
+	text, err := spliceTOML(document.text, document.data, transformed)
+	if err != nil {
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "warning: %s, comments are dropped\n", err)
+		}
+		return transformed, nil
+	}
+	return tomlDocument{text: text, data: transformed}, nil
+}
+
+// Applies the differences between the old and new data to the text.
+func spliceTOML(text string, old interface{}, new interface{}) (string, error) {
+	spans, err := scanTOML(text)
+	if err != nil {
+		return text, err
+	}
+	type splice struct {
+		from, to    int
+		replacement string
+	}
+	var splices []splice
+	var diff func(old interface{}, new interface{}, at []string) error
+	diff = func(old interface{}, new interface{}, at []string) error {
+		key := strings.Join(at, "\x00")
+		span, isValue := spans[key]
+		if !isValue {
+			if oldKeys, newKeys := mapKeys(old), mapKeys(new); oldKeys != nil && newKeys != nil {
+				for _, k := range newKeys {
+					if _, exists := mapValue(old, k); !exists {
+						return fmt.Errorf("the key '%s' cannot be added to the TOML text", describeTOMLKey(append(at, k)))
+					}
+				}
+				for _, k := range oldKeys {
+					oldValue, _ := mapValue(old, k)
+					newValue, exists := mapValue(new, k)
+					if !exists {
+						removed, ok := spans[strings.Join(append(at, k), "\x00")]
+						if !ok {
+							return fmt.Errorf("the table '%s' cannot be removed from the TOML text", describeTOMLKey(append(at, k)))
+						}
+						splices = append(splices, splice{removed.lineStart, removed.next, ""})
+					} else if err := diff(oldValue, newValue, append(at, k)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			oldTables, oldOK := old.([]map[string]interface{})
+			newTables, newOK := new.([]map[string]interface{})
+			if oldOK && newOK && len(oldTables) == len(newTables) {
+				for n := range oldTables {
+					if err := diff(oldTables[n], newTables[n], append(at, "#"+strconv.Itoa(n))); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+		if reflect.DeepEqual(old, new) {
+			return nil
+		} else if !isValue {
+			return fmt.Errorf("the value of '%s' cannot be changed in the TOML text", describeTOMLKey(at))
+		} else if strings.ContainsAny(text[span.start:span.end], "\r\n") {
+			return fmt.Errorf("the value of '%s' spans several lines", describeTOMLKey(at))
+		}
+		encoded, err := encodeTOMLValue(new)
+		if err != nil {
+			return fmt.Errorf("the value of '%s' cannot be written inline: %s", describeTOMLKey(at), err)
+		}
+		splices = append(splices, splice{span.start, span.end, encoded})
+		return nil
+	}
+	if err := diff(old, plainMaps(new), nil); err != nil {
+		return text, err
+	}
+
+	sort.Slice(splices, func(i, j int) bool { return splices[i].from > splices[j].from })
+	for _, s := range splices {
+		text = text[:s.from] + s.replacement + text[s.to:]
+	}
+	return text, nil
+}
+
+func describeTOMLKey(at []string) string {
+	return strings.Replace(strings.Join(at, "."), ".#", "#", -1)
+}
+
+// Encodes a value as an inline TOML value.
+func encodeTOMLValue(value interface{}) (string, error) {
+	buffer := &bytes.Buffer{}
+	err := toml.NewEncoder(buffer).Encode(map[string]interface{}{"v": value})
+	if err != nil {
+		return "", err
+	}
+	encoded := strings.TrimRight(buffer.String(), "\n")
+	if !strings.HasPrefix(encoded, "v = ") || strings.Contains(encoded, "\n") {
+		return "", fmt.Errorf("%s is not an inline value", describeType(value))
+	}
+	return strings.TrimPrefix(encoded, "v = "), nil
+}
+
+// Finds the key-value pairs in TOML text by their keys (joined with NUL,
+// elements of arrays of tables as #n).
+func scanTOML(text string) (map[string]tomlSpan, error) {
+	spans := make(map[string]tomlSpan)
+	var (
+		table       []string
+		arrayCounts = make(map[string]int)
+	)
+	for pos := 0; pos < len(text); {
+		lineStart := pos
+		pos = skipTOMLSpace(text, pos)
+		if pos >= len(text) || text[pos] == '\n' || text[pos] == '\r' || text[pos] == '#' {
+			pos = nextTOMLLine(text, pos)
+			continue
+		}
+		if text[pos] == '[' {
+			isArray := strings.HasPrefix(text[pos:], "[[")
+			if isArray {
+				pos++
+			}
+			keys, end, err := scanTOMLKey(text, pos+1)
+			if err != nil {
+				return nil, err
+			}
+			table = nil
+			for n, key := range keys {
+				table = append(table, key)
+				joined := strings.Join(table, "\x00")
+				if count, ok := arrayCounts[joined]; ok && (n < len(keys)-1 || !isArray) {
+					table = append(table, "#"+strconv.Itoa(count-1))
+				} else if n == len(keys)-1 && isArray {
+					arrayCounts[joined]++
+					table = append(table, "#"+strconv.Itoa(arrayCounts[joined]-1))
+				}
+			}
+			pos = nextTOMLLine(text, end)
+			continue
+		}
+		keys, end, err := scanTOMLKey(text, pos)
+		if err != nil {
+			return nil, err
+		}
+		end = skipTOMLSpace(text, end)
+		if end >= len(text) || text[end] != '=' {
+			return nil, fmt.Errorf("expected '=' after the key '%s'", describeTOMLKey(keys))
+		}
+		start := skipTOMLSpace(text, end+1)
+		end, err = scanTOMLValue(text, start, false)
+		if err != nil {
+			return nil, err
+		}
+		path := append(append([]string{}, table...), keys...)
+		pos = nextTOMLLine(text, end)
+		spans[strings.Join(path, "\x00")] = tomlSpan{lineStart: lineStart, start: start, end: end, next: pos}
+	}
+	return spans, nil
+}
+
+func skipTOMLSpace(text string, pos int) int {
+	for pos < len(text) && (text[pos] == ' ' || text[pos] == '\t') {
+		pos++
+	}
+	return pos
+}
+
+func nextTOMLLine(text string, pos int) int {
+	if n := strings.IndexByte(text[pos:], '\n'); n >= 0 {
+		return pos + n + 1
+	}
+	return len(text)
+}
+
+// Reads a (dotted) key and returns its parts and the position after it.
+func scanTOMLKey(text string, pos int) ([]string, int, error) {
+	var keys []string
+	for {
+		pos = skipTOMLSpace(text, pos)
+		if pos >= len(text) {
+			return nil, pos, fmt.Errorf("unexpected end of the TOML text in a key")
+		}
+		switch text[pos] {
+		case '"':
+			end, err := scanTOMLValue(text, pos, true)
+			if err != nil {
+				return nil, pos, err
+			}
+			key, err := strconv.Unquote(text[pos:end])
+			if err != nil {
+				return nil, pos, fmt.Errorf("invalid key %s", text[pos:end])
+			}
+			keys, pos = append(keys, key), end
+		case '\'':
+			end := strings.IndexByte(text[pos+1:], '\'')
+			if end < 0 {
+				return nil, pos, fmt.Errorf("unterminated key")
+			}
+			keys, pos = append(keys, text[pos+1:pos+1+end]), pos+end+2
+		default:
+			end := pos
+			for end < len(text) && (isTOMLBareKeyChar(text[end])) {
+				end++
+			}
+			if end == pos {
+				return nil, pos, fmt.Errorf("invalid character '%c' in a key", text[pos])
+			}
+			keys, pos = append(keys, text[pos:end]), end
+		}
+		pos = skipTOMLSpace(text, pos)
+		if pos >= len(text) || text[pos] != '.' {
+			return keys, pos, nil
+		}
+		pos++
+	}
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// Returns the position after the value starting at pos. Values in arrays
+// and inline tables (nested) also end at commas and closing brackets.
+func scanTOMLValue(text string, pos int, nested bool) (int, error) {
+	switch {
+	case strings.HasPrefix(text[pos:], `"""`), strings.HasPrefix(text[pos:], `'''`):
+		quotes := text[pos : pos+3]
+		for i := pos + 3; i+3 <= len(text); i++ {
+			if quotes[0] == '"' && text[i] == '\\' {
+				i++
+			} else if text[i:i+3] == quotes {
+				end := i + 3
+				for n := 0; n < 2 && end < len(text) && text[end] == quotes[0]; n++ {
+					end++
+				}
+				return end, nil
+			}
+		}
+	case text[pos] == '"':
+		for i := pos + 1; i < len(text) && text[i] != '\n'; i++ {
+			if text[i] == '\\' {
+				i++
+			} else if text[i] == '"' {
+				return i + 1, nil
+			}
+		}
+	case text[pos] == '\'':
+		if end := strings.IndexAny(text[pos+1:], "'\n"); end >= 0 && text[pos+1+end] == '\'' {
+			return pos + end + 2, nil
+		}
+	case text[pos] == '[':
+		for i := pos + 1; i < len(text); {
+			switch text[i] {
+			case ' ', '\t', '\r', '\n', ',':
+				i++
+			case '#':
+				i = nextTOMLLine(text, i)
+			case ']':
+				return i + 1, nil
+			default:
+				end, err := scanTOMLValue(text, i, true)
+				if err != nil {
+					return end, err
+				}
+				i = end
+			}
+		}
+	case text[pos] == '{':
+		for i := pos + 1; i < len(text); {
+			switch text[i] {
+			case ' ', '\t', ',':
+				i++
+			case '}':
+				return i + 1, nil
+			default:
+				_, end, err := scanTOMLKey(text, i)
+				if err != nil {
+					return end, err
+				}
+				end = skipTOMLSpace(text, end)
+				if end >= len(text) || text[end] != '=' {
+					return end, fmt.Errorf("expected '=' in an inline table")
+				}
+				i, err = scanTOMLValue(text, skipTOMLSpace(text, end+1), true)
+				if err != nil {
+					return i, err
+				}
+			}
+		}
+	default:
+		stop := "#\r\n"
+		if nested {
+			stop += ",]}"
+		}
+		end := pos
+		for end < len(text) && !strings.ContainsRune(stop, rune(text[end])) {
+			end++
+		}
+		for end > pos && (text[end-1] == ' ' || text[end-1] == '\t') {
+			end--
+		}
+		if end > pos {
+			return end, nil
+		}
+	}
+	return pos, fmt.Errorf("invalid or unterminated TOML value at offset %d", pos)
+}
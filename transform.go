@@ -1,16 +1,26 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"math"
+	"math/rand"
+	"net/url"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 )
 
 // A transformer accepts arbitrary data and applies some rules to it.
 //
 // If the data is modifiable (such as a map), it may do so directly.
-// If this is not desirable, a deep copy should be passed instead.
+// If this is not desirable, a deep copy should be passed instead (see WithCopy).
 type Transformer interface {
 	Transform(interface{}) (interface{}, error)
 }
@@ -53,6 +63,1510 @@ func (t NilRemovalTransformer) Transform(data interface{}) (interface{}, error)
 	return cTransformer.Transform(data)
 }
 
+// The kinds of values that RemoveZeroValuesTransformer can remove.
+const (
+	ZeroNumbers = "number"
+	ZeroStrings = "string"
+	ZeroBools   = "bool"
+	ZeroEmpty   = "empty"
+)
+
+var zeroKinds = []string{ZeroNumbers, ZeroStrings, ZeroBools, ZeroEmpty}
+
+// A transformer removing key-value pairs whose values are zero values of the
+// kinds given: 0, "", false, or empty objects and arrays (including those
+// only containing removed values). Array elements are kept since they are
+// positional, but zero values in objects within arrays are removed. Nulls
+// are left to NilRemovalTransformer.
+type RemoveZeroValuesTransformer struct {
+	Kinds map[string]bool
+}
+
+// Parses a comma-separated list of zero kinds (see zeroKinds).
+func ParseZeroKinds(list string) (map[string]bool, error) {
+	kinds := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !containsFold(name, zeroKinds) {
+			return nil, fmt.Errorf("unknown kind of zero value '%s' (expected one of %s)", name, strings.Join(zeroKinds, ", "))
+		}
+		kinds[name] = true
+	}
+	return kinds, nil
+}
+
+func (t RemoveZeroValuesTransformer) Transform(data interface{}) (interface{}, error) {
+	return t.removeZeroValues(data), nil
+}
+
+func (t RemoveZeroValuesTransformer) removeZeroValues(data interface{}) interface{} {
+	if isObject(data) {
+		for _, key := range mapKeys(data) {
+			value, _ := mapValue(data, key)
+			if value = t.removeZeroValues(value); t.isZero(value) {
+				deleteMapKey(data, key)
+			} else {
+				setMapValue(data, key, value)
+			}
+		}
+	} else if elements, ok := data.([]interface{}); ok {
+		for n, element := range elements {
+			elements[n] = t.removeZeroValues(element)
+		}
+	}
+	return data
+}
+
+func (t RemoveZeroValuesTransformer) isZero(value interface{}) bool {
+	if isObject(value) {
+		return t.Kinds[ZeroEmpty] && len(mapKeys(value)) == 0
+	} else if describeType(value) == "an array" {
+		return t.Kinds[ZeroEmpty] && reflect.ValueOf(value).Len() == 0
+	} else if isNil(value) {
+		return false
+	}
+	v := reflect.ValueOf(value)
+	switch scalarType(value) {
+	case valueTypeInt, valueTypeFloat:
+		if n, ok := value.(json.Number); ok {
+			f, err := n.Float64()
+			return t.Kinds[ZeroNumbers] && err == nil && f == 0
+		} else if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+			return t.Kinds[ZeroNumbers] && v.Float() == 0
+		}
+		return t.Kinds[ZeroNumbers] && v.IsZero()
+	case valueTypeString:
+		return t.Kinds[ZeroStrings] && v.Len() == 0
+	case valueTypeBool:
+		return t.Kinds[ZeroBools] && !v.Bool()
+	}
+	return false
+}
+
+// A transformer delegating to another one with a deep copy of the data, so
+// that the caller's data is never modified.
+//
+// Maps (including ordered maps), slices and arrays are copied recursively.
+// Everything else is shared: scalars cannot be modified anyway, but data
+// behind pointers (other than ordered maps), functions and channels are not
+// copied.
+type DeepCopyTransformer struct {
+	Transformer Transformer
+}
+
+// Wraps the transformer so that it operates on a deep copy of the data.
+func WithCopy(transformer Transformer) Transformer {
+	return DeepCopyTransformer{Transformer: transformer}
+}
+
+func (t DeepCopyTransformer) Transform(data interface{}) (interface{}, error) {
+	return t.Transformer.Transform(copyData(data))
+}
+
+// Returns a deep copy of the data as described for DeepCopyTransformer.
+func copyData(data interface{}) interface{} {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			copied[k] = copyData(v)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(d))
+		for n, v := range d {
+			copied[n] = copyData(v)
+		}
+		return copied
+	case *OrderedMap:
+		if d == nil {
+			return d
+		}
+		copied := NewOrderedMap()
+		for _, key := range d.Keys() {
+			copied.Set(key, copyData(d.values[key]))
+		}
+		return copied
+	}
+	switch value := reflect.ValueOf(data); value.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return copyValue(value).Interface()
+	}
+	return data
+}
+
+func copyValue(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		return reflect.ValueOf(copyData(value.Interface()))
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for _, key := range value.MapKeys() {
+			copied.SetMapIndex(key, copyValue(value.MapIndex(key)))
+		}
+		return copied
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(copyValue(value.Index(i)))
+		}
+		return copied
+	case reflect.Array:
+		copied := reflect.New(value.Type()).Elem()
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(copyValue(value.Index(i)))
+		}
+		return copied
+	}
+	return value
+}
+
+// Copies a map or slice without copying its values.
+func shallowCopy(data interface{}) interface{} {
+	switch d := data.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			copied[k] = v
+		}
+		return copied
+	case []interface{}:
+		return append([]interface{}(nil), d...)
+	case *OrderedMap:
+		copied := NewOrderedMap()
+		for _, key := range d.Keys() {
+			copied.Set(key, d.values[key])
+		}
+		return copied
+	}
+	value := reflect.ValueOf(data)
+	switch value.Kind() {
+	case reflect.Map:
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for _, key := range value.MapKeys() {
+			copied.SetMapIndex(key, value.MapIndex(key))
+		}
+		return copied.Interface()
+	case reflect.Slice:
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		reflect.Copy(copied, value)
+		return copied.Interface()
+	}
+	return data
+}
+
+// A transformer applying another one to chunks of a top-level array
+// concurrently (other data is passed on as it is) and concatenating the
+// results in order, e.g. to speed up CPU-bound transformations of large
+// inputs. The first error aborts the transformation.
+//
+// The transformer must process the elements independently of each other and
+// be safe for concurrent use, which the built-in element-wise transformers
+// are (unlike, e.g., GroupByTransformer). As each chunk is transformed as
+// if it were the data, paths (such as those of a PathScopedTransformer) and
+// the paths in errors are relative to the chunk.
+type ParallelTransformer struct {
+	Transformer Transformer
+	Workers     int
+}
+
+func (t ParallelTransformer) Transform(data interface{}) (interface{}, error) {
+	elements, ok := data.([]interface{})
+	if !ok || t.Workers < 2 || len(elements) < 2 {
+		return t.Transformer.Transform(data)
+	}
+	// more chunks than workers so that the remaining ones can be skipped after an error
+	chunkSize := (len(elements) + 4*t.Workers - 1) / (4 * t.Workers)
+	chunks := make([][]interface{}, (len(elements)+chunkSize-1)/chunkSize)
+	indexes := make(chan int, len(chunks))
+	for n := range chunks {
+		indexes <- n
+	}
+	close(indexes)
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		firstErr error
+	)
+	for w := 0; w < t.Workers && w < len(chunks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range indexes {
+				lock.Lock()
+				failed := firstErr != nil
+				lock.Unlock()
+				if failed {
+					return
+				}
+				start, end := n*chunkSize, (n+1)*chunkSize
+				if end > len(elements) {
+					end = len(elements)
+				}
+				// the capacity keeps transformers from appending into the next chunk
+				transformed, err := t.Transformer.Transform(elements[start:end:end])
+				if err == nil {
+					chunks[n], err = topLevelArray(transformed)
+				}
+				if err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					lock.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return data, firstErr
+	}
+	result := make([]interface{}, 0, len(elements))
+	for _, chunk := range chunks {
+		result = append(result, chunk...)
+	}
+	return result, nil
+}
+
+// A transformer skipping the elements of an array that Transformer fails on,
+// e.g. records that cannot be converted with --keep-going. Each element is
+// transformed on its own as a one-element array, as if it were the data like
+// with ParallelTransformer, so Transformer must not depend on the other
+// elements. The elements failing are passed to OnRecordError with their
+// (1-based) position in the array and skipped if it returns true, otherwise
+// the transformation fails with the error.
+type RecordSkippingTransformer struct {
+	Transformer   Transformer
+	OnRecordError RecordErrorHandler
+}
+
+func (t RecordSkippingTransformer) Transform(data interface{}) (interface{}, error) {
+	elements, ok := data.([]interface{})
+	if !ok || t.OnRecordError == nil {
+		return t.Transformer.Transform(data)
+	}
+	result := make([]interface{}, 0, len(elements))
+	for n := range elements {
+		transformed, err := t.Transformer.Transform(elements[n : n+1 : n+1])
+		if err == nil {
+			var records []interface{}
+			if records, err = topLevelArray(transformed); err == nil {
+				result = append(result, records...)
+				continue
+			}
+		}
+		if !t.OnRecordError(n+1, err) {
+			return data, err
+		}
+	}
+	return result, nil
+}
+
+// A transformer filling in keys missing from the data with the values of a defaults document.
+//
+// Maps are merged recursively, the data takes precedence for everything else
+// (including explicit nulls). Empty data (nil) is replaced by the defaults.
+// Default values are not copied, i.e. the result may share structures with
+// the defaults.
+type DefaultsTransformer struct {
+	Defaults interface{}
+}
+
+func (t DefaultsTransformer) Transform(data interface{}) (interface{}, error) {
+	if isNil(data) {
+		return t.Defaults, nil
+	}
+	return data, mergeDefaults(data, t.Defaults)
+}
+
+func mergeDefaults(data interface{}, defaults interface{}) error {
+	if odata, ok := data.(*OrderedMap); ok && odata != nil {
+		return mergeOrderedDefaults(odata, defaults)
+	}
+	if odefaults, ok := defaults.(*OrderedMap); ok && odefaults != nil {
+		defaults = plainMaps(odefaults)
+	}
+	dvalue := reflect.ValueOf(data)
+	defvalue := reflect.ValueOf(defaults)
+	if isNil(data) || isNil(defaults) || dvalue.Kind() != reflect.Map || defvalue.Kind() != reflect.Map {
+		return nil
+	}
+	keyType := dvalue.Type().Key()
+	for _, k := range defvalue.MapKeys() {
+		dk := k
+		if !k.Type().AssignableTo(keyType) {
+			if keyType.Kind() != reflect.String {
+				return fmt.Errorf("default key '%v' is incompatible with the data's keys", k.Interface())
+			}
+			dk = reflect.ValueOf(fmt.Sprint(k.Interface())).Convert(keyType)
+		}
+		current := dvalue.MapIndex(dk)
+		if !current.IsValid() {
+			value, ok := assignableValue(defvalue.MapIndex(k), dvalue.Type().Elem())
+			if !ok {
+				return fmt.Errorf("default value for key '%v' is incompatible with the data", k.Interface())
+			}
+			dvalue.SetMapIndex(dk, value)
+		} else if err := mergeDefaults(current.Interface(), defvalue.MapIndex(k).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merges defaults into an ordered map, appending missing keys in the order of the defaults.
+func mergeOrderedDefaults(data *OrderedMap, defaults interface{}) error {
+	var keys []string
+	values := make(map[string]interface{})
+	switch d := defaults.(type) {
+	case *OrderedMap:
+		keys = d.Keys()
+		values = d.values
+	default:
+		dvalue := reflect.ValueOf(defaults)
+		if isNil(defaults) || dvalue.Kind() != reflect.Map {
+			return nil
+		}
+		for _, k := range dvalue.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			values[key] = dvalue.MapIndex(k).Interface()
+		}
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		if current, ok := data.Get(key); !ok {
+			data.Set(key, values[key])
+		} else if err := mergeDefaults(current, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A transformer applying another transformer only to the values at the given paths.
+// Without paths, the data as a whole is transformed.
+type PathScopedTransformer struct {
+	Paths       []Path
+	Transformer Transformer
+}
+
+func (t PathScopedTransformer) Transform(data interface{}) (interface{}, error) {
+	if len(t.Paths) == 0 {
+		return t.Transformer.Transform(data)
+	}
+	var err error
+	for _, path := range t.Paths {
+		data, err = updatePath(data, path, func(value interface{}, at Path) (interface{}, error) {
+			return t.Transformer.Transform(value)
+		})
+		if err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// Replaces all scalars (anything but maps and arrays) by the result of the
+// function, recursively. The function is also passed the path of the value.
+func transformLeaves(data interface{}, fn func(value interface{}, at Path) (interface{}, error)) (interface{}, error) {
+	return transformLeavesFrom(data, Path{}, fn)
+}
+
+func transformLeavesFrom(data interface{}, at Path, fn func(value interface{}, at Path) (interface{}, error)) (interface{}, error) {
+	if keys := mapKeys(data); keys != nil {
+		for _, key := range keys {
+			value, _ := mapValue(data, key)
+			transformed, err := transformLeavesFrom(value, at.append(PathSegment{Key: key}), fn)
+			if err != nil {
+				return data, err
+			}
+			if err = setMapValue(data, key, transformed); err != nil {
+				return data, err
+			}
+		}
+		return data, nil
+	}
+	value := reflect.ValueOf(data)
+	if !isNil(data) && value.Kind() == reflect.Slice {
+		for i := 0; i < value.Len(); i++ {
+			transformed, err := transformLeavesFrom(value.Index(i).Interface(), at.append(PathSegment{IsIndex: true, Index: i}), fn)
+			if err != nil {
+				return data, err
+			}
+			if err = setSliceElement(data, i, transformed); err != nil {
+				return data, err
+			}
+		}
+		return data, nil
+	}
+	return fn(data, at)
+}
+
+// Like transformLeaves, but copy-on-write: the data is left alone and the
+// objects and arrays containing values the function changed are copied
+// (sharing the others), e.g. so that output formats can adjust the data
+// without changing it for the caller.
+func transformLeavesCopy(data interface{}, fn func(value interface{}, at Path) (interface{}, error)) (interface{}, error) {
+	result, _, err := transformLeavesCopyFrom(data, Path{}, fn)
+	return result, err
+}
+
+func transformLeavesCopyFrom(data interface{}, at Path, fn func(value interface{}, at Path) (interface{}, error)) (interface{}, bool, error) {
+	if keys := mapKeys(data); keys != nil {
+		result, copied := data, false
+		for _, key := range keys {
+			value, _ := mapValue(data, key)
+			transformed, changed, err := transformLeavesCopyFrom(value, at.append(PathSegment{Key: key}), fn)
+			if err != nil {
+				return data, false, err
+			} else if !changed {
+				continue
+			} else if !copied {
+				result, copied = shallowCopy(data), true
+			}
+			if err = setMapValue(result, key, transformed); err != nil {
+				return data, false, err
+			}
+		}
+		return result, copied, nil
+	}
+	value := reflect.ValueOf(data)
+	if !isNil(data) && value.Kind() == reflect.Slice {
+		result, copied := data, false
+		for i := 0; i < value.Len(); i++ {
+			transformed, changed, err := transformLeavesCopyFrom(value.Index(i).Interface(), at.append(PathSegment{IsIndex: true, Index: i}), fn)
+			if err != nil {
+				return data, false, err
+			} else if !changed {
+				continue
+			} else if !copied {
+				result, copied = shallowCopy(data), true
+			}
+			if err = setSliceElement(result, i, transformed); err != nil {
+				return data, false, err
+			}
+		}
+		return result, copied, nil
+	}
+	transformed, err := fn(data, at)
+	if err != nil {
+		return data, false, err
+	}
+	return transformed, !sameLeaf(data, transformed), nil
+}
+
+// Checks if two scalars are the same (values of types that cannot be
+// compared never are).
+func sameLeaf(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Comparable() && a == b
+}
+
+// The largest integer JavaScript numbers (IEEE 754 doubles) represent exactly,
+// Number.MAX_SAFE_INTEGER.
+const maxSafeInteger = 1<<53 - 1
+
+// A transformer replacing integers beyond ±maxSafeInteger by strings with
+// their decimal digits, so that JavaScript consumers do not lose precision.
+// Other numbers are left alone. Floats are only affected if they have no
+// fractional part (their value may already be rounded).
+type JSSafeNumberTransformer struct{}
+
+func (t JSSafeNumberTransformer) Transform(data interface{}) (interface{}, error) {
+	return transformLeavesCopy(data, func(value interface{}, at Path) (interface{}, error) {
+		if number, ok := value.(json.Number); ok {
+			if i, err := strconv.ParseInt(number.String(), 10, 64); err == nil {
+				value = i
+			} else if !strings.ContainsAny(number.String(), ".eE") {
+				return number.String(), nil // too large even for 64 bits
+			} else {
+				return value, nil
+			}
+		}
+		v := reflect.ValueOf(value)
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.Int() > maxSafeInteger || v.Int() < -maxSafeInteger {
+				return strconv.FormatInt(v.Int(), 10), nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if v.Uint() > maxSafeInteger {
+				return strconv.FormatUint(v.Uint(), 10), nil
+			}
+		case reflect.Float32, reflect.Float64:
+			if f := v.Float(); !math.IsInf(f, 0) && f == math.Trunc(f) && math.Abs(f) > maxSafeInteger {
+				return strconv.FormatFloat(f, 'f', -1, 64), nil
+			}
+		}
+		return value, nil
+	})
+}
+
+// A transformer percent-encoding all strings, as query components or path segments.
+type URLEncodeTransformer struct {
+	PathEscaping bool
+}
+
+func (t URLEncodeTransformer) Transform(data interface{}) (interface{}, error) {
+	return transformLeaves(data, func(value interface{}, at Path) (interface{}, error) {
+		s, ok := value.(string)
+		if !ok {
+			return value, nil
+		} else if t.PathEscaping {
+			return url.PathEscape(s), nil
+		} else {
+			return url.QueryEscape(s), nil
+		}
+	})
+}
+
+// A transformer decoding percent-encoded strings, as query components or path segments.
+// Malformed encodings are kept as they are if Lenient is set or fail otherwise.
+type URLDecodeTransformer struct {
+	PathEscaping bool
+	Lenient      bool
+}
+
+func (t URLDecodeTransformer) Transform(data interface{}) (interface{}, error) {
+	return transformLeaves(data, func(value interface{}, at Path) (interface{}, error) {
+		s, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		var (
+			decoded string
+			err     error
+		)
+		if t.PathEscaping {
+			decoded, err = url.PathUnescape(s)
+		} else {
+			decoded, err = url.QueryUnescape(s)
+		}
+		if err != nil && t.Lenient {
+			return s, nil
+		} else if err != nil {
+			return s, fmt.Errorf("cannot decode the value at %s: %s", describePath(at), err)
+		}
+		return decoded, nil
+	})
+}
+
+// A transformer rewriting strings that are decimal numbers (optionally with
+// an exponent, e.g. `1E-05` or `+1.50`) in their shortest plain decimal
+// notation (`0.00001`, `1.5`) while keeping them strings, so that no precision
+// is lost. Other strings, surrounding spaces included, are left alone, as are
+// numbers whose notation would have more than maxDecimalDigits digits.
+type NormalizeNumericStringsTransformer struct{}
+
+const maxDecimalDigits = 1000
+
+var decimalStringPattern = regexp.MustCompile(`^([+-]?)([0-9]*)(?:\.([0-9]*))?(?:[eE]([+-]?[0-9]+))?$`)
+
+func (t NormalizeNumericStringsTransformer) Transform(data interface{}) (interface{}, error) {
+	return transformLeaves(data, func(value interface{}, at Path) (interface{}, error) {
+		if s, ok := value.(string); ok {
+			if normalized, ok := plainDecimal(s); ok {
+				return normalized, nil
+			}
+		}
+		return value, nil
+	})
+}
+
+// Returns the decimal number in plain notation without leading or trailing
+// zeros (and without sign if it is zero).
+func plainDecimal(s string) (string, bool) {
+	match := decimalStringPattern.FindStringSubmatch(s)
+	if match == nil || match[2]+match[3] == "" {
+		return s, false
+	}
+	digits, point := match[2]+match[3], len(match[2])
+	if match[4] != "" {
+		exponent, err := strconv.Atoi(match[4])
+		if err != nil || exponent > maxDecimalDigits || exponent < -maxDecimalDigits {
+			return s, false
+		}
+		point += exponent
+	}
+	trimmed := strings.TrimLeft(digits, "0")
+	point -= len(digits) - len(trimmed)
+	digits = strings.TrimRight(trimmed, "0")
+	switch {
+	case digits == "":
+		return "0", true
+	case point > maxDecimalDigits || point < -maxDecimalDigits:
+		return s, false
+	case point <= 0:
+		digits = "0." + strings.Repeat("0", -point) + digits
+	case point >= len(digits):
+		digits += strings.Repeat("0", point-len(digits))
+	default:
+		digits = digits[:point] + "." + digits[point:]
+	}
+	if match[1] == "-" {
+		digits = "-" + digits
+	}
+	return digits, true
+}
+
+const (
+	valueTypeNull     = "null"
+	valueTypeBool     = "bool"
+	valueTypeInt      = "int"
+	valueTypeFloat    = "float"
+	valueTypeString   = "string"
+	valueTypeDateTime = "datetime"
+)
+
+// The names accepted for the types of scalar values, "number" is short for int and float.
+var valueTypeNames = []string{valueTypeString, valueTypeInt, valueTypeFloat, "number", valueTypeBool, valueTypeNull, valueTypeDateTime}
+
+// A transformer rejecting data containing scalar values of types not in the
+// allowed set (maps and arrays are always allowed). The error names the path
+// of the first offending value. The data itself is not modified.
+type TypeWhitelistTransformer struct {
+	Allowed map[string]bool
+}
+
+// Parses a comma-separated list of type names (see valueTypeNames).
+func ParseValueTypes(list string) (map[string]bool, error) {
+	allowed := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == "number":
+			allowed[valueTypeInt] = true
+			allowed[valueTypeFloat] = true
+		case containsFold(name, valueTypeNames):
+			allowed[name] = true
+		default:
+			return nil, fmt.Errorf("unknown type '%s' (expected one of %s)", name, strings.Join(valueTypeNames, ", "))
+		}
+	}
+	return allowed, nil
+}
+
+func (t TypeWhitelistTransformer) Transform(data interface{}) (interface{}, error) {
+	return transformLeaves(data, func(value interface{}, at Path) (interface{}, error) {
+		if valueType := scalarType(value); !t.Allowed[valueType] {
+			return value, fmt.Errorf("the value at %s is a %s, which is not allowed", describePath(at), valueType)
+		}
+		return value, nil
+	})
+}
+
+// Returns the type name of a scalar value. Numbers with a fractional part
+// (or non-finite ones) are floats, all others are ints, so that numbers
+// read from formats without an integer type are classified consistently.
+func scalarType(value interface{}) string {
+	if isNil(value) {
+		return valueTypeNull
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return valueTypeDateTime
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return scalarType(f)
+		}
+		return valueTypeFloat
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Bool:
+		return valueTypeBool
+	case reflect.String:
+		return valueTypeString
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return valueTypeInt
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); !math.IsInf(f, 0) && f == math.Trunc(f) {
+			return valueTypeInt
+		}
+		return valueTypeFloat
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// A transformer wrapping the values at the paths in single-element arrays
+// unless they are arrays already (or null), so that consumers can rely on
+// arrays even where a single element is represented by the element itself.
+type EnsureArrayTransformer struct {
+	Paths []Path
+}
+
+func (t EnsureArrayTransformer) Transform(data interface{}) (interface{}, error) {
+	var err error
+	for _, path := range t.Paths {
+		data, err = updatePath(data, path, func(value interface{}, at Path) (interface{}, error) {
+			if isNil(value) || describeType(value) == "an array" {
+				return value, nil
+			}
+			return []interface{}{value}, nil
+		})
+		if err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// A transformer failing unless all paths are present (with any value,
+// including null), as a quick structural check of documents. Wildcards
+// require the rest of the path in each element they match. The error names
+// every path that is missing.
+type RequireKeysTransformer struct {
+	Paths []Path
+}
+
+func (t RequireKeysTransformer) Transform(data interface{}) (interface{}, error) {
+	var missing []string
+	for _, path := range t.Paths {
+		for _, at := range missingPaths(data, path, Path{}) {
+			missing = append(missing, "'"+at.String()+"'")
+		}
+	}
+	switch len(missing) {
+	case 0:
+		return data, nil
+	case 1:
+		return data, fmt.Errorf("the required key %s is missing", missing[0])
+	}
+	return data, fmt.Errorf("%d required keys are missing: %s", len(missing), strings.Join(missing, ", "))
+}
+
+// A transformer replacing the data by the value at the path, or by an array
+// of the values matching a path with wildcards. A missing value is an error.
+type ExtractTransformer struct {
+	Path Path
+}
+
+func (t ExtractTransformer) Transform(data interface{}) (interface{}, error) {
+	if t.Path.hasWildcards() {
+		matches := []interface{}{}
+		matchPath(data, t.Path, func(value interface{}, at Path) {
+			matches = append(matches, value)
+		})
+		return matches, nil
+	}
+	value, ok := lookupPath(data, t.Path)
+	if !ok {
+		return data, fmt.Errorf("there is no value at %s", describePath(t.Path))
+	}
+	return value, nil
+}
+
+// A transformer deleting the values matching the path: keys of objects and
+// elements of arrays (only the outermost matches of a path with `**`, see
+// updatePath). Deleting the data itself leaves null. The number of values
+// deleted is added to Matches if set.
+type DeletePathTransformer struct {
+	Path    Path
+	Matches *int
+}
+
+func (t DeletePathTransformer) Transform(data interface{}) (interface{}, error) {
+	matches := outermostMatches(data, t.Path)
+	var err error
+	// backwards, so that the indexes of the elements still to be deleted stay the same
+	for n := len(matches) - 1; n >= 0; n-- {
+		match := matches[n]
+		if len(match) == 0 {
+			data = nil
+			continue
+		}
+		last := match[len(match)-1]
+		data, err = updatePathFrom(data, match[:len(match)-1], Path{}, func(parent interface{}, at Path) (interface{}, error) {
+			if !last.IsIndex {
+				deleteMapKey(parent, last.Key)
+				return parent, nil
+			}
+			value := reflect.ValueOf(parent)
+			elements := make([]interface{}, 0, value.Len()-1)
+			for i := 0; i < value.Len(); i++ {
+				if i != last.Index {
+					elements = append(elements, value.Index(i).Interface())
+				}
+			}
+			return elements, nil
+		})
+		if err != nil {
+			return data, err
+		}
+	}
+	if t.Matches != nil {
+		*t.Matches += len(matches)
+	}
+	return data, nil
+}
+
+// A transformer setting the value at the path (see setPath) or, for a path
+// with wildcards or if only Existing values are to be replaced, replacing
+// each value matching it by a copy of the value (only the outermost matches
+// of a path with `**`). The number of values set is added to Matches if set.
+type SetPathTransformer struct {
+	Path     Path
+	Value    interface{}
+	Existing bool
+	Matches  *int
+}
+
+func (t SetPathTransformer) Transform(data interface{}) (interface{}, error) {
+	matches := 1
+	var err error
+	if !t.Path.hasWildcards() && !t.Existing {
+		data, err = setPath(data, t.Path, copyData(t.Value))
+	} else {
+		matches = 0
+		data, err = updatePath(data, t.Path, func(interface{}, Path) (interface{}, error) {
+			matches++
+			return copyData(t.Value), nil
+		})
+	}
+	if err == nil && t.Matches != nil {
+		*t.Matches += matches
+	}
+	return data, err
+}
+
+// Returns the paths (concrete up to the missing key) the data lacks.
+func missingPaths(data interface{}, path Path, at Path) []Path {
+	if len(path) == 0 {
+		return nil
+	}
+	segment := path[0]
+	if segment.Recursive {
+		// satisfied by a match at any depth
+		matched := false
+		matchPath(data, path, func(interface{}, Path) { matched = true })
+		if matched {
+			return nil
+		}
+		return []Path{append(at.append(segment), path[1:]...)}
+	} else if segment.Wildcard {
+		var missing []Path
+		matchPath(data, path[:1], func(value interface{}, element Path) {
+			missing = append(missing, missingPaths(value, path[1:], at.append(element[0]))...)
+		})
+		return missing
+	}
+	var (
+		value interface{}
+		ok    bool
+	)
+	if segment.IsIndex {
+		value, ok = sliceElement(data, segment.Index)
+	} else {
+		value, ok = mapValue(data, segment.Key)
+	}
+	if !ok {
+		return []Path{append(at.append(segment), path[1:]...)}
+	}
+	return missingPaths(value, path[1:], at.append(segment))
+}
+
+// Policies for non-finite numbers (infinities and NaN) in formats that cannot
+// represent them.
+const (
+	NonFiniteError  = "error"
+	NonFiniteNull   = "null"
+	NonFiniteString = "string"
+	NonFiniteOmit   = "omit"
+)
+
+var nonFinitePolicies = []string{NonFiniteError, NonFiniteNull, NonFiniteString, NonFiniteOmit}
+
+// A transformer replacing non-finite floats according to the policy: by null,
+// by strings (`+Inf`, `-Inf`, `NaN`), or by removing the key or element.
+// NonFiniteError (the default) fails with the path of the first one.
+type NonFiniteTransformer struct {
+	Policy string
+}
+
+// Marks values to be removed.
+type omittedValue struct{}
+
+func (t NonFiniteTransformer) Transform(data interface{}) (interface{}, error) {
+	policy := strings.ToLower(t.Policy)
+	if policy != "" && !containsFold(policy, nonFinitePolicies) {
+		return data, fmt.Errorf("unknown policy for non-finite numbers '%s'", t.Policy)
+	}
+	omitted := false
+	data, err := transformLeavesCopy(data, func(value interface{}, at Path) (interface{}, error) {
+		var f float64
+		switch v := value.(type) {
+		case float64:
+			f = v
+		case float32:
+			f = float64(v)
+		default:
+			return value, nil
+		}
+		if !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return value, nil
+		}
+		switch policy {
+		case NonFiniteNull:
+			return nil, nil
+		case NonFiniteString:
+			return strconv.FormatFloat(f, 'g', -1, 64), nil
+		case NonFiniteOmit:
+			omitted = true
+			return omittedValue{}, nil
+		}
+		return value, fmt.Errorf("non-finite number %v at %s", f, describePath(at))
+	})
+	if err != nil || !omitted {
+		return data, err
+	}
+	return removeOmitted(data), nil
+}
+
+// Removes the omitted values from a copy of the data (leaving the objects
+// and arrays without any alone).
+func removeOmitted(data interface{}) interface{} {
+	if !containsOmitted(data) {
+		return data
+	}
+	if keys := mapKeys(data); keys != nil {
+		data = shallowCopy(data)
+		for _, key := range keys {
+			value, _ := mapValue(data, key)
+			if _, ok := value.(omittedValue); ok {
+				deleteMapKey(data, key)
+			} else {
+				setMapValue(data, key, removeOmitted(value))
+			}
+		}
+	} else if elements, ok := data.([]interface{}); ok {
+		kept := make([]interface{}, 0, len(elements))
+		for _, element := range elements {
+			if _, ok := element.(omittedValue); !ok {
+				kept = append(kept, removeOmitted(element))
+			}
+		}
+		return kept
+	}
+	return data
+}
+
+func containsOmitted(data interface{}) bool {
+	if _, ok := data.(omittedValue); ok {
+		return true
+	}
+	for _, key := range mapKeys(data) {
+		if value, _ := mapValue(data, key); containsOmitted(value) {
+			return true
+		}
+	}
+	if elements, ok := data.([]interface{}); ok {
+		for _, element := range elements {
+			if containsOmitted(element) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A transformer turning string keys that are integers (such as "1", but not
+// "01") into integer keys, e.g. to restore YAML maps after a round-trip
+// through JSON. Only the objects at the paths are converted, all objects if
+// there are none.
+//
+// Objects with such keys become maps with mixed keys, i.e. the order of
+// ordered maps is lost. Only YAML output keeps integer keys, TOML output does
+// not support them.
+type NumericKeysTransformer struct {
+	Paths []Path
+}
+
+func (t NumericKeysTransformer) Transform(data interface{}) (interface{}, error) {
+	if len(t.Paths) == 0 {
+		return numericKeys(data, true), nil
+	}
+	var err error
+	for _, path := range t.Paths {
+		data, err = updatePath(data, path, func(value interface{}, at Path) (interface{}, error) {
+			return numericKeys(value, false), nil
+		})
+		if err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+func numericKeys(data interface{}, recursive bool) interface{} {
+	if elements, ok := data.([]interface{}); ok && recursive {
+		for n, element := range elements {
+			elements[n] = numericKeys(element, true)
+		}
+		return elements
+	} else if !isObject(data) {
+		return data
+	}
+	keys := mapKeys(data)
+	converted := make(map[interface{}]interface{}, len(keys))
+	numeric := false
+	for _, key := range keys {
+		value, _ := mapValue(data, key)
+		if recursive {
+			value = numericKeys(value, true)
+			setMapValue(data, key, value)
+		}
+		if n, err := strconv.Atoi(key); err == nil && strconv.Itoa(n) == key {
+			converted[n] = value
+			numeric = true
+		} else {
+			converted[key] = value
+		}
+	}
+	if !numeric {
+		return data
+	}
+	return converted
+}
+
+// Which of the keys differing only in case FoldKeysTransformer keeps.
+const (
+	FoldKeysFirst = "first"
+	FoldKeysLast  = "last"
+	FoldKeysLower = "lower"
+)
+
+var foldKeysChoices = []string{FoldKeysFirst, FoldKeysLast, FoldKeysLower}
+
+// A transformer combining keys that differ only in case (e.g. `Host`, `host`
+// and `HOST`) into one, recursively. Keep selects the key kept: the first or
+// last one in the order of the object (sorted unless it is ordered) or the
+// key in lower case, at the position of the first one. The value is that of
+// the last key; with Strict, keys with different values are an error.
+type FoldKeysTransformer struct {
+	Keep   string
+	Strict bool
+}
+
+func (t FoldKeysTransformer) Transform(data interface{}) (interface{}, error) {
+	if !containsFold(t.Keep, foldKeysChoices) && t.Keep != "" {
+		return data, fmt.Errorf("unknown key choice '%s' (expected one of %s)", t.Keep, strings.Join(foldKeysChoices, ", "))
+	}
+	return t.foldKeys(data, Path{})
+}
+
+func (t FoldKeysTransformer) foldKeys(data interface{}, at Path) (interface{}, error) {
+	if elements, ok := data.([]interface{}); ok {
+		for n, element := range elements {
+			folded, err := t.foldKeys(element, at.append(PathSegment{Index: n, IsIndex: true}))
+			if err != nil {
+				return data, err
+			}
+			elements[n] = folded
+		}
+		return elements, nil
+	} else if !isObject(data) {
+		return data, nil
+	}
+	keys := mapKeys(data)
+	variants := make(map[string][]string, len(keys))
+	var folded []string
+	for _, key := range keys {
+		value, _ := mapValue(data, key)
+		value, err := t.foldKeys(value, at.append(PathSegment{Key: key}))
+		if err != nil {
+			return data, err
+		}
+		setMapValue(data, key, value)
+		fold := foldCase(key)
+		if variants[fold] == nil {
+			folded = append(folded, fold)
+		}
+		variants[fold] = append(variants[fold], key)
+	}
+	if len(folded) == len(keys) {
+		return data, nil
+	}
+
+	var object interface{} = make(map[string]interface{}, len(folded))
+	if _, ok := data.(*OrderedMap); ok {
+		object = NewOrderedMap()
+	}
+	for _, fold := range folded {
+		keys := variants[fold]
+		value, _ := mapValue(data, keys[len(keys)-1])
+		if t.Strict {
+			for _, key := range keys {
+				if other, _ := mapValue(data, key); !reflect.DeepEqual(other, value) {
+					return data, fmt.Errorf("the keys '%s' at %s differ only in case but have different values",
+						strings.Join(keys, "', '"), describePath(at))
+				}
+			}
+		}
+		key := keys[0]
+		switch strings.ToLower(t.Keep) {
+		case FoldKeysLast:
+			key = keys[len(keys)-1]
+		case FoldKeysLower:
+			key = strings.ToLower(key)
+		}
+		setMapValue(object, key, value)
+	}
+	return object, nil
+}
+
+// A transformer cleaning up the keys of all objects, recursively: control
+// characters (other than whitespace) and byte order marks are removed, and
+// whitespace (including non-breaking and other Unicode spaces) at the start
+// and the end, with CollapseSpaces also runs of whitespace inside keys
+// replaced by a single space. Keys that become equal are an error naming the
+// original keys.
+type NormalizeKeysTransformer struct {
+	CollapseSpaces bool
+}
+
+func (t NormalizeKeysTransformer) Transform(data interface{}) (interface{}, error) {
+	return t.normalizeKeys(data, Path{})
+}
+
+func (t NormalizeKeysTransformer) normalizeKeys(data interface{}, at Path) (interface{}, error) {
+	if elements, ok := data.([]interface{}); ok {
+		for n, element := range elements {
+			normalized, err := t.normalizeKeys(element, at.append(PathSegment{Index: n, IsIndex: true}))
+			if err != nil {
+				return data, err
+			}
+			elements[n] = normalized
+		}
+		return elements, nil
+	} else if !isObject(data) {
+		return data, nil
+	}
+	keys := mapKeys(data)
+	originals := make(map[string]string, len(keys))
+	normalized := make([]string, len(keys))
+	changed := false
+	for n, key := range keys {
+		value, _ := mapValue(data, key)
+		value, err := t.normalizeKeys(value, at.append(PathSegment{Key: key}))
+		if err != nil {
+			return data, err
+		}
+		setMapValue(data, key, value)
+		normalized[n] = t.normalizeKey(key)
+		if original, ok := originals[normalized[n]]; ok {
+			return data, fmt.Errorf("the keys '%s' and '%s' at %s are both '%s' when normalized",
+				original, key, describePath(at), normalized[n])
+		}
+		originals[normalized[n]] = key
+		changed = changed || normalized[n] != key
+	}
+	if !changed {
+		return data, nil
+	}
+	var object interface{} = make(map[string]interface{}, len(keys))
+	if _, ok := data.(*OrderedMap); ok {
+		object = NewOrderedMap()
+	}
+	for n, key := range keys {
+		value, _ := mapValue(data, key)
+		setMapValue(object, normalized[n], value)
+	}
+	return object, nil
+}
+
+func (t NormalizeKeysTransformer) normalizeKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r == '\ufeff' || (unicode.IsControl(r) && !unicode.IsSpace(r)) {
+			return -1
+		}
+		return r
+	}, key)
+	if t.CollapseSpaces {
+		return strings.Join(strings.Fields(key), " ")
+	}
+	return strings.TrimFunc(key, unicode.IsSpace)
+}
+
+// A transformer turning an object into an array of entries, e.g. `{"a":1}`
+// into `[{"key":"a","value":1}]` (like JavaScript's Object.entries), with
+// the keys in the order of the object (sorted unless it is ordered). The field
+// names default to "key" and "value". Nulls are left alone.
+type EntriesTransformer struct {
+	KeyField   string
+	ValueField string
+}
+
+func (t EntriesTransformer) Transform(data interface{}) (interface{}, error) {
+	if isNil(data) {
+		return data, nil
+	} else if !isObject(data) {
+		return data, fmt.Errorf("expected an object for entries but found %s", describeType(data))
+	}
+	keyField, valueField := entryFields(t.KeyField, t.ValueField)
+	keys := mapKeys(data)
+	entries := make([]interface{}, len(keys))
+	for n, key := range keys {
+		value, _ := mapValue(data, key)
+		entry := NewOrderedMap()
+		entry.Set(keyField, key)
+		entry.Set(valueField, value)
+		entries[n] = entry
+	}
+	return entries, nil
+}
+
+// The inverse of EntriesTransformer, turning an array of entries into an
+// object in the order of the entries (like JavaScript's Object.fromEntries).
+// Later entries replace earlier ones with the same key, entries without a
+// value field have the value null. Keys must be scalars, those that are not
+// strings are converted. Nulls are left alone.
+type FromEntriesTransformer struct {
+	KeyField   string
+	ValueField string
+}
+
+func (t FromEntriesTransformer) Transform(data interface{}) (interface{}, error) {
+	if isNil(data) {
+		return data, nil
+	}
+	entries, err := topLevelArray(data)
+	if err != nil {
+		return data, fmt.Errorf("expected an array of entries but found %s", describeType(data))
+	}
+	keyField, valueField := entryFields(t.KeyField, t.ValueField)
+	object := NewOrderedMap()
+	for n, entry := range entries {
+		if !isObject(entry) {
+			return data, fmt.Errorf("entry %d is %s rather than an object", n, describeType(entry))
+		}
+		key, ok := mapValue(entry, keyField)
+		if !ok || isNil(key) {
+			return data, fmt.Errorf("entry %d has no '%s' field", n, keyField)
+		} else if isObject(key) || describeType(key) == "an array" {
+			return data, fmt.Errorf("the key of entry %d is %s", n, describeType(key))
+		}
+		value, _ := mapValue(entry, valueField)
+		object.Set(fmt.Sprint(key), value)
+	}
+	return object, nil
+}
+
+// A transformer merging an array of objects into one object, e.g.
+// `[{"a":1},{"b":2}]` into `{"a":1,"b":2}` as YAML configurations often
+// have lists of single-entry maps, with the keys in the order of the
+// elements. A key in more than one element is an error unless LastWins is
+// set, which keeps the value of the last one. Elements that are not objects
+// fail the transformation. Nulls are left alone.
+type MergeArrayOfObjectsTransformer struct {
+	LastWins bool
+}
+
+func (t MergeArrayOfObjectsTransformer) Transform(data interface{}) (interface{}, error) {
+	if isNil(data) {
+		return data, nil
+	}
+	elements, err := topLevelArray(data)
+	if err != nil {
+		return data, fmt.Errorf("expected an array of objects to merge but found %s", describeType(data))
+	}
+	merged := NewOrderedMap()
+	for n, element := range elements {
+		if !isObject(element) {
+			return data, fmt.Errorf("element %d is %s rather than an object", n, describeType(element))
+		}
+		for _, key := range mapKeys(element) {
+			if _, ok := merged.Get(key); ok && !t.LastWins {
+				return data, fmt.Errorf("the key '%s' of element %d is also in an earlier element", key, n)
+			}
+			value, _ := mapValue(element, key)
+			merged.Set(key, value)
+		}
+	}
+	return merged, nil
+}
+
+func entryFields(keyField string, valueField string) (string, string) {
+	if keyField == "" {
+		keyField = "key"
+	}
+	if valueField == "" {
+		valueField = "value"
+	}
+	return keyField, valueField
+}
+
+// A transformer replacing booleans by other values, e.g. 1 and 0 or "Y" and
+// "N" for systems without booleans. The renderings are used as they are, so
+// they may be of any type.
+type BoolFormatTransformer struct {
+	True  interface{}
+	False interface{}
+}
+
+func (t BoolFormatTransformer) Transform(data interface{}) (interface{}, error) {
+	return transformLeaves(data, func(value interface{}, at Path) (interface{}, error) {
+		if b, ok := value.(bool); ok && b {
+			return t.True, nil
+		} else if ok {
+			return t.False, nil
+		}
+		return value, nil
+	})
+}
+
+// Modes of masking numbers.
+const (
+	MaskBucket = "bucket"
+	MaskZero   = "zero"
+	MaskRedact = "redact"
+	MaskNoise  = "noise"
+)
+
+var maskModes = []string{MaskBucket, MaskZero, MaskRedact, MaskNoise}
+
+// A transformer masking numbers, e.g. to share data without exact values.
+// MaskBucket (the default) rounds them to the nearest multiple of the
+// granularity, MaskZero replaces them by 0, MaskRedact by a label of the
+// range of the granularity they are in (such as "1000..2000"), and MaskNoise
+// adds uniformly distributed noise of up to ±Noise, random but deterministic
+// for a seed. Integers (including floats without a fractional part) stay
+// integers. Strings (even numeric ones), non-finite numbers and the
+// structure are left alone.
+type MaskNumbersTransformer struct {
+	Mode string
+	// The size of buckets and ranges, 1 if not positive.
+	Granularity float64
+	Noise       float64
+	Seed        int64
+}
+
+func (t MaskNumbersTransformer) Transform(data interface{}) (interface{}, error) {
+	mode := strings.ToLower(t.Mode)
+	if mode == "" {
+		mode = MaskBucket
+	} else if !containsFold(mode, maskModes) {
+		return data, fmt.Errorf("unknown mode for masking numbers '%s' (expected one of %s)", t.Mode, strings.Join(maskModes, ", "))
+	}
+	granularity := t.Granularity
+	if granularity <= 0 {
+		granularity = 1
+	}
+	random := rand.New(rand.NewSource(t.Seed))
+	return transformLeaves(data, func(value interface{}, at Path) (interface{}, error) {
+		x, integer, ok := numericValue(value)
+		if !ok || math.IsInf(x, 0) || math.IsNaN(x) {
+			return value, nil
+		}
+		// as for scalarType, numbers without a fractional part count as integers
+		integer = integer || x == math.Trunc(x)
+		switch mode {
+		case MaskZero:
+			x = 0
+		case MaskRedact:
+			low := math.Floor(x/granularity) * granularity
+			return strconv.FormatFloat(low, 'f', -1, 64) + ".." + strconv.FormatFloat(low+granularity, 'f', -1, 64), nil
+		case MaskNoise:
+			x += (random.Float64()*2 - 1) * t.Noise
+			if integer {
+				x = math.Round(x)
+			}
+		default:
+			x = math.Round(x/granularity) * granularity
+		}
+		if integer && x == math.Trunc(x) {
+			return int64(x), nil
+		}
+		return x, nil
+	})
+}
+
+// A transformer truncating objects and arrays to their first keys and
+// elements, e.g. to share the structure of a large configuration in a bug
+// report. Limits are the numbers of keys and elements kept at each level
+// (the top level first), the last one applies to all deeper levels and 0
+// keeps all of them. The keys of objects are in their order, which is
+// alphabetical unless it is preserved. If Marker is set, truncated arrays
+// end with it as an element and truncated objects have it as a key with the
+// number of keys left out as its value, which fails the transformation if
+// one of the keys kept is the marker.
+type TruncateTransformer struct {
+	Limits []int
+	Marker string
+}
+
+func (t TruncateTransformer) Transform(data interface{}) (interface{}, error) {
+	for _, limit := range t.Limits {
+		if limit < 0 {
+			return data, fmt.Errorf("invalid limit %d (expected a number of keys, or 0 for all)", limit)
+		}
+	}
+	return t.truncate(data, Path{})
+}
+
+func (t TruncateTransformer) truncate(data interface{}, at Path) (interface{}, error) {
+	limit := 0
+	if len(t.Limits) > len(at) {
+		limit = t.Limits[len(at)]
+	} else if len(t.Limits) > 0 {
+		limit = t.Limits[len(t.Limits)-1]
+	}
+	if keys := mapKeys(data); keys != nil {
+		object, set := newObjectLike(data)
+		for n, key := range keys {
+			if limit > 0 && n == limit {
+				if t.Marker != "" {
+					set(t.Marker, int64(len(keys)-limit))
+				}
+				break
+			} else if limit > 0 && limit < len(keys) && t.Marker != "" && key == t.Marker {
+				return data, fmt.Errorf("the marker '%s' is a key of the object at %s", t.Marker, describePath(at))
+			}
+			value, _ := mapValue(data, key)
+			truncated, err := t.truncate(value, at.append(PathSegment{Key: key}))
+			if err != nil {
+				return data, err
+			}
+			set(key, truncated)
+		}
+		return object, nil
+	}
+	value := reflect.ValueOf(data)
+	if isNil(data) || value.Kind() != reflect.Slice {
+		return data, nil
+	}
+	elements := []interface{}{}
+	for i := 0; i < value.Len(); i++ {
+		if limit > 0 && i == limit {
+			if t.Marker != "" {
+				elements = append(elements, t.Marker)
+			}
+			break
+		}
+		truncated, err := t.truncate(value.Index(i).Interface(), at.append(PathSegment{Index: i, IsIndex: true}))
+		if err != nil {
+			return data, err
+		}
+		elements = append(elements, truncated)
+	}
+	return elements, nil
+}
+
 // Modifies or converts strings and returns either the original string or the modified one.
 type StringConverter func(s string) interface{}
 
@@ -88,15 +1602,43 @@ func (t callingTransformer) Transform(data interface{}) (interface{}, error) {
 func (t callingTransformer) transformInterface(data interface{}) (interface{}, error) {
 	switch d := data.(type) {
 	case string:
+		if t.stringTransformer == nil {
+			return data, nil
+		}
 		return t.stringTransformer(d), nil
 	case float64:
+		if t.float64Transformer == nil {
+			return data, nil
+		}
 		return t.float64Transformer(d), nil
 	case float32:
+		if t.float64Transformer == nil {
+			return float64(d), nil
+		}
 		return t.float64Transformer(float64(d)), nil
 	case complex128:
+		if t.complex128Transformer == nil {
+			return data, nil
+		}
 		return t.complex128Transformer(d), nil
 	case complex64:
+		if t.complex128Transformer == nil {
+			return complex128(d), nil
+		}
 		return t.complex128Transformer(complex128(d)), nil
+	case *OrderedMap:
+		return t.transformOrderedMap(d)
+	case map[string]interface{}:
+		// the types produced by the decoders avoid reflection
+		if d == nil {
+			return nil, nil
+		}
+		return t.transformStringMap(d)
+	case []interface{}:
+		if d == nil {
+			return nil, nil
+		}
+		return t.transformSlice(d)
 	default:
 		if isNil(data) {
 			return nil, nil
@@ -106,7 +1648,13 @@ func (t callingTransformer) transformInterface(data interface{}) (interface{}, e
 		case reflect.Map:
 			return t.transformMap(reflect.ValueOf(data))
 		case reflect.Slice, reflect.Array:
-			return t.transformSlice(data.([]interface{}))
+			// e.g. the []string of line formats
+			value := reflect.ValueOf(data)
+			elements := make([]interface{}, value.Len())
+			for n := range elements {
+				elements[n] = value.Index(n).Interface()
+			}
+			return t.transformSlice(elements)
 		default:
 			return data, nil
 		}
@@ -130,6 +1678,9 @@ func (t callingTransformer) transformMap(data reflect.Value) (interface{}, error
 		data.SetMapIndex(k, reflect.ValueOf(d))
 	}
 
+	if t.kvSelector == nil {
+		return data.Interface(), nil
+	}
 	for _, k := range data.MapKeys() {
 		v := data.MapIndex(k)
 		if !t.kvSelector(k.Interface(), v.Interface()) {
@@ -139,6 +1690,60 @@ func (t callingTransformer) transformMap(data reflect.Value) (interface{}, error
 	return data.Interface(), nil
 }
 
+// Like transformMap (which removes keys whose value became nil).
+func (t callingTransformer) transformStringMap(data map[string]interface{}) (interface{}, error) {
+	for k, v := range data {
+		if isNil(v) {
+			continue // do not remove nil values here by accident
+		}
+		d, err := t.transformInterface(v)
+		if err != nil {
+			return data, err
+		}
+		if d == nil {
+			delete(data, k)
+		} else {
+			data[k] = d
+		}
+	}
+	if t.kvSelector == nil {
+		return data, nil
+	}
+	for k, v := range data {
+		if !t.kvSelector(k, v) {
+			delete(data, k)
+		}
+	}
+	return data, nil
+}
+
+func (t callingTransformer) transformOrderedMap(data *OrderedMap) (interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+	for _, k := range data.Keys() {
+		v, _ := data.Get(k)
+		if isNil(v) {
+			continue // do not remove nil values here by accident
+		}
+		d, err := t.transformInterface(v)
+		if err != nil {
+			return data, err
+		}
+		data.Set(k, d)
+	}
+	if t.kvSelector == nil {
+		return data, nil
+	}
+	for _, k := range append([]string{}, data.Keys()...) {
+		v, _ := data.Get(k)
+		if !t.kvSelector(k, v) {
+			data.Delete(k)
+		}
+	}
+	return data, nil
+}
+
 func (t callingTransformer) transformSlice(data []interface{}) (interface{}, error) {
 	if isNil(data) {
 		return data, nil
@@ -150,33 +1755,41 @@ func (t callingTransformer) transformSlice(data []interface{}) (interface{}, err
 		}
 		data[n] = d
 	}
-	for n := 0; n < len(data); n++ {
-		if !t.sliceSelector(data[n]) {
-			if n == 0 {
-				data = data[1:]
-			} else if n == (len(data) - 1) {
-				data = data[:n]
-			} else {
-				data = append(data[:n], data[n+1:]...)
-			}
-			n--
+	if t.sliceSelector == nil {
+		return data, nil
+	}
+	// filtered in place rather than by removing elements one at a time
+	selected := data[:0]
+	for _, element := range data {
+		if t.sliceSelector(element) {
+			selected = append(selected, element)
 		}
 	}
-	return data, nil
+	return selected, nil
 }
 
-// A transformer that applies other transformers in sequence.
+// A transformer that applies other transformers in sequence. If Log is set,
+// a line is written to it for each step (see WithStepLog).
 type TransformerPipeline struct {
 	Transformers []Transformer
+	Log          io.Writer
+	// The number of the pipeline as a step of an enclosing one, e.g. "2."
+	step string
 }
 
 func (m TransformerPipeline) Transform(value interface{}) (interface{}, error) {
 	var err error
-	for _, t := range m.Transformers {
+	for n, t := range m.Transformers {
 		if t == nil {
 			continue // ignore silently
 		}
-		value, err = t.Transform(value)
+		if m.Log == nil {
+			value, err = t.Transform(value)
+		} else {
+			before, start := summarizeData(value), time.Now()
+			value, err = t.Transform(value)
+			m.logStep(n, t, before, summarizeData(value), time.Since(start), err)
+		}
 		if err != nil {
 			return value, err
 		}
@@ -184,6 +1797,53 @@ func (m TransformerPipeline) Transform(value interface{}) (interface{}, error) {
 	return value, nil
 }
 
+// Writes a line of key=value pairs on a step, e.g.
+// `step=2.1 transformer=FoldKeysTransformer before=object/3 after=object/2 duration=15µs`.
+func (m TransformerPipeline) logStep(n int, t Transformer, before string, after string, duration time.Duration, err error) {
+	line := fmt.Sprintf("step=%s%d transformer=%s before=%s after=%s duration=%s",
+		m.step, n+1, strings.TrimPrefix(fmt.Sprintf("%T", t), "main."), before, after, duration)
+	if err != nil {
+		line += fmt.Sprintf(" error=%q", err.Error())
+	}
+	fmt.Fprintln(m.Log, line)
+}
+
+// Returns the type of the data and, for objects, arrays and strings, their
+// size (keys, elements or characters), e.g. `object/3`.
+func summarizeData(data interface{}) string {
+	switch {
+	case isObject(data):
+		return fmt.Sprintf("object/%d", len(mapKeys(data)))
+	case describeType(data) == "an array":
+		return fmt.Sprintf("array/%d", reflect.ValueOf(data).Len())
+	}
+	if s, ok := data.(string); ok {
+		return fmt.Sprintf("string/%d", len([]rune(s)))
+	}
+	return scalarType(data)
+}
+
+// Returns the transformer as a pipeline logging each step to the writer,
+// including the steps of the pipelines in it (numbered like `2.1`).
+func WithStepLog(t Transformer, w io.Writer) Transformer {
+	if _, ok := t.(TransformerPipeline); !ok {
+		t = NewMultiTransformer(t)
+	}
+	return withStepLog(t, w, "")
+}
+
+func withStepLog(t Transformer, w io.Writer, step string) Transformer {
+	pipeline, ok := t.(TransformerPipeline)
+	if !ok {
+		return t
+	}
+	steps := make([]Transformer, len(pipeline.Transformers))
+	for n, transformer := range pipeline.Transformers {
+		steps[n] = withStepLog(transformer, w, fmt.Sprintf("%s%d.", step, n+1))
+	}
+	return TransformerPipeline{Transformers: steps, Log: w, step: step}
+}
+
 func NewMultiTransformer(transformers ...Transformer) Transformer {
 	var transformer TransformerPipeline = TransformerPipeline{
 		Transformers: make([]Transformer, 0),
@@ -202,38 +1862,15 @@ func NewConfigurableTransformer(s StringConverter, f Float64Converter, c Complex
 		return NopTransformer{}
 	}
 
-	transformer := callingTransformer{}
-	if s == nil {
-		transformer.stringTransformer = func(s string) interface{} { return s }
-	} else {
-		transformer.stringTransformer = s
-	}
-
-	if f == nil {
-		transformer.float64Transformer = func(f float64) interface{} { return f }
-	} else {
-		transformer.float64Transformer = f
-	}
-
-	if c == nil {
-		transformer.complex128Transformer = func(c complex128) interface{} { return c }
-	} else {
-		transformer.complex128Transformer = c
-	}
-
-	if es == nil {
-		transformer.sliceSelector = func(element interface{}) bool { return true }
-	} else {
-		transformer.sliceSelector = es
-	}
-
-	if kv == nil {
-		transformer.kvSelector = func(key, value interface{}) bool { return true }
-	} else {
-		transformer.kvSelector = kv
+	// missing converters and selectors are left nil so that values are kept
+	// as they are (without converting them to interface values again)
+	return callingTransformer{
+		stringTransformer:     s,
+		float64Transformer:    f,
+		complex128Transformer: c,
+		sliceSelector:         es,
+		kvSelector:            kv,
 	}
-
-	return transformer
 }
 
 func NonNilElementSelector(element interface{}) bool {
@@ -262,6 +1899,50 @@ func NonNilKeyValueSelector(key interface{}, value interface{}) bool {
 	return !isNil(key) && !isNil(value)
 }
 
+// The strings read as booleans by default (see StringToBoolParser).
+var (
+	DefaultTrueStrings  = []string{"true", "yes", "on"}
+	DefaultFalseStrings = []string{"false", "no", "off"}
+)
+
+// Returns a converter replacing the strings (compared without case and
+// surrounding spaces) by true or false. A string cannot be in both lists.
+func StringToBoolParser(trueStrings []string, falseStrings []string) (StringConverter, error) {
+	values := make(map[string]bool, len(trueStrings)+len(falseStrings))
+	for _, s := range trueStrings {
+		values[strings.ToLower(strings.TrimSpace(s))] = true
+	}
+	for _, s := range falseStrings {
+		normalized := strings.ToLower(strings.TrimSpace(s))
+		if values[normalized] {
+			return nil, fmt.Errorf("'%s' cannot be both true and false", s)
+		}
+		values[normalized] = false
+	}
+	return func(s string) interface{} {
+		if b, ok := values[strings.ToLower(strings.TrimSpace(s))]; ok {
+			return b
+		}
+		return s
+	}, nil
+}
+
+// Returns a converter applying the converters in order as long as the
+// value is a string.
+func ChainStringConverters(converters ...StringConverter) StringConverter {
+	return func(s string) interface{} {
+		var value interface{} = s
+		for _, converter := range converters {
+			str, ok := value.(string)
+			if !ok {
+				break
+			}
+			value = converter(str)
+		}
+		return value
+	}
+}
+
 func StringToFiniteNumberParser(s string) interface{} {
 	return CustomStringNumberParser(s, 64, 64, true)
 }
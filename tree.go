@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// An output "format" writing an indented outline of the data like a file
+// system tree: keys and array indices with the types of their values (but
+// not the values themselves). Levels deeper than MaxDepth (if positive) are
+// omitted, their containers only show the number of children.
+type TreeFormat struct {
+	MaxDepth int
+}
+
+func (f TreeFormat) Name() string {
+	return "tree"
+}
+
+func (f TreeFormat) SupportedExtensions() []string {
+	return []string{".txt"}
+}
+
+func (f TreeFormat) Marshal(data interface{}, w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(treeLabel(data))
+	b.WriteString("\n")
+	f.writeChildren(&b, data, "", 1)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (f TreeFormat) writeChildren(b *strings.Builder, data interface{}, indent string, depth int) {
+	if f.MaxDepth > 0 && depth > f.MaxDepth {
+		return
+	}
+	names, values := treeChildren(data)
+	for i, name := range names {
+		branch, continuation := "├─ ", "│  "
+		if i == len(names)-1 {
+			branch, continuation = "└─ ", "   "
+		}
+		fmt.Fprintf(b, "%s%s%s: %s\n", indent, branch, name, treeLabel(values[i]))
+		f.writeChildren(b, values[i], indent+continuation, depth+1)
+	}
+}
+
+// Returns the names (keys or indices) and values of the children of a map or
+// array, nil for anything else.
+func treeChildren(data interface{}) ([]string, []interface{}) {
+	if keys := mapKeys(data); keys != nil {
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			values[i], _ = mapValue(data, key)
+		}
+		return keys, values
+	}
+	if isNil(data) {
+		return nil, nil
+	}
+	value := reflect.ValueOf(data)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return nil, nil
+	}
+	names := make([]string, value.Len())
+	values := make([]interface{}, value.Len())
+	for i := range names {
+		names[i] = fmt.Sprintf("[%d]", i)
+		values[i] = value.Index(i).Interface()
+	}
+	return names, values
+}
+
+func treeLabel(value interface{}) string {
+	names, _ := treeChildren(value)
+	switch describeType(value) {
+	case "an object":
+		return "object (" + pluralize(len(names), "key") + ")"
+	case "an array":
+		return "array (" + pluralize(len(names), "element") + ")"
+	default:
+		return scalarType(value)
+	}
+}
+
+func pluralize(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
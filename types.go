@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The types values can be coerced to (see TypeCoercionTransformer).
+var coercibleTypes = []string{valueTypeInt, valueTypeFloat, valueTypeBool, valueTypeString, valueTypeNull}
+
+// The type of the scalars matching a path (which may have wildcards).
+type TypeDeclaration struct {
+	Path Path
+	Type string
+}
+
+// Parses type declarations given as an object mapping paths to types, e.g.
+// `{"servers[].port": "int", "zip": "string"}`, the most specific paths
+// first (see compareSpecificity) so that the order of the keys (which most
+// formats do not keep) does not matter.
+func ParseTypeDeclarations(data interface{}) ([]TypeDeclaration, error) {
+	if !isObject(data) {
+		return nil, fmt.Errorf("the types must be an object mapping paths to types but are %s", describeType(data))
+	}
+	var declarations []TypeDeclaration
+	for _, key := range mapKeys(data) {
+		path, err := ParsePath(key)
+		if err != nil {
+			return nil, err
+		}
+		value, _ := mapValue(data, key)
+		name, ok := value.(string)
+		if !ok || !containsFold(name, coercibleTypes) {
+			return nil, fmt.Errorf("invalid type %v of '%s' (expected one of %s)", value, key, strings.Join(coercibleTypes, ", "))
+		}
+		declarations = append(declarations, TypeDeclaration{Path: path, Type: strings.ToLower(name)})
+	}
+	sort.Slice(declarations, func(i, j int) bool {
+		if c := compareSpecificity(declarations[i].Path, declarations[j].Path); c != 0 {
+			return c < 0
+		}
+		return declarations[i].Path.String() < declarations[j].Path.String()
+	})
+	return declarations, nil
+}
+
+// Compares paths segment by segment from the start, returning a negative
+// number if a is more specific than b: keys and indices are more specific
+// than `*` and `[]`, which are more specific than the end of a path, which is
+// more specific than `**` (so that `**.a` comes before `**` and `a` before
+// `a.**`).
+func compareSpecificity(a, b Path) int {
+	rank := func(p Path, n int) int {
+		switch {
+		case n >= len(p):
+			return 2
+		case p[n].Recursive:
+			return 3
+		case p[n].Wildcard:
+			return 1
+		}
+		return 0
+	}
+	for n := 0; n < len(a) || n < len(b); n++ {
+		if c := rank(a, n) - rank(b, n); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// A transformer coercing the scalars at the declared paths to their types:
+// strings are parsed (booleans as with StringToBoolParser and the default
+// strings unless TrueStrings or FalseStrings are given, and nulls from empty
+// strings and `null`), numbers are converted between ints and floats (if
+// integral), and any scalar can become a string. A value that cannot be
+// coerced fails the transformation with its path, unless Lenient is set,
+// which keeps it as it is. Where several declarations match, the first one
+// is used, which is the most specific one if they were parsed with
+// ParseTypeDeclarations.
+//
+// Strings at other paths are passed to Fallback if set, e.g. to parse all
+// other numbers, so that the declarations take precedence over it.
+type TypeCoercionTransformer struct {
+	Types        []TypeDeclaration
+	Lenient      bool
+	TrueStrings  []string
+	FalseStrings []string
+	Fallback     StringConverter
+}
+
+func (t TypeCoercionTransformer) Transform(data interface{}) (interface{}, error) {
+	trueStrings, falseStrings := t.TrueStrings, t.FalseStrings
+	if len(trueStrings) == 0 && len(falseStrings) == 0 {
+		trueStrings, falseStrings = DefaultTrueStrings, DefaultFalseStrings
+	}
+	bools, err := StringToBoolParser(trueStrings, falseStrings)
+	if err != nil {
+		return data, err
+	}
+	return transformLeaves(data, func(value interface{}, at Path) (interface{}, error) {
+		for _, declaration := range t.Types {
+			if !declaration.Path.matches(at) {
+				continue
+			}
+			coerced, ok := coerceValue(value, declaration.Type, bools)
+			if !ok && !t.Lenient {
+				return value, fmt.Errorf("the value %s at %s cannot be coerced to %s", describeValue(value), describePath(at), declaration.Type)
+			} else if !ok {
+				return value, nil
+			}
+			return coerced, nil
+		}
+		if s, ok := value.(string); ok && t.Fallback != nil {
+			return t.Fallback(s), nil
+		}
+		return value, nil
+	})
+}
+
+// Returns the value as the type, or false if it cannot be converted.
+func coerceValue(value interface{}, valueType string, bools StringConverter) (interface{}, bool) {
+	s, isString := value.(string)
+	if isString {
+		s = strings.TrimSpace(s)
+	}
+	switch valueType {
+	case valueTypeString:
+		if isString {
+			return value, true
+		} else if b, ok := value.(bool); ok {
+			return strconv.FormatBool(b), true
+		} else if n, ok := value.(json.Number); ok {
+			return n.String(), true
+		}
+		switch v := reflect.ValueOf(value); v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.FormatInt(v.Int(), 10), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return strconv.FormatUint(v.Uint(), 10), true
+		case reflect.Float32, reflect.Float64:
+			return strconv.FormatFloat(v.Float(), 'f', -1, 64), true
+		}
+	case valueTypeNull:
+		if isNil(value) || (isString && (s == "" || s == "null")) {
+			return nil, true
+		}
+	case valueTypeBool:
+		if b, ok := value.(bool); ok {
+			return b, true
+		} else if isString {
+			b, ok := bools(s).(bool)
+			return b, ok
+		}
+	case valueTypeInt:
+		if isString {
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i, true
+			}
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return value, false
+			}
+			value = f
+		}
+		switch v := reflect.ValueOf(value); v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Int(), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if v.Uint() <= math.MaxInt64 {
+				return int64(v.Uint()), true
+			}
+		}
+		// integral floats, as long as they are exact
+		if isNumber(value) {
+			if f := numberValue(value); f == math.Trunc(f) && math.Abs(f) <= maxSafeInteger {
+				return int64(f), true
+			}
+		}
+	case valueTypeFloat:
+		var f float64
+		if isString {
+			var err error
+			if f, err = strconv.ParseFloat(s, 64); err != nil {
+				return value, false
+			}
+		} else if isNumber(value) {
+			f = numberValue(value)
+		} else {
+			return value, false
+		}
+		if !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return f, true
+		}
+	}
+	return value, false
+}
+
+func isNumber(value interface{}) bool {
+	valueType := scalarType(value)
+	return valueType == valueTypeInt || valueType == valueTypeFloat
+}
+
+// Describes a scalar for messages, e.g. 'abc' for strings.
+func describeValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return "'" + s + "'"
+	} else if isNil(value) {
+		return "null"
+	}
+	return fmt.Sprint(value)
+}
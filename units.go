@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	UnitDuration = "duration"
+	UnitSize     = "size"
+)
+
+var parsedUnits = []string{UnitDuration, UnitSize}
+
+// The factors of the byte size suffixes (in lower case). K, M, etc. are
+// decimal unless binary sizes are requested, KiB, MiB, etc. always binary.
+var sizeExponents = map[string]int{"": 0, "b": 0,
+	"k": 1, "kb": 1, "kib": 1, "m": 2, "mb": 2, "mib": 2, "g": 3, "gb": 3, "gib": 3,
+	"t": 4, "tb": 4, "tib": 4, "p": 5, "pb": 5, "pib": 5, "e": 6, "eb": 6, "eib": 6}
+
+var sizePattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]*)?|\.[0-9]+)\s*([a-zA-Z]*)$`)
+
+// A transformer parsing strings with units such as `30s` or `10MB`, e.g. in
+// INI files, into numbers. UnitDuration parses durations as time.ParseDuration
+// does (such as `1h30m`) into nanoseconds, or into their canonical form (such
+// as `1h30m0s`) if Canonical is set. UnitSize parses byte sizes (such as
+// `10MB`, `1.5 GiB` or `512`) into bytes, with K, M, G, T, P and E (with or
+// without B) as powers of 1000 unless Binary is set and KiB, MiB etc. as
+// powers of 1024; fractions of bytes are rounded. Strings that cannot be
+// parsed stay as they are, as do all other values.
+type ParseUnitsTransformer struct {
+	Unit      string
+	Canonical bool
+	Binary    bool
+}
+
+func (t ParseUnitsTransformer) Transform(data interface{}) (interface{}, error) {
+	var parse func(s string) (interface{}, bool)
+	switch strings.ToLower(t.Unit) {
+	case UnitDuration:
+		parse = t.parseDuration
+	case UnitSize:
+		parse = t.parseSize
+	default:
+		return data, fmt.Errorf("unknown unit '%s' (expected one of %s)", t.Unit, strings.Join(parsedUnits, ", "))
+	}
+	return transformLeaves(data, func(value interface{}, at Path) (interface{}, error) {
+		if s, ok := value.(string); ok {
+			if parsed, ok := parse(strings.TrimSpace(s)); ok {
+				return parsed, nil
+			}
+		}
+		return value, nil
+	})
+}
+
+func (t ParseUnitsTransformer) parseDuration(s string) (interface{}, bool) {
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, false
+	} else if t.Canonical {
+		return duration.String(), true
+	}
+	return int64(duration), true
+}
+
+func (t ParseUnitsTransformer) parseSize(s string) (interface{}, bool) {
+	match := sizePattern.FindStringSubmatch(s)
+	if match == nil {
+		return nil, false
+	}
+	suffix := strings.ToLower(match[2])
+	exponent, ok := sizeExponents[suffix]
+	if !ok {
+		return nil, false
+	}
+	base := 1000.0
+	if t.Binary || strings.HasSuffix(suffix, "ib") {
+		base = 1024
+	}
+	if i, err := strconv.ParseInt(match[1], 10, 64); err == nil {
+		// exact for integers (as long as they fit)
+		for ; exponent > 0; exponent-- {
+			if i > math.MaxInt64/int64(base) {
+				return nil, false
+			}
+			i *= int64(base)
+		}
+		return i, true
+	}
+	f, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return nil, false
+	}
+	bytes := math.Round(f * math.Pow(base, float64(exponent)))
+	// float64(math.MaxInt64) is 2^63, which does not fit
+	if bytes >= math.MaxInt64 {
+		return nil, false
+	}
+	return int64(bytes), true
+}
@@ -5,8 +5,11 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 
 	mowcli "github.com/jawher/mow.cli"
@@ -17,7 +20,10 @@ const (
 	exitInputError         int = 1
 	exitOutputError        int = 2
 	exitTransformError     int = 4
+	exitCheckError         int = 8
+	exitSkippedRecords     int = 16
 	exitConfigurationError int = 32
+	exitVerifyError        int = 64
 )
 
 var (
@@ -32,28 +38,97 @@ var (
 		"":     "",
 	}
 
+	// Whether backslash escapes are interpreted in all delimiters (rather than
+	// only in those starting with a backslash).
+	escapeDelimiters bool = false
+
+	// The size of the buffer for writing outputs, no buffer is used if not positive.
+	outputBufferSize int = 64 * 1024
+
+	// The reader used for stdin, which may be replaced by other data.
+	stdin io.Reader = os.Stdin
+
 	// Default messages for certain exit codes.
 	exitMessages map[int]string = map[int]string{
 		exitNoError:            "",
 		exitInputError:         "input error: could not read the data or unmarshal",
 		exitOutputError:        "output error: could not marshal or write the data",
 		exitTransformError:     "transform error: could not transform the data according to the arguments provided",
+		exitCheckError:         "check error: the data did not pass a check",
+		exitSkippedRecords:     "partial input: some records could not be read or transformed and were skipped",
 		exitConfigurationError: "configuration error",
+		exitVerifyError:        "verify error: the output read back differs from the data",
 	}
 )
 
-// Internal tool to convert a user-supplied named character to the actual character.
-func normalizeDelim(label string) string {
-	if len(label) == 1 {
-		return label
+// Converts a user-supplied delimiter to the actual characters: names such as
+// TAB (see namedDelimiters and RegisterDelimiter) take precedence, Go/C-style
+// escapes such as `\x1f` are interpreted if the delimiter starts with a
+// backslash (or escapeDelimiters is set), anything else, e.g. " | ", is used
+// literally.
+func NormalizeDelim(label string) (string, error) {
+	if delim, ok := namedDelimiters[label]; ok {
+		return delim, nil
 	}
-	delim, ok := namedDelimiters[label]
-	if ok {
-		return delim
-	} else {
-		exit(exitConfigurationError, "unknown delimiter '"+label+"'")
-		return "" // unreachable
+	if len(label) > 1 && (escapeDelimiters || strings.HasPrefix(label, "\\")) {
+		return unescapeDelim(label)
 	}
+	return label, nil
+}
+
+func unescapeDelim(label string) (string, error) {
+	var b strings.Builder
+	for rest := label; rest != ""; {
+		r, multibyte, tail, err := strconv.UnquoteChar(rest, 0)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape sequence in delimiter '%s'", label)
+		}
+		if multibyte {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte(byte(r))
+		}
+		rest = tail
+	}
+	return b.String(), nil
+}
+
+// Registers a named delimiter (replacing any existing one with the name).
+// Single characters cannot be names since they are always used literally.
+func RegisterDelimiter(name string, delim string) error {
+	if len([]rune(name)) < 2 {
+		return fmt.Errorf("invalid delimiter name '%s' (at least two characters are required)", name)
+	} else if delim == "" {
+		return fmt.Errorf("the delimiter '%s' must not be empty", name)
+	}
+	namedDelimiters[name] = delim
+	return nil
+}
+
+// A repeatable command line option value that registers named delimiters
+// given as NAME=DELIMITER (where the delimiter may be escaped or a name itself).
+type delimiterDefinitions struct{}
+
+func (delimiterDefinitions) Set(definition string) error {
+	kv := strings.SplitN(definition, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("invalid delimiter definition '%s' (NAME=DELIMITER expected)", definition)
+	}
+	delim, err := NormalizeDelim(kv[1])
+	if err != nil {
+		return err
+	}
+	return RegisterDelimiter(kv[0], delim)
+}
+
+func (delimiterDefinitions) String() string {
+	return ""
+}
+
+func (delimiterDefinitions) Clear() {}
+
+func (delimiterDefinitions) IsDefault() bool {
+	return true
 }
 
 // Read lines from a character stream (as a slice of bytes).
@@ -67,12 +142,55 @@ func readLines(data []byte) ([]string, error) {
 	return lines, scanner.Err()
 }
 
+// Reads the records of a character stream: lines if the delimiter is empty,
+// otherwise the strings between delimiters (without an empty one at the
+// end). Reading stops after max records unless max is not positive, so that
+// the rest of large inputs is not read if it is not needed.
+func readRecords(reader io.Reader, delimiter string, max int) ([]string, error) {
+	scanner := bufio.NewScanner(reader)
+	if delimiter != "" {
+		// unlike lines, records are not limited to the default buffer size
+		scanner.Buffer(nil, math.MaxInt32)
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			if i := bytes.Index(data, []byte(delimiter)); i >= 0 {
+				return i + len(delimiter), data[:i], nil
+			} else if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		})
+	}
+	var records []string
+	for (max <= 0 || len(records) < max) && scanner.Scan() {
+		records = append(records, scanner.Text())
+	}
+	return records, scanner.Err()
+}
+
 // Splits a character stream (as a byte slice) by the given separator and returns the delimited strings.
 func readSeparatedStrings(data []byte, separator string) []string {
 	return strings.Split(string(data), separator)
 }
 
-// Exits the application gracefully and with an error message.
+// Parses a comma-separated list of KEY=VALUE pairs into a map.
+// Surrounding spaces of keys and values are kept as they may be significant.
+func parseKeyValueList(list string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	if list == "" {
+		return pairs, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid key-value pair '%s'", pair)
+		}
+		pairs[kv[0]] = kv[1]
+	}
+	return pairs, nil
+}
+
+// Exits the application gracefully and with an error message
+// (unless messages are suppressed with the quiet option).
 func exit(code int, message string) {
 	if message == "" {
 		msg, found := exitMessages[code]
@@ -82,7 +200,7 @@ func exit(code int, message string) {
 			message = msg
 		}
 	}
-	if message != "" {
+	if message != "" && !quiet {
 		os.Stderr.WriteString(fmt.Sprintln(message))
 	}
 	mowcli.Exit(code)
@@ -98,6 +216,28 @@ func containsFold(value string, slice []string) bool {
 	return false
 }
 
+// Checks if the file is a terminal (or another character device).
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// The languages whose locales write decimal numbers with a comma.
+var decimalCommaLanguages = []string{"bg", "cs", "da", "de", "el", "es", "fi", "fr", "hr", "hu", "id", "it",
+	"nb", "nl", "nn", "no", "pl", "pt", "ro", "ru", "sk", "sl", "sv", "tr", "uk", "vi"}
+
+// Checks if the locale of the environment (LC_ALL, LC_NUMERIC or LANG, e.g.
+// `de_DE.UTF-8`) writes decimal numbers with a comma.
+func localeUsesDecimalComma() bool {
+	for _, variable := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if locale := os.Getenv(variable); locale != "" {
+			language := strings.FieldsFunc(locale, func(r rune) bool { return r == '_' || r == '.' || r == '-' || r == '@' })
+			return len(language) > 0 && containsFold(language[0], decimalCommaLanguages)
+		}
+	}
+	return false
+}
+
 // Creates the actual indentation string of a given length.
 // The indentation is 0 if pretty is false, otherwise of a
 // length of count (if greater than 0) or a default indent,
@@ -112,38 +252,34 @@ func createIndentString(pretty bool, count int) string {
 	}
 }
 
-// A utility function to read, transform, and write data.
-func ConvertStream(reader io.Reader, informat Unmarshaler, transformer Transformer, writer io.Writer, outformat Marshaler) error {
-	data, err := informat.Unmarshal(reader)
+// A utility function to read, transform, and write data. The hooks (if
+// any) are called after each stage of the conversion, see StageHook.
+func ConvertStream(reader io.Reader, informat Unmarshaler, transformer Transformer, writer io.Writer, outformat Marshaler, hooks ...StageHook) error {
+	var data interface{}
+	err := measureStage("read", hooks, func() (_ interface{}, err error) {
+		data, err = informat.Unmarshal(reader)
+		return data, err
+	})
 	if err != nil {
 		return err
 	}
-	var transformed interface{}
-	if transformer != nil {
-		transformed, err = transformer.Transform(data)
-		if err != nil {
-			return err
-		}
-	} else {
-		transformed = data
+	transformed, err := transformStages(data, transformer, hooks)
+	if err != nil {
+		return err
 	}
-	return outformat.Marshal(transformed, writer)
+	return measureStage("write", hooks, func() (interface{}, error) {
+		return nil, outformat.Marshal(transformed, writer)
+	})
 }
 
 // A utility function to read from a file, transform the format, and write the output.
 // It treates empty file names and `-` indicate stdin/stdout.
-func ConvertFile(infile string, informat Unmarshaler, transformer Transformer, outfile string, outformat Marshaler) error {
-	var reader io.Reader
-	if infile == "" || infile == "-" {
-		reader = os.Stdin
-	} else {
-		var file, err = os.OpenFile(infile, os.O_RDONLY, 0)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-		reader = file
+func ConvertFile(infile string, informat Unmarshaler, transformer Transformer, outfile string, outformat Marshaler, hooks ...StageHook) error {
+	reader, err := openInputFile(infile)
+	if err != nil {
+		return err
 	}
+	defer reader.Close()
 
 	var writer io.Writer
 	if outfile == "" || outfile == "-" {
@@ -157,7 +293,154 @@ func ConvertFile(infile string, informat Unmarshaler, transformer Transformer, o
 		writer = file
 	}
 
-	return ConvertStream(reader, informat, transformer, writer, outformat)
+	buffered := newOutputBuffer(writer)
+	err = ConvertStream(reader, informat, transformer, buffered, outformat, hooks...)
+	ferr := measureStage("flush", hooks, func() (interface{}, error) {
+		return nil, buffered.Flush()
+	})
+	if err == nil {
+		err = ferr
+	}
+	return err
+}
+
+type flushingWriter interface {
+	io.Writer
+	Flush() error
+}
+
+type unbufferedWriter struct {
+	io.Writer
+}
+
+func (w unbufferedWriter) Flush() error {
+	return nil
+}
+
+// Wraps the writer in a buffer of the configured output buffer size.
+// The buffer must be flushed when done.
+func newOutputBuffer(writer io.Writer) flushingWriter {
+	if outputBufferSize <= 0 {
+		return unbufferedWriter{writer}
+	}
+	return bufio.NewWriterSize(writer, outputBufferSize)
+}
+
+// A utility function to read and unmarshal a file (or stdin for empty file names and `-`).
+func ReadFile(infile string, informat Unmarshaler) (interface{}, error) {
+	reader, err := openInputFile(infile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return informat.Unmarshal(reader)
+}
+
+// A utility function to read several files (or stdin for `-`) as one continuous
+// stream. Only formats with line-like records can be concatenated, a missing
+// record delimiter at the end of a file is added.
+func ConcatFiles(infiles []string, informat Unmarshaler) (interface{}, error) {
+	delimiter, ok := recordDelimiter(informat)
+	if !ok {
+		return nil, fmt.Errorf("%s files cannot be concatenated, only line-based formats can", formatNameOf(informat))
+	}
+	readers := make([]io.Reader, 0, len(infiles))
+	for _, infile := range infiles {
+		reader, err := openInputFile(infile)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		readers = append(readers, &terminatedReader{reader: reader, terminator: []byte(delimiter)})
+	}
+	return informat.Unmarshal(io.MultiReader(readers...))
+}
+
+// Returns the delimiter between records for line-based formats.
+func recordDelimiter(format Unmarshaler) (string, bool) {
+	switch f := format.(type) {
+	case NDJSONFormat:
+		return "\n", true
+	case TextFormat:
+		if f.RecordDelimiter == "" {
+			return "\n", true
+		}
+		return f.RecordDelimiter, true
+	}
+	return "", false
+}
+
+func formatNameOf(format Unmarshaler) string {
+	if named, ok := format.(FileFormat); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", format)
+}
+
+// A reader that appends the terminator if the (non-empty) data does not end with it.
+type terminatedReader struct {
+	reader     io.Reader
+	terminator []byte
+	tail       []byte
+	suffix     io.Reader
+}
+
+func (r *terminatedReader) Read(p []byte) (int, error) {
+	if r.suffix != nil {
+		return r.suffix.Read(p)
+	}
+	n, err := r.reader.Read(p)
+	r.tail = append(r.tail, p[:n]...)
+	if len(r.tail) > len(r.terminator) {
+		r.tail = r.tail[len(r.tail)-len(r.terminator):]
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	if len(r.tail) > 0 && !bytes.Equal(r.tail, r.terminator) {
+		r.suffix = bytes.NewReader(r.terminator)
+	} else {
+		r.suffix = bytes.NewReader(nil)
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return r.suffix.Read(p)
+}
+
+// A utility function to marshal data into a (new or truncated) file.
+func WriteFile(outfile string, data interface{}, outformat Marshaler) error {
+	file, err := os.Create(outfile)
+	if err != nil {
+		return err
+	}
+	buffered := newOutputBuffer(file)
+	err = outformat.Marshal(data, buffered)
+	if ferr := buffered.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Opens a file for reading, empty file names and `-` indicate stdin.
+func openInputFile(infile string) (io.ReadCloser, error) {
+	if infile == "" || infile == "-" {
+		return ioutil.NopCloser(stdin), nil
+	}
+	return os.OpenFile(infile, os.O_RDONLY, 0)
+}
+
+// Reads the whole input file (stdin for empty names and `-`).
+func readFileContent(fileName string) ([]byte, error) {
+	reader, err := openInputFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
 }
 
 // Check if the value is nil (and doesn't panic if it is not a nil-able type).
@@ -176,6 +459,23 @@ func isNil(value interface{}) bool {
 	return false
 }
 
+// Unwraps interface values and checks if the result can be assigned to the given type.
+// Nil values are converted to the zero value of nil-able types.
+func assignableValue(value reflect.Value, to reflect.Type) (reflect.Value, bool) {
+	for value.IsValid() && value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+	if !value.IsValid() {
+		switch to.Kind() {
+		case reflect.Interface, reflect.Map, reflect.Slice, reflect.Ptr:
+			return reflect.Zero(to), true
+		default:
+			return value, false
+		}
+	}
+	return value, value.Type().AssignableTo(to)
+}
+
 // Convert all strings in a given slice to lower case.
 func sliceToLower(sl []string) []string {
 	var t []string = make([]string, len(sl))
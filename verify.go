@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// An output format that reads its output back after writing it and compares
+// the result with the data written, so that values the format cannot
+// represent (such as dates becoming strings or integers becoming floats)
+// do not get lost silently. Numbers may differ by up to Epsilon.
+type VerifyingFormat struct {
+	InputOutputFormat
+	Epsilon float64
+}
+
+// A difference between the data written and the data read back.
+type VerifyError struct {
+	Path   Path
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("the output differs from the data at %s: %s", describePath(e.Path), e.Reason)
+}
+
+func (f VerifyingFormat) Marshal(data interface{}, w io.Writer) error {
+	buffer := &bytes.Buffer{}
+	if err := f.InputOutputFormat.Marshal(data, buffer); err != nil {
+		return err
+	}
+	if _, err := w.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+	readBack, err := f.InputOutputFormat.Unmarshal(buffer)
+	if err != nil {
+		return fmt.Errorf("the output cannot be read back: %s", err)
+	}
+	return compareData(writtenForm(f.InputOutputFormat, data), readBack, Path{}, f.Epsilon, distinguishesIntegers(f.InputOutputFormat))
+}
+
+// Returns the data as the format reads it back regardless of any loss, i.e.
+// with the structural changes it always makes on output.
+func writtenForm(format Marshaler, data interface{}) interface{} {
+	switch f := format.(type) {
+	case TOMLFormat:
+		if kind := reflect.ValueOf(data).Kind(); kind != reflect.Map && kind != reflect.Struct && !isObject(data) {
+			return map[string]interface{}{NonemptyDefaultKey(f.DefaultKey): data}
+		}
+	case NDJSONFormat:
+		if _, ok := data.([]interface{}); !ok {
+			return []interface{}{data}
+		}
+	case YAMLFormat:
+		if documents, ok := data.([]interface{}); ok && f.MultiDocument && len(documents) == 1 {
+			return documents[0]
+		}
+	}
+	return data
+}
+
+// Checks if the format reads integers and floats as different types. Floats
+// without a fractional part may still be read back as integers, since many
+// inputs (such as JSON) do not tell them apart.
+func distinguishesIntegers(format Marshaler) bool {
+	switch format.(type) {
+	case YAMLFormat, TOMLFormat:
+		return true
+	}
+	return false
+}
+
+// Compares the expected data with the actual data and returns the first
+// difference (in the order of the keys and elements).
+func compareData(expected interface{}, actual interface{}, at Path, epsilon float64, integers bool) error {
+	if x, xInteger, ok := numericValue(expected); ok {
+		y, yInteger, ok := numericValue(actual)
+		switch {
+		case !ok:
+			return &VerifyError{at, fmt.Sprintf("a number was written but %s was read back", describeType(actual))}
+		case integers && xInteger && !yInteger:
+			return &VerifyError{at, "an integer was written but a float was read back"}
+		case math.Abs(x-y) > epsilon && !(math.IsNaN(x) && math.IsNaN(y)) && x != y:
+			return &VerifyError{at, fmt.Sprintf("%v was written but %v was read back", x, y)}
+		}
+		return nil
+	}
+	if isObject(expected) {
+		if !isObject(actual) {
+			return &VerifyError{at, fmt.Sprintf("an object was written but %s was read back", describeType(actual))}
+		}
+		for _, key := range mapKeys(expected) {
+			value, _ := mapValue(expected, key)
+			actualValue, ok := mapValue(actual, key)
+			if !ok {
+				return &VerifyError{at.append(PathSegment{Key: key}), "the key is missing"}
+			}
+			if err := compareData(value, actualValue, at.append(PathSegment{Key: key}), epsilon, integers); err != nil {
+				return err
+			}
+		}
+		for _, key := range mapKeys(actual) {
+			if _, ok := mapValue(expected, key); !ok {
+				return &VerifyError{at.append(PathSegment{Key: key}), "the key was not written"}
+			}
+		}
+		return nil
+	}
+	if describeType(expected) == "an array" {
+		elements, _ := topLevelArray(expected)
+		actualElements, err := topLevelArray(actual)
+		if err != nil {
+			return &VerifyError{at, fmt.Sprintf("an array was written but %s was read back", describeType(actual))}
+		} else if len(elements) != len(actualElements) {
+			return &VerifyError{at, fmt.Sprintf("%d elements were written but %d were read back", len(elements), len(actualElements))}
+		}
+		for n, element := range elements {
+			if err := compareData(element, actualElements[n], at.append(PathSegment{Index: n, IsIndex: true}), epsilon, integers); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if isNil(expected) != isNil(actual) || reflect.TypeOf(expected) != reflect.TypeOf(actual) {
+		return &VerifyError{at, fmt.Sprintf("%s was written but %s was read back", describeType(expected), describeType(actual))}
+	} else if !reflect.DeepEqual(expected, actual) {
+		return &VerifyError{at, fmt.Sprintf("'%v' was written but '%v' was read back", expected, actual)}
+	}
+	return nil
+}
+
+// Returns the value of any kind of number and if it is an integer.
+func numericValue(value interface{}) (float64, bool, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		x, err := v.Float64()
+		return x, !strings.ContainsAny(string(v), ".eE"), err == nil
+	case formattedFloat:
+		return v.value, false, true
+	}
+	number := reflect.ValueOf(value)
+	switch number.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(number.Int()), true, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(number.Uint()), true, true
+	case reflect.Float32, reflect.Float64:
+		return number.Float(), false, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileAppender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	check := func(name string, expected string) {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		} else if string(content) != expected {
+			t.Errorf("unexpected content of %s: %s", name, content)
+		}
+	}
+	appender := FileAppender{File: filepath.Join(dir, "a.json"), Format: JSONFormat{}}
+	for _, data := range []interface{}{[]interface{}{1.0}, []interface{}{2.0, "x"}, []interface{}{}} {
+		if err = appender.Append(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("a.json", `[1,2,"x"]`)
+	if err = appender.Append(map[string]interface{}{"a": 1.0}); err == nil {
+		t.Error("object appended to an array")
+	}
+	appender.Wrap = true
+	if err = appender.Append(map[string]interface{}{"a": 1.0}); err != nil {
+		t.Fatal(err)
+	}
+	check("a.json", `[1,2,"x",{"a":1}]`)
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "b.jsonl"), []byte(`{"a":1}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	appender = FileAppender{File: filepath.Join(dir, "b.jsonl"), Format: NDJSONFormat{}}
+	if err = appender.Append([]interface{}{"x", 2.0}); err != nil {
+		t.Fatal(err)
+	}
+	check("b.jsonl", "{\"a\":1}\n\"x\"\n2\n")
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "a.json.lock"), nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+	appender = FileAppender{File: filepath.Join(dir, "a.json"), Format: JSONFormat{}}
+	if err = appender.Append([]interface{}{}); err == nil || !strings.Contains(err.Error(), "a.json.lock") {
+		t.Errorf("concurrent append not rejected: %v", err)
+	}
+	check("a.json", `[1,2,"x",{"a":1}]`)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const commentedYAML = `# header
+b: 1 # line
+# about a
+a:
+  - null
+  - 2 # two
+c: ~
+---
+d: 1
+# footer
+`
+
+func commentTest(t *testing.T, transformer Transformer, expected string, outputFormat YAMLFormat) {
+	inputFormat, transformer := preserveComments(YAMLFormat{}, transformer, outputFormat)
+	if !inputFormat.(YAMLFormat).Nodes {
+		t.Fatalf("%T not applied to nodes", transformer)
+	}
+	convertTransformAndTest(t, commentedYAML, expected, inputFormat, transformer, outputFormat)
+}
+
+func TestPreserveComments(t *testing.T) {
+	commentTest(t, NewMultiTransformer(NopTransformer{}), `# header
+b: 1 # line
+# about a
+a:
+  - null
+  - 2 # two
+c: ~
+---
+d: 1
+# footer
+`, YAMLFormat{MultiDocument: true})
+	commentTest(t, NilRemovalTransformer{RemoveNilValues: true, RemoveNilElements: true}, `- # header
+  b: 1 # line
+  # about a
+  a:
+    - 2 # two
+- d: 1
+  # footer
+`, YAMLFormat{})
+	commentTest(t, NewMultiTransformer(NopTransformer{}, SortKeysTransformer{}), `# about a
+a:
+  - null
+  - 2 # two
+# header
+b: 1 # line
+c: ~
+---
+d: 1
+# footer
+`, YAMLFormat{MultiDocument: true})
+
+	_, transformer := preserveComments(YAMLFormat{}, NewMultiTransformer(NopTransformer{}, EnsureArrayTransformer{}), YAMLFormat{})
+	if _, ok := transformer.(nodeTransformerAdapter); ok {
+		t.Error("transformer without node support applied to nodes")
+	}
+}
+
+const commentedTOML = `# settings
+title = "a b"  # the title
+
+[owner]
+  # who
+  name = "x y"
+  tags = [ "a b",   # first
+    "c" ]
+
+[[servers]]
+host = "h 1" # main
+`
+
+func tomlLayoutTest(t *testing.T, transformer Transformer, expected string) {
+	inputFormat, transformer := preserveComments(TOMLFormat{}, transformer, TOMLFormat{})
+	convertTransformAndTest(t, commentedTOML, expected, inputFormat, transformer, TOMLFormat{})
+}
+
+func TestPreserveTOMLLayout(t *testing.T) {
+	tomlLayoutTest(t, NopTransformer{}, commentedTOML)
+	paths, _ := ParsePaths("owner.name")
+	tomlLayoutTest(t, PathScopedTransformer{Paths: paths, Transformer: URLEncodeTransformer{}},
+		strings.Replace(commentedTOML, `name = "x y"`, `name = "x+y"`, 1))
+	paths, _ = ParsePaths("servers[0].host,title")
+	tomlLayoutTest(t, EnsureArrayTransformer{Paths: paths},
+		strings.Replace(strings.Replace(commentedTOML, `host = "h 1"`, `host = ["h 1"]`, 1), `title = "a b"`, `title = ["a b"]`, 1))
+
+	// values spanning lines cannot be changed in the text
+	paths, _ = ParsePaths("owner.tags")
+	_, output, err := processString(commentedTOML, TOMLFormat{KeepLayout: true},
+		tomlLayoutTransformer{PathScopedTransformer{Paths: paths, Transformer: URLEncodeTransformer{}}}, TOMLFormat{})
+	if err != nil || strings.Contains(output, "#") || !strings.Contains(output, `"a+b"`) {
+		t.Errorf("unexpected output for a multi-line value: '%s' (%v)", output, err)
+	}
+}
+
+func TestScanTOML(t *testing.T) {
+	spans, err := scanTOML("a.b = 1\n[[t]]\n[[t]]\n'c d' = { x = [1, \"]\"] } # c\n[t.u]\nv = \"\"\"\nx\"\"\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a\x00b", "t\x00#1\x00c d", "t\x00#1\x00u\x00v"} {
+		if _, ok := spans[key]; !ok {
+			t.Errorf("key %q not found in %v", key, spans)
+		}
+	}
+	if span := spans["t\x00#1\x00c d"]; span.end-span.start != len(`{ x = [1, "]"] }`) {
+		t.Errorf("unexpected span of an inline table: %v", span)
+	}
+}
@@ -1,6 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -32,10 +41,13 @@ var (
 	yamlInputFormat, _      = NewInputFormat("", "YAML", "", "")
 	csfCommaInputFormat, _  = NewInputFormat("", "csf", ",", "NL")
 	csfCustomInputFormat, _ = NewInputFormat("", "csf", ",", "|")
+	csfHeaderInputFormat    = TextFormat{RecordDelimiter: "", FieldDelimiter: ",", Header: true}
+	ndjsonInputFormat, _    = NewInputFormat("", "jsonl", "", "")
 
-	jsonOutputFormat, _ = NewOutputFormat("", "JSON", false)
-	yamlOutputFormat, _ = NewOutputFormat("", "yaml", false)
-	tomlOutputFormat, _ = NewOutputFormat("", "TOML", false)
+	jsonOutputFormat, _   = NewOutputFormat("", "JSON", false)
+	yamlOutputFormat, _   = NewOutputFormat("", "yaml", false)
+	tomlOutputFormat, _   = NewOutputFormat("", "TOML", false)
+	ndjsonOutputFormat, _ = NewOutputFormat("", "NDJSON", false)
 
 	jsonIndentedOutputFormat, _ = NewOutputFormat("", "json", true)
 	yamlIndentedOutputFormat    = YAMLFormat{PrettyPrint: true, Indentation: 8}
@@ -77,6 +89,27 @@ func TestYamlToJson(t *testing.T) {
 	convertAndTest(t, test_yaml, `[{"a":"b"},{"c":1},null,{"d":"e f"}]`, yamlInputFormat, jsonOutputFormat)
 }
 
+func TestYamlMergeKeys(t *testing.T) {
+	convertAndTest(t, `x-defaults: &defaults
+  restart: always
+  env: {A: 1}
+x-image: &image
+  image: b
+  restart: never
+services:
+  web:
+    <<: [*defaults, *image]
+    image: a
+  db:
+    <<: *defaults
+    logging:
+      <<: *image
+`, `{"services":{"db":{"env":{"A":1},"logging":{"image":"b","restart":"never"},"restart":"always"},`+
+		`"web":{"env":{"A":1},"image":"a","restart":"always"}},`+
+		`"x-defaults":{"env":{"A":1},"restart":"always"},"x-image":{"image":"b","restart":"never"}}`,
+		yamlInputFormat, jsonOutputFormat)
+}
+
 func TestYamlToToml(t *testing.T) {
 	convertAndTest(t, `{"a": 1, "b": 0, "c": -0.3}`, `a = 1
 b = 0
@@ -111,6 +144,8 @@ func TestCsfToYaml(t *testing.T) {
 func TestCsfToToml(t *testing.T) {
 	convertTransformAndTest(t, test_csf, `_ = [["a", "b", "c"], [1, 2, 3]]
 `, csfCommaInputFormat, jsonNumberTransformer, tomlOutputFormat)
+	convertTransformAndTest(t, test_csf, `data = [["a", "b", "c"], [1, 2, 3]]
+`, csfCommaInputFormat, jsonNumberTransformer, TOMLFormat{DefaultKey: "data"})
 }
 
 func TestCsfParsing(t *testing.T) {
@@ -120,14 +155,155 @@ func TestCsfParsing(t *testing.T) {
 		csfCustomInputFormat, jsonNumberTransformer, jsonOutputFormat)
 }
 
+func TestCsfHeaderToJson(t *testing.T) {
+	convertTransformAndTest(t, test_csf+"4,5\n", `[{"a":1,"b":2,"c":3},{"a":4,"b":5}]`,
+		csfHeaderInputFormat, jsonNumberTransformer, jsonOutputFormat)
+}
+
+func TestCsfHeaderRename(t *testing.T) {
+	format := csfHeaderInputFormat
+	format.HeaderRename = map[string]string{"First Name": "first_name", "x": "y"}
+	convertAndTest(t, "First Name,Age\nAda,36\n", `[{"Age":"36","first_name":"Ada"}]`,
+		format, jsonOutputFormat)
+}
+
+func TestCsfHeaderPreservesColumnOrder(t *testing.T) {
+	format := csfHeaderInputFormat
+	format.PreserveOrder = true
+	input := "zeta,alpha,mid\n1,x,\n2,y,z\n"
+	convertTransformAndTest(t, input, `[{"zeta":1,"alpha":"x","mid":""},{"zeta":2,"alpha":"y","mid":"z"}]`,
+		format, jsonNumberTransformer, jsonOutputFormat)
+	convertAndTest(t, input, `- zeta: "1"
+  alpha: x
+  mid: ""
+- zeta: "2"
+  alpha: "y"
+  mid: z
+`, format, yamlOutputFormat)
+	convertAndTest(t, "b,a\n1,2\n", `[[_]]
+a = "2"
+b = "1"
+`, format, tomlOutputFormat)
+}
+
+func TestCsfSkipRows(t *testing.T) {
+	format := csfHeaderInputFormat
+	format.SkipRows = 2
+	convertAndTest(t, "Title\n\na,b\n1,2\n", `[{"a":"1","b":"2"}]`, format, jsonOutputFormat)
+	format.SkipRows = 5
+	convertAndTest(t, "Title\n\na,b\n1,2\n", `[]`, format, jsonOutputFormat)
+}
+
+func TestFixedWidth(t *testing.T) {
+	input := "NAME  AGE  CITY\nAnn   30   Rome\nBob   4\n\nCé    25   New York\n"
+	convertAndTest(t, input, `[["NAME","AGE","CITY"],["Ann","30","Rome"],["Bob","4",""],["Cé","25","New York"]]`,
+		FixedWidthFormat{Widths: []int{6, 5, 4}}, jsonOutputFormat)
+	convertAndTest(t, input, `[{"AGE":"4","CITY":"","NAME":"Bob"}]`,
+		FixedWidthFormat{Widths: []int{6, 5, 4}, Header: true, SkipRecords: 1, RecordLimit: 1}, jsonOutputFormat)
+	convertAndTest(t, input, `[{"a":"Ann   ","b":"30   ","c":"Rome"}]`,
+		FixedWidthFormat{Widths: []int{6, 5, 4}, Names: []string{"a", "b", "c"}, Header: true, KeepPadding: true, RecordLimit: 1},
+		jsonOutputFormat)
+
+	strict := FixedWidthFormat{Widths: []int{6, 5, 4}, Strict: true}
+	if _, _, err := processString(input, strict, nil, jsonOutputFormat); err == nil {
+		t.Error("long line not rejected")
+	}
+	strict.OnRecordError = func(int, error) bool { return true }
+	convertAndTest(t, input, `[["NAME","AGE","CITY"],["Ann","30","Rome"],["Bob","4",""]]`, strict, jsonOutputFormat)
+
+	widths, names, err := ParseFieldWidths("name:10, age:5")
+	if err != nil || fmt.Sprint(widths) != "[10 5]" || strings.Join(names, ",") != "name,age" {
+		t.Errorf("unexpected fields %v %v (%v)", widths, names, err)
+	}
+	for _, invalid := range []string{"10,x", "a:10,5", "0", ":5"} {
+		if _, _, err := ParseFieldWidths(invalid); err == nil {
+			t.Errorf("invalid widths '%s' not rejected", invalid)
+		}
+	}
+}
+
+func TestFixedWidthExport(t *testing.T) {
+	input := `[{"name":"Ann","age":30,"city":"Rome"},{"name":"Bartholomew","age":4.5}]`
+	convertAndTest(t, input, "30 RomeAnn        \n4.5    Bartholomew\n", jsonInputFormat, FixedWidthFormat{})
+	convertAndTest(t, input, "agecityname       \n 30RomeAnn        \n4.5    Bartholomew\n", jsonInputFormat,
+		FixedWidthFormat{Header: true, AlignNumbers: true})
+	convertAndTest(t, input, "Ann  30  \nBarth4.5 \n", jsonInputFormat,
+		FixedWidthFormat{Names: []string{"name", "age"}, Widths: []int{5, 4}, Truncate: true})
+	convertAndTest(t, `[[1,"é"],[22]]`, " 1é\n22 \n", jsonInputFormat, FixedWidthFormat{AlignNumbers: true})
+
+	for _, format := range []FixedWidthFormat{{Widths: []int{6, 3, 4}}, {Widths: []int{6, 4}}} {
+		_, _, err := processString(input, jsonInputFormat, nil, format)
+		var marshalError *MarshalError
+		if !errors.As(err, &marshalError) {
+			t.Errorf("unexpected error for widths %v: %v", format.Widths, err)
+		}
+	}
+}
+
+func TestPresetFormats(t *testing.T) {
+	passwd := "# users\nroot:x:0:0:root:/root:/bin/bash\n\nnobody:x:65534:65534::/nonexistent\n"
+	format, err := NewFormat("", "PASSWD", ",", "NL", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convertAndTest(t, passwd, `[{"gecos":"root","gid":"0","home":"/root","name":"root","passwd":"x","shell":"/bin/bash","uid":"0"},`+
+		`{"gecos":"","gid":"65534","home":"/nonexistent","name":"nobody","passwd":"x","shell":"","uid":"65534"}]`,
+		format.(InputFormat), jsonOutputFormat)
+
+	group := formatPresets["group"]
+	group.PreserveOrder = true
+	convertAndTest(t, "wheel:x:10:root,ann\nusers:x:100:\n", `[{"name":"wheel","passwd":"x","gid":"10","members":["root","ann"]},`+
+		`{"name":"users","passwd":"x","gid":"100","members":[]}]`, group, jsonOutputFormat)
+	group.SkipRecords, group.RecordLimit = 1, 1
+	convertAndTest(t, "a:x:1:\nb:x:2:\nc:x:3:\n", `[{"name":"b","passwd":"x","gid":"2","members":[]}]`, group, jsonOutputFormat)
+
+	group = formatPresets["group"]
+	if _, _, err = processString("a:x:1:\nb:x:2::\n", group, nil, jsonOutputFormat); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("unexpected error for too many fields: %v", err)
+	}
+	group.OnRecordError = func(int, error) bool { return true }
+	convertAndTest(t, "a:x:1:\nb:x:2::\n", `[{"gid":"1","members":[],"name":"a","passwd":"x"}]`, group, jsonOutputFormat)
+}
+
+func TestCsfHeaderErrors(t *testing.T) {
+	_, _, err := processString("a,a\n1,2\n", csfHeaderInputFormat, nil, jsonOutputFormat)
+	if err == nil {
+		t.Error("duplicate header fields not detected")
+	}
+	_, _, err = processString("a,b\n1,2,3\n", csfHeaderInputFormat, nil, jsonOutputFormat)
+	if err == nil {
+		t.Error("records longer than the header not detected")
+	}
+}
+
+func TestCsfExport(t *testing.T) {
+	format, _ := NewOutputFormat("", "csf", false)
+	convertAndTest(t, `{"b": "x", "a": 1, "c": {"d": [1, "<"]}}`, "a;1\nb;x\nc;{\"d\":[1,\"<\"]}\n", jsonInputFormat,
+		TextFormat{FieldDelimiter: ";"})
+	convertAndTest(t, `{"a":1,"b":"x"}`, "a,1\nb,x\n", jsonInputFormat, format)
+	convertAndTest(t, `{"a": 1, "c": {"d": true}}`, "name|value\na|1\nc.d|true\n", jsonInputFormat,
+		TextFormat{FieldDelimiter: "|", Header: true, Columns: []string{"name", "value"}, DottedKeys: true})
+	convertAndTest(t, `[{"a": 1, "b": "x"}, {"b": "y", "c": null}, [1, 2], "s"]`, "a,b,c\n1,x,\n,y,\n1,2\ns\n",
+		jsonInputFormat, TextFormat{FieldDelimiter: ",", Header: true})
+	convertAndTest(t, `["a", "b"]`, "a\x00b\x00", jsonInputFormat, TextFormat{RecordDelimiter: "\000"})
+
+	_, _, err := processString(`[{"a": "x,y"}]`, jsonInputFormat, nil, format)
+	var marshalError *MarshalError
+	if !errors.As(err, &marshalError) || describePath(marshalError.Path) != "'[0].a'" {
+		t.Errorf("field containing the delimiter not rejected: %v", err)
+	}
+}
+
 func TestStrings(t *testing.T) {
 	format, _ := NewInputFormat("", "Strings", "", "")
 	convertAndTest(t, "abc\ndef\n", `["abc","def"]`, format, jsonOutputFormat)
+	convertTransformAndTest(t, "1\n2.5\nx\n", `[1,2.5,"x"]`, format, jsonNumberTransformer, jsonOutputFormat)
 }
 
 func TestNTStrings(t *testing.T) {
 	format, _ := NewInputFormat("", "NTStr", "", "")
 	convertAndTest(t, "a\000b\000", `["a","b"]`, format, jsonOutputFormat)
+	convertTransformAndTest(t, "-1\0003\000", `[-1,3]`, format, jsonNumberTransformer, jsonOutputFormat)
 }
 
 func TestTomlImport(t *testing.T) {
@@ -164,6 +340,59 @@ c = -8
 `, `{"_":{"a":3.14},"b":{"c":-8}}`, format, jsonNumberTransformer, jsonOutputFormat)
 }
 
+func TestConfIniImport(t *testing.T) {
+	for _, name := range []string{"app.conf", "setup.CFG"} {
+		format, err := NewInputFormat(name, "auto", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		convertAndTest(t, "# settings\nuser = nobody\nport=8080\n", `{"_":{"port":"8080","user":"nobody"}}`, format, jsonOutputFormat)
+		convertAndTest(t, "; only comments\n", `{}`, format, jsonOutputFormat)
+	}
+}
+
+func TestIniValueDelimiter(t *testing.T) {
+	input := "hosts = a.com, b.com ,c.com\n[s]\nports = 1|2\nname = x\n"
+	convertTransformAndTest(t, input, `{"_":{"hosts":"a.com, b.com ,c.com"},"s":{"name":"x","ports":[1,2]}}`,
+		INIFormat{ValueDelimiter: "|"}, jsonNumberTransformer, jsonOutputFormat)
+	convertAndTest(t, input, `{"_":{"hosts":["a.com","b.com","c.com"]},"s":{"name":"x","ports":"1|2"}}`,
+		INIFormat{ValueDelimiter: ","}, jsonOutputFormat)
+}
+
+func TestIniEmptyDefault(t *testing.T) {
+	input := "[default]\n\n[s]\na = 1\n"
+	convertAndTest(t, input, `{"s":{"a":"1"}}`, INIFormat{}, jsonOutputFormat)
+	convertAndTest(t, input, `{"_":{},"s":{"a":"1"}}`, INIFormat{KeepEmptyDefault: true}, jsonOutputFormat)
+	convertAndTest(t, "[s]\na = 1\n", `{"s":{"a":"1"}}`, INIFormat{KeepEmptyDefault: true}, jsonOutputFormat)
+	convertAndTest(t, "a = 1\n", `{"_":{"a":"1"}}`, INIFormat{KeepEmptyDefault: true}, jsonOutputFormat)
+}
+
+func TestIniExport(t *testing.T) {
+	input := `{"top": "x", "l": [1, 2], "a": {"k": "v; w", "b": {"c": 1, "d": {"e": true}}}}`
+	for policy, expected := range map[string]string{
+		ININestingFlattenDots:     "l   = 1\nl   = 2\ntop = x\n\n[a]\nb.c   = 1\nb.d.e = true\nk     = `v; w`\n",
+		ININestingFlattenSections: "l   = 1\nl   = 2\ntop = x\n\n[a]\nk = `v; w`\n\n[a.b]\nc = 1\n\n[a.b.d]\ne = true\n",
+	} {
+		convertAndTest(t, input, expected, jsonInputFormat, INIFormat{NestingPolicy: policy})
+	}
+	convertAndTest(t, `{"a": {"b": {"c": 1}}}`, "[a.b]\nc = 1\n", jsonInputFormat,
+		INIFormat{NestingPolicy: ININestingFlattenSections})
+	convertAndTest(t, `{"_": {"a": [1, "b"]}, "c": {}}`, "a = 1,b\n\n[c]\n", jsonInputFormat,
+		INIFormat{ArrayStyle: INIArraysJoined})
+	convertAndTest(t, `"s"`, "_ = s\n", jsonInputFormat, INIFormat{})
+
+	for input, path := range map[string]string{
+		`{"a": {"b": {"c": 1}}}`: "'a.b'",
+		`{"a": [[1]]}`:           "'a[0]'",
+	} {
+		_, _, err := processString(input, jsonInputFormat, nil, INIFormat{})
+		var marshalError *MarshalError
+		if !errors.As(err, &marshalError) || describePath(marshalError.Path) != path {
+			t.Errorf("unexpected error for %s: %v", input, err)
+		}
+	}
+}
+
 func TestStringsIndentedJson(t *testing.T) {
 	format, _ := NewInputFormat("", "strings", "", "")
 	convertAndTest(t, "abc\ndef\n",
@@ -191,6 +420,56 @@ c = 2.0
 `, format, tomlOutputFormat)
 }
 
+func TestYamlMultiDocumentRoundTrip(t *testing.T) {
+	format := YAMLFormat{MultiDocument: true}
+	convertAndTest(t, `[{"a": 1}, [2], null, "b"]`, `a: 1
+---
+- 2
+---
+null
+---
+b
+`, jsonInputFormat, format)
+	convertAndTest(t, test_yaml, `a: b
+---
+c: 1
+---
+null
+---
+d: e f
+`, yamlInputFormat, format)
+	convertAndTest(t, `{"a": [1]}`, `a:
+  - 1
+`, jsonInputFormat, format)
+}
+
+func TestYamlStartAndNullStyles(t *testing.T) {
+	input := `{"a": null, "b": [null, 1]}`
+	for style, expected := range map[string]string{
+		YAMLNullsEmpty: "a:\nb:\n  -\n  - 1\n",
+		YAMLNullsNull:  "a: null\nb:\n  - null\n  - 1\n",
+		YAMLNullsTilde: "a: ~\nb:\n  - ~\n  - 1\n",
+	} {
+		convertAndTest(t, input, expected, jsonInputFormat, YAMLFormat{NullStyle: style})
+	}
+	convertAndTest(t, `[{"a": null}, 1]`, "---\na: ~\n---\n1\n", jsonInputFormat,
+		YAMLFormat{ExplicitStart: true, MultiDocument: true, NullStyle: YAMLNullsTilde})
+	convertAndTest(t, "# c\na: ~ # x\n", "---\n# c\na: # x\n", YAMLFormat{Nodes: true},
+		YAMLFormat{ExplicitStart: true, NullStyle: YAMLNullsEmpty})
+	if err := (YAMLFormat{NullStyle: "none"}).Marshal(nil, ioutil.Discard); err == nil {
+		t.Error("unknown null style not rejected")
+	}
+}
+
+func TestYamlQuoteStrings(t *testing.T) {
+	output := "\"a\":\n  - \"on\"\n  - \"1.0\"\n  - 1.5\n  - null\n  - true\n  - \"x\\ny\"\n\"yes\": \"no\"\n"
+	convertAndTest(t, `{"yes": "no", "a": ["on", "1.0", 1.5, null, true, "x\ny"]}`, output,
+		jsonInputFormat, YAMLFormat{QuoteStrings: true})
+	convertAndTest(t, output, `{"a":["on","1.0",1.5,null,true,"x\ny"],"yes":"no"}`, yamlInputFormat, jsonOutputFormat)
+	convertAndTest(t, "# c\na: b # x\n", "# c\n\"a\": \"b\" # x\n", YAMLFormat{Nodes: true},
+		YAMLFormat{QuoteStrings: true, NullStyle: YAMLNullsTilde})
+}
+
 func TestStringsIndentedYaml(t *testing.T) {
 	format := jsonInputFormat
 	input := `{"a": 1, "b": {"c": 2}}`
@@ -205,3 +484,585 @@ b:
   c: 2
 `, format, yamlOutputFormat)
 }
+
+func TestNdjson(t *testing.T) {
+	convertAndTest(t, "{\"a\":1}\n\n[2, 3]\n\"s\"\n", `[{"a":1},[2,3],"s"]`, ndjsonInputFormat, jsonOutputFormat)
+	convertAndTest(t, `[{"a":1},null]`, "{\"a\":1}\nnull\n", jsonInputFormat, ndjsonOutputFormat)
+	convertAndTest(t, `{"a":1}`, "{\"a\":1}\n", jsonInputFormat, ndjsonOutputFormat)
+}
+
+func TestRecordWindow(t *testing.T) {
+	input := "{\"a\":1}\n{\"a\":2}\n\n{\"a\":3}\nnot JSON\n"
+	convertAndTest(t, input, `[{"a":2},{"a":3}]`, NDJSONFormat{SkipRecords: 1, RecordLimit: 2}, jsonOutputFormat)
+	convertAndTest(t, "{\"a\":1}\n", `[]`, NDJSONFormat{SkipRecords: 5}, jsonOutputFormat)
+	convertAndTest(t, "not JSON\n{\"a\":2}\n", `[{"a":2}]`,
+		NDJSONFormat{SkipRecords: 1, OnRecordError: func(int, error) bool { return false }}, jsonOutputFormat)
+
+	format := csfHeaderInputFormat
+	format.SkipRows, format.SkipRecords, format.RecordLimit = 1, 1, 1
+	convertAndTest(t, "Title\na,b\n1,2\n3,4\n5,6\n", `[{"a":"3","b":"4"}]`, format, jsonOutputFormat)
+	convertAndTest(t, "a\nb\nc\n", `["b","c"]`, TextFormat{RecordDelimiter: "\n", SkipRecords: 1}, jsonOutputFormat)
+
+	// the input after the limit is not read
+	for _, test := range []struct {
+		input    string
+		expected string
+		format   InputFormat
+	}{
+		{"a\nb\nc\n", `["b"]`, TextFormat{SkipRecords: 1, RecordLimit: 1}},
+		{"a;b;;c;;", `["a;b"]`, TextFormat{RecordDelimiter: ";;", RecordLimit: 1}},
+		{"Title;;a,b;;3,4;;5,6;;", `[{"a":"3","b":"4"}]`, TextFormat{FieldDelimiter: ",", RecordDelimiter: ";;", Header: true, SkipRows: 1, RecordLimit: 1}},
+		{"a\nb\nc\n", `[{"x":"a"}]`, FixedWidthFormat{Names: []string{"x"}, Widths: []int{3}, RecordLimit: 1}},
+	} {
+		data, err := test.format.Unmarshal(io.MultiReader(strings.NewReader(test.input), failingReader{}))
+		if err != nil {
+			t.Errorf("%s read past the limit: %v", test.format.Name(), err)
+			continue
+		}
+		if encoded, _ := json.Marshal(data); string(encoded) != test.expected {
+			t.Errorf("unexpected data %s for %s", encoded, test.format.Name())
+		}
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read past the limit")
+}
+
+func TestTomlArrayStyles(t *testing.T) {
+	input := `{"a":[1,[2,"x, ]"]],"b":{"c":["p","q"],"e":[]}}`
+	multiline := TOMLFormat{ArrayStyle: TOMLArraysMultiline}
+	convertAndTest(t, input, "a = [\n  1.0,\n  [2.0, \"x, ]\"],\n]\n\n[b]\nc = [\n  \"p\",\n  \"q\",\n]\ne = []\n",
+		jsonInputFormat, multiline)
+	_, output, _ := processString(input, jsonInputFormat, nil, multiline)
+	// the decoder reads empty arrays as nil
+	convertAndTest(t, output, `{"a":[1,[2,"x, ]"]],"b":{"c":["p","q"],"e":null}}`, TOMLFormat{}, jsonOutputFormat)
+
+	convertAndTest(t, input, "a = [1.0, \"2,x, ]\"]\n\n[b]\nc = \"p,q\"\ne = []\n",
+		jsonInputFormat, TOMLFormat{ArrayStyle: TOMLArraysJoined})
+
+	tables := `{"s":[{"h":"a","p":[1.5],"n":null,"a key":{"x":true}}],"b":{"d":[{"f":[2]}]}}`
+	inline := TOMLFormat{ArrayStyle: TOMLArraysInline, PrettyPrint: true, Floats: FloatFormat{Style: FloatStyleFixed, Precision: 2}}
+	convertAndTest(t, tables, "s = [{\"a key\" = {x = true}, h = \"a\", p = [1.50]}]\n\n[b]\n  d = [{f = [2.00]}]\n",
+		jsonInputFormat, inline)
+	convertAndTest(t, tables, "[b]\n\n  [[b.d]]\n    f = [\n      2.0,\n    ]\n\n[[s]]\n  h = \"a\"\n  p = [\n    1.5,\n  ]\n  [s.\"a key\"]\n    x = true\n",
+		jsonInputFormat, TOMLFormat{ArrayStyle: TOMLArraysMultiline, PrettyPrint: true})
+	_, output, _ = processString(tables, jsonInputFormat, nil, inline)
+	convertAndTest(t, output, `{"b":{"d":[{"f":[2]}]},"s":[{"a key":{"x":true},"h":"a","p":[1.5]}]}`, TOMLFormat{}, jsonOutputFormat)
+}
+
+func TestTomlArraysOfTables(t *testing.T) {
+	input := `[[servers]]
+host = "a"
+ports = ["http", "https"]
+
+[[servers]]
+host = "b"
+
+[[servers.aliases]]
+name = "c"
+`
+	output := convertAndTest(t, input, `{"servers":[{"host":"a","ports":["http","https"]},{"aliases":[{"name":"c"}],"host":"b"}]}`,
+		TOMLFormat{}, jsonOutputFormat)
+	convertAndTest(t, output.(string), input, jsonInputFormat, tomlOutputFormat)
+
+	for input, path := range map[string]string{
+		`{"a": [1, {"b": 2}]}`:             "'a'",
+		`{"a": [{"b": 1}, [2]]}`:           "'a'",
+		`{"a": {"b": [1, null]}}`:          "'a.b[1]'",
+		`{"a": [{"b": [{"c": null}, 1]}]}`: "'a[0].b'",
+		`[null]`:                           "'_[0]'",
+	} {
+		_, _, err := processString(input, jsonInputFormat, nil, tomlOutputFormat)
+		var marshalError *MarshalError
+		if !errors.As(err, &marshalError) || describePath(marshalError.Path) != path {
+			t.Errorf("unexpected error for %s: %v", input, err)
+		}
+	}
+}
+
+func TestSkippingRecords(t *testing.T) {
+	var skipped []int
+	skip := func(record int, err error) bool {
+		skipped = append(skipped, record)
+		return len(skipped) <= 2
+	}
+	convertAndTest(t, "{\"a\":1}\n{bad\n\n[2]\nnope", `[{"a":1},[2]]`, NDJSONFormat{OnRecordError: skip}, jsonOutputFormat)
+	if len(skipped) != 2 || skipped[0] != 2 || skipped[1] != 5 {
+		t.Errorf("unexpected skipped records: %v", skipped)
+	}
+	skipped = nil
+	convertAndTest(t, "a,b\n1,2,3\n4,5\n", `[{"a":"4","b":"5"}]`,
+		TextFormat{FieldDelimiter: ",", Header: true, OnRecordError: skip}, jsonOutputFormat)
+	if _, _, err := processString("a\n1,2\n3,4\n", TextFormat{FieldDelimiter: ",", Header: true, OnRecordError: skip}, nil, jsonOutputFormat); err == nil {
+		t.Error("exceeding the maximum number of errors did not fail")
+	}
+
+	skipped = nil
+	types, _ := ParseTypeDeclarations(map[string]interface{}{"[].n": "int"})
+	transformer := RecordSkippingTransformer{Transformer: TypeCoercionTransformer{Types: types}, OnRecordError: skip}
+	convertTransformAndTest(t, `[{"n":"1"},{"n":"x"},{"n":"3"}]`, `[{"n":1},{"n":3}]`, jsonInputFormat, transformer, jsonOutputFormat)
+	if len(skipped) != 1 || skipped[0] != 2 {
+		t.Errorf("unexpected skipped records: %v", skipped)
+	}
+	if _, _, err := processString(`[{"n":"x"},{"n":"y"}]`, jsonInputFormat, transformer, jsonOutputFormat); err == nil {
+		t.Error("exceeding the maximum number of errors in transformations did not fail")
+	}
+}
+
+func TestEnv(t *testing.T) {
+	environment := []string{"APP_A=1", "APP_DB__HOST=h=x", "APP_DB__PORT=5", "OTHER=o", "APP_=p"}
+	convertAndTest(t, "", `{"A":"1","DB__HOST":"h=x","DB__PORT":"5"}`,
+		EnvFormat{Environment: environment, Prefix: "APP_"}, jsonOutputFormat)
+	convertAndTest(t, "ignored=1", `{"A":"1","DB":{"HOST":"h=x","PORT":"5"}}`,
+		EnvFormat{Environment: environment, Prefix: "APP_", Nest: true}, jsonOutputFormat)
+	convertAndTest(t, "# comment\nexport A=\"a b\"\n\nB='c'\nC=\n", `{"A":"a b","B":"c","C":""}`,
+		EnvFormat{}, jsonOutputFormat)
+
+	if _, _, err := processString("", EnvFormat{Environment: []string{"A=1", "A__B=2"}, Nest: true}, nil, jsonOutputFormat); err == nil {
+		t.Error("conflicting variables not rejected")
+	}
+	if _, _, err := processString("A\n", EnvFormat{}, nil, jsonOutputFormat); err == nil {
+		t.Error("invalid line not rejected")
+	}
+}
+
+func TestEnvExport(t *testing.T) {
+	shell, _ := NewOutputFormat("", "shell", false)
+	input := `{"db":{"host":"h","pass":"a\"b$c` + "`" + `d\\e"},"ports":[1,2],"my-app":{"x":true},"n":null}`
+	convertAndTest(t, input, "export DB__HOST=\"h\"\nexport DB__PASS=\"a\\\"b\\$c\\`d\\\\e\"\n"+
+		"export MY_APP__X=\"true\"\nexport N=\"\"\nexport PORTS__0=\"1\"\nexport PORTS__1=\"2\"\n", jsonInputFormat, shell)
+	convertAndTest(t, `{"ports":[1,2],"1":{"a":"x"}}`, "APP_1_a=\"x\"\nAPP_ports=\"1 2\"\n", jsonInputFormat,
+		EnvFormat{Prefix: "APP_", KeySeparator: "_", ArrayStyle: EnvArraysJoined})
+	convertAndTest(t, `["a"]`, "v__0=\"a\"\n", jsonInputFormat, EnvFormat{DefaultKey: "v"})
+	convertAndTest(t, `{"1":"a"}`, "_1=\"a\"\n", jsonInputFormat, EnvFormat{})
+
+	for input, format := range map[string]EnvFormat{
+		`{"a-b":1,"a_b":2}`: {},
+		`{"a":[[1]]}`:       {ArrayStyle: EnvArraysJoined},
+	} {
+		_, _, err := processString(input, jsonInputFormat, nil, format)
+		var marshalError *MarshalError
+		if !errors.As(err, &marshalError) {
+			t.Errorf("unexpected error for %s: %v", input, err)
+		}
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	object := `{"a":1,"b":{"c":1,"c":[2]},"a":2}`
+	convertAndTest(t, object, `{"a":1,"b":{"c":1}}`, JSONFormat{DuplicateKeys: DuplicateKeysFirst}, jsonOutputFormat)
+	convertAndTest(t, object, `{"a":2,"b":{"c":[2]}}`, JSONFormat{DuplicateKeys: DuplicateKeysLast}, jsonOutputFormat)
+	convertAndTest(t, "{\"a\":1,\"a\":2}\n[{\"b\":1,\"b\":2}]\n", `[{"a":1},[{"b":1}]]`,
+		NDJSONFormat{DuplicateKeys: DuplicateKeysFirst}, jsonOutputFormat)
+
+	ini := "a=1\na=2\n[s]\nb=1\n[s]\nb=3\n"
+	convertAndTest(t, ini, `{"_":{"a":"1"},"s":{"b":"1"}}`, INIFormat{DuplicateKeys: DuplicateKeysFirst}, jsonOutputFormat)
+	convertAndTest(t, ini, `{"_":{"a":"2"},"s":{"b":"3"}}`, INIFormat{DuplicateKeys: DuplicateKeysLast}, jsonOutputFormat)
+	convertAndTest(t, "A=1\nA=2\n", `{"A":"1"}`, EnvFormat{DuplicateKeys: DuplicateKeysFirst}, jsonOutputFormat)
+	convertAndTest(t, "a,b,a\n1,2,3\n", `[{"a":"1","b":"2"}]`,
+		TextFormat{FieldDelimiter: ",", Header: true, DuplicateKeys: DuplicateKeysFirst}, jsonOutputFormat)
+	convertAndTest(t, "a,b,a\n1,2,3\n", `[{"a":"3","b":"2"}]`,
+		TextFormat{FieldDelimiter: ",", Header: true, DuplicateKeys: DuplicateKeysLast}, jsonOutputFormat)
+
+	for input, format := range map[string]Unmarshaler{
+		object:                JSONFormat{DuplicateKeys: DuplicateKeysError},
+		`{"a":1} {}`:          JSONFormat{DuplicateKeys: DuplicateKeysError},
+		ini:                   INIFormat{DuplicateKeys: DuplicateKeysError},
+		"A=1\nA=2\n":          EnvFormat{DuplicateKeys: DuplicateKeysError},
+		"{\"a\":1,\"a\":1}\n": NDJSONFormat{DuplicateKeys: DuplicateKeysError},
+	} {
+		if _, _, err := processString(input, format, nil, jsonOutputFormat); err == nil {
+			t.Errorf("duplicate keys in '%s' not rejected", input)
+		}
+	}
+}
+
+func TestJSONSubtree(t *testing.T) {
+	input := `{"a":{"b":[1,{"c":"x"},[2]]},"d":{"e":1,"e":2},"f":[{"g":1},{"g":2}]}`
+	for path, expected := range map[string]string{
+		"a.b[1]":    `{"c":"x"}`,
+		"a.b[2][0]": `2`,
+		"d.e":       `2`,
+		"":          `{"a":{"b":[1,{"c":"x"},[2]]},"d":{"e":2},"f":[{"g":1},{"g":2}]}`,
+	} {
+		parsed, err := ParsePath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		convertAndTest(t, input, expected, JSONSubtreeFormat{Path: parsed}, jsonOutputFormat)
+		convertTransformAndTest(t, input, expected, JSONFormat{}, ExtractTransformer{Path: parsed}, jsonOutputFormat)
+	}
+	convertAndTest(t, input, `1`, JSONSubtreeFormat{JSONFormat: JSONFormat{DuplicateKeys: DuplicateKeysFirst}, Path: Path{{Key: "d"}, {Key: "e"}}},
+		jsonOutputFormat)
+	convertTransformAndTest(t, input, `[1,2]`, JSONFormat{}, ExtractTransformer{Path: Path{{Key: "f"}, {IsIndex: true, Wildcard: true}, {Key: "g"}}},
+		jsonOutputFormat)
+
+	for input, format := range map[string]JSONSubtreeFormat{
+		`{"a":1} x`:   {Path: Path{{Key: "a"}}},
+		`{"a":1}`:     {Path: Path{{Key: "b"}}},
+		`[1]`:         {Path: Path{{Key: "a"}}},
+		`{"a":[1}`:    {Path: Path{{Key: "b"}}},
+		`{"a":[1,2]}`: {Path: Path{{Key: "a"}, {Wildcard: true}}},
+		`{"a":1}  `:   {JSONFormat: JSONFormat{DuplicateKeys: DuplicateKeysError}, Path: Path{{Key: "a"}}},
+	} {
+		if _, _, err := processString(input, format, nil, jsonOutputFormat); err == nil {
+			t.Errorf("extracting %s from '%s' did not fail", describePath(format.Path), input)
+		}
+	}
+}
+
+// A large JSON document with a small value at the end.
+func largeJSONInput(b *testing.B) []byte {
+	encoded, err := json.Marshal(map[string]interface{}{"items": largeJSONDocument(), "meta": map[string]interface{}{"version": 1}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return encoded
+}
+
+// Compare the allocations with BenchmarkJSONExtract: only the tokens of the
+// rest of the document are read, so they should be a fraction.
+func BenchmarkJSONSubtree(b *testing.B) {
+	input := largeJSONInput(b)
+	format := JSONSubtreeFormat{Path: Path{{Key: "meta"}, {Key: "version"}}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := format.Unmarshal(bytes.NewReader(input)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJSONExtract(b *testing.B) {
+	input := largeJSONInput(b)
+	extract := ExtractTransformer{Path: Path{{Key: "meta"}, {Key: "version"}}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		data, err := JSONFormat{}.Unmarshal(bytes.NewReader(input))
+		if err == nil {
+			_, err = extract.Transform(data)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFallbackFormats(t *testing.T) {
+	inputFormat, err := NewInputFormat("", "json, TOML,yaml", ",", "NL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected := ""
+	fallback := inputFormat.(FallbackFormat)
+	fallback.OnSelect = func(format InputFormat) { selected = format.Name() }
+
+	convertAndTest(t, `{"a":1}`, `{"a":1}`, fallback, jsonOutputFormat)
+	if selected != formatNameJSON {
+		t.Errorf("unexpected format %s selected", selected)
+	}
+	convertAndTest(t, "a = 1\nb = 2\n", `{"a":1,"b":2}`, fallback, jsonOutputFormat)
+	if selected != formatNameTOML {
+		t.Errorf("unexpected format %s selected", selected)
+	}
+	convertAndTest(t, "- a\n", `["a"]`, fallback, jsonOutputFormat)
+	if selected != formatNameYAML {
+		t.Errorf("unexpected format %s selected", selected)
+	}
+	_, _, err = processString("a: [", fallback, nil, jsonOutputFormat)
+	if err == nil || !strings.Contains(err.Error(), "JSON: ") || !strings.Contains(err.Error(), "YAML: ") {
+		t.Errorf("errors of all formats not reported: %v", err)
+	}
+	if _, err = NewInputFormat("", "json,nope", ",", "NL"); err == nil {
+		t.Error("unknown format in the list not rejected")
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	for input, expected := range map[string]string{
+		"\ufeff {\"a\": 1}":       formatNameJSON,
+		"[1, 2]\n":                formatNameJSON,
+		"{\"a\":1}\n\n[2]\n":      formatNameNDJSON,
+		"---\na = 1\n":            formatNameYAML,
+		"a = 1\n[b]\nc = \"d\"\n": formatNameTOML,
+		"; c\n[b]\nc = d e\n":     formatNameINI,
+		"a:\n  - b=c\n":           formatNameYAML,
+		"- a\n":                   formatNameYAML,
+	} {
+		if name, ok := DetectFormat([]byte(input)); !ok || name != expected {
+			t.Errorf("unexpected format %s (%v) detected for %q", name, ok, input)
+		}
+	}
+	for _, input := range []string{"", " \n", "text", "a,b\n1,2\n", "{a"} {
+		if name, ok := DetectFormat([]byte(input)); ok {
+			t.Errorf("format %s detected for %q", name, input)
+		}
+	}
+
+	inputFormat, err := NewInputFormat("data.yaml", sniffFormat, ",", "NL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	convertAndTest(t, "[s]\nk = v\n", `{"s":{"k":"v"}}`, inputFormat, jsonOutputFormat)
+	convertAndTest(t, "text", `"text"`, inputFormat, jsonOutputFormat)
+	inputFormat, _ = NewInputFormat("data.txt", sniffFormat, ",", "NL")
+	if _, _, err = processString("text", inputFormat, nil, jsonOutputFormat); err == nil {
+		t.Error("undetectable format not rejected")
+	}
+
+	// the automatic format for unknown extensions
+	inputFormat, err = NewInputFormat("data.txt", autoFormat, ",", "NL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	convertAndTest(t, "{\"a\":1}\n{\"a\":2}\n", `[{"a":1},{"a":2}]`, inputFormat, jsonOutputFormat)
+	sniffing, _ := inputFormat.(SniffingFormat)
+	sniffing.Default, _ = NewInputFormat("", "Lines", ",", "NL")
+	convertAndTest(t, "a,b\n1,2\n", `["a,b","1,2"]`, sniffing, jsonOutputFormat)
+	if inputFormat, _ = NewInputFormat("data.csv", autoFormat, ",", "NL"); inputFormat.Name() != formatNameCSF {
+		t.Errorf("unexpected format %s for a known extension", inputFormat.Name())
+	}
+}
+
+func largeJSONDocument() interface{} {
+	elements := make([]interface{}, 5000)
+	for n := range elements {
+		elements[n] = map[string]interface{}{"id": float64(n), "name": fmt.Sprintf("item %d", n), "tags": []interface{}{"a", "b"}}
+	}
+	return elements
+}
+
+func BenchmarkJSONMarshal(b *testing.B) {
+	data := largeJSONDocument()
+	format := JSONFormat{}
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if err := format.Marshal(data, ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The baseline for BenchmarkJSONMarshal: the format writes from a pooled
+// buffer and should allocate far less than json.Marshal, which returns a copy.
+func BenchmarkJSONMarshalStdlib(b *testing.B) {
+	data := largeJSONDocument()
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		bytes, err := json.Marshal(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ioutil.Discard.Write(bytes)
+	}
+}
+
+func TestJSONMarshalAllocations(t *testing.T) {
+	if testing.Short() {
+		t.Skip("benchmark")
+	}
+	encoded := testing.Benchmark(BenchmarkJSONMarshal)
+	marshalled := testing.Benchmark(BenchmarkJSONMarshalStdlib)
+	if encoded.AllocedBytesPerOp() > marshalled.AllocedBytesPerOp()/2 {
+		t.Errorf("writing JSON allocates %d bytes, json.Marshal only %d",
+			encoded.AllocedBytesPerOp(), marshalled.AllocedBytesPerOp())
+	}
+}
+
+func TestMarshalKeepsData(t *testing.T) {
+	data := func() interface{} {
+		return map[string]interface{}{
+			"a": []interface{}{math.Inf(1), 1.25, int64(1) << 60},
+			"b": map[string]interface{}{"c": math.NaN(), "d": "x"},
+			"e": []interface{}{"y"},
+		}
+	}
+	original, expected := data(), data()
+	format := JSONFormat{NonFinite: NonFiniteOmit, JSSafeNumbers: true, Floats: FloatFormat{Style: FloatStyleFixed, Precision: 1}}
+	var b strings.Builder
+	if err := format.Marshal(original, &b); err != nil {
+		t.Fatal(err)
+	} else if b.String() != `{"a":[1.2,"1152921504606846976"],"b":{"d":"x"},"e":["y"]}` {
+		t.Errorf("unexpected output %s", b.String())
+	}
+	// NaN is never equal to itself
+	expected.(map[string]interface{})["b"].(map[string]interface{})["c"] = original.(map[string]interface{})["b"].(map[string]interface{})["c"]
+	if fmt.Sprint(original) != fmt.Sprint(expected) {
+		t.Errorf("the data was changed to %v", original)
+	}
+
+	ordered := NewOrderedMap()
+	ordered.Set("c", 1)
+	nested := map[string]interface{}{"a": []interface{}{ordered}, "b": "x"}
+	b.Reset()
+	if err := (TOMLFormat{}).Marshal(nested, &b); err != nil {
+		t.Fatal(err)
+	}
+	if nested["a"].([]interface{})[0] != ordered {
+		t.Error("marshalling to TOML replaced an ordered map of the data")
+	}
+
+	unchanged := map[string]interface{}{"a": []interface{}{"x"}}
+	result, _ := transformLeavesCopy(unchanged, func(value interface{}, at Path) (interface{}, error) { return value, nil })
+	if reflect.ValueOf(result).Pointer() != reflect.ValueOf(unchanged).Pointer() {
+		t.Error("unchanged data was copied")
+	}
+}
+
+func TestNonFiniteNumbers(t *testing.T) {
+	input := "a: .inf\nb: [1, .nan, {c: -.inf}]\nd: 2.5\n"
+	for policy, expected := range map[string]string{
+		NonFiniteNull:   `{"a":null,"b":[1,null,{"c":null}],"d":2.5}`,
+		NonFiniteString: `{"a":"+Inf","b":[1,"NaN",{"c":"-Inf"}],"d":2.5}`,
+		NonFiniteOmit:   `{"b":[1,{}],"d":2.5}`,
+	} {
+		convertAndTest(t, input, expected, yamlInputFormat, JSONFormat{NonFinite: policy})
+	}
+	convertAndTest(t, input, "{\"b\":[1,{}],\"d\":2.5}\n", yamlInputFormat, NDJSONFormat{NonFinite: NonFiniteOmit})
+	convertAndTest(t, input, "a: .inf\nb:\n  - 1\n  - .nan\n  - c: -.inf\nd: 2.5\n", yamlInputFormat, yamlOutputFormat)
+
+	for _, format := range []Marshaler{JSONFormat{}, JSONFormat{NonFinite: NonFiniteError}, NDJSONFormat{}} {
+		_, _, err := processString("b: [1, {c: .nan}]", yamlInputFormat, nil, format)
+		if err == nil || !strings.Contains(err.Error(), "'b[1].c'") {
+			t.Errorf("unexpected error for non-finite numbers: %v", err)
+		}
+	}
+	if _, err := (NonFiniteTransformer{Policy: "zero"}).Transform(1.0); err == nil {
+		t.Error("unknown policy not rejected")
+	}
+}
+
+func TestASCIIOnlyJSON(t *testing.T) {
+	input := `{"name": "héllo 🎉", "tag": "<b>"}`
+	convertAndTest(t, input, `{"name":"héllo 🎉","tag":"\u003cb\u003e"}`, jsonInputFormat, JSONFormat{})
+	convertAndTest(t, input, `{"name":"h\u00e9llo \ud83c\udf89","tag":"\u003cb\u003e"}`, jsonInputFormat, JSONFormat{ASCIIOnly: true})
+	convertAndTest(t, input, "{\n  \"name\": \"h\\u00e9llo \\ud83c\\udf89\",\n  \"tag\": \"\\u003cb\\u003e\"\n}",
+		jsonInputFormat, JSONFormat{ASCIIOnly: true, PrettyPrint: true})
+	convertAndTest(t, `["ü"]`, "\"\\u00fc\"\n", jsonInputFormat, NDJSONFormat{ASCIIOnly: true})
+
+	_, output, _ := processString(input, jsonInputFormat, nil, JSONFormat{ASCIIOnly: true})
+	for _, b := range []byte(output) {
+		if b >= 0x80 {
+			t.Fatalf("non-ASCII output: %s", output)
+		}
+	}
+}
+
+func TestVerifiedOutput(t *testing.T) {
+	input := "a: 1\nb: [2.5, x]\nc: {d: null}\n"
+	convertAndTest(t, input, `{"a":1,"b":[2.5,"x"],"c":{"d":null}}`, yamlInputFormat, VerifyingFormat{InputOutputFormat: JSONFormat{}})
+	convertAndTest(t, `[1, 2]`, "_ = [1.0, 2.0]\n", jsonInputFormat, VerifyingFormat{InputOutputFormat: TOMLFormat{}})
+
+	for _, test := range []struct {
+		input  string
+		format InputOutputFormat
+		path   string
+	}{
+		{input, TOMLFormat{}, "c.d"},
+		{"a: 2021-01-02T03:04:05Z\n", JSONFormat{}, "a"},
+		{"a: 1\n", INIFormat{}, "a"},
+		{"[1, 2]\n", TextFormat{FieldDelimiter: ","}, "[0]"},
+		{"a: [1, 2, 3]\n", TOMLFormat{ArrayStyle: TOMLArraysJoined}, "a"},
+	} {
+		_, _, err := processString(test.input, yamlInputFormat, nil, VerifyingFormat{InputOutputFormat: test.format})
+		var verifyError *VerifyError
+		if !errors.As(err, &verifyError) || verifyError.Path.String() != test.path {
+			t.Errorf("unexpected verification of %s output for %q: %v", test.format.Name(), test.input, err)
+		}
+	}
+
+	format := VerifyingFormat{InputOutputFormat: JSONFormat{Floats: FloatFormat{Style: FloatStyleFixed, Precision: 1}}}
+	if _, _, err := processString("a: 1.25", yamlInputFormat, nil, format); err == nil {
+		t.Error("rounded float not detected")
+	}
+	format.Epsilon = 0.1
+	convertAndTest(t, "a: 1.25", `{"a":1.2}`, yamlInputFormat, format)
+}
+
+func TestBOMOutput(t *testing.T) {
+	convertAndTest(t, `[1, 2]`, "\ufeff1\n---\n2\n", jsonInputFormat, BOMFormat{YAMLFormat{MultiDocument: true}})
+	convertAndTest(t, `[[1.5, "ä"], [2, "b"]]`, "\ufeff1.5;ä\r\n2;b\r\n", jsonInputFormat,
+		BOMFormat{TextFormat{FieldDelimiter: ";", RecordDelimiter: "\r\n"}})
+
+	defer func(all, numeric, lang string) {
+		os.Setenv("LC_ALL", all)
+		os.Setenv("LC_NUMERIC", numeric)
+		os.Setenv("LANG", lang)
+	}(os.Getenv("LC_ALL"), os.Getenv("LC_NUMERIC"), os.Getenv("LANG"))
+	os.Setenv("LC_ALL", "")
+	for locale, expected := range map[string]bool{"de_DE.UTF-8": true, "fr": true, "en_US.UTF-8": false, "C": false, "_": false} {
+		os.Setenv("LC_NUMERIC", "")
+		os.Setenv("LANG", locale)
+		if localeUsesDecimalComma() != expected {
+			t.Errorf("unexpected decimal separator for locale %s", locale)
+		}
+	}
+	os.Setenv("LC_NUMERIC", "en_GB")
+	if localeUsesDecimalComma() {
+		t.Error("LC_NUMERIC not preferred to LANG")
+	}
+}
+
+func TestConversionStages(t *testing.T) {
+	recorded := &stageTimings{}
+	transformer := NewMultiTransformer(NopTransformer{},
+		NewMultiTransformer(EnsureArrayTransformer{}, PathScopedTransformer{Transformer: JSSafeNumberTransformer{}}))
+	writer := &strings.Builder{}
+	err := ConvertStream(strings.NewReader(`{"a": 1}`), jsonInputFormat, transformer, writer, JSONFormat{}, recorded.Record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, stage := range recorded.Stages {
+		names = append(names, stage.Name)
+	}
+	expected := "read, transform 1: EnsureArrayTransformer, transform 2: PathScopedTransformer(JSSafeNumberTransformer), write"
+	if strings.Join(names, ", ") != expected {
+		t.Errorf("unexpected stages %v", names)
+	}
+	table := &strings.Builder{}
+	if err = recorded.Write(table); err != nil {
+		t.Error(err)
+	}
+	if lines := strings.Split(table.String(), "\n"); len(lines) != 7 || !strings.HasPrefix(lines[5], "total ") {
+		t.Errorf("unexpected table:\n%s", table.String())
+	}
+
+	recorded = &stageTimings{}
+	err = ConvertStream(strings.NewReader(`[1]`), jsonInputFormat, TypeWhitelistTransformer{}, writer, JSONFormat{}, recorded.Record)
+	if err == nil || len(recorded.Stages) != 2 || recorded.Stages[1].Name != "transform 1: TypeWhitelistTransformer" {
+		t.Errorf("the failing stage was not reported: %v %v", err, recorded.Stages)
+	}
+	for n, expected := range map[uint64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 3 << 30: "3.0 GiB"} {
+		if formatted := formatBytes(n); formatted != expected {
+			t.Errorf("%d formatted as %s, expected %s", n, formatted, expected)
+		}
+	}
+}
+
+func TestPerDocument(t *testing.T) {
+	paths, _ := ParsePaths("")
+	transformer := PerDocumentTransformer{EnsureArrayTransformer{Paths: paths}}
+	input := "a: 1\n---\n- x\n---\nnull\n"
+	convertTransformAndTest(t, input, "- a: 1\n---\n- x\n---\nnull\n",
+		DocumentsFormat{yamlInputFormat}, transformer, documentsOutputFormat(YAMLFormat{}))
+	convertTransformAndTest(t, input, "[{\"a\":1}]\n[\"x\"]\nnull\n",
+		DocumentsFormat{yamlInputFormat}, transformer, documentsOutputFormat(JSONFormat{}))
+	// a single document that is an array stays one document
+	convertTransformAndTest(t, "- 1\n- 2\n", "- 1\n- 2\n",
+		DocumentsFormat{yamlInputFormat}, transformer, documentsOutputFormat(YAMLFormat{}))
+	convertTransformAndTest(t, "a: 1\n", "a = 1\n",
+		DocumentsFormat{yamlInputFormat}, PerDocumentTransformer{NopTransformer{}}, documentsOutputFormat(tomlOutputFormat))
+
+	if _, _, err := processString(input, DocumentsFormat{yamlInputFormat}, transformer, documentsOutputFormat(tomlOutputFormat)); err == nil {
+		t.Error("several documents written as TOML")
+	}
+	if _, _, err := processString(input, DocumentsFormat{yamlInputFormat}, PerDocumentTransformer{TypeWhitelistTransformer{}}, ndjsonOutputFormat); err == nil || !strings.HasPrefix(err.Error(), "document 1: ") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
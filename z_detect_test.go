@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectInput(t *testing.T) {
+	for _, test := range []struct {
+		fileName string
+		input    string
+		expected string
+	}{
+		{"a.yaml", "---\na: 1\n---\nb: 2\n", "YAML (magic bytes, 2 documents)"},
+		{"-", "a: 1\nb: [2]\n", "YAML (content, 1 documents)"},
+		{"a.csv", "a,b\n1,2\n", "CSF (extension, 2 records)"},
+		{"a.txt", "{\"a\":1}\n{\"a\":2}\n", "NDJSON (content, 2 records)"},
+		{"a", `{"a": [1]}`, "JSON (content)"},
+		{"a.ini", "[s]\nkey = value with spaces\n", "INI (content)"},
+		{"a.yaml", "", "YAML (extension, 0 documents)"},
+		{".env", "\n", "ENV (extension)"},
+	} {
+		detection, err := DetectInput(test.fileName, []byte(test.input))
+		if err != nil || detection.String() != test.expected {
+			t.Errorf("unexpected detection for %s: %s (%v)", test.fileName, detection, err)
+		}
+	}
+	for fileName, input := range map[string]string{"a.dat": "junk junk", "a.json": "junk junk"} {
+		if detection, err := DetectInput(fileName, []byte(input)); err == nil {
+			t.Errorf("unidentifiable input %s detected as %s", fileName, detection)
+		}
+	}
+
+	output := &strings.Builder{}
+	results := []detectionResult{{Input: "a.csv", Detection: Detection{formatNameCSF, DetectedByExtension, 2}}, {Input: "b", Err: errNotDetected}}
+	if err := writeDetectionResults(output, results, true); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"format":"CSF","input":"a.csv","method":"extension","records":2},{"error":"the format could not be detected","format":null,"input":"b"}]` + "\n"
+	if output.String() != expected {
+		t.Errorf("unexpected JSON output: %s", output)
+	}
+}
+
+func TestDetectInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	valid, junk := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.dat")
+	if err := ioutil.WriteFile(valid, []byte(`{"a": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(junk, []byte("junk junk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, code := detectInputs([]string{filepath.Join(dir, "missing.json"), valid, junk})
+	if code != exitInputError|exitCheckError {
+		t.Errorf("unexpected exit code %d", code)
+	}
+	if len(results) != 3 || results[0].Err == nil || results[1].Err != nil || results[2].Err == nil {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if results[1].Detection.String() != "JSON (content)" {
+		t.Errorf("unexpected detection %s after an unreadable input", results[1].Detection)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+const test_floats_yaml = "a: 1e3\nb: 2.50\nc: 7\nd: [0.125, -3.14159]\ne: 1.0e-7\n"
+
+func TestFloatFormats(t *testing.T) {
+	for _, test := range []struct {
+		floats           FloatFormat
+		json, yaml, toml string
+	}{
+		{FloatFormat{}, `{"a":1000,"b":2.5,"c":7,"d":[0.125,-3.14159],"e":1e-7}`,
+			"a: 1000\nb: 2.5\nc: 7\nd:\n  - 0.125\n  - -3.14159\ne: 1e-07\n",
+			"a = 1000.0\nb = 2.5\nc = 7\nd = [0.125, -3.14159]\ne = 0.0000001\n"},
+		{FloatFormat{Style: FloatStyleAuto, Precision: 2}, `{"a":1000,"b":2.5,"c":7,"d":[0.12,-3.14],"e":0}`,
+			"a: 1000\nb: 2.5\nc: 7\nd:\n  - 0.12\n  - -3.14\ne: 0\n",
+			"a = 1000.0\nb = 2.5\nc = 7\nd = [0.12, -3.14]\ne = 0.0\n"},
+		{FloatFormat{Style: FloatStyleFixed, Precision: 2}, `{"a":1000.00,"b":2.50,"c":7,"d":[0.12,-3.14],"e":0.00}`,
+			"a: 1000.00\nb: 2.50\nc: 7\nd:\n  - 0.12\n  - -3.14\ne: 0.00\n",
+			"a = 1000.00\nb = 2.50\nc = 7\nd = [0.12, -3.14]\ne = 0.00\n"},
+		{FloatFormat{Style: FloatStyleFixed, Precision: -1}, `{"a":1000.0,"b":2.5,"c":7,"d":[0.125,-3.14159],"e":0.0000001}`,
+			"a: 1000.0\nb: 2.5\nc: 7\nd:\n  - 0.125\n  - -3.14159\ne: 0.0000001\n",
+			"a = 1000.0\nb = 2.5\nc = 7\nd = [0.125, -3.14159]\ne = 0.0000001\n"},
+		{FloatFormat{Style: FloatStyleExponential, Precision: 1}, `{"a":1.0e+03,"b":2.5e+00,"c":7,"d":[1.2e-01,-3.1e+00],"e":1.0e-07}`,
+			"a: 1.0e+03\nb: 2.5e+00\nc: 7\nd:\n  - 1.2e-01\n  - -3.1e+00\ne: 1.0e-07\n",
+			"a = 1.0e+03\nb = 2.5e+00\nc = 7\nd = [1.2e-01, -3.1e+00]\ne = 1.0e-07\n"},
+	} {
+		convertAndTest(t, test_floats_yaml, test.json, yamlInputFormat, JSONFormat{Floats: test.floats})
+		convertAndTest(t, test_floats_yaml, test.yaml, yamlInputFormat, YAMLFormat{Floats: test.floats})
+		convertAndTest(t, test_floats_yaml, test.toml, yamlInputFormat, TOMLFormat{Floats: test.floats})
+	}
+
+	// integers in exact JSON input stay integers
+	convertAndTest(t, `{"a": 7, "b": 7.0, "c": 1e2}`, `{"a":7,"b":7.00,"c":100.00}`,
+		JSONFormat{UseNumber: true}, JSONFormat{Floats: FloatFormat{Style: FloatStyleFixed, Precision: 2}})
+	convertAndTest(t, `{"a": 7, "b": 7.0}`, "a = 7\nb = 7.0\n",
+		JSONFormat{UseNumber: true}, TOMLFormat{Floats: FloatFormat{Style: FloatStyleFixed, Precision: 1}})
+
+	if _, err := (FloatFormat{Style: "engineering"}).apply(1.0); err == nil {
+		t.Error("unknown float style not rejected")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatOptions(t *testing.T) {
+	options, err := ParseFormatOptions([]string{
+		"yaml:explicit-start=true", "YAML:Indent=4", "jsonl:js-safe-numbers=1",
+		"csf:field-delimiter=TAB", "csf:columns=b,a", "ini:default-key=data",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := FormatOptions{
+		"yaml":   {"explicit-start": "true", "indentation": "4"},
+		"ndjson": {"js-safe-numbers": "1"},
+		"csf":    {"field-delimiter": "TAB", "columns": "b,a"},
+		"ini":    {"default-key": "data"},
+	}
+	if !reflect.DeepEqual(options, expected) {
+		t.Errorf("unexpected options %v", options)
+	}
+	for _, formats := range [][2]FileFormat{
+		{YAMLFormat{PrettyPrint: true}, YAMLFormat{PrettyPrint: true, ExplicitStart: true, Indentation: 4}},
+		{NDJSONFormat{}, NDJSONFormat{JSSafeNumbers: true}},
+		{TextFormat{FieldDelimiter: ",", RecordDelimiter: "\n"}, TextFormat{FieldDelimiter: "\t", RecordDelimiter: "\n", Columns: []string{"b", "a"}}},
+		{JSONFormat{}, JSONFormat{}},
+	} {
+		format, configured := formats[0], formats[1]
+		applied, err := options.Apply(format)
+		if err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(applied, configured) {
+			t.Errorf("unexpected format %#v", applied)
+		}
+	}
+
+	for option, expected := range map[string]string{
+		"yaml:indents=4":     "unknown YAML format option 'indents' (expected one of explicit-start, indentation, multi-document, null-style, pretty-print, quote-strings)",
+		"yaml:nodes=true":    "unknown YAML format option 'nodes'",
+		"json:use-number":    "invalid format option 'json:use-number' (expected FORMAT:OPTION=VALUE)",
+		"xml:indent=2":       "unknown format 'xml' in format option 'xml:indent=2'",
+		"toml:indentation=x": "invalid value 'x' of TOML format option 'indentation': not an integer",
+		"json:indent=x":      "invalid value 'x' of JSON format option 'indentation': not an integer",
+	} {
+		if _, err := ParseFormatOptions([]string{option}); err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("unexpected error for %s: %v", option, err)
+		}
+	}
+	for name, expected := range map[string]string{"JSSafeNumbers": "js-safe-numbers", "ASCIIOnly": "ascii-only", "DefaultKey": "default-key", "Header": "header"} {
+		if kebabCase(name) != expected {
+			t.Errorf("%s converted to %s", name, kebabCase(name))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func generateAndTest(t *testing.T, input string, expected string, format TypeScriptFormat) {
+	data, err := jsonInputFormat.Unmarshal(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &strings.Builder{}
+	err = format.Marshal(data, writer)
+	if err != nil {
+		t.Error(err)
+	}
+	if writer.String() != expected {
+		t.Errorf("unexpected TypeScript declarations, found:\n%s\nexpected:\n%s", writer.String(), expected)
+	}
+}
+
+func TestTypeScriptInterfaces(t *testing.T) {
+	generateAndTest(t, `{
+		"servers": [{"host": "a", "port": 1}, {"host": "b", "tags": ["x", 1]}],
+		"my-key": null,
+		"n": {"x": true},
+		"empty": []
+	}`, `export interface Config {
+  empty: unknown[];
+  "my-key": null;
+  n: ConfigN;
+  servers: ConfigServersItem[];
+}
+
+export interface ConfigN {
+  x: boolean;
+}
+
+export interface ConfigServersItem {
+  host: string;
+  port?: number;
+  tags?: (string | number)[];
+}
+`, TypeScriptFormat{RootName: "Config"})
+}
+
+func TestTypeScriptInlineTypes(t *testing.T) {
+	generateAndTest(t, `[{"a": {"b": 1}}, {"a": {"b": "x"}}, 2]`, `export type Root = ({
+  a: {
+    b: string | number;
+  };
+} | number)[];
+`, TypeScriptFormat{InlineObjects: true})
+}
+
+func TestTypeScriptNames(t *testing.T) {
+	if tsTypeName("first name") != "FirstName" || tsTypeName("2nd-key") != "_2ndKey" {
+		t.Error("incorrect type names")
+	}
+	if tsPropertyName("a_b$") != "a_b$" || tsPropertyName("a-b") != `"a-b"` {
+		t.Error("incorrect property names")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalNumbers(t *testing.T) {
+	cases := map[float64]string{
+		0:                      "0",
+		math.Copysign(0, -1):   "0",
+		1:                      "1",
+		-4.5:                   "-4.5",
+		0.000001:               "0.000001",
+		1e-7:                   "1e-7",
+		1e21:                   "1e+21",
+		1e23:                   "1e+23",
+		333333333.3333333:      "333333333.3333333",
+		9007199254740992:       "9007199254740992",
+		295147905179352830000:  "295147905179352830000",
+		5e-324:                 "5e-324",
+		1.7976931348623157e308: "1.7976931348623157e+308",
+		-1.25e-10:              "-1.25e-10",
+	}
+	for f, expected := range cases {
+		actual, err := CanonicalJSON(f)
+		if err != nil {
+			t.Error(err)
+		}
+		if string(actual) != expected {
+			t.Errorf("canonical number of %v is %s, expected %s", f, actual, expected)
+		}
+	}
+	if _, err := CanonicalJSON(math.Inf(1)); err == nil {
+		t.Error("non-finite number not rejected")
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	data := map[string]interface{}{
+		"b":          []interface{}{int64(1), "< \"\\\n\x01", nil, true},
+		"a":          map[interface{}]interface{}{2: "x", "1": 1.5},
+		"é":          1,
+		"\U0001F600": 2,
+		"ﬁ":          3,
+	}
+	actual, err := CanonicalJSON(data)
+	if err != nil {
+		t.Error(err)
+	}
+	// surrogate pairs (U+1F600) sort before U+FB01 in UTF-16
+	expected := "{\"a\":{\"1\":1.5,\"2\":\"x\"},\"b\":[1,\"< \\\"\\\\\\n\\u0001\",null,true],\"é\":1,\"\U0001F600\":2,\"ﬁ\":3}"
+	if string(actual) != expected {
+		t.Errorf("unexpected canonical JSON %s, expected %s", actual, expected)
+	}
+}
+
+func TestHashIgnoresFormatting(t *testing.T) {
+	yamlData, _ := yamlInputFormat.Unmarshal(strings.NewReader("b: 1\na: [1.5, x]\n"))
+	jsonData, _ := jsonInputFormat.Unmarshal(strings.NewReader(`{ "a": [1.5, "x"], "b": 1.0 }`))
+	for _, algorithm := range hashAlgorithmNames() {
+		yamlHash, err := HashData(yamlData, algorithm)
+		if err != nil {
+			t.Error(err)
+		}
+		jsonHash, _ := HashData(jsonData, algorithm)
+		if yamlHash != jsonHash {
+			t.Errorf("%s digests differ: %s vs %s", algorithm, yamlHash, jsonHash)
+		}
+	}
+	if _, err := HashData(yamlData, "md4"); err == nil {
+		t.Error("unsupported algorithm not rejected")
+	}
+}
+
+func TestBlake2b(t *testing.T) {
+	cases := map[string]string{
+		"":    "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce",
+		"abc": "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+	}
+	for input, expected := range cases {
+		sum := blake2b512([]byte(input))
+		if hex.EncodeToString(sum[:]) != expected {
+			t.Errorf("incorrect BLAKE2b-512 digest of '%s': %x", input, sum)
+		}
+	}
+	// multiple blocks, exact block boundary
+	long := blake2b512([]byte(strings.Repeat("a", 256)))
+	other := blake2b512([]byte(strings.Repeat("a", 257)))
+	if long == other {
+		t.Error("block boundary handling is broken")
+	}
+}
+
+func TestChecksumList(t *testing.T) {
+	entries, err := readChecksumList(strings.NewReader("ABC  a file.json\n\ndef *b.yaml\n"))
+	if err != nil {
+		t.Error(err)
+	}
+	if len(entries) != 2 || entries[0].Hash != "abc" || entries[0].Name != "a file.json" || entries[1].Name != "b.yaml" {
+		t.Errorf("unexpected checksum entries: %v", entries)
+	}
+	if _, err = readChecksumList(strings.NewReader("abc\n")); err == nil {
+		t.Error("invalid checksum line not detected")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func lintTest(t *testing.T, input string, linter Linter, asJSON bool, expected string) []LintFinding {
+	data, err := jsonInputFormat.Unmarshal(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings := linter.Lint(data)
+	writer := &strings.Builder{}
+	if err = writeLintFindings(writer, findings, asJSON); err != nil {
+		t.Error(err)
+	}
+	if writer.String() != expected {
+		t.Errorf("unexpected findings, found:\n%s\nexpected:\n%s", writer.String(), expected)
+	}
+	return findings
+}
+
+func TestLint(t *testing.T) {
+	input := `{"a": [1, "x", null], "B": "2020-01-02", "b": "2020-01-02", "c ": "12", "c": 1, "d": {"e": {"f": [1]}}}`
+	findings := lintTest(t, input, Linter{MaxDepth: 3}, false, `b: warning: the key differs from 'B' only by case [key-case]
+b: info: the value is the same as that of 'B' [duplicate-value]
+c : error: the key differs from 'c' only by whitespace [key-whitespace]
+B: info: the string '2020-01-02' looks like a date [date-string]
+a: warning: the elements are of 2 different types [mixed-array]
+b: info: the string '2020-01-02' looks like a date [date-string]
+c : info: the string '12' looks like a number [numeric-string]
+d.e.f: error: nested deeper than 3 levels [deep-nesting]
+`)
+	if !hasLintErrors(findings) {
+		t.Error("errors not detected")
+	}
+
+	enabled, err := ParseLintRules("", "key-whitespace,deep-nesting,date-string,duplicate-value,key-case")
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings = lintTest(t, input, Linter{Enabled: enabled, MaxDepth: 3}, true,
+		`[{"message":"the elements are of 2 different types","path":"a","rule":"mixed-array","severity":"warning"},`+
+			`{"message":"the string '12' looks like a number","path":"c ","rule":"numeric-string","severity":"info"}]`+"\n")
+	if hasLintErrors(findings) {
+		t.Error("unexpected errors")
+	}
+	enabled, _ = ParseLintRules("numeric-string", "")
+	lintTest(t, `["nan", "1e3", ["x"]]`, Linter{Enabled: enabled}, true,
+		`[{"message":"the string '1e3' looks like a number","path":"[1]","rule":"numeric-string","severity":"info"}]`+"\n")
+	if _, err = ParseLintRules("nope", ""); err == nil {
+		t.Error("unknown rule not rejected")
+	}
+}
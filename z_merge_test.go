@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func mergeJSONAndTest(t *testing.T, merger Merger, expected string, inputs ...string) {
+	sources := make([]MergeSource, len(inputs))
+	for n, input := range inputs {
+		data, err := jsonInputFormat.Unmarshal(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[n] = MergeSource{Name: string(rune('a' + n)), Data: data}
+	}
+	merged, err := merger.Merge(sources)
+	if err != nil {
+		t.Errorf("merge failed (%s): %v", merger.OnConflict, err)
+		return
+	}
+	output, _ := json.Marshal(merged)
+	if string(output) != expected {
+		t.Errorf("unexpected merge (%s/%s): %s", merger.OnConflict, merger.Arrays, output)
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	inputs := []string{`{"a": 1, "b": {"x": [1, 2], "y": 1}}`, `{"b": {"x": [3], "z": 2}, "c": true}`}
+	for merger, expected := range map[Merger]string{
+		{OnConflict: MergeLast}:                        `{"a":1,"b":{"x":[3],"y":1,"z":2},"c":true}`,
+		{OnConflict: MergeFirst}:                       `{"a":1,"b":{"x":[1,2],"y":1,"z":2},"c":true}`,
+		{OnConflict: MergeConcatArrays}:                `{"a":1,"b":{"x":[1,2,3],"y":1,"z":2},"c":true}`,
+		{OnConflict: MergeDeep}:                        `{"a":1,"b":{"x":[3,2],"y":1,"z":2},"c":true}`,
+		{OnConflict: MergeDeep, Arrays: ArraysReplace}: `{"a":1,"b":{"x":[3],"y":1,"z":2},"c":true}`,
+		{OnConflict: MergeFirst, Arrays: ArraysConcat}: `{"a":1,"b":{"x":[1,2,3],"y":1,"z":2},"c":true}`,
+		{OnConflict: MergeError, Arrays: ArraysConcat}: `{"a":1,"b":{"x":[1,2,3],"y":1,"z":2},"c":true}`,
+		{OnConflict: MergeLast, AnnotateSource: true}:  `{"__sources":{"a":["a"],"b":["a","b"],"c":["b"]},"a":1,"b":{"x":[3],"y":1,"z":2},"c":true}`,
+	} {
+		mergeJSONAndTest(t, merger, expected, inputs...)
+	}
+	mergeJSONAndTest(t, Merger{OnConflict: MergeError}, `{"a":1,"b":2}`, `{"a": 1}`, `{"a": 1, "b": 2}`)
+
+	sources := []MergeSource{{"base.json", map[string]interface{}{"a": map[string]interface{}{"b": 1}}},
+		{"site.json", map[string]interface{}{"a": map[string]interface{}{"b": 2}}}}
+	_, err := Merger{OnConflict: MergeError}.Merge(sources)
+	if err == nil || !strings.Contains(err.Error(), "'a.b'") || !strings.Contains(err.Error(), "'base.json' and 'site.json'") {
+		t.Errorf("unexpected conflict error: %v", err)
+	}
+	if _, err := (Merger{OnConflict: "latest"}).Merge(sources); err == nil {
+		t.Error("unknown conflict policy not rejected")
+	}
+	sources = append(sources, MergeSource{"list.json", []interface{}{}})
+	if _, err := (Merger{OnConflict: MergeLast, AnnotateSource: true}).Merge(sources); err == nil {
+		t.Error("annotation of an array not rejected")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNameTemplate(t *testing.T) {
+	names, err := NewNameTemplate(`{{.InputBase}}-{{printf "%02d" .Index}}-{{.Field "meta.name"}}{{.Ext}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{"meta": map[string]interface{}{"name": "a/b: c"}}
+	name, err := names.Render(OutputName{Index: 3, InputBase: inputBaseName("dir/in.json"), Ext: ".yaml", data: data}, "part 3")
+	if err != nil {
+		t.Error(err)
+	} else if name != "in-03-a_b_ c.yaml" {
+		t.Errorf("unexpected name '%s'", name)
+	}
+	if _, err = names.Render(OutputName{Index: 3, InputBase: "in", Ext: ".yaml", data: data}, "part 4"); err == nil {
+		t.Error("colliding names not rejected")
+	}
+	name, err = names.Render(OutputName{Index: 4, InputBase: "in", data: 1}, "part 5")
+	if err != nil || name != "in-04-" {
+		t.Errorf("unexpected name '%s' for a missing field (%v)", name, err)
+	}
+
+	byKey, _ := NewNameTemplate(defaultSplitByKeyNameTemplate)
+	if name, _ = byKey.Render(OutputName{Key: "svc/1", Ext: ".json"}, "key 'svc/1'"); name != "svc_1.json" {
+		t.Errorf("unexpected name '%s' for a key", name)
+	}
+	if _, err = byKey.Render(OutputName{Key: "svc:1", Ext: ".json"}, "key 'svc:1'"); err == nil {
+		t.Error("keys colliding once sanitized not rejected")
+	}
+
+	if _, err = NewNameTemplate("{{.Key"); err == nil {
+		t.Error("invalid template not rejected")
+	}
+	for input, expected := range map[string]string{"..": "_", " ": "_", "a\tb": "a_b", "x?.json": "x_.json"} {
+		if sanitized := sanitizeFileName(input); sanitized != expected {
+			t.Errorf("'%s' sanitized to '%s' instead of '%s'", input, sanitized, expected)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPathParsing(t *testing.T) {
+	cases := map[string]Path{
+		"":         nil,
+		"a":        {{Key: "a"}},
+		"a.b[2].c": {{Key: "a"}, {Key: "b"}, {IsIndex: true, Index: 2}, {Key: "c"}},
+		"[0][]":    {{IsIndex: true, Index: 0}, {IsIndex: true, Wildcard: true}},
+		"*.x[*]":   {{Key: "*", Wildcard: true}, {Key: "x"}, {IsIndex: true, Wildcard: true}},
+		`a\.b.\*`:  {{Key: "a.b"}, {Key: "*"}},
+		"**.a":     {{Key: "**", Wildcard: true, Recursive: true}, {Key: "a"}},
+		`\**.a`:    {{Key: "**"}, {Key: "a"}},
+	}
+	for input, expected := range cases {
+		actual, err := ParsePath(input)
+		if err != nil {
+			t.Errorf("failed to parse '%s': %s", input, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("incorrect path for '%s': %v", input, actual)
+		}
+		if actual.String() != input && input != "*.x[*]" {
+			t.Errorf("path '%s' is formatted as '%s'", input, actual.String())
+		}
+	}
+	for _, invalid := range []string{".a", "a..b", "a.", "a[b]", "a[1", "a[-1]", "a[0]b"} {
+		if _, err := ParsePath(invalid); err == nil {
+			t.Errorf("invalid path '%s' not rejected", invalid)
+		}
+	}
+}
+
+func TestPathLookup(t *testing.T) {
+	ordered := NewOrderedMap()
+	ordered.Set("o", "ordered")
+	data := map[string]interface{}{
+		"a": []interface{}{map[interface{}]interface{}{1: "one"}, ordered},
+		"b": map[string]interface{}{"x": 1, "y": 2},
+	}
+	for path, expected := range map[string]interface{}{"a[0].1": "one", "a[1].o": "ordered", "b.y": 2} {
+		p, _ := ParsePath(path)
+		if actual, ok := lookupPath(data, p); !ok || actual != expected {
+			t.Errorf("incorrect value at '%s': %v", path, actual)
+		}
+	}
+	p, _ := ParsePath("a[2]")
+	if _, ok := lookupPath(data, p); ok {
+		t.Error("found value beyond the end of an array")
+	}
+
+	var matches []string
+	p, _ = ParsePath("*.*")
+	matchPath(data, p, func(value interface{}, at Path) {
+		matches = append(matches, at.String())
+	})
+	if !reflect.DeepEqual(matches, []string{"b.x", "b.y"}) {
+		t.Errorf("incorrect wildcard matches: %v", matches)
+	}
+	matches = nil
+	p, _ = ParsePath("a[].o")
+	matchPath(data, p, func(value interface{}, at Path) {
+		matches = append(matches, at.String())
+	})
+	if !reflect.DeepEqual(matches, []string{"a[1].o"}) {
+		t.Errorf("incorrect element wildcard matches: %v", matches)
+	}
+}
+
+func TestRecursiveWildcard(t *testing.T) {
+	input := `{"image": 0, "spec": {"containers": [{"image": "a", "env": {"image": "b"}}, {"name": "c"}]}}`
+	for path, expected := range map[string][]string{
+		"**.image":                {"image", "spec.containers[0].env.image", "spec.containers[0].image"},
+		"spec.**.image":           {"spec.containers[0].env.image", "spec.containers[0].image"},
+		"spec.**.containers[].**": {"spec.containers[0]", "spec.containers[0].env", "spec.containers[0].env.image", "spec.containers[0].image", "spec.containers[1]", "spec.containers[1].name"},
+		"**.nope":                 nil,
+	} {
+		data, _ := jsonInputFormat.Unmarshal(strings.NewReader(input))
+		p, _ := ParsePath(path)
+		var matches []string
+		matchPath(data, p, func(value interface{}, at Path) {
+			matches = append(matches, at.String())
+		})
+		sort.Strings(matches)
+		if !reflect.DeepEqual(matches, expected) {
+			t.Errorf("incorrect matches of '%s': %v", path, matches)
+		}
+	}
+
+	// only the outermost matches are updated
+	p, _ := ParsePath("**.a")
+	updated, err := updatePath(map[string]interface{}{"a": map[string]interface{}{"a": 1}, "b": []interface{}{map[string]interface{}{"a": 2}}}, p,
+		func(value interface{}, at Path) (interface{}, error) {
+			return []interface{}{value}, nil
+		})
+	if err != nil || fmt.Sprint(updated) != "map[a:[map[a:1]] b:[map[a:[2]]]]" {
+		t.Errorf("incorrect update: %v (%v)", updated, err)
+	}
+	all, _ := ParsePaths("**")
+	encoded, err := PathScopedTransformer{Paths: all, Transformer: URLEncodeTransformer{}}.Transform(map[string]interface{}{"a": map[string]interface{}{"b": "x y"}})
+	if err != nil || fmt.Sprint(encoded) != "map[a:map[b:x+y]]" {
+		t.Errorf("values transformed more than once: %v (%v)", encoded, err)
+	}
+	if missing := missingPaths(map[string]interface{}{"b": 1}, p, Path{}); len(missing) != 1 || missing[0].String() != "**.a" {
+		t.Errorf("incorrect missing paths: %v", missing)
+	}
+}
+
+func TestCombineLeafPaths(t *testing.T) {
+	dev := map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": []interface{}{1, nil}, "e": map[string]interface{}{}}}
+	prod := map[string]interface{}{"a": 2, "b": map[string]interface{}{"d": "x"}}
+	if paths := combineLeafPaths([]interface{}{dev, prod}, false); !reflect.DeepEqual(paths, []string{"a", "b.c[0]", "b.c[1]", "b.d"}) {
+		t.Errorf("unexpected union: %v", paths)
+	}
+	if paths := combineLeafPaths([]interface{}{dev, prod}, true); !reflect.DeepEqual(paths, []string{"a"}) {
+		t.Errorf("unexpected intersection: %v", paths)
+	}
+	if paths := combineLeafPaths([]interface{}{dev, "scalar"}, true); len(paths) != 0 {
+		t.Errorf("unexpected intersection with a scalar: %v", paths)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	test_records_json = `[
+		{"id": 1, "region": "eu", "meta": {"tier": 1}},
+		{"id": 2, "region": "us", "meta": {"tier": 2}},
+		{"id": 3, "region": "eu"},
+		{"id": 4, "meta": {"tier": 1}}
+	]`
+)
+
+func TestGroupBy(t *testing.T) {
+	path, _ := ParsePath("region")
+	convertTransformAndTest(t, test_records_json,
+		`{"eu":[{"id":1,"meta":{"tier":1},"region":"eu"},{"id":3,"region":"eu"}],"us":[{"id":2,"meta":{"tier":2},"region":"us"}]}`,
+		jsonInputFormat, GroupByTransformer{Path: path}, jsonOutputFormat)
+
+	path, _ = ParsePath("meta.tier")
+	convertTransformAndTest(t, test_records_json, `{"1":2,"2":1,"none":1}`,
+		jsonInputFormat, GroupByTransformer{Path: path, MissingKey: "none", Count: true}, jsonOutputFormat)
+
+	_, _, err := processString(`{"a": 1}`, jsonInputFormat, GroupByTransformer{Path: path}, jsonOutputFormat)
+	if err == nil {
+		t.Error("grouping of a non-array not rejected")
+	}
+
+	path, _ = ParsePath("k")
+	convertTransformAndTest(t, `[{"k":"none"}]`, `{"none":[{"k":"none"}]}`,
+		jsonInputFormat, GroupByTransformer{Path: path, MissingKey: "none"}, jsonOutputFormat)
+	_, _, err = processString(`[{"k":"none"},{}]`, jsonInputFormat, GroupByTransformer{Path: path, MissingKey: "none"}, jsonOutputFormat)
+	if err == nil || !strings.Contains(err.Error(), "'none'") {
+		t.Errorf("missing key colliding with a group not rejected: %v", err)
+	}
+}
+
+func TestUniqueBy(t *testing.T) {
+	input := `[{"id": 1, "v": "a"}, {"id": "1"}, {"v": "x"}, {"id": 1, "v": "b"}, {"id": 2}, {"id": 1, "v": "c"}]`
+	path, _ := ParsePath("id")
+	removed := 0
+	convertTransformAndTest(t, input, `[{"id":1,"v":"a"},{"id":"1"},{"v":"x"},{"id":2}]`,
+		jsonInputFormat, UniqueByTransformer{Path: path, Removed: &removed}, jsonOutputFormat)
+	if removed != 2 {
+		t.Errorf("incorrect number of removed elements: %d", removed)
+	}
+	convertTransformAndTest(t, input, `[{"id":1,"v":"c"},{"id":"1"},{"id":2}]`,
+		jsonInputFormat, UniqueByTransformer{Path: path, KeepLast: true, RequireKey: true}, jsonOutputFormat)
+}
+
+func TestJoin(t *testing.T) {
+	var right interface{}
+	right, _ = csfHeaderInputFormat.Unmarshal(strings.NewReader("oid,user_id,region\n10,1,x\n11,1,y\n12,9,z\n"))
+	left, _ := ParsePath("id")
+	rightPath, _ := ParsePath("user_id")
+	join := JoinTransformer{Right: right, LeftPath: left, RightPath: rightPath, Kind: JoinInner, RightSuffix: "_right"}
+	convertTransformAndTest(t, test_records_json,
+		`[{"id":1,"meta":{"tier":1},"oid":"10","region":"eu","region_right":"x","user_id":"1"},`+
+			`{"id":1,"meta":{"tier":1},"oid":"11","region":"eu","region_right":"y","user_id":"1"}]`,
+		jsonInputFormat, join, jsonOutputFormat)
+
+	join.Kind = JoinOuter
+	join.RightPrefix, join.RightSuffix = "r_", ""
+	convertTransformAndTest(t, `[{"id":2},{"id":1,"region":"eu"}]`,
+		`[{"id":2},{"id":1,"oid":"10","r_region":"x","region":"eu","user_id":"1"},`+
+			`{"id":1,"oid":"11","r_region":"y","region":"eu","user_id":"1"},{"oid":"12","region":"z","user_id":"9"}]`,
+		jsonInputFormat, join, jsonOutputFormat)
+
+	join = JoinTransformer{Right: []interface{}{map[string]interface{}{"id": "2", "v": true}}, LeftPath: left, RightPath: left, Kind: JoinLeft}
+	convertTransformAndTest(t, `[{"id":1},{"id":2}]`, `[{"id":1},{"id":2,"v":true}]`,
+		jsonInputFormat, join, jsonOutputFormat)
+
+	join.Kind = "cross"
+	if _, _, err := processString(`[]`, jsonInputFormat, join, jsonOutputFormat); err == nil {
+		t.Error("unknown join kind not rejected")
+	}
+	join.Kind = JoinInner
+	if _, _, err := processString(`[1]`, jsonInputFormat, join, jsonOutputFormat); err == nil {
+		t.Error("joining non-objects not rejected")
+	}
+}
+
+func TestFrequency(t *testing.T) {
+	input := `{"items": [{"status": "open"}, {"status": "done"}, {"status": null}, {"status": "open"}, {}, {"status": "done"}, {"status": 1}]}`
+	path, _ := ParsePath("items[].status")
+	convertTransformAndTest(t, input, `{"done":2,"open":2,"1":1,"null":1}`,
+		jsonInputFormat, FrequencyTransformer{Path: path}, jsonOutputFormat)
+	convertTransformAndTest(t, input, `{"done":2}`,
+		jsonInputFormat, FrequencyTransformer{Path: path, Top: 1}, jsonOutputFormat)
+	convertTransformAndTest(t, `[{"a":1},"x",{"a":1}]`, `{"{\"a\":1}":2,"x":1}`,
+		jsonInputFormat, FrequencyTransformer{}, jsonOutputFormat)
+}
+
+func TestTranspose(t *testing.T) {
+	convertTransformAndTest(t, `[[1,2],[3,4],[5,6]]`, `[[1,3,5],[2,4,6]]`,
+		jsonInputFormat, TransposeTransformer{}, jsonOutputFormat)
+	convertTransformAndTest(t, `[[1,2],[3],[]]`, `[[1,3,null],[2,null,null]]`,
+		jsonInputFormat, TransposeTransformer{}, jsonOutputFormat)
+	path, _ := ParsePath("data")
+	convertTransformAndTest(t, `{"data":[],"x":1}`, `{"data":[],"x":1}`,
+		jsonInputFormat, TransposeTransformer{Path: path}, jsonOutputFormat)
+	convertTransformAndTest(t, "name,age\nann,31\nbob,42\n", `{"name":["ann","bob"],"age":["31","42"]}`,
+		TextFormat{FieldDelimiter: ",", Header: true, PreserveOrder: true}, TransposeTransformer{}, jsonOutputFormat)
+	convertTransformAndTest(t, `[{"b":1},{"a":2}]`, `{"b":[1,null],"a":[null,2]}`,
+		jsonInputFormat, TransposeTransformer{}, jsonOutputFormat)
+
+	for _, input := range []string{`[[1,2],[3]]`, `[{"a":1},{"b":2}]`, `[{"a":1},[2]]`, `{"a":1}`} {
+		if _, _, err := processString(input, jsonInputFormat, TransposeTransformer{Strict: true}, jsonOutputFormat); err == nil {
+			t.Errorf("transposing %s did not fail", input)
+		}
+	}
+}
+
+func TestZip(t *testing.T) {
+	values := []interface{}{"v1", 2.0, nil}
+	convertTransformAndTest(t, `["k1","k2",3]`, `{"k1":"v1","k2":2,"3":null}`,
+		jsonInputFormat, ZipTransformer{Values: values}, jsonOutputFormat)
+	convertTransformAndTest(t, `["k1","k2"]`, `[["k1","v1"],["k2",2],[null,null]]`,
+		jsonInputFormat, ZipTransformer{Values: values, Pairs: true, Pad: true}, jsonOutputFormat)
+	convertTransformAndTest(t, `["a","b","c","d"]`, `{"a":"v1","b":2,"c":null,"d":null}`,
+		jsonInputFormat, ZipTransformer{Values: values, Pad: true}, jsonOutputFormat)
+
+	for _, transformer := range []ZipTransformer{{Values: values}, {Values: values, Pad: true}, {Values: "x"}} {
+		if _, _, err := processString(`["a","b"]`, jsonInputFormat, transformer, jsonOutputFormat); err == nil {
+			t.Errorf("zipping with %v did not fail", transformer)
+		}
+	}
+	if _, _, err := processString(`["a","a","b"]`, jsonInputFormat, ZipTransformer{Values: values}, jsonOutputFormat); err == nil {
+		t.Error("duplicate keys not rejected")
+	}
+}
+
+func TestIndexAnnotation(t *testing.T) {
+	path, _ := ParsePath("x")
+	convertTransformAndTest(t, `[{"a":1},2,{"b":3}]`, `[{"_index":0,"a":1},2,{"_index":2,"b":3}]`,
+		jsonInputFormat, IndexAnnotateTransformer{Key: "_index"}, jsonOutputFormat)
+	convertTransformAndTest(t, `{"x":[{"a":1},2]}`, `{"x":[{"a":1,"n":0},{"n":1,"v":2}]}`,
+		jsonInputFormat, IndexAnnotateTransformer{Path: path, Key: "n", ValueKey: "v"}, jsonOutputFormat)
+	convertTransformAndTest(t, `[{"_index":0,"a":1},{"_index":1,"v":2},{"v":3},4]`, `[{"a":1},2,{"v":3},4]`,
+		jsonInputFormat, IndexAnnotateTransformer{Key: "_index", ValueKey: "v", Strip: true}, jsonOutputFormat)
+
+	ordered := NewOrderedMap()
+	ordered.Set("z", 1)
+	annotated, err := IndexAnnotateTransformer{Key: "_index"}.Transform([]interface{}{ordered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := mapKeys(annotated.([]interface{})[0]); strings.Join(keys, ",") != "_index,z" {
+		t.Errorf("index not added first to an ordered object: %v", keys)
+	}
+	if _, _, err = processString(`[{"_index":5}]`, jsonInputFormat, IndexAnnotateTransformer{Key: "_index"}, jsonOutputFormat); err == nil {
+		t.Error("existing index key not rejected")
+	}
+}
+
+func TestStrictParse(t *testing.T) {
+	csf := TextFormat{FieldDelimiter: ",", Header: true}
+	parsed := NewMultiTransformer(NewConfigurableTransformer(StringToFiniteNumberParser, nil, nil, nil, nil), StrictParseTransformer{})
+	convertTransformAndTest(t, "name,price\na,1\n2,2.5\nc,\n", `[{"name":"a","price":1},{"name":2,"price":2.5},{"name":"c","price":""}]`,
+		csf, parsed, jsonOutputFormat)
+	convertTransformAndTest(t, `{"a": "x"}`, `{"a":"x"}`, jsonInputFormat, parsed, jsonOutputFormat)
+
+	_, _, err := processString("name,price\na,1\nb,n/a\nc,3\n", csf, parsed, jsonOutputFormat)
+	if err == nil || !strings.Contains(err.Error(), "record 2, column 'price': 'n/a' is a string rather than a number") {
+		t.Errorf("unexpected error for a dirty cell: %v", err)
+	}
+	_, _, err = processString("1,x\n2,3\nn/a,y\n", TextFormat{FieldDelimiter: ","}, parsed, jsonOutputFormat)
+	if err == nil || !strings.Contains(err.Error(), "record 3, column 1:") {
+		t.Errorf("unexpected error for a dirty field of an array: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const petstore = `openapi: "3.0.0"
+info:
+  version: 1.0.0
+  title: Swagger Petstore
+  license:
+    name: MIT
+servers:
+  - url: http://petstore.swagger.io/v1
+paths:
+  /pets:
+    get:
+      summary: List all pets
+      operationId: listPets
+      tags:
+        - pets
+      parameters:
+        - name: limit
+          in: query
+          description: How many items to return at one time (max 100)
+          required: false
+          schema:
+            type: integer
+            maximum: 100
+            format: int32
+      responses:
+        '200':
+          description: A paged array of pets
+          headers:
+            x-next:
+              description: A link to the next page of responses
+              schema:
+                type: string
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Pets"
+        default:
+          description: unexpected error
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Error"
+    post:
+      summary: Create a pet
+      operationId: createPets
+      tags:
+        - pets
+      responses:
+        '201':
+          description: Null response
+        default:
+          description: unexpected error
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Error"
+  /pets/{petId}:
+    get:
+      summary: Info for a specific pet
+      operationId: showPetById
+      tags:
+        - pets
+      parameters:
+        - name: petId
+          in: path
+          required: true
+          description: The id of the pet to retrieve
+          schema:
+            type: string
+      responses:
+        '200':
+          description: Expected response to a valid request
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Pet"
+        default:
+          description: unexpected error
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Error"
+components:
+  schemas:
+    Pet:
+      type: object
+      required:
+        - id
+        - name
+      properties:
+        id:
+          type: integer
+          format: int64
+        name:
+          type: string
+        tag:
+          type: string
+    Pets:
+      type: array
+      maxItems: 100
+      items:
+        $ref: "#/components/schemas/Pet"
+    Error:
+      type: object
+      required:
+        - code
+        - message
+      properties:
+        code:
+          type: integer
+          format: int32
+        message:
+          type: string
+`
+
+func TestResolveRefs(t *testing.T) {
+	data, err := yamlInputFormat.Unmarshal(strings.NewReader(petstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := RefResolvingTransformer{}.Transform(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs := matchingValues(t, resolved, "**.$ref"); len(refs) > 0 {
+		t.Errorf("%d $ref left", len(refs))
+	}
+	types := matchingValues(t, resolved, "paths./pets.get.responses.200.content.application/json.schema.items.properties.name.type")
+	if !reflect.DeepEqual(types, []interface{}{"string"}) {
+		t.Errorf("unexpected type of the names of pets: %v", types)
+	}
+	if refs := matchingValues(t, data, "**.$ref"); len(refs) != 6 {
+		t.Errorf("the input was modified (%d $ref left)", len(refs))
+	}
+
+	for _, test := range []struct {
+		input    string
+		expected string
+		err      string
+	}{
+		{`{"a":{"$ref":"#/b~1c/0"},"b/c":[{"d":"~"}]}`, `{"a":{"d":"~"},"b/c":[{"d":"~"}]}`, ""},
+		{`{"a":{"$ref":"#/b","x":1},"b":{"$ref":"#/c"},"c":[1]}`, `{"a":[1],"b":[1],"c":[1]}`, ""},
+		{`{"a":{"$ref":"other.json#/b"}}`, `{"a":{"$ref":"other.json#/b"}}`, ""},
+		{`{"a":{"$ref":"#/b"},"b":{"c":{"$ref":"#/a"}}}`, "",
+			"circular $ref at 'a.c': #/b -> #/a -> #/b"},
+		{`{"a":{"b":{"$ref":"#"}}}`, "", "circular $ref at 'a.b.a.b': # -> #"},
+		{`{"a":{"$ref":"#/b/1"},"b":[1]}`, "", "'1' is not an index"},
+		{`{"a":{"$ref":"#/c"}}`, "", "there is no key 'c'"},
+	} {
+		data, err := jsonInputFormat.Unmarshal(strings.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resolved, err := RefResolvingTransformer{}.Transform(data)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("unexpected error for %s: %v", test.input, err)
+			}
+			continue
+		}
+		expected, _ := jsonInputFormat.Unmarshal(strings.NewReader(test.expected))
+		if err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(resolved, expected) {
+			t.Errorf("%s resolved to %v", test.input, resolved)
+		}
+	}
+
+	data, _ = jsonInputFormat.Unmarshal(strings.NewReader(`{"a":{"$ref":"#/b"},"b":{"$ref":"#/c"},"c":1}`))
+	if _, err := (RefResolvingTransformer{MaxDepth: 1}).Transform(data); err == nil || !strings.Contains(err.Error(), "more than 1") {
+		t.Errorf("nesting beyond the maximum depth not rejected: %v", err)
+	}
+}
+
+func TestResolveExternalRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"common/schemas.yaml": "User:\n  $ref: '#/Name'\nName:\n  type: string\nLoop:\n  $ref: '../loop.json'\n",
+		"loop.json":           `{"$ref": "common/schemas.yaml#/Loop"}`,
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0750)
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := map[string]interface{}{"user": map[string]interface{}{"$ref": "common/schemas.yaml#/User"}}
+	resolved, err := RefResolvingTransformer{External: true, Dir: dir}.Transform(data)
+	expected := map[string]interface{}{"user": map[string]interface{}{"type": "string"}}
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("unexpected result: %v", resolved)
+	}
+
+	data = map[string]interface{}{"loop": map[string]interface{}{"$ref": "loop.json"}}
+	if _, err = (RefResolvingTransformer{External: true, Dir: dir}).Transform(data); err == nil || !strings.Contains(err.Error(), "circular") {
+		t.Errorf("circular references between files not rejected: %v", err)
+	}
+	data = map[string]interface{}{"a": map[string]interface{}{"$ref": "missing.json"}}
+	if _, err = (RefResolvingTransformer{External: true, Dir: dir}).Transform(data); err == nil {
+		t.Error("reference to a missing file resolved")
+	}
+}
+
+func matchingValues(t *testing.T, data interface{}, path string) []interface{} {
+	parsed, err := ParsePath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []interface{}
+	matchPath(data, parsed, func(value interface{}, at Path) {
+		values = append(values, value)
+	})
+	return values
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	data, err := jsonInputFormat.Unmarshal(strings.NewReader(`{"spec": {"containers": [{"image": "a", "ports": [80]}, {"image": "b"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written interface{}
+	repl := &REPL{Data: data, Write: func(target string, data interface{}) error {
+		written = data
+		return nil
+	}}
+	commands := `spec.containers[0].image
+keys spec.containers[0]
+length spec.containers
+type spec.containers[0].ports[0]
+spec.containers[].image
+set spec.containers[1].ports [443]
+spec.containers[1]
+set spec.containers[2] 1
+nope
+
+!4
+write out.json
+quit
+type spec
+`
+	writer := &strings.Builder{}
+	if err = repl.Run(strings.NewReader(commands), writer); err != nil {
+		t.Fatal(err)
+	}
+	expected := `"a"
+[
+  "image",
+  "ports"
+]
+2
+"int"
+[
+  "a",
+  "b"
+]
+{
+  "image": "b",
+  "ports": [
+    443
+  ]
+}
+error: cannot set element 2 of an array
+error: there is no value at 'nope'
+type spec.containers[0].ports[0]
+"int"
+wrote out.json
+`
+	if writer.String() != expected {
+		t.Errorf("unexpected output, found:\n%s\nexpected:\n%s", writer.String(), expected)
+	}
+	if len(repl.History) != 12 {
+		t.Errorf("unexpected history %v", repl.History)
+	}
+	if ports, _ := lookupPath(written, Path{{Key: "spec"}, {Key: "containers"}, {Index: 1, IsIndex: true}, {Key: "ports"}}); describeType(ports) != "an array" {
+		t.Errorf("the change was not written: %v", written)
+	}
+}
+
+func TestColorizeJSON(t *testing.T) {
+	colorized := string(colorizeJSON([]byte(`{"a\"": ["x", -1.5, true, null]}`)))
+	expected := "{" + replColorKey + `"a\""` + replColorReset + ": [" +
+		replColorString + `"x"` + replColorReset + ", " +
+		replColorNumber + "-1.5" + replColorReset + ", " +
+		replColorLiteral + "true" + replColorReset + ", " +
+		replColorLiteral + "null" + replColorReset + "]}"
+	if colorized != expected {
+		t.Errorf("unexpected colors %q, expected %q", colorized, expected)
+	}
+}
+
+func TestREPLWildcardSet(t *testing.T) {
+	data, err := jsonInputFormat.Unmarshal(strings.NewReader(`{"users": [{"password": "a"}, {"password": "b", "x": {"password": "c"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repl := &REPL{Data: data}
+	writer := &strings.Builder{}
+	if err = repl.Run(strings.NewReader("set users[*].password x\nset **.password y\nset **.nope 1\nusers[].**.password\n"), writer); err != nil {
+		t.Fatal(err)
+	}
+	expected := `set 2 values
+set 3 values
+error: no value matches '**.nope'
+[
+  "y",
+  "y",
+  "y"
+]
+`
+	if writer.String() != expected {
+		t.Errorf("unexpected output, found:\n%s\nexpected:\n%s", writer.String(), expected)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A minimal validator for the subset of JSON Schema produced by the inference.
+func validateInferredSchema(schema map[string]interface{}, value interface{}, path string) error {
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch tt := t.(type) {
+		case string:
+			types = []string{tt}
+		case []string:
+			types = tt
+		}
+		actual := jsonSchemaType(value)
+		matched := false
+		for _, t := range types {
+			if t == actual || (t == schemaTypeNumber && actual == schemaTypeInteger) {
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: type %s not in %v", path, actual, types)
+		}
+	}
+	if enum, ok := schema["enum"]; ok {
+		found := false
+		for _, e := range enum.([]string) {
+			if e == value {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v not in enum %v", path, value, enum)
+		}
+	}
+	if object, ok := value.(map[string]interface{}); ok {
+		if required, ok := schema["required"]; ok {
+			for _, key := range required.([]string) {
+				if _, ok := object[key]; !ok {
+					return fmt.Errorf("%s: missing required key %s", path, key)
+				}
+			}
+		}
+		if properties, ok := schema["properties"]; ok {
+			for key, property := range properties.(map[string]interface{}) {
+				if v, ok := object[key]; ok {
+					err := validateInferredSchema(property.(map[string]interface{}), v, path+"."+key)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if array, ok := value.([]interface{}); ok {
+		if items, ok := schema["items"]; ok {
+			for n, v := range array {
+				err := validateInferredSchema(items.(map[string]interface{}), v, fmt.Sprintf("%s[%d]", path, n))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonSchemaType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return schemaTypeNull
+	case bool:
+		return schemaTypeBoolean
+	case float64:
+		if v == math.Trunc(v) {
+			return schemaTypeInteger
+		}
+		return schemaTypeNumber
+	case string:
+		return schemaTypeString
+	case []interface{}:
+		return schemaTypeArray
+	case map[string]interface{}:
+		return schemaTypeObject
+	}
+	return reflect.TypeOf(value).String()
+}
+
+func inferAndValidate(t *testing.T, input string, enumThreshold int) map[string]interface{} {
+	data, err := jsonInputFormat.Unmarshal(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := SchemaInferenceTransformer{EnumThreshold: enumThreshold}.Transform(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the inference must not modify the data, so it can be validated afterwards
+	err = validateInferredSchema(schema.(map[string]interface{}), data, "$")
+	if err != nil {
+		t.Errorf("inferred schema does not validate its input: %s (schema: %v)", err, schema)
+	}
+	return schema.(map[string]interface{})
+}
+
+func TestSchemaInference(t *testing.T) {
+	schema := inferAndValidate(t, `[
+		{"id": 1, "name": "a", "kind": "x", "tags": ["t"], "score": 1},
+		{"id": 2, "name": null, "kind": "y", "tags": [], "score": 2.5},
+		{"id": 3, "kind": "x", "extra": {"deep": true}}
+	]`, 2)
+	if schema["$schema"] != schemaDialect || schema["type"] != schemaTypeArray {
+		t.Errorf("unexpected top-level schema: %v", schema)
+	}
+	items := schema["items"].(map[string]interface{})
+	if !reflect.DeepEqual(items["required"], []string{"id", "kind"}) {
+		t.Errorf("unexpected required keys: %v", items["required"])
+	}
+	properties := items["properties"].(map[string]interface{})
+	if !reflect.DeepEqual(properties["name"].(map[string]interface{})["type"], []string{schemaTypeNull, schemaTypeString}) {
+		t.Errorf("type union not inferred: %v", properties["name"])
+	}
+	if properties["score"].(map[string]interface{})["type"] != schemaTypeNumber {
+		t.Errorf("integer/number union not collapsed: %v", properties["score"])
+	}
+	if !reflect.DeepEqual(properties["kind"].(map[string]interface{})["enum"], []string{"x", "y"}) {
+		t.Errorf("enum not inferred: %v", properties["kind"])
+	}
+	if _, ok := properties["name"].(map[string]interface{})["enum"]; ok {
+		t.Errorf("enum inferred for a nullable field: %v", properties["name"])
+	}
+}
+
+func TestSchemaInferenceOfEmptyAndScalarDocuments(t *testing.T) {
+	inferAndValidate(t, `[]`, 0)
+	inferAndValidate(t, `[{}, {"a": 1}]`, 0)
+	inferAndValidate(t, `"text"`, 1)
+	inferAndValidate(t, `[1, "a", null, [true], {"b": [{"c": 1}, {}]}]`, 0)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serverRequest(server http.Handler, method string, target string, contentType string, accept string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func serverTest(t *testing.T, response *httptest.ResponseRecorder, status int, contentType string, body string) {
+	if response.Code != status {
+		t.Errorf("unexpected status %d (expected %d): %s", response.Code, status, response.Body.String())
+	}
+	if response.Header().Get("Content-Type") != contentType {
+		t.Errorf("unexpected content type '%s' (expected '%s')", response.Header().Get("Content-Type"), contentType)
+	}
+	if body != "" && strings.TrimSpace(response.Body.String()) != body {
+		t.Errorf("unexpected body '%s' (expected '%s')", response.Body.String(), body)
+	}
+}
+
+func TestServerConversion(t *testing.T) {
+	server := NewConversionServer(64, 2)
+	serverTest(t, serverRequest(server, http.MethodPost, "/convert?from=yaml&to=json", "", "", "a: [1, null]"),
+		http.StatusOK, "application/json", `{"a":[1,null]}`)
+	serverTest(t, serverRequest(server, http.MethodPost, "/convert?transform=remove-nulls&elements", "application/json", "text/html, application/yaml", `{"a":[1,null]}`),
+		http.StatusOK, "application/yaml", "a:\n  - 1")
+	serverTest(t, serverRequest(server, http.MethodPost, "/convert?from=csf&header", "", "", "a,b\n1,2\n"),
+		http.StatusOK, "application/json", `[{"a":"1","b":"2"}]`)
+}
+
+func TestServerOutputFormats(t *testing.T) {
+	server := NewConversionServer(64, 2)
+	for _, name := range outputFormats {
+		if name == autoFormat {
+			continue
+		}
+		input := `{"a":"1"}`
+		if name == formatNameCSF || name == formatNameFixed {
+			input = `[{"a":"1"}]`
+		}
+		response := serverRequest(server, http.MethodPost, "/convert?from=json&to="+name, "", "", input)
+		mediaType := formatMediaTypes[name]
+		if len(mediaType) == 0 {
+			t.Errorf("no media type for %s output", name)
+			continue
+		}
+		serverTest(t, response, http.StatusOK, mediaType[0], "")
+		if name := formatForMediaType(mediaType[0]); !containsFold(name, outputFormats) {
+			t.Errorf("the media type %s is not read as an output format (%s)", mediaType[0], name)
+		}
+	}
+	if mediaType := mediaTypeFor("tree"); mediaType != "text/plain" {
+		t.Errorf("unexpected media type %s of a format without one", mediaType)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	server := NewConversionServer(64, 1)
+	serverTest(t, serverRequest(server, http.MethodPost, "/convert?from=json", "", "", "{"),
+		http.StatusBadRequest, "application/json", "")
+	serverTest(t, serverRequest(server, http.MethodPost, "/convert", "", "", "{}"),
+		http.StatusBadRequest, "application/json", "")
+	serverTest(t, serverRequest(server, http.MethodPost, "/convert?from=json&transform=nope", "", "", "{}"),
+		http.StatusBadRequest, "application/json", `{"error":"unknown transformer 'nope'"}`)
+	serverTest(t, serverRequest(server, http.MethodPost, "/convert?from=json&transform=enforce-types&allow=string", "", "", `{"a":1}`),
+		http.StatusUnprocessableEntity, "application/json", "")
+	serverTest(t, serverRequest(server, http.MethodPost, "/convert?from=json", "", "", strings.Repeat(" ", 65)),
+		http.StatusRequestEntityTooLarge, "application/json", "")
+	serverTest(t, serverRequest(server, http.MethodGet, "/convert?from=json", "", "", ""),
+		http.StatusMethodNotAllowed, "application/json", "")
+
+	server.slots <- struct{}{}
+	serverTest(t, serverRequest(server, http.MethodPost, "/convert?from=json", "", "", "{}"),
+		http.StatusServiceUnavailable, "application/json", "")
+	<-server.slots
+}
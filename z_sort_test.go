@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortKeys(t *testing.T) {
+	fold, _ := NewCollation("FOLD")
+	convertTransformAndTest(t, `{"b":1,"B":{"é":1,"e":2,"Z":3},"a":[{"y":1,"x":2}]}`,
+		`{"B":{"Z":3,"e":2,"é":1},"a":[{"x":2,"y":1}],"b":1}`,
+		jsonInputFormat, SortKeysTransformer{}, jsonOutputFormat)
+	convertTransformAndTest(t, `{"b":1,"B":{"é":1,"e":2,"Z":3},"a":[{"y":1,"x":2}]}`,
+		`{"a":[{"x":2,"y":1}],"B":{"e":2,"Z":3,"é":1},"b":1}`,
+		jsonInputFormat, SortKeysTransformer{Less: fold}, jsonOutputFormat)
+	if _, err := NewCollation("de_DE"); err == nil || !strings.Contains(err.Error(), "locale-aware") {
+		t.Errorf("locale collation not rejected: %v", err)
+	}
+	if _, err := NewCollation("bytewise"); err == nil || !strings.Contains(err.Error(), "unknown collation") {
+		t.Errorf("unknown collation not rejected: %v", err)
+	}
+}
+
+func TestSortArrays(t *testing.T) {
+	fold, _ := NewCollation(CollationFold)
+	convertTransformAndTest(t, `[3,"b","A",null,true,false,1.5,"a",{"x":[2,1]},[2,1]]`,
+		`[null,false,true,1.5,3,"A","a","b",{"x":[1,2]},[1,2]]`,
+		jsonInputFormat, SortArraysTransformer{Less: fold}, jsonOutputFormat)
+	convertTransformAndTest(t, `["b","a","B"]`, `["B","a","b"]`,
+		jsonInputFormat, SortArraysTransformer{}, jsonOutputFormat)
+}
+
+func TestNaturalCollation(t *testing.T) {
+	natural, err := NewCollation(CollationNatural)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convertTransformAndTest(t, `{"item10":1,"item2":2,"Item1":{"b":1,"a10":2,"a9":3}}`,
+		`{"Item1":{"a9":3,"a10":2,"b":1},"item2":2,"item10":1}`,
+		jsonInputFormat, SortKeysTransformer{Less: natural}, jsonOutputFormat)
+	convertTransformAndTest(t, `{"item10":1,"item2":2}`, "item2: 2\nitem10: 1\n",
+		jsonInputFormat, SortKeysTransformer{Less: natural}, yamlOutputFormat)
+	convertTransformAndTest(t, `["v1.10","v1.9","V1.9","v01.9","x","v1.9a",2,"v"]`,
+		`[2,"v","V1.9","v1.9","v01.9","v1.9a","v1.10","x"]`,
+		jsonInputFormat, SortArraysTransformer{Less: natural}, jsonOutputFormat)
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -112,6 +116,20 @@ func TestNilRemovalFromMap(t *testing.T) {
 	}
 }
 
+func TestZeroValueRemoval(t *testing.T) {
+	input := `{"a": 0, "b": "", "c": false, "d": {"e": null, "f": []}, "g": [0, {"h": 0.0}], "i": 1, "j": "x"}`
+	all, _ := ParseZeroKinds(strings.Join(zeroKinds, ","))
+	removal := NewMultiTransformer(NilRemovalTransformer{RemoveNilValues: true}, RemoveZeroValuesTransformer{Kinds: all})
+	convertTransformAndTest(t, input, `{"g":[0,{}],"i":1,"j":"x"}`, jsonInputFormat, removal, jsonOutputFormat)
+
+	kinds, _ := ParseZeroKinds("bool, empty")
+	convertTransformAndTest(t, input, `{"a":0,"b":"","d":{"e":null},"g":[0,{"h":0}],"i":1,"j":"x"}`,
+		jsonInputFormat, RemoveZeroValuesTransformer{Kinds: kinds}, jsonOutputFormat)
+	if _, err := ParseZeroKinds("number,null"); err == nil {
+		t.Error("unknown kind of zero value not rejected")
+	}
+}
+
 func TestTrivialNilRemoval(t *testing.T) {
 	transformer := NilRemovalTransformer{}
 
@@ -139,3 +157,512 @@ func TestRecursiveNilRemoval(t *testing.T) {
 		t.Errorf("incorrect recursive array nil transformation detected: %v", val)
 	}
 }
+
+func TestDefaults(t *testing.T) {
+	var data, defaults interface{}
+	data = map[string]interface{}{
+		"a": "input",
+		"b": map[string]interface{}{"c": nil},
+		"n": 1,
+	}
+	defaults = map[string]interface{}{
+		"a": "default",
+		"b": map[string]interface{}{"c": 2, "d": 3},
+		"e": []interface{}{4},
+		"n": map[string]interface{}{"x": 1},
+	}
+	val, err := DefaultsTransformer{Defaults: defaults}.Transform(data)
+	if err != nil {
+		t.Error(err)
+	}
+	actual := val.(map[string]interface{})
+	b := actual["b"].(map[string]interface{})
+	if actual["a"] != "input" || actual["n"] != 1 || b["c"] != nil || b["d"] != 3 || len(actual["e"].([]interface{})) != 1 {
+		t.Errorf("incorrect defaults applied: %v", actual)
+	}
+
+	val, err = DefaultsTransformer{Defaults: defaults}.Transform(nil)
+	if err != nil || val.(map[string]interface{})["a"] != "default" {
+		t.Errorf("defaults not used for empty input: %v", val)
+	}
+}
+
+func TestDefaultsWithIncompatibleKeys(t *testing.T) {
+	data := map[int]interface{}{1: "a"}
+	_, err := DefaultsTransformer{Defaults: map[string]interface{}{"b": 1}}.Transform(data)
+	if err == nil {
+		t.Error("incompatible keys not detected")
+	}
+}
+
+func TestOrderedMapTransformation(t *testing.T) {
+	data := NewOrderedMap()
+	data.Set("b", nil)
+	data.Set("a", []interface{}{nil, "1"})
+	transformer := NewMultiTransformer(
+		NilRemovalTransformer{RemoveNilValues: true, RemoveNilElements: true},
+		NewConfigurableTransformer(StringToFiniteNumberParser, nil, nil, nil, nil),
+		DefaultsTransformer{Defaults: map[string]interface{}{"c": 3, "a": 4}},
+	)
+	val, err := transformer.Transform(data)
+	if err != nil {
+		t.Error(err)
+	}
+	actual := val.(*OrderedMap)
+	a, _ := actual.Get("a")
+	if len(actual.Keys()) != 2 || actual.Keys()[0] != "a" || actual.Keys()[1] != "c" ||
+		len(a.([]interface{})) != 1 || a.([]interface{})[0] != int64(1) {
+		t.Errorf("incorrect ordered map transformation: %v %v", actual.Keys(), actual.values)
+	}
+}
+
+func TestURLTransformers(t *testing.T) {
+	paths, _ := ParsePaths("a,c[]")
+	encoder := PathScopedTransformer{Paths: paths, Transformer: URLEncodeTransformer{}}
+	convertTransformAndTest(t, `{"a": "x y&z", "b": "x y", "c": ["/p q", 1]}`,
+		`{"a":"x+y%26z","b":"x y","c":["%2Fp+q",1]}`, jsonInputFormat, encoder, jsonOutputFormat)
+	convertTransformAndTest(t, `["/p q"]`, `["%2Fp%20q"]`,
+		jsonInputFormat, URLEncodeTransformer{PathEscaping: true}, jsonOutputFormat)
+
+	convertTransformAndTest(t, `{"a": ["x+y%26z", 1], "b": {"c": "%2Fp%20q"}}`,
+		`{"a":["x y\u0026z",1],"b":{"c":"/p q"}}`, jsonInputFormat, URLDecodeTransformer{}, jsonOutputFormat)
+	convertTransformAndTest(t, `["a+b%zz"]`, `["a+b%zz"]`,
+		jsonInputFormat, URLDecodeTransformer{PathEscaping: true, Lenient: true}, jsonOutputFormat)
+	_, _, err := processString(`{"a": [0, "%zz"]}`, jsonInputFormat, URLDecodeTransformer{}, jsonOutputFormat)
+	if err == nil || !strings.Contains(err.Error(), "'a[1]'") {
+		t.Errorf("malformed encoding not reported with its path: %v", err)
+	}
+}
+
+func TestNormalizeNumericStrings(t *testing.T) {
+	convertTransformAndTest(t, `["1E-05", "+1.50", "-0.0", "007", "1e3", "12.5e-1", ".5", "-1.2E+2", 1e-5, "x", "1e", " 1", "."]`,
+		`["0.00001","1.5","0","7","1000","1.25","0.5","-120",0.00001,"x","1e"," 1","."]`,
+		jsonInputFormat, NormalizeNumericStringsTransformer{}, jsonOutputFormat)
+	convertTransformAndTest(t, `["0.1000000000000000000000000001", "1e5000"]`, `["0.1000000000000000000000000001","1e5000"]`,
+		jsonInputFormat, NormalizeNumericStringsTransformer{}, jsonOutputFormat)
+
+	paths, _ := ParsePaths("a")
+	convertTransformAndTest(t, `{"a": "1E2", "b": "1E2"}`, `{"a":"100","b":"1E2"}`,
+		jsonInputFormat, PathScopedTransformer{Paths: paths, Transformer: NormalizeNumericStringsTransformer{}}, jsonOutputFormat)
+}
+
+func TestTypeWhitelist(t *testing.T) {
+	allowed, err := ParseValueTypes("string, int,BOOL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	convertTransformAndTest(t, `{"a":[1,"x",{"b":true}],"c":{}}`, `{"a":[1,"x",{"b":true}],"c":{}}`,
+		jsonInputFormat, TypeWhitelistTransformer{Allowed: allowed}, jsonOutputFormat)
+	_, _, err = processString(`{"a":[1,"x",{"b":1.5}]}`, jsonInputFormat, TypeWhitelistTransformer{Allowed: allowed}, jsonOutputFormat)
+	if err == nil || !strings.Contains(err.Error(), "'a[2].b'") {
+		t.Errorf("disallowed float not reported with its path: %v", err)
+	}
+	_, _, err = processString(`[null]`, jsonInputFormat, TypeWhitelistTransformer{Allowed: allowed}, jsonOutputFormat)
+	if err == nil {
+		t.Error("disallowed null not rejected")
+	}
+	allowed, _ = ParseValueTypes("number")
+	convertTransformAndTest(t, `[1, 2.5]`, `[1,2.5]`, jsonInputFormat, TypeWhitelistTransformer{Allowed: allowed}, jsonOutputFormat)
+	if _, err = ParseValueTypes("string,decimal"); err == nil {
+		t.Error("unknown type name not rejected")
+	}
+}
+
+func TestEnsureArray(t *testing.T) {
+	paths, _ := ParsePaths("items[].tags,owner,missing.x,none")
+	convertTransformAndTest(t,
+		`{"items":[{"tags":"a"},{"tags":["b","c"]},{}],"owner":{"name":"x"},"none":null}`,
+		`{"items":[{"tags":["a"]},{"tags":["b","c"]},{}],"none":null,"owner":[{"name":"x"}]}`,
+		jsonInputFormat, EnsureArrayTransformer{Paths: paths}, jsonOutputFormat)
+	paths, _ = ParsePaths("")
+	convertTransformAndTest(t, `1`, `[1]`, jsonInputFormat, EnsureArrayTransformer{Paths: paths}, jsonOutputFormat)
+}
+
+func TestRequireKeys(t *testing.T) {
+	input := `{"app":{"db":{"port":1,"user":null}},"items":[{"name":"a"},{}],"list":[1]}`
+	paths, _ := ParsePaths("app.db.port,app.db.user,items[0].name,list[0]")
+	convertTransformAndTest(t, input, `{"app":{"db":{"port":1,"user":null}},"items":[{"name":"a"},{}],"list":[1]}`,
+		jsonInputFormat, RequireKeysTransformer{Paths: paths}, jsonOutputFormat)
+
+	for paths, expected := range map[string]string{
+		"app.db.host":                    "the required key 'app.db.host' is missing",
+		"app.db.host,x.y,items[].name":   "3 required keys are missing: 'app.db.host', 'x.y', 'items[1].name'",
+		"list[1],app.*.port,app.db.port": "the required key 'list[1]' is missing",
+	} {
+		parsed, _ := ParsePaths(paths)
+		_, _, err := processString(input, jsonInputFormat, RequireKeysTransformer{Paths: parsed}, jsonOutputFormat)
+		if err == nil || err.Error() != expected {
+			t.Errorf("unexpected error for %s: %v", paths, err)
+		}
+	}
+}
+
+func TestJSSafeNumbers(t *testing.T) {
+	convertTransformAndTest(t, `{"a":[1,-2.5,9007199254740991,1e20]}`, `{"a":[1,-2.5,9007199254740991,"100000000000000000000"]}`,
+		jsonInputFormat, JSSafeNumberTransformer{}, jsonOutputFormat)
+	convertAndTest(t, "a: 9007199254740993\nb: -9007199254740993\nc: 18446744073709551615\n",
+		`{"a":"9007199254740993","b":"-9007199254740993","c":"18446744073709551615"}`,
+		yamlInputFormat, JSONFormat{JSSafeNumbers: true})
+	convertAndTest(t, `[9007199254740993, 123456789012345678901234567890, 1.5e300]`,
+		`["9007199254740993","123456789012345678901234567890",1.5e300]`,
+		JSONFormat{UseNumber: true}, JSONFormat{JSSafeNumbers: true})
+}
+
+func TestDeepCopyTransformer(t *testing.T) {
+	ordered := NewOrderedMap()
+	ordered.Set("keep", 1)
+	ordered.Set("drop", nil)
+	data := map[string]interface{}{
+		"a":       nil,
+		"list":    []interface{}{nil, map[string]interface{}{"b": nil, "c": 1}},
+		"generic": map[interface{}]interface{}{"e": nil, 1: "f"},
+		"ordered": ordered,
+	}
+	expected := fmt.Sprintf("%#v", data)
+	transformer := WithCopy(NilRemovalTransformer{RemoveNilValues: true, RemoveNilElements: true})
+	transformed, err := transformer.Transform(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := fmt.Sprintf("%#v", data); actual != expected {
+		t.Errorf("original data modified: %s", actual)
+	}
+	if _, ok := transformed.(map[string]interface{})["a"]; ok {
+		t.Error("copy not transformed")
+	}
+	if ordered.Len() != 2 {
+		t.Error("ordered map modified")
+	}
+
+	tables := []map[string]interface{}{{"d": []interface{}{1}}}
+	copied := copyData(tables).([]map[string]interface{})
+	copied[0]["d"].([]interface{})[0] = 2
+	if tables[0]["d"].([]interface{})[0] != 1 {
+		t.Error("nested slice not copied")
+	}
+}
+
+func TestNumericKeys(t *testing.T) {
+	input := `{"1": "a", "x": {"2": "b", "02": "c", "-3": "d"}, "l": [{"4": null}]}`
+	convertTransformAndTest(t, input, "1: a\nl:\n  - 4: null\nx:\n  -3: d\n  2: b\n  \"02\": c\n",
+		jsonInputFormat, NumericKeysTransformer{}, yamlOutputFormat)
+
+	paths, _ := ParsePaths("x")
+	convertTransformAndTest(t, input, "\"1\": a\nl:\n  - \"4\": null\nx:\n  -3: d\n  2: b\n  \"02\": c\n",
+		jsonInputFormat, NumericKeysTransformer{Paths: paths}, yamlOutputFormat)
+}
+
+func TestFoldKeys(t *testing.T) {
+	input := `{"host":"a","Host":"b","x":[{"PORT":1,"Port":1}],"y":1}`
+	convertTransformAndTest(t, input, `{"Host":"a","x":[{"PORT":1}],"y":1}`,
+		jsonInputFormat, FoldKeysTransformer{}, jsonOutputFormat)
+	convertTransformAndTest(t, input, `{"host":"a","x":[{"port":1}],"y":1}`,
+		jsonInputFormat, FoldKeysTransformer{Keep: FoldKeysLower}, jsonOutputFormat)
+	convertTransformAndTest(t, input, `{"host":"a","x":[{"Port":1}],"y":1}`,
+		jsonInputFormat, FoldKeysTransformer{Keep: FoldKeysLast}, jsonOutputFormat)
+	convertTransformAndTest(t, "z,b,B\n2,1,1\n", `[{"z":"2","b":"1"}]`,
+		TextFormat{FieldDelimiter: ",", Header: true, PreserveOrder: true}, FoldKeysTransformer{Strict: true}, jsonOutputFormat)
+
+	if _, _, err := processString(input, jsonInputFormat, FoldKeysTransformer{Strict: true}, jsonOutputFormat); err == nil ||
+		!strings.Contains(err.Error(), "'Host', 'host' at the top level") {
+		t.Errorf("keys with different values not rejected: %v", err)
+	}
+}
+
+func TestNormalizeKeys(t *testing.T) {
+	input := "{\"\ufeff name \": 1, \"email\u00a0\": {\"a\\u0000b\": 2}, \"first \\t name\": [{\" x\": 3}]}"
+	convertTransformAndTest(t, input, `{"email":{"ab":2},"first \t name":[{"x":3}],"name":1}`,
+		jsonInputFormat, NormalizeKeysTransformer{}, jsonOutputFormat)
+	convertTransformAndTest(t, input, `{"email":{"ab":2},"first name":[{"x":3}],"name":1}`,
+		jsonInputFormat, NormalizeKeysTransformer{CollapseSpaces: true}, jsonOutputFormat)
+	convertTransformAndTest(t, "b , a\n1,2\n", `[{"b":"1","a":"2"}]`,
+		TextFormat{FieldDelimiter: ",", Header: true, PreserveOrder: true}, NormalizeKeysTransformer{}, jsonOutputFormat)
+
+	_, _, err := processString(`{"a": {"b": 1, " b": 2}}`, jsonInputFormat, NormalizeKeysTransformer{}, jsonOutputFormat)
+	if err == nil || !strings.Contains(err.Error(), "' b' and 'b' at 'a'") {
+		t.Errorf("colliding keys not reported: %v", err)
+	}
+}
+
+func TestBoolFormat(t *testing.T) {
+	input := `{"a": true, "b": [false, "true", 1], "c": {"d": false}}`
+	convertTransformAndTest(t, input, `{"a":1,"b":[0,"true",1],"c":{"d":0}}`,
+		jsonInputFormat, BoolFormatTransformer{True: int64(1), False: int64(0)}, jsonOutputFormat)
+
+	paths, _ := ParsePaths("c")
+	convertTransformAndTest(t, input, `{"a":true,"b":[false,"true",1],"c":{"d":"N"}}`,
+		jsonInputFormat, PathScopedTransformer{Paths: paths, Transformer: BoolFormatTransformer{True: "Y", False: "N"}},
+		jsonOutputFormat)
+	if boolRendering("1", false) != int64(1) || boolRendering("1", true) != "1" || boolRendering("Y", false) != "Y" {
+		t.Error("unexpected boolean renderings")
+	}
+}
+
+func TestEntries(t *testing.T) {
+	input := `{"b": {"x": 1, "a": [2]}, "a": null}`
+	convertTransformAndTest(t, input, `[{"key":"a","value":null},{"key":"b","value":{"a":[2],"x":1}}]`,
+		jsonInputFormat, EntriesTransformer{}, jsonOutputFormat)
+	paths, _ := ParsePaths("b,a")
+	convertTransformAndTest(t, input, `{"a":null,"b":[{"k":"a","v":[2]},{"k":"x","v":1}]}`,
+		jsonInputFormat, PathScopedTransformer{Paths: paths, Transformer: EntriesTransformer{KeyField: "k", ValueField: "v"}},
+		jsonOutputFormat)
+
+	entries := `[{"key": "z", "value": 1}, {"key": 2, "value": [3]}, {"key": "z", "value": 4}, {"key": "n"}]`
+	convertTransformAndTest(t, entries, `{"z":4,"2":[3],"n":null}`, jsonInputFormat, FromEntriesTransformer{}, jsonOutputFormat)
+	convertTransformAndTest(t, `{"b":2,"a":1}`, `{"a":1,"b":2}`, jsonInputFormat,
+		NewMultiTransformer(EntriesTransformer{}, FromEntriesTransformer{}), jsonOutputFormat)
+
+	for _, test := range []struct {
+		input       string
+		transformer Transformer
+	}{
+		{`[1]`, EntriesTransformer{}},
+		{`{"key": "a"}`, FromEntriesTransformer{}},
+		{`[1]`, FromEntriesTransformer{}},
+		{`[{"value": 1}]`, FromEntriesTransformer{}},
+		{`[{"key": [1], "value": 1}]`, FromEntriesTransformer{}},
+	} {
+		if _, _, err := processString(test.input, jsonInputFormat, test.transformer, jsonOutputFormat); err == nil {
+			t.Errorf("invalid input %s not rejected by %T", test.input, test.transformer)
+		}
+	}
+}
+
+func TestMergeArrayOfObjects(t *testing.T) {
+	input := `[{"b": 1}, {"a": [2], "c": {"y": 1, "x": 2}}, {"b": 3}]`
+	convertTransformAndTest(t, input, `{"b":3,"a":[2],"c":{"x":2,"y":1}}`,
+		jsonInputFormat, MergeArrayOfObjectsTransformer{LastWins: true}, jsonOutputFormat)
+	paths, _ := ParsePaths("a,n")
+	convertTransformAndTest(t, `{"a": [{"x": 1}, {"y": 2}], "n": null}`, `{"a":{"x":1,"y":2},"n":null}`,
+		jsonInputFormat, PathScopedTransformer{Paths: paths, Transformer: MergeArrayOfObjectsTransformer{}}, jsonOutputFormat)
+	convertTransformAndTest(t, `[]`, `{}`, jsonInputFormat, MergeArrayOfObjectsTransformer{}, jsonOutputFormat)
+
+	for _, invalid := range []string{input, `[{"a": 1}, 2]`, `[{"a": 1}, null]`, `{"a": 1}`} {
+		if _, _, err := processString(invalid, jsonInputFormat, MergeArrayOfObjectsTransformer{}, jsonOutputFormat); err == nil {
+			t.Errorf("invalid input %s merged", invalid)
+		}
+	}
+}
+
+func TestMaskNumbers(t *testing.T) {
+	input := `{"a": 1234, "b": [5.5, -1499.9, .inf], "c": "1234", "d": {"e": 999}}`
+	for mode, expected := range map[string]string{
+		"":         `{"a":1000,"b":[0,-1000,"+Inf"],"c":"1234","d":{"e":1000}}`,
+		MaskZero:   `{"a":0,"b":[0,0,"+Inf"],"c":"1234","d":{"e":0}}`,
+		MaskRedact: `{"a":"1000..2000","b":["0..1000","-2000..-1000","+Inf"],"c":"1234","d":{"e":"0..1000"}}`,
+	} {
+		convertTransformAndTest(t, input, expected, yamlInputFormat,
+			MaskNumbersTransformer{Mode: mode, Granularity: 1000}, JSONFormat{NonFinite: NonFiniteString})
+	}
+	convertTransformAndTest(t, `[1.26, 7]`, `[1.5,7]`, jsonInputFormat, MaskNumbersTransformer{Granularity: 0.5}, jsonOutputFormat)
+
+	noise := MaskNumbersTransformer{Mode: MaskNoise, Noise: 10, Seed: 42}
+	_, first, _ := processString(input, yamlInputFormat, noise, jsonOutputFormat)
+	_, second, _ := processString(input, yamlInputFormat, noise, jsonOutputFormat)
+	if first != second {
+		t.Errorf("noise not deterministic: %s and %s", first, second)
+	}
+	masked, _ := noise.Transform(map[string]interface{}{"a": 1234, "b": 5.5})
+	if a := masked.(map[string]interface{})["a"].(int64); a < 1224 || a > 1244 || a == 1234 {
+		t.Errorf("unexpected noise for an integer: %v", masked)
+	}
+	if _, err := (MaskNumbersTransformer{Mode: "hash"}).Transform(1); err == nil {
+		t.Error("unknown mode not rejected")
+	}
+}
+
+func TestEditPaths(t *testing.T) {
+	input := `{"users": [{"name": "a", "password": "x"}, {"name": "b"}, {"password": "y"}], "metadata": {"managedFields": [1], "spec": {"metadata": {"managedFields": [2]}}}}`
+	for path, expected := range map[string]string{
+		"users[*].password":         `{"metadata":{"managedFields":[1],"spec":{"metadata":{"managedFields":[2]}}},"users":[{"name":"a"},{"name":"b"},{}]}`,
+		"**.managedFields":          `{"metadata":{"spec":{"metadata":{}}},"users":[{"name":"a","password":"x"},{"name":"b"},{"password":"y"}]}`,
+		"users[0]":                  `{"metadata":{"managedFields":[1],"spec":{"metadata":{"managedFields":[2]}}},"users":[{"name":"b"},{"password":"y"}]}`,
+		"users[]":                   `{"metadata":{"managedFields":[1],"spec":{"metadata":{"managedFields":[2]}}},"users":[]}`,
+		"**.metadata.managedFields": `{"metadata":{"spec":{"metadata":{}}},"users":[{"name":"a","password":"x"},{"name":"b"},{"password":"y"}]}`,
+		"users[0].*":                `{"metadata":{"managedFields":[1],"spec":{"metadata":{"managedFields":[2]}}},"users":[{},{"name":"b"},{"password":"y"}]}`,
+	} {
+		p, _ := ParsePath(path)
+		convertTransformAndTest(t, input, expected, jsonInputFormat, DeletePathTransformer{Path: p}, jsonOutputFormat)
+	}
+
+	matches := 0
+	p, _ := ParsePath("**.password")
+	convertTransformAndTest(t, input, `{"metadata":{"managedFields":[1],"spec":{"metadata":{"managedFields":[2]}}},"users":[{"name":"a","password":"*"},{"name":"b"},{"password":"*"}]}`,
+		jsonInputFormat, SetPathTransformer{Path: p, Value: "*", Matches: &matches}, jsonOutputFormat)
+	p, _ = ParsePath("**.managedFields")
+	convertTransformAndTest(t, input, `{"metadata":{"spec":{"metadata":{}}},"users":[{"name":"a","password":"x"},{"name":"b"},{"password":"y"}]}`,
+		jsonInputFormat, DeletePathTransformer{Path: p, Matches: &matches}, jsonOutputFormat)
+	if matches != 4 {
+		t.Errorf("unexpected number of matches: %d", matches)
+	}
+
+	p, _ = ParsePath("users[1].password")
+	convertTransformAndTest(t, `{"users": [{}, {}]}`, `{"users":[{},{"password":"*"}]}`,
+		jsonInputFormat, SetPathTransformer{Path: p, Value: "*"}, jsonOutputFormat)
+	convertTransformAndTest(t, `{"users": [{}, {}]}`, `{"users":[{},{}]}`,
+		jsonInputFormat, SetPathTransformer{Path: p, Value: "*", Existing: true}, jsonOutputFormat)
+}
+
+func TestTruncate(t *testing.T) {
+	input := `{"b": [1, 2, 3], "a": {"z": 1, "y": {"q": [1, 2, 3]}, "x": 3}, "c": []}`
+	convertTransformAndTest(t, input, `{"a":{"x":3,"y":{"q":[1,2]}},"b":[1,2]}`,
+		jsonInputFormat, TruncateTransformer{Limits: []int{2}}, jsonOutputFormat)
+	convertTransformAndTest(t, input, `{"a":{"...":2,"x":3},"b":[1,"..."],"c":[]}`,
+		jsonInputFormat, TruncateTransformer{Limits: []int{0, 1}, Marker: "..."}, jsonOutputFormat)
+	convertTransformAndTest(t, input, `{"a":{"x":3,"y":{"q":[1,2,3]},"z":1}}`,
+		jsonInputFormat, TruncateTransformer{Limits: []int{1, 0}}, jsonOutputFormat)
+
+	ordered := NewOrderedMap()
+	ordered.Set("b", 1.0)
+	ordered.Set("a", 2.0)
+	truncated, err := TruncateTransformer{Limits: []int{1}}.Transform(ordered)
+	if keys := mapKeys(truncated); err != nil || !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Errorf("the first key of an ordered object not kept: %v, %v", keys, err)
+	}
+	if _, _, err := processString(input, jsonInputFormat, TruncateTransformer{Limits: []int{1, -1}}, jsonOutputFormat); err == nil {
+		t.Error("negative limit accepted")
+	}
+
+	// the marker may be a key left out, but not one kept
+	convertTransformAndTest(t, `{"x":{"a":1,"b":2,"~":3}}`, `{"x":{"a":1,"b":2,"~":1}}`,
+		jsonInputFormat, TruncateTransformer{Limits: []int{0, 2}, Marker: "~"}, jsonOutputFormat)
+	_, _, err = processString(`{"x":{"...":1,"b":2,"c":3}}`, jsonInputFormat, TruncateTransformer{Limits: []int{0, 2}, Marker: "..."}, jsonOutputFormat)
+	if err == nil || !strings.Contains(err.Error(), "at 'x'") {
+		t.Errorf("colliding marker not rejected: %v", err)
+	}
+}
+
+// An array of objects like typical JSON records, some values and elements nil.
+func largeTestArray(size int) []interface{} {
+	elements := make([]interface{}, size)
+	for n := range elements {
+		if n%10 == 9 {
+			continue
+		}
+		elements[n] = map[string]interface{}{
+			"id":     float64(n),
+			"name":   "element",
+			"score":  1.5,
+			"note":   nil,
+			"tags":   []interface{}{"a", nil, "b"},
+			"nested": map[string]interface{}{"active": true, "deleted": nil},
+		}
+	}
+	return elements
+}
+
+func BenchmarkTransformLargeArray(b *testing.B) {
+	transformer := NilRemovalTransformer{RemoveNilValues: true, RemoveNilElements: true}
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		data := largeTestArray(100000)
+		b.StartTimer()
+		if _, err := transformer.Transform(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTransformFastPaths(t *testing.T) {
+	transformers := []callingTransformer{
+		NewConfigurableTransformer(nil, nil, nil, NonNilElementSelector, NonNilValueSelector).(callingTransformer),
+		NewConfigurableTransformer(StringToFiniteNumberParser, nil, nil, nil, nil).(callingTransformer),
+		NewConfigurableTransformer(func(s string) interface{} {
+			if s == "drop" {
+				return nil
+			}
+			return s
+		}, nil, nil, nil, NonNilKeySelector).(callingTransformer),
+	}
+	for n, transformer := range transformers {
+		fast, err := transformer.transformStringMap(map[string]interface{}{
+			"a": "1", "b": nil, "c": "drop", "d": []interface{}{nil, "2", float32(1.5)},
+			"e": map[string]interface{}{"f": nil, "g": "x"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reflective, err := transformer.transformMap(reflect.ValueOf(map[string]interface{}{
+			"a": "1", "b": nil, "c": "drop", "d": []interface{}{nil, "2", float32(1.5)},
+			"e": map[string]interface{}{"f": nil, "g": "x"},
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(fast, reflective) {
+			t.Errorf("transformer %d: fast path %v differs from %v", n, fast, reflective)
+		}
+	}
+
+	data := largeTestArray(1000)
+	transformed, err := NilRemovalTransformer{RemoveNilValues: true, RemoveNilElements: true}.Transform(data)
+	elements := transformed.([]interface{})
+	if err != nil || len(elements) != 900 || len(elements[0].(map[string]interface{})) != 5 ||
+		len(elements[0].(map[string]interface{})["tags"].([]interface{})) != 2 {
+		t.Errorf("unexpected transformation: %v (%v)", elements[0], err)
+	}
+}
+
+func TestParallelTransformer(t *testing.T) {
+	transformer := NilRemovalTransformer{RemoveNilValues: true, RemoveNilElements: true}
+	serial, _ := transformer.Transform(largeTestArray(1001))
+	for _, workers := range []int{1, 2, 3, 8} {
+		parallel, err := ParallelTransformer{Transformer: transformer, Workers: workers}.Transform(largeTestArray(1001))
+		if err != nil || !reflect.DeepEqual(parallel, serial) {
+			t.Errorf("parallel transformation with %d workers differs (%v)", workers, err)
+		}
+	}
+
+	allowed, _ := ParseValueTypes("string")
+	_, err := ParallelTransformer{Transformer: TypeWhitelistTransformer{Allowed: allowed}, Workers: 4}.
+		Transform([]interface{}{"a", "b", 1, "c", "d", "e", "f", "g", "h"})
+	if err == nil {
+		t.Error("error of a worker not returned")
+	}
+
+	object := map[string]interface{}{"a": nil}
+	transformed, err := ParallelTransformer{Transformer: transformer, Workers: 4}.Transform(object)
+	if err != nil || len(transformed.(map[string]interface{})) != 0 {
+		t.Errorf("objects not transformed as a whole: %v (%v)", transformed, err)
+	}
+}
+
+func TestStringToBoolParser(t *testing.T) {
+	parser, err := StringToBoolParser(DefaultTrueStrings, DefaultFalseStrings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transformer := NewConfigurableTransformer(ChainStringConverters(parser, StringToFiniteNumberParser), nil, nil, nil, nil)
+	transformed, err := transformer.Transform(map[string]interface{}{"a": []interface{}{"Yes", " off", "1", "y", true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"a": []interface{}{true, false, int64(1), "y", true}}
+	if !reflect.DeepEqual(transformed, expected) {
+		t.Errorf("unexpected coercion %v", transformed)
+	}
+	if _, err = StringToBoolParser([]string{"1"}, []string{"0", "1"}); err == nil {
+		t.Error("strings both true and false not rejected")
+	}
+}
+
+func TestStepLog(t *testing.T) {
+	log := &strings.Builder{}
+	transformer := WithStepLog(NewMultiTransformer(
+		NewMultiTransformer(NopTransformer{}, EntriesTransformer{}), EntriesTransformer{}), log)
+	if _, _, err := processString(`{"a": "xy", "b": [1]}`, jsonInputFormat, transformer, jsonOutputFormat); err == nil {
+		t.Error("the entries of an array did not fail")
+	}
+	durations := regexp.MustCompile(` duration=\S+`)
+	expected := `step=1.1 transformer=NopTransformer before=object/2 after=object/2
+step=1.2 transformer=EntriesTransformer before=object/2 after=array/2
+step=1 transformer=TransformerPipeline before=object/2 after=array/2
+step=2 transformer=EntriesTransformer before=array/2 after=array/2 error="expected an object for entries but found an array"
+`
+	if steps := durations.ReplaceAllString(log.String(), ""); steps != expected {
+		t.Errorf("unexpected log:\n%s", log.String())
+	}
+	if summary := summarizeData("hé"); summary != "string/2" {
+		t.Errorf("unexpected summary of a string: %s", summary)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func treeTest(t *testing.T, input string, expected string, format TreeFormat) {
+	data, err := jsonInputFormat.Unmarshal(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &strings.Builder{}
+	err = format.Marshal(data, writer)
+	if err != nil {
+		t.Error(err)
+	}
+	if writer.String() != expected {
+		t.Errorf("unexpected tree, found:\n%s\nexpected:\n%s", writer.String(), expected)
+	}
+}
+
+func TestTree(t *testing.T) {
+	treeTest(t, `{"b": {"c": "x", "d": [true]}, "a": [1, 1.5, null]}`, `object (2 keys)
+├─ a: array (3 elements)
+│  ├─ [0]: int
+│  ├─ [1]: float
+│  └─ [2]: null
+└─ b: object (2 keys)
+   ├─ c: string
+   └─ d: array (1 element)
+      └─ [0]: bool
+`, TreeFormat{})
+	treeTest(t, `[{"a": {"b": 1}}]`, `array (1 element)
+└─ [0]: object (1 key)
+`, TreeFormat{MaxDepth: 1})
+	treeTest(t, `"x"`, "string\n", TreeFormat{})
+}
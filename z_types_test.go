@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTypeCoercion(t *testing.T) {
+	types, err := ParseTypeDeclarations(map[string]interface{}{
+		"servers[].port": "int", "**.zip": "string", "ratio": "Float", "on": "bool", "none": "null", "count": "int",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := func() interface{} {
+		return map[string]interface{}{
+			"servers": []interface{}{map[string]interface{}{"port": "8080"}, map[string]interface{}{"port": 443.0}},
+			"a":       map[string]interface{}{"b": map[string]interface{}{"zip": json.Number("01234")}},
+			"zip":     12345.0, "ratio": "2.5", "on": "Yes", "none": "", "count": "3.0", "n": "5",
+		}
+	}
+	expected := map[string]interface{}{
+		"servers": []interface{}{map[string]interface{}{"port": int64(8080)}, map[string]interface{}{"port": int64(443)}},
+		"a":       map[string]interface{}{"b": map[string]interface{}{"zip": "01234"}},
+		"zip":     "12345", "ratio": 2.5, "on": true, "none": nil, "count": int64(3), "n": "5",
+	}
+	result, err := TypeCoercionTransformer{Types: types}.Transform(input())
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result: %v", result)
+	}
+
+	expected["n"] = int64(5)
+	result, err = TypeCoercionTransformer{Types: types, Fallback: StringToFiniteNumberParser}.Transform(input())
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(result, expected) {
+		t.Errorf("unexpected result with a fallback: %v", result)
+	}
+
+	for _, value := range []interface{}{"x", "1.5", true, 1e300} {
+		data := map[string]interface{}{"count": value}
+		_, err := TypeCoercionTransformer{Types: types}.Transform(data)
+		if err == nil || !strings.Contains(err.Error(), "at 'count' cannot be coerced to int") {
+			t.Errorf("%v coerced to int: %v", value, err)
+		}
+		result, err := TypeCoercionTransformer{Types: types, Lenient: true}.Transform(data)
+		if err != nil || !reflect.DeepEqual(result, map[string]interface{}{"count": value}) {
+			t.Errorf("%v not kept with lenient coercion: %v, %v", value, result, err)
+		}
+	}
+
+	for _, invalid := range []interface{}{[]interface{}{"int"}, map[string]interface{}{"a": "date"}, map[string]interface{}{"a": 1.0}} {
+		if _, err := ParseTypeDeclarations(invalid); err == nil {
+			t.Errorf("invalid types %v accepted", invalid)
+		}
+	}
+}
+
+func TestTypePrecedence(t *testing.T) {
+	types, err := ParseTypeDeclarations(map[string]interface{}{
+		"**": "string", "**.port": "float", "a.**": "bool", "servers[].port": "int", "servers[].*": "null",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, declaration := range types {
+		order = append(order, declaration.Path.String())
+	}
+	expected := []string{"servers[].port", "servers[].*", "a.**", "**.port", "**"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("unexpected order %v, expected %v", order, expected)
+	}
+
+	input := map[string]interface{}{
+		"servers": []interface{}{map[string]interface{}{"port": "80", "host": ""}},
+		"b":       map[string]interface{}{"port": "81"},
+		"a":       map[string]interface{}{"port": "yes"},
+		"c":       12.0,
+	}
+	result, err := TypeCoercionTransformer{Types: types}.Transform(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"servers": []interface{}{map[string]interface{}{"port": int64(80), "host": nil}},
+		"b":       map[string]interface{}{"port": 81.0},
+		"a":       map[string]interface{}{"port": true},
+		"c":       "12",
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("unexpected result: %v", result)
+	}
+}
+
+func TestPathMatches(t *testing.T) {
+	for _, test := range []struct {
+		pattern  string
+		concrete string
+		expected bool
+	}{
+		{"a.b", "a.b", true},
+		{"a.b", "a.c", false},
+		{"a[].b", "a[3].b", true},
+		{"a.*", "a.b", true},
+		{"a.*", "a.b.c", false},
+		{"**.c", "c", true},
+		{"**.c", "a[1].b.c", true},
+		{"a.**", "a.b.c", true},
+		{"a.**", "b.c", false},
+	} {
+		pattern, err := ParsePath(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		concrete, err := ParsePath(test.concrete)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pattern.matches(concrete) != test.expected {
+			t.Errorf("%s matching %s is not %v", test.pattern, test.concrete, test.expected)
+		}
+	}
+}
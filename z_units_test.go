@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUnits(t *testing.T) {
+	for _, test := range []struct {
+		transformer ParseUnitsTransformer
+		input       interface{}
+		expected    interface{}
+	}{
+		{ParseUnitsTransformer{Unit: UnitDuration}, "30s", int64(30e9)},
+		{ParseUnitsTransformer{Unit: UnitDuration}, " 1h30m ", int64(5400e9)},
+		{ParseUnitsTransformer{Unit: UnitDuration, Canonical: true}, "90m", "1h30m0s"},
+		{ParseUnitsTransformer{Unit: UnitDuration}, "30", "30"},
+		{ParseUnitsTransformer{Unit: UnitDuration}, 30.0, 30.0},
+		{ParseUnitsTransformer{Unit: UnitSize}, "512", int64(512)},
+		{ParseUnitsTransformer{Unit: UnitSize}, "10MB", int64(10000000)},
+		{ParseUnitsTransformer{Unit: UnitSize}, "10mb", int64(10000000)},
+		{ParseUnitsTransformer{Unit: UnitSize, Binary: true}, "10M", int64(10485760)},
+		{ParseUnitsTransformer{Unit: UnitSize}, "1.5 GiB", int64(1610612736)},
+		{ParseUnitsTransformer{Unit: UnitSize}, "0.5k", int64(500)},
+		{ParseUnitsTransformer{Unit: UnitSize}, "8EiB", "8EiB"},
+		{ParseUnitsTransformer{Unit: UnitSize}, "10 parsecs", "10 parsecs"},
+		{ParseUnitsTransformer{Unit: UnitSize}, "-1KB", "-1KB"},
+		{ParseUnitsTransformer{Unit: UnitSize},
+			map[string]interface{}{"a": []interface{}{"1K", true, nil}},
+			map[string]interface{}{"a": []interface{}{int64(1000), true, nil}}},
+	} {
+		result, err := test.transformer.Transform(test.input)
+		if err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%v parsed as %s to %#v rather than %#v", test.input, test.transformer.Unit, result, test.expected)
+		}
+	}
+	if _, err := (ParseUnitsTransformer{Unit: "parsec"}).Transform("1"); err == nil {
+		t.Error("unknown unit accepted")
+	}
+}
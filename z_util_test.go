@@ -1,7 +1,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -23,6 +31,340 @@ func TestIndentStrings(t *testing.T) {
 	indentStringTest(t, false, 2, 0, 0)
 }
 
+func TestKeyValueList(t *testing.T) {
+	pairs, err := parseKeyValueList("a=b,First Name=first_name,c=")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(pairs) != 3 || pairs["a"] != "b" || pairs["First Name"] != "first_name" || pairs["c"] != "" {
+		t.Errorf("unexpected key-value pairs: %v", pairs)
+	}
+	if _, err = parseKeyValueList("a=b,c"); err == nil {
+		t.Error("missing value separator not detected")
+	}
+}
+
+func TestConcatFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{"a.jsonl": "{\"a\":1}\n{\"a\":2}", "b.jsonl": "", "c.jsonl": "{\"a\":3}\n"}
+	var names []string
+	for _, name := range []string{"a.jsonl", "b.jsonl", "c.jsonl"} {
+		names = append(names, filepath.Join(dir, name))
+		if err = ioutil.WriteFile(names[len(names)-1], []byte(files[name]), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ConcatFiles(names, NDJSONFormat{})
+	if err != nil {
+		t.Error(err)
+	} else if len(data.([]interface{})) != 3 {
+		t.Errorf("unexpected concatenation: %v", data)
+	}
+	data, err = ConcatFiles(names[:1], TextFormat{RecordDelimiter: "|"})
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(data, []string{"{\"a\":1}\n{\"a\":2}"}) {
+		t.Errorf("missing delimiter not added: %v", data)
+	}
+	if _, err = ConcatFiles(names, JSONFormat{}); err == nil {
+		t.Error("concatenation of a structured format not rejected")
+	}
+}
+
+func TestInlineData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { stdin, inlineDataSet = os.Stdin, false }()
+
+	outfile := filepath.Join(dir, "out.json")
+	app := configureApp()
+	err = app.Run([]string{appName, "convert", "--data", "a: [1, 'two: 2']\nb: {c: null}", "-i", "yaml", "-o", "json", "-", outfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, _ := ioutil.ReadFile(outfile)
+	if string(output) != `{"a":[1,"two: 2"],"b":{"c":null}}` {
+		t.Errorf("unexpected output for inline data: %s", output)
+	}
+}
+
+func TestAdditionalOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { alsoOutputs = nil }()
+
+	infile := filepath.Join(dir, "src.toml")
+	if err = ioutil.WriteFile(infile, []byte("a = 1\n[b]\nc = [\"x\"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	outfiles := []string{filepath.Join(dir, "out.json"), filepath.Join(dir, "out.yaml"), filepath.Join(dir, "out")}
+	err = configureApp().Run([]string{appName, "convert", infile, outfiles[0],
+		"--also-output", outfiles[1], "--also-output", outfiles[2] + ":ini"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, expected := range []string{`{"a":1,"b":{"c":["x"]}}`, "a: 1\nb:\n  c:\n    - x\n", "a = 1\n\n[b]\nc = x\n"} {
+		if output, _ := ioutil.ReadFile(outfiles[n]); string(output) != expected {
+			t.Errorf("unexpected output in %s: %s", outfiles[n], output)
+		}
+	}
+}
+
+func TestOutputSubtree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { atPath = "" }()
+
+	infile := filepath.Join(dir, "in.json")
+	if err = ioutil.WriteFile(infile, []byte(`{"meta": {}, "items": [{"a": 1}, {"a": [2]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{"items[1]": "a:\n  - 2\n", "items[].a": "- 1\n- - 2\n"} {
+		outfile := filepath.Join(dir, path+".yaml")
+		if err = configureApp().Run([]string{appName, "convert", "--at", path, infile, outfile}); err != nil {
+			t.Fatal(err)
+		}
+		if output, _ := ioutil.ReadFile(outfile); string(output) != expected {
+			t.Errorf("unexpected output for %s: %q", path, output)
+		}
+	}
+}
+
+func TestParallelismConflict(t *testing.T) {
+	defer func() { parallelism, typesFile = 1, "" }()
+	for _, paths := range []string{"[3]", "[].x"} {
+		parallelism = 1
+		if err := parallelismConflict(paths); err != nil {
+			t.Errorf("serial transformation of %s rejected: %v", paths, err)
+		}
+		parallelism = 4
+		if err := parallelismConflict(paths); err == nil || !strings.Contains(err.Error(), "--paths") {
+			t.Errorf("parallel transformation of %s not rejected: %v", paths, err)
+		}
+	}
+	if err := parallelismConflict(""); err != nil {
+		t.Errorf("parallel transformation without paths rejected: %v", err)
+	}
+	typesFile = "types.yaml"
+	if err := parallelismConflict(""); err == nil || !strings.Contains(err.Error(), "--types") {
+		t.Errorf("parallel transformation with types not rejected: %v", err)
+	}
+}
+
+func TestEditInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { edited = nil }()
+
+	bundle := filepath.Join(dir, "bundle.yaml")
+	content := "kind: A\nmetadata:\n  managedFields: [1]\n  name: a\n---\nkind: B\nitems:\n  - metadata: {managedFields: [2], name: b}\n"
+	if err = ioutil.WriteFile(bundle, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = configureApp().Run([]string{appName, "del", "--in-place", "**.metadata.managedFields", bundle}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "kind: A\nmetadata:\n  name: a\n---\nitems:\n  - metadata:\n      name: b\nkind: B\n"
+	if output, _ := ioutil.ReadFile(bundle); string(output) != expected {
+		t.Errorf("unexpected result: %q", output)
+	} else if edited.Matches != 2 {
+		t.Errorf("unexpected number of matches: %d", edited.Matches)
+	}
+	if perDocument || outputType != autoFormat {
+		t.Errorf("options changed by the edit: %v %s", perDocument, outputType)
+	}
+}
+
+func TestRewriteInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yaml")
+	if err = ioutil.WriteFile(file, []byte("# settings\nb: {c: 1}\na:   [1,2]\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err = configureApp().Run([]string{appName, "fmt", "-w", "--preserve-comments", file}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# settings\nb: {c: 1}\na: [1, 2]\n"
+	if output, _ := ioutil.ReadFile(file); string(output) != expected {
+		t.Errorf("unexpected result: %q", output)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0640 {
+		t.Errorf("the mode was not kept: %v", info.Mode())
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("unexpected files left behind: %d", len(entries))
+	}
+
+	if err = rewriteConflict(YAMLFormat{}, JSONFormat{}); err == nil {
+		t.Error("rewriting YAML as JSON not rejected")
+	} else if err = rewriteConflict(YAMLFormat{}, YAMLFormat{MultiDocument: true}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSelectInputFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the options and checks of the format selected apply
+	infile, outfile := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.json")
+	if err = ioutil.WriteFile(infile, []byte("a,b\n1,2\n3,4\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = configureApp().Run([]string{appName, "convert", "-i", "json,csf", "--header", "--skip", "1", infile, outfile}); err != nil {
+		t.Fatal(err)
+	}
+	if output, _ := ioutil.ReadFile(outfile); string(output) != `[{"a":"3","b":"4"}]` {
+		t.Errorf("unexpected output %s", output)
+	}
+	file := filepath.Join(dir, "config.txt")
+	if err = ioutil.WriteFile(file, []byte("b: {c: 1}\na:   [1,2]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = configureApp().Run([]string{appName, "fmt", "-w", "-i", "json,yaml", file}); err != nil {
+		t.Fatal(err)
+	}
+	if output, _ := ioutil.ReadFile(file); string(output) != "a:\n  - 1\n  - 2\nb:\n  c: 1\n" {
+		t.Errorf("unexpected result %q", output)
+	}
+
+	defer func(original io.Reader) { stdin = original }(stdin)
+	stdin = strings.NewReader("a = 1\n")
+	fallback := FallbackFormat{Formats: []InputFormat{JSONFormat{}, TOMLFormat{}}}
+	if selected := selectInputFormat(fallback, "-"); selected.Name() != formatNameTOML {
+		t.Errorf("unexpected format %s selected", selected.Name())
+	}
+	if input, _ := ioutil.ReadAll(stdin); string(input) != "a = 1\n" {
+		t.Errorf("stdin not buffered: %q", input)
+	}
+	stdin = strings.NewReader("a: [")
+	if selected := selectInputFormat(fallback, ""); selected.Name() != fallback.Name() {
+		t.Errorf("unexpected format %s selected for an unreadable input", selected.Name())
+	}
+
+	// formats without records are not tried with the record options
+	defer func() { skipRecords = 0 }()
+	skipRecords = 1
+	configured := configureInputFormat(FallbackFormat{Formats: []InputFormat{JSONFormat{}, TextFormat{FieldDelimiter: ","}}}, infile)
+	if configured.Name() != formatNameCSF {
+		t.Errorf("unexpected formats %s tried with --skip", configured.Name())
+	}
+}
+
+func TestLimitKeysAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { atPath, limitKeys, limitMarker = "", "", "" }()
+
+	infile, outfile := filepath.Join(dir, "in.json"), filepath.Join(dir, "out.json")
+	if err = ioutil.WriteFile(infile, []byte(`{"a":{"b":[1,2,3],"c":{"d":1,"e":2}},"f":1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = configureApp().Run([]string{appName, "convert", "--at", "a", "--limit-keys", "0,1", "--limit-marker", "...", infile, outfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, _ := ioutil.ReadFile(outfile); string(output) != `{"b":[1,"..."],"c":{"...":1,"d":1}}` {
+		t.Errorf("unexpected output: %s", output)
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { skippedRecords = 0 }()
+
+	infile, outfile := filepath.Join(dir, "in.jsonl"), filepath.Join(dir, "out.json")
+	if err = ioutil.WriteFile(infile, []byte("{\"n\":1}\n{\"n\":\"x\"}\n{bad\n{\"n\":3}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = configureApp().Run([]string{appName, "enforce-types", "--allow", "int", "--keep-going=silent", "-o", "json", infile, outfile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, _ := ioutil.ReadFile(outfile); string(output) != `[{"n":1},{"n":3}]` {
+		t.Errorf("unexpected output: %s", output)
+	} else if skippedRecords != 2 || !keepGoingSilent || keepGoing {
+		t.Errorf("unexpected state after skipping: %d %v %v", skippedRecords, keepGoing, keepGoingSilent)
+	}
+
+	// only commands transforming the records on their own skip them
+	elementWise = false
+	if err := keepGoingConflict(); err == nil {
+		t.Error("--keep-going accepted for a command transforming the whole input")
+	}
+	elementWise, typesFile = true, "types.json"
+	if err := keepGoingConflict(); err == nil || !strings.Contains(err.Error(), "--types") {
+		t.Errorf("--keep-going accepted with --types: %v", err)
+	}
+	elementWise, typesFile = false, ""
+
+	// --max-errors also limits --keep-going
+	skippedRecords, keepGoing, keepGoingSilent, maxErrors = 0, true, true, 1
+	defer func() { keepGoing, keepGoingSilent, maxErrors = false, false, 0 }()
+	if !skipRecord(1, errors.New("first")) || skipRecord(2, errors.New("second")) {
+		t.Error("--max-errors ignored with --keep-going")
+	}
+	if err := (keepGoingValue{}).Set("later"); err == nil {
+		t.Error("invalid --keep-going value accepted")
+	}
+}
+
+func TestOutputBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(size int) { outputBufferSize = size }(outputBufferSize)
+
+	infile, outfile := filepath.Join(dir, "in.json"), filepath.Join(dir, "out.json")
+	if err = ioutil.WriteFile(infile, []byte(`[1, 2, 3, 4, 5, 6, 7, 8, 9]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 4, 64 * 1024} {
+		outputBufferSize = size
+		os.Remove(outfile)
+		if err = ConvertFile(infile, JSONFormat{}, nil, outfile, JSONFormat{}); err != nil {
+			t.Error(err)
+		}
+		if output, _ := ioutil.ReadFile(outfile); string(output) != "[1,2,3,4,5,6,7,8,9]" {
+			t.Errorf("incomplete output with a buffer of %d bytes: %s", size, output)
+		}
+	}
+}
+
 func TestCliBuilder(t *testing.T) {
 	app := configureApp()
 	if app == nil {
@@ -31,3 +373,155 @@ func TestCliBuilder(t *testing.T) {
 		app.PrintLongHelp()
 	}
 }
+
+func TestBatchJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{appName, "batch", "--output-dir", dir, "-o", "json", "--jobs", "4"}
+	for n := 0; n < 20; n++ {
+		infile := filepath.Join(dir, fmt.Sprintf("in%d.yaml", n))
+		if err = ioutil.WriteFile(infile, []byte(fmt.Sprintf("n: %d\n", n)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, infile)
+	}
+	if err = configureApp().Run(args); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 20; n++ {
+		output, _ := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("in%d.json", n)))
+		if string(output) != fmt.Sprintf(`{"n":%d}`, n) {
+			t.Errorf("unexpected output for input %d: %s", n, output)
+		}
+	}
+}
+
+func TestBatchErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inputs := map[string]string{"a.yaml": "a: 1\n", "b.json": `{"b":`, "d.yaml": "d: [1, 2]\n"}
+	var files []string
+	for _, name := range []string{"a.yaml", "b.json", "c.yaml", "d.yaml"} {
+		files = append(files, filepath.Join(dir, name))
+		if input, ok := inputs[name]; ok {
+			if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(input), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	names, err := NewNameTemplate(defaultBatchNameTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputDir := filepath.Join(dir, "out")
+	if err = os.Mkdir(outputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	results := runBatch(newBatchTasks(files, outputDir, false), names, 3)
+	for n, failed := range []bool{false, true, true, false} {
+		if failed && (results[n].err == nil || results[n].code != exitInputError ||
+			!strings.HasPrefix(results[n].err.Error(), files[n]+": ")) {
+			t.Errorf("unexpected result for %s: %v (%d)", files[n], results[n].err, results[n].code)
+		} else if !failed && results[n].err != nil {
+			t.Errorf("unexpected error for %s: %v", files[n], results[n].err)
+		}
+	}
+	written, _ := ioutil.ReadDir(outputDir)
+	if len(written) != 2 {
+		t.Errorf("expected the 2 valid inputs to be converted, got %d files", len(written))
+	}
+}
+
+func TestNormalizeDelim(t *testing.T) {
+	for label, expected := range map[string]string{";": ";", "TAB": "\t", "NUL": "\000", "NL": ""} {
+		if delim, err := NormalizeDelim(label); err != nil || delim != expected {
+			t.Errorf("unexpected delimiter %q for %s (%v)", delim, label, err)
+		}
+	}
+	if delim, _ := NormalizeDelim(" | "); delim != " | " {
+		t.Errorf("multi-character delimiter not used literally: %q", delim)
+	}
+	if err := RegisterDelimiter("PIPES", "||"); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(namedDelimiters, "PIPES")
+	if delim, _ := NormalizeDelim("PIPES"); delim != "||" {
+		t.Errorf("registered delimiter not found: %q", delim)
+	}
+	for _, name := range []string{"", "P"} {
+		if err := RegisterDelimiter(name, "|"); err == nil {
+			t.Errorf("invalid delimiter name '%s' not rejected", name)
+		}
+	}
+	for label, expected := range map[string]string{`\x1f`: "\x1f", `\t|`: "\t|", `\u00e4`: "ä", `\`: `\`} {
+		if delim, err := NormalizeDelim(label); err != nil || delim != expected {
+			t.Errorf("unexpected delimiter %q for %s (%v)", delim, label, err)
+		}
+	}
+	if _, err := NormalizeDelim(`\q`); err == nil {
+		t.Error("invalid escape sequence not rejected")
+	}
+	if delim, _ := NormalizeDelim(`a\tb`); delim != `a\tb` {
+		t.Errorf("delimiter not starting with a backslash unescaped: %q", delim)
+	}
+	escapeDelimiters = true
+	delim, _ := NormalizeDelim(`a\tb`)
+	escapeDelimiters = false
+	if delim != "a\tb" {
+		t.Errorf("delimiter not unescaped: %q", delim)
+	}
+	format, err := NewInputFormat("", "csf", "PIPES", " ; ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := format.Unmarshal(strings.NewReader("a||b ; c||d"))
+	if err != nil || !reflect.DeepEqual(data, []interface{}{[]interface{}{"a", "b"}, []interface{}{"c", "d"}}) {
+		t.Errorf("unexpected data %#v (%v)", data, err)
+	}
+}
+
+func TestStageDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dump, err := newStageDump(filepath.Join(dir, "dump.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transformer := NewMultiTransformer(NilRemovalTransformer{}, jsonNumberTransformer)
+	writer := &strings.Builder{}
+	err = ConvertStream(strings.NewReader("a=1\nb=\n"), EnvFormat{}, transformer, writer, YAMLFormat{}, dump.Record)
+	if err != nil || dump.Err != nil {
+		t.Fatal(err, dump.Err)
+	}
+	dumped, err := ioutil.ReadFile(dump.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "{\n  \"a\": \"1\",\n  \"b\": \"\"\n}\n{\n  \"a\": 1,\n  \"b\": \"\"\n}\n"
+	if string(dumped) != expected {
+		t.Errorf("unexpected dump:\n%s\nexpected:\n%s", dumped, expected)
+	}
+}
+
+func TestStreamedExtraction(t *testing.T) {
+	extract := ExtractTransformer{Path: Path{{Key: "a"}}}
+	if format, transformer := streamedExtraction(JSONFormat{}, extract, false); transformer != nil {
+		t.Errorf("expected a streamed extraction, got %#v", format)
+	}
+	typesFile = "types.yaml"
+	defer func() { typesFile = "" }()
+	if format, transformer := streamedExtraction(JSONFormat{}, extract, false); transformer == nil {
+		t.Errorf("expected the whole document to be read with --types, got %#v", format)
+	}
+}